@@ -2,15 +2,14 @@ package v1
 
 import (
 	"net/http"
-	"strconv"
 
 	"gopkg.in/mgo.v2/bson"
 
 	"github.com/dgrijalva/jwt-go"
-	"github.com/google/uuid"
 
-	"github.com/TinyKitten/TimelineServer/config"
+	"github.com/TinyKitten/TimelineServer/imaging"
 	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/TinyKitten/TimelineServer/validation"
 
 	"github.com/TinyKitten/TimelineServer/token"
 	"go.uber.org/zap"
@@ -32,16 +31,27 @@ type (
 	}
 	AccountSettingsResponse struct {
 		DisplayName string `json:"screen_name"`
+
+		Birthday      *models.Birthday      `json:"birthday,omitempty"` // 公開範囲を含む誕生日(本人のみ)
+		ProfileFields []models.ProfileField `json:"profile_fields"`
 	}
+	// AccountSettingsRequest プロフィールの変更。省略した項目は変更しない
+	// PATCHではnullを指定した項目を削除し、POSTではnullや空の値を指定した項目も変更しない
 	AccountSettingsRequest struct {
-		Name        string `json:"name"`
-		URL         string `json:"url"`
-		Location    string `json:"location"`
-		Description string `json:"description"`
+		Name          patchValue `json:"name"`
+		URL           patchValue `json:"url"`
+		Location      patchValue `json:"location"`
+		Description   patchValue `json:"description"`
+		Birthday      patchValue `json:"birthday"`
+		PinnedPostID  patchValue `json:"pinned_post_id"`
+		ProfileFields patchValue `json:"profile_fields"`
 	}
 	AccountImageRequest struct {
 		Image string `json:"image"`
 	}
+	DeactivateRequest struct {
+		Password string `json:"password" validate:"required"`
+	}
 )
 
 func (h *APIHandler) AccountCreate(c echo.Context) error {
@@ -50,9 +60,13 @@ func (h *APIHandler) AccountCreate(c echo.Context) error {
 		h.logger.Debug("API Error", zap.String("Error", err.Error()))
 		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
 	}
+	errs := validation.FieldErrors{}
 	if err := c.Validate(reqUser); err != nil {
 		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+		errs = validation.FromValidator(reqUser, err)
+	}
+	if err := h.validateSignup(reqUser, errs); err != nil {
+		return err
 	}
 	hashed, err := utils.HashPassword(reqUser.Password)
 	if err != nil {
@@ -66,7 +80,10 @@ func (h *APIHandler) AccountCreate(c echo.Context) error {
 		return handleMgoError(err)
 	}
 
-	token, err := token.CreateToken(u.ID, false)
+	// 送信に失敗しても登録は完了させる(resend_verification.json で再送できる)
+	h.sendVerificationMail(*u)
+
+	token, err := token.CreateToken(u.ID, u.TokenVersion)
 	if err != nil {
 		h.logger.Debug("API Error", zap.String("Error", err.Error()))
 		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrLoginFailed}
@@ -76,6 +93,27 @@ func (h *APIHandler) AccountCreate(c echo.Context) error {
 	return c.JSON(http.StatusCreated, resp)
 }
 
+// validateSignup スクリーンネームとパスワードを入力規則に照らして検証し、errsと合わせて返す
+func (h *APIHandler) validateSignup(req *SignupReq, errs validation.FieldErrors) error {
+	errs.Add("id", h.rules.ScreenName(req.ID))
+	errs.Add("password", h.rules.Password(req.Password, req.ID))
+
+	if _, invalid := errs["id"]; !invalid {
+		available, err := h.screenNameAvailable(req.ID, "")
+		if err != nil {
+			return err
+		}
+		if !available {
+			errs.Add("id", validation.ErrScreenNameTaken)
+		}
+	}
+
+	if len(errs) != 0 {
+		return badFields(errs)
+	}
+	return nil
+}
+
 func (h *APIHandler) Login(c echo.Context) error {
 	reqUser := new(LoginReq)
 	if err := c.Bind(reqUser); err != nil {
@@ -83,25 +121,51 @@ func (h *APIHandler) Login(c echo.Context) error {
 		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
 	}
 
+	if err := h.checkLoginLock(c, reqUser.ID); err != nil {
+		return err
+	}
+
 	u, err := h.db.FindUser(reqUser.ID, true)
 	if err != nil {
 		h.logger.Debug("API Error", zap.String("Error", err.Error()))
 		if err == mgo.ErrNotFound {
-			return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrLoginFailed}
+			return h.loginFailed(c, reqUser.ID, nil, &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrLoginFailed})
 		}
 		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrLoginFailed}
 	}
 	if matched := utils.CheckPasswordHash(reqUser.Password, u.Password); !matched {
+		return h.loginFailed(c, reqUser.ID, u, &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrLoginFailed})
+	}
+
+	// 退会手続き中のアカウントは削除日時まではログインで復帰できる
+	if u.Deactivated && !h.clock.Now().Before(u.PurgeAt) {
 		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrLoginFailed}
 	}
 
-	// 凍結
-	if u.Suspended {
-		// TODO: どこかで凍結情報をキャッシュする
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrSuspended}
+	// 凍結(期限を過ぎていれば解除済みとして扱う)
+	if u.IsSuspended(h.clock.Now()) {
+		return h.suspendedError(u)
+	}
+
+	// 二段階認証が有効な場合はチャレンジトークンを返し、login_2fa.json で完了させる
+	if u.TwoFactorEnabled {
+		challenge, err := token.CreateChallengeToken(u.ID, h.clock.Now())
+		if err != nil {
+			h.logger.Error("Failed to create challenge token", zap.String("Reason", err.Error()))
+			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrLoginFailed}
+		}
+		return c.JSON(http.StatusOK, &models.TwoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		})
+	}
+
+	h.loginSucceeded(reqUser.ID)
+	if err := h.reactivate(u); err != nil {
+		return err
 	}
 
-	token, err := token.CreateToken(u.ID, false)
+	token, err := token.CreateToken(u.ID, u.TokenVersion)
 	if err != nil {
 		h.logger.Error("Failed to create jwt token", zap.String("Reason", err.Error()))
 		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrLoginFailed}
@@ -112,29 +176,21 @@ func (h *APIHandler) Login(c echo.Context) error {
 
 func (h *APIHandler) GetUser(c echo.Context) error {
 	// Jwtチェック
-	config := config.GetAPIConfig()
-	tokenStr := c.QueryParam("token")
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Jwt), nil
-	})
+	claims, err := h.parseQueryToken(c)
 	if err != nil {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
-	}
-	if !token.Valid {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+		return err
 	}
+	viewerID := bson.ObjectIdHex(claims["id"].(string))
 
 	screenName := c.QueryParam("screen_name")
 	userId := c.QueryParam("user_id")
 
 	if screenName != "" {
-		user, err := h.db.FindUser(screenName, true)
+		user, err := h.findUserByScreenName(screenName)
 		if err != nil {
 			return handleMgoError(err)
 		}
-		resp := models.UserToUserResponse(*user)
+		resp := models.UserToUserResponseFor(*user, viewerID)
 		return c.JSON(http.StatusOK, resp)
 	}
 
@@ -143,7 +199,10 @@ func (h *APIHandler) GetUser(c echo.Context) error {
 		if err != nil {
 			return handleMgoError(err)
 		}
-		resp := models.UserToUserResponse(*user)
+		if user.Deactivated {
+			return handleMgoError(mgo.ErrNotFound)
+		}
+		resp := models.UserToUserResponseFor(*user, viewerID)
 		return c.JSON(http.StatusOK, resp)
 	}
 
@@ -151,33 +210,60 @@ func (h *APIHandler) GetUser(c echo.Context) error {
 	return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
 }
 
-func (h *APIHandler) AccountDelete(c echo.Context) error {
-	id := c.Param("id")
+// reactivate 退会手続き中であれば取り消す。ログインの完了時に呼ぶ
+func (h *APIHandler) reactivate(u *models.User) error {
+	if !u.Deactivated {
+		return nil
+	}
+	if err := h.db.ReactivateUser(u.ID); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	u.Deactivated = false
+	return nil
+}
 
-	err := h.db.DeleteUser(id)
+// DeactivateAccount パスワードを確認して退会手続きを開始する
+// 猶予期間内にログインすれば復帰でき、過ぎると全データが削除される
+func (h *APIHandler) DeactivateAccount(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	req := new(DeactivateRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if err := c.Validate(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return badFields(validation.FromValidator(req, err))
+	}
+
+	u, err := h.db.FindUserByOID(id, false)
 	if err != nil {
 		h.logger.Debug("API Error", zap.String("Error", err.Error()))
 		return handleMgoError(err)
 	}
-	return c.JSON(http.StatusNoContent, &messageResponse{Message: RespDeleted})
+	if matched := utils.CheckPasswordHash(req.Password, u.Password); !matched {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrLoginFailed}
+	}
+
+	now := h.clock.Now()
+	if err := h.db.DeactivateUser(id, now, now.Add(accountPurgeGrace)); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return c.JSON(http.StatusOK, &messageResponse{Message: RespDeactivated})
 }
 
 func (h *APIHandler) GetAccountSettings(c echo.Context) error {
 	// Jwtチェック
-	config := config.GetAPIConfig()
-	tokenStr := c.QueryParam("token")
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Jwt), nil
-	})
+	claims, err := h.parseQueryToken(c)
 	if err != nil {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
-	}
-	if !token.Valid {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+		return err
 	}
-	claims := token.Claims.(jwt.MapClaims)
 	id := claims["id"].(string)
 
 	user, err := h.db.FindUserByOID(bson.ObjectIdHex(id), true)
@@ -186,11 +272,19 @@ func (h *APIHandler) GetAccountSettings(c echo.Context) error {
 		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
 	}
 
+	fields := user.ProfileFields
+	if fields == nil {
+		fields = []models.ProfileField{}
+	}
 	return c.JSON(http.StatusOK, &AccountSettingsResponse{
-		DisplayName: user.DisplayName,
+		DisplayName:   user.DisplayName,
+		Birthday:      user.Birthday,
+		ProfileFields: fields,
 	})
 }
 
+// SetAccountSettings プロフィールを変更する。変更は1回の更新でまとめて反映する
+// 以前からのPOSTは空の値を無視し、PATCHはJSON Merge Patchとして扱う
 func (h *APIHandler) SetAccountSettings(c echo.Context) error {
 	jwtUser := c.Get("user").(*jwt.Token)
 	claims := jwtUser.Claims.(jwt.MapClaims)
@@ -203,38 +297,17 @@ func (h *APIHandler) SetAccountSettings(c echo.Context) error {
 		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
 	}
 
-	if req.Name != "" {
-		err := h.db.UpdateUser(id, "displayName", req.Name)
-		if err != nil {
-			h.logger.Debug("API Error", zap.String("Error", err.Error()))
-			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
-		}
+	set, unset, errs := h.profileUpdate(id, req, c.Request().Method == echo.POST)
+	if len(errs) != 0 {
+		return badFields(errs)
 	}
-	if req.URL != "" {
-		err := h.db.UpdateUser(id, "websiteUrl", req.URL)
-		if err != nil {
-			h.logger.Debug("API Error", zap.String("Error", err.Error()))
-			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
-		}
-	}
-	if req.Location != "" {
-		err := h.db.UpdateUser(id, "location", req.Location)
-		if err != nil {
-			h.logger.Debug("API Error", zap.String("Error", err.Error()))
-			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
-		}
-	}
-	if req.Description != "" {
-		err := h.db.UpdateUser(id, "description", req.Description)
-		if err != nil {
-			h.logger.Debug("API Error", zap.String("Error", err.Error()))
-			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
-		}
-	}
-
-	if req.Name == "" && req.URL == "" && req.Location == "" && req.Description == "" {
+	if len(set) == 0 && len(unset) == 0 {
 		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
 	}
+	if err := h.db.UpdateProfile(id, set, unset); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
 
 	newUser, err := h.db.FindUserByOID(id, true)
 	if err != nil {
@@ -242,7 +315,7 @@ func (h *APIHandler) SetAccountSettings(c echo.Context) error {
 		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
 	}
 
-	resp := models.UserToUserResponse(*newUser)
+	resp := models.UserToUserResponseFor(*newUser, id)
 
 	return c.JSON(http.StatusOK, resp)
 }
@@ -259,49 +332,19 @@ func (h *APIHandler) UpdateAccountProfileImage(c echo.Context) error {
 		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
 	}
 
-	ext := utils.DetectFileExtension(req.Image)
-	if ext == "" {
-		return &echo.HTTPError{Code: http.StatusUnsupportedMediaType, Message: ErrMediaNotSupported}
-	}
-
-	dat, err := utils.DecodeImage(req.Image)
+	key, err := h.storeProfileImage(req.Image, imaging.AvatarVariants)
 	if err != nil {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+		return err
 	}
 
-	path := config.GetUploadImagePath()
-	uuid, err := uuid.NewUUID()
+	legacyURL, err := h.db.UpdateAvatar(id, h.storage.URL(key), key)
 	if err != nil {
 		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
-	}
-
-	filePath := path + uuid.String() + ext
-	err = utils.SaveFile(dat, filePath, 1)
-	if err != nil && err != utils.ErrFileHuge {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
-	}
-	if err == utils.ErrFileHuge {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusRequestEntityTooLarge, Message: ErrTooLargeImage}
-	}
-
-	cfg := config.GetAPIConfig()
-	portStr := strconv.Itoa(cfg.Port)
-
-	var avatarURL string
-	if cfg.Secure {
-		avatarURL = "https://" + cfg.Endpoint + ":" + portStr + "/" + cfg.Version + "/" + filePath
-	} else {
-		avatarURL = "http://" + cfg.Endpoint + ":" + portStr + "/" + cfg.Version + "/" + filePath
-	}
-	err = h.db.UpdateUser(id, "avatarUrl", avatarURL)
-	if err != nil {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+		h.releaseBlob(key)
+		return handleMgoError(err)
 	}
+	// 重複排除の導入前の画像は参照の記録がないので、ここで削除する
+	h.removeFiles(h.avatarKeys(legacyURL))
 
 	u, err := h.db.FindUserByOID(id, true)
 	if err != nil {
@@ -309,5 +352,7 @@ func (h *APIHandler) UpdateAccountProfileImage(c echo.Context) error {
 		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
 	}
 
-	return c.JSON(http.StatusCreated, u)
+	resp := models.UserToUserResponse(*u)
+
+	return c.JSON(http.StatusCreated, resp)
 }
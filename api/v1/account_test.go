@@ -7,7 +7,10 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/TinyKitten/TimelineServer/limiter"
 	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/TinyKitten/TimelineServer/validation"
 	"github.com/labstack/echo"
 	"github.com/stretchr/testify/assert"
 	validator "gopkg.in/go-playground/validator.v9"
@@ -20,7 +23,7 @@ func TestAccountCreate(t *testing.T) {
 	reqParams := SignupReq{
 		ID:       "PikkaPikka1Nensei",
 		Email:    "elemschoo@example.com",
-		Password: "password",
+		Password: "password1",
 	}
 	j, err := json.Marshal(reqParams)
 	if err != nil {
@@ -44,3 +47,67 @@ func TestAccountCreate(t *testing.T) {
 	}
 
 }
+
+func TestLoginLockout(t *testing.T) {
+	hashed, err := utils.HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := models.NewUser("bruteforced", hashed, "bruteforced@example.com", false)
+	e, _, _ := setupTest(t, u)
+
+	for i := int64(1); i < limiter.DefaultPolicy.AccountThreshold; i++ {
+		c, _ := postJSON(e, "/1.0/account/login.json", LoginReq{ID: u.UserID, Password: "wrong"}, "")
+		if he, ok := th.Login(c).(*echo.HTTPError); !ok || he.Message != ErrLoginFailed {
+			t.Fatalf("expected login failure, actual %v", he)
+		}
+	}
+	c, rec := postJSON(e, "/1.0/account/login.json", LoginReq{ID: u.UserID, Password: "wrong"}, "")
+	if he, ok := th.Login(c).(*echo.HTTPError); !ok || he.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected lockout, actual %v", he)
+	}
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// ロック中は正しいパスワードでもログインできない
+	c, _ = postJSON(e, "/1.0/account/login.json", LoginReq{ID: u.UserID, Password: "password"}, "")
+	if he, ok := th.Login(c).(*echo.HTTPError); !ok || he.Message != ErrTooManyAttempts {
+		t.Fatalf("expected lockout, actual %v", he)
+	}
+
+	events, err := th.db.GetEvents(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*events) != 1 || (*events)[0].Type != models.LoginFailedEvent {
+		t.Fatalf("expected LoginFailedEvent, actual %v", events)
+	}
+}
+
+func TestAccountCreateValidation(t *testing.T) {
+	e := echo.New()
+	e.Validator = &CustomValidator{validator: validator.New()}
+
+	fieldErrors := func(req SignupReq) validation.FieldErrors {
+		c, _ := postJSON(e, "/1.0/account/create.json", req, "")
+		he, ok := th.AccountCreate(c).(*echo.HTTPError)
+		if !ok || he.Code != http.StatusBadRequest {
+			t.Fatalf("expected bad request, actual %v", he)
+		}
+		return he.Message.(*fieldErrorResponse).Errors
+	}
+
+	errs := fieldErrors(SignupReq{ID: "Super", Email: "super@example.com", Password: "a"})
+	assert.Equal(t, validation.ErrScreenNameReserved.Error(), errs["id"])
+	assert.NotEmpty(t, errs["password"])
+
+	errs = fieldErrors(SignupReq{ID: "kit.*", Password: "password1"})
+	assert.Equal(t, validation.ErrScreenNameCharset.Error(), errs["id"])
+	assert.Equal(t, "required", errs["email"])
+
+	c, rec := postJSON(e, "/1.0/account/create.json", SignupReq{ID: "CaseKitten", Email: "casekitten@example.com", Password: "password1"}, "")
+	if assert.NoError(t, th.AccountCreate(c)) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+	errs = fieldErrors(SignupReq{ID: "casekitten", Email: "casekitten2@example.com", Password: "password1"})
+	assert.Equal(t, validation.ErrScreenNameTaken.Error(), errs["id"])
+}
@@ -3,10 +3,10 @@ package v1
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/TinyKitten/TimelineServer/models"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo"
 	"go.uber.org/zap"
 	"gopkg.in/mgo.v2/bson"
@@ -14,45 +14,116 @@ import (
 
 type ABasicRequest struct {
 	UserID bson.ObjectId `json:"user_id"`
+	Reason string        `json:"reason"`
 }
 
-// 管理者API　ObjectIDで処理
+type ASuspendRequest struct {
+	UserID bson.ObjectId `json:"user_id"`
+	Reason string        `json:"reason"`
+	Until  time.Time     `json:"until"`
+}
+
+type ARolesRequest struct {
+	UserID bson.ObjectId `json:"user_id"`
+	Roles  []models.Role `json:"roles"`
+	Reason string        `json:"reason"`
+}
+
+// AUserSuspendHandler ユーザを凍結する。untilを指定しない場合は無期限
 func (h *APIHandler) AUserSuspendHandler(c echo.Context) error {
-	user := c.Get("user").(*jwt.Token)
-	claims := user.Claims.(jwt.MapClaims)
-	// 管理者チェック
-	if claims["admin"].(bool) == false {
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrAdminOnly}
-	}
-	req := new(ABasicRequest)
+	req := new(ASuspendRequest)
 	if err := c.Bind(req); err != nil {
 		h.logger.Debug("API Error", zap.String("Error", ErrParamsRequired))
 		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
 	}
+	// 理由はログイン時に本人に表示するため必須
+	if req.Reason == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if !req.Until.IsZero() && !req.Until.After(h.clock.Now()) {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+	}
 
-	u, err := h.db.FindUserByOID(req.UserID, true)
+	u, err := h.db.FindUserByOID(req.UserID, false)
 	if err != nil {
 		h.logger.Debug("API Error", zap.String("Error", ErrParamsRequired))
 		return handleMgoError(err)
 	}
 
-	err = h.db.UpdateUser(req.UserID, "suspended", true)
+	if err := h.suspend(c, u, req.Until, req.Reason); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return h.adminUserResponse(c, u.ID)
+}
+
+// AUnsuspendHandler ユーザの凍結を解除する
+func (h *APIHandler) AUnsuspendHandler(c echo.Context) error {
+	req := new(ABasicRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", ErrParamsRequired))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+
+	u, err := h.db.FindUserByOID(req.UserID, false)
 	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if err := h.db.UnsuspendUser(u.ID); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	h.audit(c, models.AuditUnsuspend, u, req.Reason,
+		bson.M{"suspended": u.IsSuspended(h.clock.Now()), "suspension_reason": u.SuspensionReason}, bson.M{"suspended": false})
+
+	return h.adminUserResponse(c, u.ID)
+}
+
+// AWarnHandler ユーザに警告を通知する
+func (h *APIHandler) AWarnHandler(c echo.Context) error {
+	req := new(ABasicRequest)
+	if err := c.Bind(req); err != nil {
 		h.logger.Debug("API Error", zap.String("Error", ErrParamsRequired))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if req.Reason == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+
+	u, err := h.db.FindUserByOID(req.UserID, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if err := h.warn(c, u, "", req.Reason); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
 		return handleMgoError(err)
 	}
-	resp := models.UserToUserResponse(*u)
 
-	return c.JSON(http.StatusOK, resp)
+	return h.adminUserResponse(c, u.ID)
 }
 
-func (h *APIHandler) ASetOfficialFlag(c echo.Context) error {
-	user := c.Get("user").(*jwt.Token)
-	claims := user.Claims.(jwt.MapClaims)
-	// 管理者チェック
-	if claims["admin"].(bool) == false {
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrAdminOnly}
+// AGetUser 管理者向けの情報を含むユーザ情報を返す
+func (h *APIHandler) AGetUser(c echo.Context) error {
+	id := c.QueryParam("user_id")
+	if !bson.IsObjectIdHex(id) {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	return h.adminUserResponse(c, bson.ObjectIdHex(id))
+}
+
+// adminUserResponse DBから最新のユーザを取得し、管理者向けの情報を含めて返す
+func (h *APIHandler) adminUserResponse(c echo.Context, id bson.ObjectId) error {
+	u, err := h.db.FindUserByOID(id, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
 	}
+	return c.JSON(http.StatusOK, models.UserToAdminUserResponse(*u, h.clock.Now()))
+}
+
+func (h *APIHandler) ASetOfficialFlag(c echo.Context) error {
 
 	oid := bson.ObjectIdHex(c.QueryParam("oid"))
 	flag, err := strconv.ParseBool(c.QueryParam("flag"))
@@ -60,9 +131,81 @@ func (h *APIHandler) ASetOfficialFlag(c echo.Context) error {
 		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrBadFormat}
 	}
 
+	u, err := h.db.FindUserByOID(oid, false)
+	if err != nil {
+		return handleMgoError(err)
+	}
 	if err := h.db.SetOfficial(oid, flag); err != nil {
 		return handleMgoError(err)
 	}
+	h.audit(c, models.AuditSetOfficial, u, c.QueryParam("reason"), bson.M{"official": u.Official}, bson.M{"official": flag})
 
 	return c.JSON(http.StatusOK, &messageResponse{Message: "ok"})
 }
+
+// ADeleteUserHandler 猶予期間を置かずにユーザの全データを削除する
+func (h *APIHandler) ADeleteUserHandler(c echo.Context) error {
+	req := new(ABasicRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", ErrParamsRequired))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+
+	u, err := h.db.FindUserByOID(req.UserID, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	// 途中で失敗しても定期削除で再試行されるよう、先に削除対象にする
+	now := h.clock.Now()
+	if err := h.db.DeactivateUser(u.ID, now, now); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	// 削除後に個人情報が残らないよう、メールアドレスは記録しない
+	h.audit(c, models.AuditDeleteUser, u, req.Reason, bson.M{"id": u.ID.Hex(), "screen_name": u.UserID}, nil)
+	if err := h.purgeAccount(*u); err != nil {
+		h.logger.Error("Failed to purge account", zap.String("Reason", err.Error()))
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+	}
+
+	return c.JSON(http.StatusOK, &messageResponse{Message: RespDeleted})
+}
+
+// ASetRoles ユーザの管理権限を設定する
+func (h *APIHandler) ASetRoles(c echo.Context) error {
+	req := new(ARolesRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", ErrParamsRequired))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	for _, role := range req.Roles {
+		if !role.Valid() {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+		}
+	}
+
+	// 管理者がいなくなるのを防ぐため、自分の管理者権限は外せない
+	if req.UserID == actor(c).ID && !(models.User{Roles: req.Roles}).HasRole(models.RoleAdmin) {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+	}
+
+	before, err := h.db.FindUserByOID(req.UserID, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if err := h.db.SetRoles(req.UserID, req.Roles); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	u, err := h.db.FindUserByOID(req.UserID, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	h.audit(c, models.AuditSetRoles, u, req.Reason, bson.M{"roles": before.Roles}, bson.M{"roles": u.Roles})
+
+	return c.JSON(http.StatusOK, models.UserToAdminUserResponse(*u, h.clock.Now()))
+}
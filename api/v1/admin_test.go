@@ -8,10 +8,12 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/TinyKitten/TimelineServer/config"
 	"github.com/TinyKitten/TimelineServer/models"
 	"github.com/TinyKitten/TimelineServer/token"
+	"github.com/TinyKitten/TimelineServer/utils"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	"github.com/stretchr/testify/assert"
@@ -22,7 +24,7 @@ func TestNormalGrantAccess(t *testing.T) {
 	e := echo.New()
 	dummyObjID := bson.NewObjectId()
 
-	token, err := token.CreateToken(dummyObjID, false)
+	token, err := token.CreateToken(dummyObjID, 0)
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -35,7 +37,7 @@ func TestNormalGrantAccess(t *testing.T) {
 	c := e.NewContext(req, rec)
 	err = middleware.JWTWithConfig(middleware.JWTConfig{
 		SigningKey: []byte(config.MockJwtToken),
-	})(th.AUserSuspendHandler)(c)
+	})(th.RequireRole(models.RoleModerator)(th.AUserSuspendHandler))(c)
 
 	if err == nil {
 		t.Fatal("should reject")
@@ -50,7 +52,7 @@ func TestNormalGrantAccess(t *testing.T) {
 	c = e.NewContext(req, rec)
 	err = middleware.JWTWithConfig(middleware.JWTConfig{
 		SigningKey: []byte(config.MockJwtToken),
-	})(th.ASetOfficialFlag)(c)
+	})(th.RequireRole(models.RoleAdmin)(th.ASetOfficialFlag))(c)
 
 	if err == nil {
 		t.Fatal("should reject")
@@ -62,18 +64,20 @@ func TestUserSuspendHandler(t *testing.T) {
 	e := echo.New()
 
 	u := models.NewUser("susp", "password", "susp@example.com", false)
+	u.Roles = []models.Role{models.RoleModerator}
 	err := th.db.Insert("users", u)
 	if err != nil {
 		t.Error(err)
 	}
 
-	token, err := token.CreateToken(u.ID, true)
+	token, err := token.CreateToken(u.ID, 0)
 	if err != nil {
 		t.Errorf(err.Error())
 	}
 
-	reqParams := ABasicRequest{
+	reqParams := ASuspendRequest{
 		UserID: u.ID,
+		Reason: "spam",
 	}
 	j, err := json.Marshal(reqParams)
 	if err != nil {
@@ -87,14 +91,14 @@ func TestUserSuspendHandler(t *testing.T) {
 	c := e.NewContext(req, rec)
 	err = middleware.JWTWithConfig(middleware.JWTConfig{
 		SigningKey: []byte(config.MockJwtToken),
-	})(th.AUserSuspendHandler)(c)
+	})(th.RequireRole(models.RoleModerator)(th.AUserSuspendHandler))(c)
 
 	u, err = th.db.FindUserByOID(u.ID, true)
 	if err != nil {
 		t.Error(err)
 	}
 
-	if !u.Suspended {
+	if !u.Suspended || u.SuspensionReason != "spam" {
 		t.Fatal("not suspended")
 	}
 
@@ -115,12 +119,13 @@ func TestSetOfficialFlagHandler(t *testing.T) {
 	e := echo.New()
 
 	u := models.NewUser("erai", "password", "erai@example.com", false)
+	u.Roles = []models.Role{models.RoleAdmin}
 	err := th.db.Insert("users", u)
 	if err != nil {
 		t.Error(err)
 	}
 
-	token, err := token.CreateToken(u.ID, true)
+	token, err := token.CreateToken(u.ID, 0)
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -134,7 +139,7 @@ func TestSetOfficialFlagHandler(t *testing.T) {
 	c := e.NewContext(req, rec)
 	err = middleware.JWTWithConfig(middleware.JWTConfig{
 		SigningKey: []byte(config.MockJwtToken),
-	})(th.ASetOfficialFlag)(c)
+	})(th.RequireRole(models.RoleAdmin)(th.ASetOfficialFlag))(c)
 
 	u, err = th.db.FindUserByOID(u.ID, true)
 	if err != nil {
@@ -159,9 +164,124 @@ func TestSetOfficialFlagHandler(t *testing.T) {
 	c = e.NewContext(req, rec)
 	err = middleware.JWTWithConfig(middleware.JWTConfig{
 		SigningKey: []byte(config.MockJwtToken),
-	})(th.ASetOfficialFlag)(c)
+	})(th.RequireRole(models.RoleAdmin)(th.ASetOfficialFlag))(c)
 
 	if err == nil {
 		t.Fatal("No error")
 	}
 }
+
+func TestSetRolesHandler(t *testing.T) {
+	admin := models.NewUser("boss", "password", "boss@example.com", false)
+	admin.Roles = []models.Role{models.RoleAdmin}
+	mod := models.NewUser("helper", "password", "helper@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, admin, mod)
+	adminToken, modToken := sessions[0], sessions[1]
+	handler := jwtMiddleware(th.RequireRole(models.RoleAdmin)(th.ASetRoles))
+
+	c, rec := postJSON(e, "/1.0/super/update_roles.json", ARolesRequest{UserID: mod.ID, Roles: []models.Role{models.RoleModerator}}, adminToken)
+	if assert.NoError(t, handler(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	u, err := th.db.FindUserByOID(mod.ID, false)
+	if assert.NoError(t, err) {
+		assert.True(t, u.HasRole(models.RoleModerator))
+		assert.False(t, u.HasRole(models.RoleAdmin))
+	}
+
+	// モデレーターは凍結できるが権限の変更はできない
+	c, _ = postJSON(e, "/1.0/super/update_roles.json", ARolesRequest{UserID: mod.ID, Roles: []models.Role{models.RoleAdmin}}, modToken)
+	if he, ok := handler(c).(*echo.HTTPError); !ok || he.Code != http.StatusForbidden {
+		t.Fatalf("moderator should be rejected, actual %v", he)
+	}
+
+	// 存在しないロールや自分の管理者権限の削除は拒否する
+	c, _ = postJSON(e, "/1.0/super/update_roles.json", ARolesRequest{UserID: mod.ID, Roles: []models.Role{"root"}}, adminToken)
+	if he, ok := handler(c).(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("unknown role should be rejected, actual %v", he)
+	}
+	c, _ = postJSON(e, "/1.0/super/update_roles.json", ARolesRequest{UserID: admin.ID}, adminToken)
+	if he, ok := handler(c).(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("admin should not demote themselves, actual %v", he)
+	}
+}
+
+func TestTemporarySuspension(t *testing.T) {
+	clock := &utils.FakeClock{Current: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+	th.clock = clock
+	defer func() { th.clock = utils.NewClock() }()
+
+	mod := models.NewUser("tempmod", "", "tempmod@example.com", false)
+	mod.Roles = []models.Role{models.RoleModerator}
+	hashed, err := utils.HashPassword("password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := models.NewUser("naughty", hashed, "naughty@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, mod, u)
+	moderate := func(h echo.HandlerFunc, body interface{}) (*httptest.ResponseRecorder, error) {
+		c, rec := postJSON(e, "/1.0/super/moderate.json", body, sessions[0])
+		return rec, jwtMiddleware(th.RequireRole(models.RoleModerator)(h))(c)
+	}
+
+	until := clock.Now().Add(24 * time.Hour)
+	rec, err := moderate(th.AUserSuspendHandler, ASuspendRequest{UserID: u.ID, Reason: "flooding", Until: until})
+	if assert.NoError(t, err) {
+		resp := models.UserResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if assert.NotNil(t, resp.Moderation) {
+			assert.True(t, resp.Moderation.Suspended)
+			assert.Equal(t, "flooding", resp.Moderation.SuspensionReason)
+			assert.True(t, until.Equal(*resp.Moderation.SuspendedUntil))
+		}
+	}
+
+	// ログイン時に理由と期限を表示する
+	c, _ := postJSON(e, "/1.0/account/login.json", LoginReq{ID: "naughty", Password: "password1"}, "")
+	he, ok := th.Login(c).(*echo.HTTPError)
+	if !ok || he.Code != http.StatusForbidden {
+		t.Fatalf("suspended user should not log in, actual %v", he)
+	}
+	if msg, ok := he.Message.(*models.SuspendedResponse); !ok || msg.Reason != "flooding" || !until.Equal(*msg.Until) {
+		t.Fatalf("unexpected message: %v", he.Message)
+	}
+
+	// 期限を過ぎると自動で解除される
+	clock.Advance(24 * time.Hour)
+	c, rec = postJSON(e, "/1.0/account/login.json", LoginReq{ID: "naughty", Password: "password1"}, "")
+	if assert.NoError(t, th.Login(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	if err := th.LiftExpiredSuspensions(); err != nil {
+		t.Fatal(err)
+	}
+	lifted, err := th.db.FindUserByOID(u.ID, true)
+	if assert.NoError(t, err) {
+		assert.False(t, lifted.Suspended)
+	}
+
+	// 警告は通知とカウントのみ
+	rec, err = moderate(th.AWarnHandler, ABasicRequest{UserID: u.ID, Reason: "be nice"})
+	if assert.NoError(t, err) {
+		assert.Contains(t, rec.Body.String(), `"warning_count":1`)
+	}
+	events, err := th.db.GetEvents(u.ID)
+	if assert.NoError(t, err) && assert.Len(t, *events, 1) {
+		assert.Equal(t, models.WarnedEvent, (*events)[0].Type)
+		assert.Equal(t, "be nice", (*events)[0].Message)
+	}
+
+	// 無期限の凍結は解除エンドポイントで解除する
+	_, err = moderate(th.AUserSuspendHandler, ASuspendRequest{UserID: u.ID, Reason: "again"})
+	assert.NoError(t, err)
+	rec, err = moderate(th.AUnsuspendHandler, ABasicRequest{UserID: u.ID, Reason: "appeal accepted"})
+	if assert.NoError(t, err) {
+		assert.Contains(t, rec.Body.String(), `"suspended":false`)
+	}
+	logs, err := th.db.FindAuditLogs(models.AuditFilter{TargetID: u.ID, Action: models.AuditUnsuspend}, 0, 10)
+	if assert.NoError(t, err) {
+		assert.Len(t, logs, 1)
+	}
+}
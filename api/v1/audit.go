@@ -0,0 +1,99 @@
+package v1
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// auditDefaultLimit 監査ログの既定の取得件数
+	auditDefaultLimit = 50
+	// auditMaxLimit 監査ログの最大取得件数
+	auditMaxLimit = 200
+)
+
+// audit 操作者の操作を監査ログに残す。targetは対象がユーザでない場合nil
+// 操作は既に完了しているため、記録に失敗してもエラーログのみ出す
+func (h *APIHandler) audit(c echo.Context, action models.AuditAction, target *models.User, reason string, before, after bson.M) {
+	log := models.AuditLog{
+		ID:        bson.NewObjectId(),
+		Action:    action,
+		Reason:    reason,
+		Before:    before,
+		After:     after,
+		CreatedAt: h.clock.Now(),
+	}
+	if a := actor(c); a != nil {
+		log.ActorID = a.ID
+		log.ActorName = a.UserID
+	}
+	if target != nil {
+		log.TargetID = target.ID
+		log.TargetName = target.UserID
+	}
+
+	if err := h.db.InsertAuditLog(log); err != nil {
+		h.logger.Error("Failed to write audit log",
+			zap.String("Action", string(action)),
+			zap.String("Actor", log.ActorID.Hex()),
+			zap.String("Reason", err.Error()))
+	}
+}
+
+// GetAuditLogs 監査ログを検索する
+// actor_id, target_id, action, since, until(RFC3339)で絞り込み、limit, cursorでページングする
+func (h *APIHandler) GetAuditLogs(c echo.Context) error {
+	filter := models.AuditFilter{Action: models.AuditAction(c.QueryParam("action"))}
+
+	for _, p := range []struct {
+		name string
+		dst  *bson.ObjectId
+	}{
+		{"actor_id", &filter.ActorID},
+		{"target_id", &filter.TargetID},
+	} {
+		v := c.QueryParam(p.name)
+		if v == "" {
+			continue
+		}
+		if !bson.IsObjectIdHex(v) {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+		}
+		*p.dst = bson.ObjectIdHex(v)
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		v := c.QueryParam(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+		}
+		*p.dst = t
+	}
+
+	limit, cursor, err := parsePage(c, "limit", auditDefaultLimit, auditMaxLimit)
+	if err != nil {
+		return err
+	}
+
+	logs, err := h.db.FindAuditLogs(filter, cursor, limit)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return c.JSON(http.StatusOK, logs)
+}
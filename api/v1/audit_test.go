@@ -0,0 +1,67 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	clock := &utils.FakeClock{Current: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+	th.clock = clock
+	defer func() { th.clock = utils.NewClock() }()
+
+	mod := models.NewUser("auditor", "password", "auditor@example.com", false)
+	mod.Roles = []models.Role{models.RoleModerator}
+	target := models.NewUser("audited", "password", "audited@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, mod, target)
+	session := sessions[0]
+
+	c, rec := postJSON(e, "/1.0/super/update_suspend.json", ABasicRequest{UserID: target.ID, Reason: "spam"}, session)
+	if assert.NoError(t, jwtMiddleware(th.RequireRole(models.RoleModerator)(th.AUserSuspendHandler))(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	query := func(q url.Values) []models.AuditLog {
+		req := httptest.NewRequest(echo.GET, "/1.0/super/audit.json?"+q.Encode(), nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+session)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		if err := jwtMiddleware(th.RequireRole(models.RoleSupport)(th.GetAuditLogs))(c); err != nil {
+			t.Fatal(err)
+		}
+		logs := []models.AuditLog{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &logs); err != nil {
+			t.Fatal(err)
+		}
+		return logs
+	}
+
+	logs := query(url.Values{"target_id": {target.ID.Hex()}, "action": {string(models.AuditSuspend)}})
+	if assert.Len(t, logs, 1) {
+		assert.Equal(t, mod.ID, logs[0].ActorID)
+		assert.Equal(t, "audited", logs[0].TargetName)
+		assert.Equal(t, "spam", logs[0].Reason)
+		assert.Equal(t, false, logs[0].Before["suspended"])
+		assert.Equal(t, true, logs[0].After["suspended"])
+	}
+
+	// 期間外は含まない
+	since := clock.Now().Add(time.Minute).Format(time.RFC3339)
+	assert.Len(t, query(url.Values{"actor_id": {mod.ID.Hex()}, "since": {since}}), 0)
+
+	req := httptest.NewRequest(echo.GET, "/1.0/super/audit.json?since=yesterday", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+session)
+	c = e.NewContext(req, httptest.NewRecorder())
+	if he, ok := jwtMiddleware(th.RequireRole(models.RoleSupport)(th.GetAuditLogs))(c).(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("invalid date should be rejected, actual %v", he)
+	}
+}
@@ -0,0 +1,158 @@
+package v1
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/imaging"
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+)
+
+const (
+	// blobSweepInterval 参照がなくなったファイルを削除する間隔
+	blobSweepInterval = time.Hour
+	// blobSweepBatch 1回の掃除で削除するファイルの最大数
+	blobSweepBatch = 500
+	// unattachedMediaTTL ポストに添付されないメディアを削除するまでの期間
+	unattachedMediaTTL = 24 * time.Hour
+	// blobRetainBackoff 削除中のファイルと同じ内容を保存する場合に、削除を待って再試行するまでの待ち時間
+	blobRetainBackoff = 100 * time.Millisecond
+	// blobRetainAttempts 削除中のファイルと同じ内容を保存する場合に、削除を待つ最大の試行回数
+	blobRetainAttempts = 20
+	// blobDeletingTimeout 削除中のまま残ったファイルを、掃除が途中で止まったものとみなして戻すまでの期間
+	blobDeletingTimeout = 10 * time.Minute
+)
+
+// errBlobBusy 同じ内容のファイルの削除が終わるのを待ちきれなかった
+var errBlobBusy = errors.New("blob is being deleted")
+
+// contentKey 内容のハッシュから保存先のキーを返す
+func contentKey(dat []byte, format string) string {
+	sum := sha256.Sum256(dat)
+	return hex.EncodeToString(sum[:]) + imaging.Ext(format)
+}
+
+// storeBlob ファイルの参照を増やしてから保存先に書き出す。filesには少なくともkeyを含める
+// 同じ内容のファイルは同じキーに上書きされるので、保存先には一つだけ残る
+func (h *APIHandler) storeBlob(key string, files map[string]storedFile) error {
+	blob := &models.Blob{Key: key, CreatedAt: h.clock.Now()}
+	for k, f := range files {
+		blob.Files = append(blob.Files, k)
+		blob.Size += int64(len(f.data))
+	}
+
+	// 削除中の場合は、掃除が記録を消すか削除中から戻すまで待つ。待ちきれなければerrBlobBusyを返す
+	// 同じ内容を同時に初めて保存した場合も重複エラーになるが、再試行すれば参照を増やせる
+	if err := h.retainBlob(blob); err != nil {
+		return err
+	}
+	if err := h.putFiles(files); err != nil {
+		h.releaseBlob(key)
+		return err
+	}
+	return nil
+}
+
+// retainBlob ファイルの参照を増やす。削除中で重複エラーになる間は最大blobRetainAttempts回まで再試行する
+func (h *APIHandler) retainBlob(blob *models.Blob) error {
+	for i := 0; i < blobRetainAttempts; i++ {
+		if i > 0 {
+			time.Sleep(blobRetainBackoff)
+		}
+		err := h.db.RetainBlob(blob)
+		if err == nil || !mgo.IsDup(err) {
+			return err
+		}
+	}
+	return errBlobBusy
+}
+
+// storeBlobError storeBlobのエラーをレスポンスに変換する
+// 同じ内容のファイルを削除している途中なら、時間をおいて再試行できるよう503を返す
+func storeBlobError(err error) *echo.HTTPError {
+	if err == errBlobBusy {
+		return &echo.HTTPError{Code: http.StatusServiceUnavailable, Message: ErrBlobBusy}
+	}
+	return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+}
+
+// releaseBlob ファイルの参照を外す。ファイルは掃除で削除する
+func (h *APIHandler) releaseBlob(key string) {
+	if err := h.db.ReleaseBlob(key); err != nil && err != mgo.ErrNotFound {
+		h.logger.Error("Failed to release blob", zap.String("Key", key), zap.String("Reason", err.Error()))
+	}
+}
+
+// StartBlobSweeper 参照がなくなったファイルを定期的に削除する
+func (h *APIHandler) StartBlobSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := h.SweepBlobs(); err != nil {
+				h.logger.Error("Failed to sweep blobs", zap.String("Reason", err.Error()))
+			}
+		}
+	}()
+}
+
+// SweepBlobs 添付されないまま期限を過ぎたメディアを削除し、参照がなくなったファイルを保存先から削除する
+// 削除に失敗したファイルや、前回の掃除が途中で止まって削除中のまま残ったファイルは次回に再試行する
+func (h *APIHandler) SweepBlobs() error {
+	now := h.clock.Now()
+	recovered, err := h.db.RecoverStaleBlobs(now.Add(-blobDeletingTimeout))
+	if err != nil {
+		return err
+	}
+	if recovered > 0 {
+		h.logger.Info("Recovered stale blobs", zap.Int("Count", recovered))
+	}
+
+	media, err := h.db.FindUnattachedMedia(now.Add(-unattachedMediaTTL))
+	if err != nil {
+		return err
+	}
+	for _, m := range media {
+		if err := h.db.RemoveUnattachedMedia(m); err != nil && err != mgo.ErrNotFound {
+			h.logger.Error("Failed to remove media", zap.String("ID", m.ID.Hex()), zap.String("Reason", err.Error()))
+		}
+	}
+
+	blobs, err := h.db.FindUnreferencedBlobs(blobSweepBatch)
+	if err != nil {
+		return err
+	}
+	for _, b := range blobs {
+		if err := h.db.MarkBlobDeleting(b.Key, h.clock.Now()); err != nil {
+			// 参照し直されたものや、別の掃除が削除しているものは残す
+			if err != mgo.ErrNotFound {
+				h.logger.Error("Failed to mark blob", zap.String("Key", b.Key), zap.String("Reason", err.Error()))
+			}
+			continue
+		}
+		removed := true
+		for _, key := range b.Files {
+			if err := h.storage.Delete(key); err != nil {
+				h.logger.Error("Failed to remove file", zap.String("Key", key), zap.String("Reason", err.Error()))
+				removed = false
+			}
+		}
+		if !removed {
+			// 同じ内容の保存を待たせ続けないよう、削除中から戻して次回に再試行する
+			if err := h.db.UnmarkBlobDeleting(b.Key); err != nil {
+				h.logger.Error("Failed to unmark blob", zap.String("Key", b.Key), zap.String("Reason", err.Error()))
+			}
+			continue
+		}
+		if err := h.db.RemoveBlob(b.Key); err != nil {
+			h.logger.Error("Failed to remove blob", zap.String("Key", b.Key), zap.String("Reason", err.Error()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package v1
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+)
+
+func TestBlobDedupAndSweep(t *testing.T) {
+	clock := &utils.FakeClock{Current: time.Now()}
+	th.clock = clock
+	defer func() { th.clock = utils.NewClock() }()
+
+	u := models.NewUser("deduper", "", "deduper@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, u)
+	session := sessions[0]
+
+	upload := func(dat []byte) *models.Media {
+		c, rec := uploadRequest(e, dat, "", session)
+		if err := jwtMiddleware(th.UploadMedia)(c); err != nil {
+			t.Fatal(err)
+		}
+		media := new(models.Media)
+		if err := json.Unmarshal(rec.Body.Bytes(), media); err != nil {
+			t.Fatal(err)
+		}
+		return media
+	}
+	avatar := func(dat []byte) string {
+		req := AccountImageRequest{Image: "data:image/png;base64," + base64.StdEncoding.EncodeToString(dat)}
+		c, rec := postJSON(e, "/1.0/account/update_profile_image.json", req, session)
+		if err := jwtMiddleware(th.UpdateAccountProfileImage)(c); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		resp := new(models.UserResponse)
+		if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatal(err)
+		}
+		key, ok := testStorage.Key(resp.AvatarURL)
+		if !ok {
+			t.Fatalf("avatar should be stored: %s", resp.AvatarURL)
+		}
+		return key
+	}
+	exists := func(key string) bool {
+		f, err := testStorage.Open(key)
+		if err != nil {
+			return false
+		}
+		f.Close()
+		return true
+	}
+
+	// 同じ内容の画像は一つのファイルにまとめる
+	first, second := upload(testPNG(7, 5)), upload(testPNG(7, 5))
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Equal(t, first.URL, second.URL)
+	mediaKey, _ := testStorage.Key(first.URL)
+	blob, err := th.db.FindBlob(mediaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, blob.Refs)
+	assert.Equal(t, 3, len(blob.Files))
+	// 後から増えたファイルも記録に加える
+	assert.NoError(t, th.db.RetainBlob(&models.Blob{Key: mediaKey, Files: []string{mediaKey, "extra.png"}}))
+	if blob, err := th.db.FindBlob(mediaKey); assert.NoError(t, err) {
+		assert.Equal(t, 3, blob.Refs)
+		assert.Equal(t, 4, len(blob.Files))
+	}
+	assert.NoError(t, th.db.ReleaseBlob(mediaKey))
+	// 添付されたメディアは掃除で消えない
+	assert.NoError(t, th.db.AttachMedia(first.ID, u.ID, models.NewPost(u.ID, "", "photo").ID))
+
+	// プロフィール画像を差し替えると以前の画像の参照が外れる
+	oldAvatar := avatar(testPNG(10, 10))
+	newAvatar := avatar(testPNG(12, 12))
+	assert.NotEqual(t, oldAvatar, newAvatar)
+	if blob, err := th.db.FindBlob(oldAvatar); assert.NoError(t, err) {
+		assert.Equal(t, 0, blob.Refs)
+	}
+
+	clock.Advance(unattachedMediaTTL + time.Minute)
+	assert.NoError(t, th.SweepBlobs())
+
+	// 添付されなかったメディアは削除され、参照が一つ残った画像は残る
+	if _, err := th.db.FindMedia(second.ID); err != mgo.ErrNotFound {
+		t.Fatalf("unattached media should be removed, actual %v", err)
+	}
+	if blob, err := th.db.FindBlob(mediaKey); assert.NoError(t, err) {
+		assert.Equal(t, 1, blob.Refs)
+	}
+	assert.True(t, exists(mediaKey))
+	// 参照がなくなったプロフィール画像は縮小版も含めて削除される
+	if _, err := th.db.FindBlob(oldAvatar); err != mgo.ErrNotFound {
+		t.Fatalf("unreferenced blob should be removed, actual %v", err)
+	}
+	assert.False(t, exists(oldAvatar))
+	assert.True(t, exists(newAvatar))
+
+	// 退会したユーザの画像も参照がなくなれば削除される
+	assert.NoError(t, th.purgeAccount(*u))
+	assert.NoError(t, th.SweepBlobs())
+	for _, key := range []string{mediaKey, newAvatar} {
+		assert.False(t, exists(key))
+		if _, err := th.db.FindBlob(key); err != mgo.ErrNotFound {
+			t.Fatalf("%s should be removed, actual %v", key, err)
+		}
+	}
+}
+
+func TestBlobStaleDeletingRecovery(t *testing.T) {
+	clock := &utils.FakeClock{Current: time.Now()}
+	th.clock = clock
+	defer func() { th.clock = utils.NewClock() }()
+
+	u := models.NewUser("staledeleter", "", "staledeleter@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, u)
+	// avatar プロフィール画像を差し替え、ステータスコードを返す
+	avatar := func(dat []byte) int {
+		req := AccountImageRequest{Image: "data:image/png;base64," + base64.StdEncoding.EncodeToString(dat)}
+		c, rec := postJSON(e, "/1.0/account/update_profile_image.json", req, sessions[0])
+		err := jwtMiddleware(th.UpdateAccountProfileImage)(c)
+		if he, ok := err.(*echo.HTTPError); ok {
+			return he.Code
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code
+	}
+	avatarKey := func() string {
+		user, err := th.db.FindUserByOID(u.ID, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.AvatarKey
+	}
+	assert.Equal(t, http.StatusCreated, avatar(testPNG(14, 14)))
+	old := avatarKey()
+	assert.Equal(t, http.StatusCreated, avatar(testPNG(16, 16)))
+	assert.NotEqual(t, old, avatarKey())
+
+	// 掃除が削除中にしたまま止まった状態にする
+	assert.NoError(t, th.db.MarkBlobDeleting(old, clock.Now()))
+	// 削除中のままの間は、同じ内容を保存しようとしても待ちきれずに503を返す
+	assert.Equal(t, http.StatusServiceUnavailable, avatar(testPNG(14, 14)))
+	// 期限内は別の掃除が削除している途中とみなして触らない
+	assert.NoError(t, th.SweepBlobs())
+	if blob, err := th.db.FindBlob(old); assert.NoError(t, err) {
+		assert.True(t, blob.Deleting)
+	}
+
+	// 期限を過ぎると削除中から戻し、参照がなければそのまま削除する
+	clock.Advance(blobDeletingTimeout + time.Minute)
+	assert.NoError(t, th.SweepBlobs())
+	if _, err := th.db.FindBlob(old); err != mgo.ErrNotFound {
+		t.Fatalf("stale blob should be removed, actual %v", err)
+	}
+	// 削除が終われば同じ内容を再び保存できる
+	assert.Equal(t, http.StatusCreated, avatar(testPNG(14, 14)))
+}
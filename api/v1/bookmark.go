@@ -0,0 +1,116 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// bookmarkDefaultLimit ブックマーク一覧の既定の取得件数
+	bookmarkDefaultLimit = 20
+	// bookmarkMaxLimit ブックマーク一覧の最大取得件数
+	bookmarkMaxLimit = 100
+)
+
+type (
+	BookmarkRequest struct {
+		PostID string `json:"id" validate:"required"`
+	}
+)
+
+// CreateBookmark ポストをブックマークする。いいねと違い投稿者には通知しない
+func (h *APIHandler) CreateBookmark(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	id := bson.ObjectIdHex(claims["id"].(string))
+
+	postID, err := h.bindBookmark(c)
+	if err != nil {
+		return err
+	}
+	resp, err := h.bookmarkedPost(postID)
+	if err != nil {
+		return err
+	}
+	if err := h.db.CreateBookmark(id, postID, h.clock.Now()); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// DestroyBookmark ブックマークを外す
+func (h *APIHandler) DestroyBookmark(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	id := bson.ObjectIdHex(claims["id"].(string))
+
+	postID, err := h.bindBookmark(c)
+	if err != nil {
+		return err
+	}
+	if err := h.db.DestroyBookmark(id, postID); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	resp, err := h.bookmarkedPost(postID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// GetBookmarks ブックマークしたポストをブックマークした日時の新しい順に返す
+// ページングはlimit, cursor(オフセット)で指定する
+func (h *APIHandler) GetBookmarks(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	id := bson.ObjectIdHex(claims["id"].(string))
+
+	limit, cursor, err := parsePage(c, "limit", bookmarkDefaultLimit, bookmarkMaxLimit)
+	if err != nil {
+		return err
+	}
+	posts, err := h.db.GetBookmarkedPosts(id, cursor, limit)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	resp, err := h.postsToResponse(posts)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &resp)
+}
+
+// bindBookmark リクエストからポストのIDを取り出す
+func (h *APIHandler) bindBookmark(c echo.Context) (bson.ObjectId, error) {
+	req := new(BookmarkRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return "", &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if !bson.IsObjectIdHex(req.PostID) {
+		return "", &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	return bson.ObjectIdHex(req.PostID), nil
+}
+
+// bookmarkedPost ポストと投稿者を取得してレスポンスに変換する
+func (h *APIHandler) bookmarkedPost(postID bson.ObjectId) (*models.PostResponse, error) {
+	post, err := h.db.FindPost(postID, true)
+	if err != nil {
+		return nil, handleMgoError(err)
+	}
+	author, err := h.db.FindUserByOID(post.UserID, true)
+	if err != nil {
+		return nil, handleMgoError(err)
+	}
+	resp := models.PostToPostResponse(*post, *author)
+	return &resp, nil
+}
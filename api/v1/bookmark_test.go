@@ -0,0 +1,80 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestBookmarks(t *testing.T) {
+	reader := models.NewUser("bookmarker", "", "bookmarker@example.com", false)
+	author := models.NewUser("bookmarked", "", "bookmarked@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, reader, author)
+	session := sessions[0]
+	posts := []*models.Post{}
+	for i := 0; i < 3; i++ {
+		p := models.NewPost(author.ID, "", fmt.Sprintf("bookmark me %d", i))
+		if err := th.db.Insert("posts", p); err != nil {
+			t.Fatal(err)
+		}
+		posts = append(posts, p)
+	}
+
+	call := func(handler echo.HandlerFunc, path string, postID bson.ObjectId) error {
+		c, _ := postJSON(e, path, BookmarkRequest{PostID: postID.Hex()}, session)
+		return jwtMiddleware(handler)(c)
+	}
+	list := func(v url.Values) []bson.ObjectId {
+		req := httptest.NewRequest(echo.GET, "/1.0/bookmarks/list.json?"+v.Encode(), nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+session)
+		rec := httptest.NewRecorder()
+		if err := jwtMiddleware(th.GetBookmarks)(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		resp := []models.PostResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		ids := []bson.ObjectId{}
+		for _, p := range resp {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+
+	for _, p := range posts {
+		assert.NoError(t, call(th.CreateBookmark, "/1.0/bookmarks/create.json", p.ID))
+	}
+	if he, ok := call(th.CreateBookmark, "/1.0/bookmarks/create.json", posts[0].ID).(*echo.HTTPError); !ok || he.Code != http.StatusConflict {
+		t.Fatalf("expected conflict, actual %v", he)
+	}
+	if he, ok := call(th.CreateBookmark, "/1.0/bookmarks/create.json", bson.NewObjectId()).(*echo.HTTPError); !ok || he.Code != http.StatusNotFound {
+		t.Fatalf("expected not found, actual %v", he)
+	}
+
+	// 投稿者にはいいねと違って何も残らない
+	stored, _ := th.db.FindPost(posts[0].ID, false)
+	assert.Empty(t, stored.FavoritedIds)
+	events, _ := th.db.GetEvents(author.ID)
+	assert.Empty(t, *events)
+
+	// 新しくブックマークした順
+	assert.Equal(t, []bson.ObjectId{posts[2].ID, posts[1].ID, posts[0].ID}, list(url.Values{}))
+	assert.Equal(t, []bson.ObjectId{posts[1].ID}, list(url.Values{"limit": {"1"}, "cursor": {"1"}}))
+
+	// 削除されたポストと外したブックマークは一覧から消える
+	assert.NoError(t, th.db.DeletePost(posts[1].ID))
+	assert.NoError(t, call(th.DestroyBookmark, "/1.0/bookmarks/destroy.json", posts[2].ID))
+	assert.Equal(t, []bson.ObjectId{posts[0].ID}, list(url.Values{}))
+	if he, ok := call(th.DestroyBookmark, "/1.0/bookmarks/destroy.json", posts[2].ID).(*echo.HTTPError); !ok || he.Code != http.StatusNotFound {
+		t.Fatalf("expected not found, actual %v", he)
+	}
+}
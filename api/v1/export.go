@@ -0,0 +1,265 @@
+package v1
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/config"
+	"github.com/TinyKitten/TimelineServer/export"
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/token"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// exportCleanInterval 期限切れのアーカイブを削除する間隔
+const exportCleanInterval = time.Hour
+
+type (
+	ExportJobResponse struct {
+		ID          bson.ObjectId       `json:"id"`
+		Status      models.ExportStatus `json:"status"`
+		CreatedAt   time.Time           `json:"created_at"`
+		CompletedAt *time.Time          `json:"completed_at,omitempty"`
+		ExpiresAt   *time.Time          `json:"expires_at,omitempty"`
+		DownloadURL string              `json:"download_url,omitempty"`
+	}
+)
+
+// StartExport データエクスポートを開始する。アーカイブは非同期に作成される
+func (h *APIHandler) StartExport(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	u, err := h.db.FindUserByOID(id, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	job, err := h.db.CreateExportJob(id, h.clock.Now())
+	if mgo.IsDup(err) {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: ErrExportRunning}
+	}
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	go h.runExport(*job, *u)
+
+	resp, err := h.exportJobResponse(job)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusAccepted, resp)
+}
+
+// GetExport 最新のデータエクスポートの状態を返す。完了していればダウンロード用のURLを含む
+func (h *APIHandler) GetExport(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	job, err := h.db.FindLatestExportJob(id)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	resp, err := h.exportJobResponse(job)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// DownloadExport 期限付きのリンクからアーカイブをダウンロードする
+func (h *APIHandler) DownloadExport(c echo.Context) error {
+	jobID, err := token.ParseExportDownloadToken(c.QueryParam("token"), h.clock.Now())
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrInvalidLink}
+	}
+
+	job, err := h.db.FindExportJob(jobID)
+	if err == mgo.ErrNotFound {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrInvalidLink}
+	}
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if job.Status != models.ExportDone || !h.clock.Now().Before(job.ExpiresAt) {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrInvalidLink}
+	}
+
+	return c.Attachment(job.Path, "timeline-export-"+job.CreatedAt.Format("20060102")+".zip")
+}
+
+// StartExportCleaner ダウンロード期限を過ぎたアーカイブを定期的に削除する
+func (h *APIHandler) StartExportCleaner(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := h.CleanExpiredExports(); err != nil {
+				h.logger.Error("Failed to clean exports", zap.String("Reason", err.Error()))
+			}
+		}
+	}()
+}
+
+// CleanExpiredExports ダウンロード期限を過ぎたアーカイブとジョブを削除する
+func (h *APIHandler) CleanExpiredExports() error {
+	jobs, err := h.db.FindExpiredExportJobs(h.clock.Now())
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		h.removeExport(job)
+	}
+	return nil
+}
+
+// runExport アーカイブを作成してジョブを完了させる
+func (h *APIHandler) runExport(job models.ExportJob, u models.User) {
+	path, err := h.writeExport(job, u)
+	if err != nil {
+		h.logger.Error("Failed to export", zap.String("ID", job.ID.Hex()), zap.String("Reason", err.Error()))
+		if err := h.db.FailExportJob(job.ID, h.clock.Now()); err != nil {
+			h.logger.Error("Failed to update export job", zap.String("Reason", err.Error()))
+		}
+		return
+	}
+
+	now := h.clock.Now()
+	if err := h.db.FinishExportJob(job.ID, path, now, now.Add(token.ExportDownloadTTL)); err != nil {
+		h.logger.Error("Failed to update export job", zap.String("Reason", err.Error()))
+		os.Remove(path)
+	}
+}
+
+func (h *APIHandler) writeExport(job models.ExportJob, u models.User) (string, error) {
+	a := export.Archive{
+		Profile: export.NewProfile(u),
+		Files:   map[string]string{},
+		Open:    h.storage.Open,
+	}
+
+	var err error
+	if a.Posts, err = h.db.GetPostsByUser(u.ID); err != nil {
+		return "", err
+	}
+	if a.Likes, err = h.db.GetLikedPosts(u.ID); err != nil {
+		return "", err
+	}
+	if a.Bookmarks, err = h.db.GetBookmarkedPosts(u.ID, 0, 0); err != nil {
+		return "", err
+	}
+	following, err := h.db.FindUserByOIDArray(u.Following, false)
+	if err != nil {
+		return "", err
+	}
+	a.Following = models.UsersToUserResponseArray(following)
+	followers, err := h.db.FindUserByOIDArray(u.Followers, false)
+	if err != nil {
+		return "", err
+	}
+	a.Followers = models.UsersToUserResponseArray(followers)
+	events, err := h.db.GetEvents(u.ID)
+	if err != nil {
+		return "", err
+	}
+	a.Events = *events
+	if a.ScreenNameHistory, err = h.db.GetScreenNameHistory(u.ID); err != nil {
+		return "", err
+	}
+	if a.Reports, err = h.db.GetReportsByReporter(u.ID); err != nil {
+		return "", err
+	}
+	for i := range a.Reports {
+		// 対応したモデレーターとそのメモは含めない
+		a.Reports[i].ClaimedBy, a.Reports[i].ClosedBy, a.Reports[i].Note = "", "", ""
+	}
+	if a.Official, err = h.db.GetOfficialApplicationsByUser(u.ID); err != nil {
+		return "", err
+	}
+	for i := range a.Official {
+		a.Official[i].ReviewedBy = ""
+	}
+	if a.Lists, err = h.db.GetListsByOwner(u.ID, false); err != nil {
+		return "", err
+	}
+	for i := range a.Lists {
+		// 購読者は他のユーザの情報なので含めない
+		a.Lists[i].Subscribers = []bson.ObjectId{}
+	}
+	for _, key := range append(h.avatarKeys(u.AvatarURL), bannerKeys(u)...) {
+		a.Files["media/"+key] = key
+	}
+	media, err := h.db.GetMediaByUser(u.ID)
+	if err != nil {
+		return "", err
+	}
+	for _, m := range media {
+		for _, key := range m.Keys() {
+			a.Files["media/"+key] = key
+		}
+	}
+
+	dir := config.GetExportPath()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, job.ID.Hex()+".zip")
+	return path, export.WriteFile(path, a)
+}
+
+// removeExport アーカイブとジョブを削除する
+func (h *APIHandler) removeExport(job models.ExportJob) {
+	if job.Path != "" {
+		if err := os.Remove(job.Path); err != nil && !os.IsNotExist(err) {
+			h.logger.Error("Failed to remove file", zap.String("Reason", err.Error()))
+			return
+		}
+	}
+	if err := h.db.RemoveExportJob(job.ID); err != nil {
+		h.logger.Error("Failed to remove export job", zap.String("Reason", err.Error()))
+	}
+}
+
+func (h *APIHandler) exportJobResponse(job *models.ExportJob) (*ExportJobResponse, error) {
+	resp := &ExportJobResponse{
+		ID:        job.ID,
+		Status:    job.Status,
+		CreatedAt: job.CreatedAt,
+	}
+	if !job.CompletedAt.IsZero() {
+		resp.CompletedAt = &job.CompletedAt
+	}
+	if job.Status != models.ExportDone {
+		return resp, nil
+	}
+
+	resp.ExpiresAt = &job.ExpiresAt
+	if !h.clock.Now().Before(job.ExpiresAt) {
+		return resp, nil
+	}
+	t, err := token.CreateExportDownloadToken(job.ID, job.ExpiresAt)
+	if err != nil {
+		h.logger.Error("Failed to create download token", zap.String("Reason", err.Error()))
+		return nil, &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+	}
+	resp.DownloadURL = apiURL("/account/export/download.json", url.Values{"token": {t}})
+	return resp, nil
+}
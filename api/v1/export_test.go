@@ -0,0 +1,108 @@
+package v1
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestExport(t *testing.T) {
+	// キャッシュにないユーザや削除済みのユーザをフォローしていても書き出せる
+	friend := models.NewUser("exported_friend", "", "exported_friend@example.com", false)
+	u := models.NewUser("exporter", "", "exporter@example.com", false)
+	u.Following = []bson.ObjectId{friend.ID, bson.NewObjectId()}
+	u.Followers = []bson.ObjectId{friend.ID}
+	e, jwtMiddleware, sessions := setupTest(t, friend, u)
+	session := sessions[1]
+	if err := th.db.UpdatePost(*models.NewPost(u.ID, "", "export me")); err != nil {
+		t.Fatal(err)
+	}
+
+	c, rec := postJSON(e, "/1.0/account/export.json", nil, session)
+	if assert.NoError(t, jwtMiddleware(th.StartExport)(c)) {
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+	}
+
+	// 完了を待つ
+	job := ExportJobResponse{}
+	for i := 0; i < 50; i++ {
+		req := httptest.NewRequest(echo.GET, "/1.0/account/export.json", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+session)
+		rec = httptest.NewRecorder()
+		if err := jwtMiddleware(th.GetExport)(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != models.ExportRunning {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if job.Status != models.ExportDone {
+		t.Fatalf("export should be done, actual %s", job.Status)
+	}
+
+	link, err := url.Parse(job.DownloadURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(echo.GET, "/1.0/account/export/download.json?"+link.RawQuery, nil)
+	rec = httptest.NewRecorder()
+	if assert.NoError(t, th.DownloadExport(e.NewContext(req, rec))) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "PK", rec.Body.String()[:2])
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range archive.File {
+		if f.Name != "following.json" && f.Name != "followers.json" {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dat, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		users := []models.UserResponse{}
+		if err := json.Unmarshal(dat, &users); err != nil {
+			t.Fatal(err)
+		}
+		if assert.Equal(t, 1, len(users), f.Name) {
+			assert.Equal(t, friend.UserID, users[0].UserID, f.Name)
+		}
+	}
+}
+
+func TestExportAlreadyRunning(t *testing.T) {
+	u := models.NewUser("impatient", "", "impatient@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, u)
+
+	if _, err := th.db.CreateExportJob(u.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := postJSON(e, "/1.0/account/export.json", nil, sessions[0])
+	err := jwtMiddleware(th.StartExport)(c)
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != http.StatusConflict {
+		t.Fatalf("second export should be rejected, actual %v", err)
+	}
+}
@@ -3,7 +3,6 @@ package v1
 import (
 	"net/http"
 
-	"github.com/TinyKitten/TimelineServer/config"
 	"github.com/TinyKitten/TimelineServer/models"
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo"
@@ -33,7 +32,7 @@ func (h *APIHandler) Follow(c echo.Context) error {
 	}
 
 	if req.DisplayName != "" {
-		f, err := h.db.FindUser(req.DisplayName, true)
+		f, err := h.findUserByScreenName(req.DisplayName)
 		if err != nil {
 			return handleMgoError(err)
 		}
@@ -86,7 +85,7 @@ func (h *APIHandler) Unfollow(c echo.Context) error {
 	}
 
 	if req.DisplayName != "" {
-		f, err := h.db.FindUser(req.DisplayName, true)
+		f, err := h.findUserByScreenName(req.DisplayName)
 		if err != nil {
 			return handleMgoError(err)
 		}
@@ -129,20 +128,10 @@ func (h *APIHandler) Unfollow(c echo.Context) error {
 }
 
 func (h *APIHandler) GetFriendsID(c echo.Context) error {
-	config := config.GetAPIConfig()
-	tokenStr := c.QueryParam("token")
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Jwt), nil
-	})
+	claims, err := h.parseQueryToken(c)
 	if err != nil {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
-	}
-	if !token.Valid {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+		return err
 	}
-	claims := token.Claims.(jwt.MapClaims)
 	id := claims["id"].(string)
 
 	user, err := h.db.FindUser(id, true)
@@ -159,16 +148,9 @@ func (h *APIHandler) GetFriendsID(c echo.Context) error {
 
 func (h *APIHandler) GetFollowersID(c echo.Context) error {
 	// Jwtチェック
-	config := config.GetAPIConfig()
-	tokenStr := c.QueryParam("token")
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Jwt), nil
-	})
+	_, err := h.parseQueryToken(c)
 	if err != nil {
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
-	}
-	if !token.Valid {
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+		return err
 	}
 
 	id := c.QueryParam("user_id")
@@ -183,7 +165,7 @@ func (h *APIHandler) GetFollowersID(c echo.Context) error {
 	}
 
 	if displayName != "" {
-		user, err = h.db.FindUser(displayName, true)
+		user, err = h.findUserByScreenName(displayName)
 		if err != nil {
 			return handleMgoError(err)
 		}
@@ -198,16 +180,9 @@ func (h *APIHandler) GetFollowersID(c echo.Context) error {
 
 func (h *APIHandler) GetFollowerList(c echo.Context) error {
 	// Jwtチェック
-	config := config.GetAPIConfig()
-	tokenStr := c.QueryParam("token")
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Jwt), nil
-	})
+	_, err := h.parseQueryToken(c)
 	if err != nil {
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
-	}
-	if !token.Valid {
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+		return err
 	}
 
 	id := c.QueryParam("user_id")
@@ -222,7 +197,7 @@ func (h *APIHandler) GetFollowerList(c echo.Context) error {
 	}
 
 	if displayName != "" {
-		user, err = h.db.FindUser(displayName, true)
+		user, err = h.findUserByScreenName(displayName)
 		if err != nil {
 			return handleMgoError(err)
 		}
@@ -244,16 +219,9 @@ func (h *APIHandler) GetFollowerList(c echo.Context) error {
 
 func (h *APIHandler) GetFriendsList(c echo.Context) error {
 	// Jwtチェック
-	config := config.GetAPIConfig()
-	tokenStr := c.QueryParam("token")
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Jwt), nil
-	})
+	_, err := h.parseQueryToken(c)
 	if err != nil {
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
-	}
-	if !token.Valid {
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+		return err
 	}
 
 	id := c.QueryParam("user_id")
@@ -268,7 +236,7 @@ func (h *APIHandler) GetFriendsList(c echo.Context) error {
 	}
 
 	if displayName != "" {
-		user, err = h.db.FindUser(displayName, true)
+		user, err = h.findUserByScreenName(displayName)
 		if err != nil {
 			return handleMgoError(err)
 		}
@@ -2,10 +2,21 @@ package v1
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/TinyKitten/TimelineServer/cache"
 	"github.com/TinyKitten/TimelineServer/config"
 	"github.com/TinyKitten/TimelineServer/db"
+	"github.com/TinyKitten/TimelineServer/imaging"
+	"github.com/TinyKitten/TimelineServer/limiter"
 	"github.com/TinyKitten/TimelineServer/logger"
+	"github.com/TinyKitten/TimelineServer/mailer"
+	"github.com/TinyKitten/TimelineServer/realtime"
+	"github.com/TinyKitten/TimelineServer/storage"
+	"github.com/TinyKitten/TimelineServer/trends"
+	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/TinyKitten/TimelineServer/validation"
 	"github.com/labstack/echo"
 	"go.uber.org/zap"
 	validator "gopkg.in/go-playground/validator.v9"
@@ -14,12 +25,27 @@ import (
 
 type (
 	APIHandler struct {
-		db     *db.MongoInstance
-		logger *zap.Logger
+		db           *db.MongoInstance
+		logger       *zap.Logger
+		clock        utils.Clock
+		mailer       mailer.Mailer
+		mailQueue    *mailer.Queue // 宛先の存在を明かさないよう非同期に送るメール
+		loginLimiter *limiter.LoginLimiter
+		rules        validation.Rules
+		hub          *realtime.Hub
+		proxyHops    int // 接続元のIPアドレスを決めるときに信頼するプロキシの段数
+
+		trends  *trends.Tracker
+		images  imaging.Options
+		storage storage.Storage
 	}
 	messageResponse struct {
 		Message string `json:"message"`
 	}
+	fieldErrorResponse struct {
+		Message string                 `json:"message"`
+		Errors  validation.FieldErrors `json:"errors"`
+	}
 	CustomValidator struct {
 		validator *validator.Validate
 	}
@@ -37,9 +63,33 @@ func NewHandler() APIHandler {
 	if err != nil {
 		logger.Panic("Failed to connect database.", zap.Skip())
 	}
+	m, err := mailer.NewMailer(config.GetMailConfig())
+	if err != nil {
+		logger.Panic("Failed to initialize mailer.", zap.String("Reason", err.Error()))
+	}
+	uploadConf := config.GetUploadImageConfig()
+	st, err := storage.New(uploadConf, apiURL(storage.ServePath(uploadConf.Path)+"/", nil), utils.NewClock())
+	if err != nil {
+		logger.Panic("Failed to initialize storage.", zap.String("Reason", err.Error()))
+	}
+	redisIns := cache.NewRedisInstance(cacheConf)
+	mailQueue := mailer.NewQueue(m, mailQueueSize, func(msg mailer.Message, err error) {
+		logger.Error("Failed to send mail", zap.String("Reason", err.Error()))
+	})
 	return APIHandler{
-		db:     mongoIns,
-		logger: logger,
+		db:           mongoIns,
+		logger:       logger,
+		clock:        utils.NewClock(),
+		mailer:       m,
+		mailQueue:    mailQueue,
+		loginLimiter: limiter.NewLoginLimiter(&redisIns, limiter.DefaultPolicy),
+		rules:        validation.NewRules(config.GetValidationConfig()),
+		hub:          realtime.NewHub(),
+		proxyHops:    config.GetAPIConfig().TrustedProxyHops,
+
+		trends:  trends.NewTracker(&redisIns, trends.DefaultPolicy),
+		images:  imaging.NewOptions(uploadConf),
+		storage: st,
 	}
 
 }
@@ -62,6 +112,30 @@ const (
 	ErrInvalidJwt        = "invalid jwt token"
 	ErrTooLargeImage     = "uploaded image is too large"
 	ErrMediaNotSupported = "uploaded media type is not supported"
+	ErrInvalidCode       = "invalid verification code"
+	ErrChallengeExpired  = "two-factor challenge expired"
+	Err2FAAlreadyEnabled = "two-factor authentication already enabled"
+	Err2FANotEnrolled    = "two-factor authentication not enrolled"
+	ErrSessionRevoked    = "session revoked"
+	ErrInvalidLink       = "invalid or expired link"
+	RespMailSent         = "mail sent"
+	ErrTooManyAttempts   = "too many login attempts"
+	ErrNameChangeTooSoon = "screen name was changed too recently"
+	RespDeactivated      = "deactivated"
+	ErrExportRunning     = "export already running"
+	ErrReportClosed      = "report already handled"
+	ErrCannotReportSelf  = "cannot report yourself"
+	ErrAlreadyApplied    = "official application already pending"
+	ErrAlreadyOfficial   = "already official"
+	ErrAlreadyReviewed   = "application already reviewed"
+	ErrInvalidMedia      = "invalid media"
+	ErrTooManyMedia      = "too many media"
+	ErrAnimatedGIF       = "animated gif is not allowed"
+	ErrTooManyLists      = "too many lists"
+	ErrTooManyMembers    = "too many list members"
+	ErrNotListOwner      = "not list owner"
+	ErrSubscribeOwnList  = "cannot subscribe to own list"
+	ErrBlobBusy          = "same file is being deleted. try again later"
 )
 
 func handleMgoError(err error) *echo.HTTPError {
@@ -75,3 +149,44 @@ func handleMgoError(err error) *echo.HTTPError {
 		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
 	}
 }
+
+// parsePage 取得件数とcursor(オフセット)をクエリから取り出す
+// 件数はmaxに丸め、数値でない・負の値は400を返す
+func parsePage(c echo.Context, name string, def, max int) (int, int, error) {
+	limit := def
+	if v := c.QueryParam(name); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return 0, 0, &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+		}
+		if n < max {
+			limit = n
+		} else {
+			limit = max
+		}
+	}
+	cursor := 0
+	if v := c.QueryParam("cursor"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+		}
+		cursor = n
+	}
+	return limit, cursor, nil
+}
+
+// badFields フィールドごとのエラーを含む400を返す
+func badFields(errs validation.FieldErrors) *echo.HTTPError {
+	return &echo.HTTPError{
+		Code:    http.StatusBadRequest,
+		Message: &fieldErrorResponse{Message: ErrBadFormat, Errors: errs},
+	}
+}
+
+// tooManyRequests Retry-Afterを付けて429を返す
+func tooManyRequests(c echo.Context, retryAfter time.Duration, message string) error {
+	secs := int64((retryAfter + time.Second - 1) / time.Second)
+	c.Response().Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	return &echo.HTTPError{Code: http.StatusTooManyRequests, Message: message}
+}
@@ -2,17 +2,35 @@ package v1
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
 
 	"github.com/TinyKitten/TimelineServer/config"
 	"github.com/TinyKitten/TimelineServer/db"
+	"github.com/TinyKitten/TimelineServer/imaging"
+	"github.com/TinyKitten/TimelineServer/limiter"
 	"github.com/TinyKitten/TimelineServer/logger"
+	"github.com/TinyKitten/TimelineServer/mailer"
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/realtime"
+	"github.com/TinyKitten/TimelineServer/storage"
+	"github.com/TinyKitten/TimelineServer/token"
+	"github.com/TinyKitten/TimelineServer/trends"
+	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/TinyKitten/TimelineServer/validation"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
 	"github.com/labstack/gommon/log"
+	validator "gopkg.in/go-playground/validator.v9"
 	dockertest "gopkg.in/ory-am/dockertest.v3"
 )
 
-var th *APIHandler
+var (
+	th          *APIHandler
+	testMailer  = mailer.NewLogMailer(ioutil.Discard, "noreply@example.com")
+	testStorage = storage.NewLocal(config.GetUploadImagePath(), "http://localhost/uploads/")
+)
 
 func TestMain(m *testing.M) {
 	pool, err := dockertest.NewPool("")
@@ -44,8 +62,18 @@ func TestMain(m *testing.M) {
 		logger := logger.GetLogger()
 
 		th = &APIHandler{
-			db:     ins,
-			logger: logger,
+			db:           ins,
+			logger:       logger,
+			clock:        utils.NewClock(),
+			mailer:       testMailer,
+			mailQueue:    mailer.NewQueue(testMailer, 16, nil),
+			loginLimiter: limiter.NewLoginLimiter(limiter.NewMemoryStore(utils.NewClock()), limiter.DefaultPolicy),
+			rules:        validation.DefaultRules,
+			hub:          realtime.NewHub(),
+
+			trends:  trends.NewTracker(trends.NewMemoryStore(utils.NewClock()), trends.DefaultPolicy),
+			images:  imaging.DefaultOptions,
+			storage: testStorage,
 		}
 
 		return ins.Ping()
@@ -62,3 +90,25 @@ func TestMain(m *testing.M) {
 	}
 	os.Exit(code)
 }
+
+// setupTest echoとJWTミドルウェアを用意し、usersを登録してそれぞれのセッションを返す
+func setupTest(t *testing.T, users ...*models.User) (*echo.Echo, echo.MiddlewareFunc, []string) {
+	e := echo.New()
+	e.Validator = &CustomValidator{validator: validator.New()}
+	jwtMiddleware := middleware.JWTWithConfig(middleware.JWTConfig{
+		SigningKey: []byte(config.MockJwtToken),
+	})
+
+	sessions := make([]string, len(users))
+	for i, u := range users {
+		if err := th.db.Insert("users", u); err != nil {
+			t.Fatal(err)
+		}
+		session, err := token.CreateToken(u.ID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions[i] = session
+	}
+	return e, jwtMiddleware, sessions
+}
@@ -0,0 +1,529 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/validation"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// listNameMaxLength リスト名の最大文字数
+	listNameMaxLength = 25
+	// listDescriptionMaxLength リストの説明の最大文字数
+	listDescriptionMaxLength = 100
+	// listMaxOwned 1人が作成できるリストの最大数
+	listMaxOwned = 1000
+	// listMaxMembers 1つのリストのメンバーの最大数
+	listMaxMembers = 5000
+	// listStatusesDefaultLimit リストのタイムラインの既定の取得件数
+	listStatusesDefaultLimit = 20
+	// listStatusesMaxLimit リストのタイムラインの最大取得件数
+	listStatusesMaxLimit = 100
+)
+
+type (
+	ListRequest struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Mode        models.ListMode `json:"mode"`
+	}
+	// ListUpdateRequest リストの変更。省略した項目は変更しない
+	ListUpdateRequest struct {
+		ListID      bson.ObjectId `json:"list_id"`
+		Name        patchValue    `json:"name"`
+		Description patchValue    `json:"description"`
+		Mode        patchValue    `json:"mode"`
+	}
+	ListIDRequest struct {
+		ListID bson.ObjectId `json:"list_id"`
+	}
+	// ListMemberRequest user_idかscreen_nameでメンバーを指定する
+	ListMemberRequest struct {
+		ListID     bson.ObjectId `json:"list_id"`
+		UserID     bson.ObjectId `json:"user_id"`
+		ScreenName string        `json:"screen_name"`
+	}
+)
+
+// CreateList リストを作成する。公開範囲を省略した場合は公開リストにする
+func (h *APIHandler) CreateList(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	id := bson.ObjectIdHex(claims["id"].(string))
+
+	req := new(ListRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if req.Mode == "" {
+		req.Mode = models.ListPublic
+	}
+	name, description := strings.TrimSpace(req.Name), strings.TrimSpace(req.Description)
+	errs := validation.FieldErrors{}
+	if err := validateListName(name); err != nil {
+		errs.Add("name", err)
+	}
+	if err := validateListDescription(description); err != nil {
+		errs.Add("description", err)
+	}
+	if !req.Mode.Valid() {
+		errs.Add("mode", errInvalidValue)
+	}
+	if len(errs) != 0 {
+		return badFields(errs)
+	}
+
+	owned, err := h.db.CountListsByOwner(id)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if owned >= listMaxOwned {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrTooManyLists}
+	}
+
+	list := models.NewList(id, name, description, req.Mode, h.clock.Now())
+	if err := h.db.CreateList(list); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return h.respondList(c, http.StatusCreated, list.ID, id)
+}
+
+// UpdateList リストの名前・説明・公開範囲を変更する。非公開にすると作成者以外の購読は外れる
+func (h *APIHandler) UpdateList(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	id := bson.ObjectIdHex(claims["id"].(string))
+
+	req := new(ListUpdateRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if _, err := h.findOwnList(req.ListID, id); err != nil {
+		return err
+	}
+
+	set, errs := bson.M{}, validation.FieldErrors{}
+	texts := []struct {
+		name     string
+		value    patchValue
+		validate func(string) error
+	}{
+		{"name", req.Name, validateListName},
+		{"description", req.Description, validateListDescription},
+	}
+	for _, t := range texts {
+		if !t.value.set {
+			continue
+		}
+		var v string
+		if !t.value.null() {
+			if err := t.value.decode(&v); err != nil {
+				errs.Add(t.name, errInvalidValue)
+				continue
+			}
+		}
+		v = strings.TrimSpace(v)
+		if err := t.validate(v); err != nil {
+			errs.Add(t.name, err)
+			continue
+		}
+		set[t.name] = v
+	}
+	if req.Mode.set {
+		var mode models.ListMode
+		if err := req.Mode.decode(&mode); err != nil || !mode.Valid() {
+			errs.Add("mode", errInvalidValue)
+		} else {
+			set["mode"] = mode
+		}
+	}
+	if len(errs) != 0 {
+		return badFields(errs)
+	}
+	if len(set) == 0 {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+
+	if err := h.db.UpdateList(req.ListID, set, h.clock.Now()); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return h.respondList(c, http.StatusOK, req.ListID, id)
+}
+
+// DestroyList リストを削除し、削除したリストを返す
+func (h *APIHandler) DestroyList(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	id := bson.ObjectIdHex(claims["id"].(string))
+
+	req := new(ListIDRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	list, err := h.findOwnList(req.ListID, id)
+	if err != nil {
+		return err
+	}
+	resp, err := h.listResponse(*list, id)
+	if err != nil {
+		return err
+	}
+	if err := h.db.DeleteList(list.ID); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return c.JSON(http.StatusOK, &resp)
+}
+
+// AddListMember リストにメンバーを追加する。追加されたユーザには通知しない
+func (h *APIHandler) AddListMember(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	id := bson.ObjectIdHex(claims["id"].(string))
+
+	req := new(ListMemberRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	list, err := h.findOwnList(req.ListID, id)
+	if err != nil {
+		return err
+	}
+	member, err := h.findListMember(req)
+	if err != nil {
+		return err
+	}
+
+	if !list.HasMember(member.ID) {
+		if err := h.db.AddListMember(list.ID, member.ID, listMaxMembers, h.clock.Now()); err != nil {
+			if err == mgo.ErrNotFound {
+				return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrTooManyMembers}
+			}
+			h.logger.Debug("API Error", zap.String("Error", err.Error()))
+			return handleMgoError(err)
+		}
+	}
+	return h.respondList(c, http.StatusOK, list.ID, id)
+}
+
+// RemoveListMember リストからメンバーを外す
+func (h *APIHandler) RemoveListMember(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	id := bson.ObjectIdHex(claims["id"].(string))
+
+	req := new(ListMemberRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	list, err := h.findOwnList(req.ListID, id)
+	if err != nil {
+		return err
+	}
+	memberID := req.UserID
+	if !memberID.Valid() {
+		member, err := h.findListMember(req)
+		if err != nil {
+			return err
+		}
+		memberID = member.ID
+	}
+
+	if err := h.db.RemoveListMember(list.ID, memberID, h.clock.Now()); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return h.respondList(c, http.StatusOK, list.ID, id)
+}
+
+// SubscribeList 他のユーザの公開リストを購読する
+func (h *APIHandler) SubscribeList(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	id := bson.ObjectIdHex(claims["id"].(string))
+
+	req := new(ListIDRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	list, err := h.findVisibleList(req.ListID, id)
+	if err != nil {
+		return err
+	}
+	if list.OwnerID == id {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrSubscribeOwnList}
+	}
+
+	if err := h.db.SubscribeList(list.ID, id); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return h.respondList(c, http.StatusOK, list.ID, id)
+}
+
+// UnsubscribeList リストの購読を外す
+func (h *APIHandler) UnsubscribeList(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	id := bson.ObjectIdHex(claims["id"].(string))
+
+	req := new(ListIDRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	list, err := h.findVisibleList(req.ListID, id)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.UnsubscribeList(list.ID, id); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return h.respondList(c, http.StatusOK, list.ID, id)
+}
+
+// GetList list_idに一致するリストを返す
+func (h *APIHandler) GetList(c echo.Context) error {
+	claims, err := h.parseQueryToken(c)
+	if err != nil {
+		return err
+	}
+	id := bson.ObjectIdHex(claims["id"].(string))
+
+	list, err := h.findVisibleList(queryListID(c), id)
+	if err != nil {
+		return err
+	}
+	resp, err := h.listResponse(*list, id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &resp)
+}
+
+// GetLists ユーザのリストを返す
+// 自分の場合(screen_name・user_idを省略した場合を含む)は作成したリストと購読しているリスト、他のユーザの場合は作成した公開リストを返す
+func (h *APIHandler) GetLists(c echo.Context) error {
+	claims, err := h.parseQueryToken(c)
+	if err != nil {
+		return err
+	}
+	id := bson.ObjectIdHex(claims["id"].(string))
+
+	target := id
+	if screenName := c.QueryParam("screen_name"); screenName != "" {
+		u, err := h.findUserByScreenName(screenName)
+		if err != nil {
+			return handleMgoError(err)
+		}
+		target = u.ID
+	} else if userID := c.QueryParam("user_id"); userID != "" {
+		if !bson.IsObjectIdHex(userID) {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+		}
+		target = bson.ObjectIdHex(userID)
+	}
+
+	lists, err := h.db.GetListsByOwner(target, target != id)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if target == id {
+		subscribed, err := h.db.GetSubscribedLists(id)
+		if err != nil {
+			h.logger.Debug("API Error", zap.String("Error", err.Error()))
+			return handleMgoError(err)
+		}
+		lists = append(lists, subscribed...)
+	}
+
+	resp := []models.ListResponse{}
+	for _, l := range lists {
+		r, err := h.listResponse(l, id)
+		if err != nil {
+			return err
+		}
+		resp = append(resp, r)
+	}
+	return c.JSON(http.StatusOK, &resp)
+}
+
+// GetListMembers リストのメンバーを返す。凍結中・退会手続き中のユーザは含めない
+func (h *APIHandler) GetListMembers(c echo.Context) error {
+	claims, err := h.parseQueryToken(c)
+	if err != nil {
+		return err
+	}
+	id := bson.ObjectIdHex(claims["id"].(string))
+
+	list, err := h.findVisibleList(queryListID(c), id)
+	if err != nil {
+		return err
+	}
+	members, err := h.db.FindVisibleUsers(list.Members, h.clock.Now())
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	resp := models.UsersToUserResponseArray(members)
+	return c.JSON(http.StatusOK, &resp)
+}
+
+// GetListStatuses リストのメンバーの投稿を新しい順に返す
+// ページングはhome.jsonと同じくlimit, cursor(オフセット)で指定する。凍結中・退会手続き中のユーザの投稿は含めない
+func (h *APIHandler) GetListStatuses(c echo.Context) error {
+	claims, err := h.parseQueryToken(c)
+	if err != nil {
+		return err
+	}
+	id := bson.ObjectIdHex(claims["id"].(string))
+
+	list, err := h.findVisibleList(queryListID(c), id)
+	if err != nil {
+		return err
+	}
+	limit, cursor, err := parsePage(c, "limit", listStatusesDefaultLimit, listStatusesMaxLimit)
+	if err != nil {
+		return err
+	}
+
+	members, err := h.db.FindVisibleUserIDs(list.Members, h.clock.Now())
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	posts, err := h.db.GetPostsByUsers(members, cursor, limit)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	resp, err := h.postsToResponse(posts)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &resp)
+}
+
+// validateListName リスト名を検証する
+func validateListName(name string) error {
+	if name == "" {
+		return errors.New("required")
+	}
+	if utf8.RuneCountInString(name) > listNameMaxLength {
+		return errors.New("must be at most " + strconv.Itoa(listNameMaxLength) + " characters")
+	}
+	return nil
+}
+
+// validateListDescription リストの説明を検証する
+func validateListDescription(description string) error {
+	if utf8.RuneCountInString(description) > listDescriptionMaxLength {
+		return errors.New("must be at most " + strconv.Itoa(listDescriptionMaxLength) + " characters")
+	}
+	return nil
+}
+
+// queryListID クエリのlist_idを取り出す。不正な値の場合は空のIDを返す
+func queryListID(c echo.Context) bson.ObjectId {
+	v := c.QueryParam("list_id")
+	if !bson.IsObjectIdHex(v) {
+		return ""
+	}
+	return bson.ObjectIdHex(v)
+}
+
+// findVisibleList 閲覧者から見えるリストを取得する。他のユーザの非公開リストは存在しないものとして扱う
+func (h *APIHandler) findVisibleList(listID, viewerID bson.ObjectId) (*models.List, error) {
+	if !listID.Valid() {
+		return nil, &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	list, err := h.db.FindList(listID)
+	if err != nil {
+		return nil, handleMgoError(err)
+	}
+	if !list.VisibleTo(viewerID) {
+		return nil, handleMgoError(mgo.ErrNotFound)
+	}
+	return list, nil
+}
+
+// findOwnList 自分が作成したリストを取得する
+func (h *APIHandler) findOwnList(listID, ownerID bson.ObjectId) (*models.List, error) {
+	list, err := h.findVisibleList(listID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if list.OwnerID != ownerID {
+		return nil, &echo.HTTPError{Code: http.StatusForbidden, Message: ErrNotListOwner}
+	}
+	return list, nil
+}
+
+// findListMember user_idかscreen_nameで指定されたユーザを取得する。退会手続き中のユーザは追加できない
+func (h *APIHandler) findListMember(req *ListMemberRequest) (*models.User, error) {
+	if req.UserID.Valid() {
+		u, err := h.db.FindUserByOID(req.UserID, true)
+		if err != nil {
+			return nil, handleMgoError(err)
+		}
+		if u.Deactivated {
+			return nil, handleMgoError(mgo.ErrNotFound)
+		}
+		return u, nil
+	}
+	if req.ScreenName == "" {
+		return nil, &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	u, err := h.findUserByScreenName(req.ScreenName)
+	if err != nil {
+		return nil, handleMgoError(err)
+	}
+	return u, nil
+}
+
+// listResponse 作成者を取得してレスポンスに変換する
+func (h *APIHandler) listResponse(list models.List, viewerID bson.ObjectId) (models.ListResponse, error) {
+	owner, err := h.db.FindUserByOID(list.OwnerID, true)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return models.ListResponse{}, handleMgoError(err)
+	}
+	return models.ListToListResponse(list, *owner, viewerID), nil
+}
+
+// respondList 変更後のリストを取得し直して返す
+func (h *APIHandler) respondList(c echo.Context, code int, listID, viewerID bson.ObjectId) error {
+	list, err := h.db.FindList(listID)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	resp, err := h.listResponse(*list, viewerID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(code, &resp)
+}
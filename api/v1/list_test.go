@@ -0,0 +1,174 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestLists(t *testing.T) {
+	owner := models.NewUser("curator", "", "curator@example.com", false)
+	reader := models.NewUser("listreader", "", "listreader@example.com", false)
+	alice := models.NewUser("listed_alice", "", "listed_alice@example.com", false)
+	bob := models.NewUser("listed_bob", "", "listed_bob@example.com", false)
+	bob.Suspended = true
+	outsider := models.NewUser("unlisted", "", "unlisted@example.com", false)
+	e, jwtMiddleware, tokens := setupTest(t, owner, reader, alice, bob, outsider)
+	sessions := map[bson.ObjectId]string{owner.ID: tokens[0], reader.ID: tokens[1]}
+	now := time.Now()
+	posts := []*models.Post{}
+	for i, u := range []*models.User{alice, outsider, alice, bob} {
+		p := models.NewPost(u.ID, "", "list timeline")
+		p.CreatedAt = now.Add(time.Duration(i) * time.Minute)
+		if err := th.db.Insert("posts", p); err != nil {
+			t.Fatal(err)
+		}
+		posts = append(posts, p)
+	}
+
+	post := func(handler echo.HandlerFunc, as bson.ObjectId, body interface{}) (*models.ListResponse, error) {
+		c, rec := postJSON(e, "/1.0/lists", body, sessions[as])
+		if err := jwtMiddleware(handler)(c); err != nil {
+			return nil, err
+		}
+		resp := new(models.ListResponse)
+		if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp, nil
+	}
+	get := func(handler echo.HandlerFunc, as bson.ObjectId, v url.Values, resp interface{}) error {
+		v.Set("token", sessions[as])
+		req := httptest.NewRequest(echo.GET, "/1.0/lists?"+v.Encode(), nil)
+		rec := httptest.NewRecorder()
+		if err := handler(e.NewContext(req, rec)); err != nil {
+			return err
+		}
+		return json.Unmarshal(rec.Body.Bytes(), resp)
+	}
+	code := func(err error) int {
+		if he, ok := err.(*echo.HTTPError); ok {
+			return he.Code
+		}
+		return 0
+	}
+
+	if _, err := post(th.CreateList, owner.ID, ListRequest{Name: " ", Mode: "secret"}); code(err) != http.StatusBadRequest {
+		t.Fatalf("expected validation error, actual %v", err)
+	}
+	list, err := post(th.CreateList, owner.ID, ListRequest{Name: "Friends", Description: "close friends"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, models.ListPublic, list.Mode)
+	listID := list.ID.Hex()
+
+	// メンバーの追加は作成者だけができる
+	_, err = post(th.AddListMember, reader.ID, ListMemberRequest{ListID: list.ID, UserID: alice.ID})
+	assert.Equal(t, http.StatusForbidden, code(err))
+	_, err = post(th.AddListMember, owner.ID, ListMemberRequest{ListID: list.ID, UserID: alice.ID})
+	assert.NoError(t, err)
+	list, err = post(th.AddListMember, owner.ID, ListMemberRequest{ListID: list.ID, ScreenName: bob.UserID})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, list.MemberCount)
+	}
+
+	// メンバーの投稿だけを新しい順に返し、凍結中のメンバーの投稿は含めない
+	timeline := []models.PostResponse{}
+	if assert.NoError(t, get(th.GetListStatuses, reader.ID, url.Values{"list_id": {listID}}, &timeline)) {
+		ids := []bson.ObjectId{}
+		for _, p := range timeline {
+			ids = append(ids, p.ID)
+		}
+		assert.Equal(t, []bson.ObjectId{posts[2].ID, posts[0].ID}, ids)
+	}
+	timeline = []models.PostResponse{}
+	if assert.NoError(t, get(th.GetListStatuses, reader.ID, url.Values{"list_id": {listID}, "limit": {"1"}, "cursor": {"1"}}, &timeline)) {
+		if assert.Equal(t, 1, len(timeline)) {
+			assert.Equal(t, posts[0].ID, timeline[0].ID)
+		}
+	}
+
+	// メンバー一覧にも凍結中のメンバーは含めない
+	members := []models.UserResponse{}
+	if assert.NoError(t, get(th.GetListMembers, reader.ID, url.Values{"list_id": {listID}}, &members)) && assert.Equal(t, 1, len(members)) {
+		assert.Equal(t, alice.ID.Hex(), members[0].ID)
+	}
+
+	// 購読すると自分のリスト一覧に含まれる
+	_, err = post(th.SubscribeList, owner.ID, ListIDRequest{ListID: list.ID})
+	assert.Equal(t, http.StatusBadRequest, code(err))
+	if list, err = post(th.SubscribeList, reader.ID, ListIDRequest{ListID: list.ID}); assert.NoError(t, err) {
+		assert.True(t, list.Following)
+		assert.Equal(t, 1, list.SubscriberCount)
+	}
+	lists := []models.ListResponse{}
+	if assert.NoError(t, get(th.GetLists, reader.ID, url.Values{}, &lists)) && assert.Equal(t, 1, len(lists)) {
+		assert.Equal(t, list.ID, lists[0].ID)
+	}
+
+	// 非公開にすると購読が外れ、作成者以外には存在しないものとして扱う
+	private, err := post(th.UpdateList, owner.ID, map[string]interface{}{"list_id": listID, "mode": models.ListPrivate})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Friends", private.Name)
+		assert.Equal(t, 0, private.SubscriberCount)
+	}
+	var show models.ListResponse
+	assert.Equal(t, http.StatusNotFound, code(get(th.GetList, reader.ID, url.Values{"list_id": {listID}}, &show)))
+	_, err = post(th.SubscribeList, reader.ID, ListIDRequest{ListID: list.ID})
+	assert.Equal(t, http.StatusNotFound, code(err))
+	lists = []models.ListResponse{}
+	if assert.NoError(t, get(th.GetLists, reader.ID, url.Values{"screen_name": {owner.UserID}}, &lists)) {
+		assert.Empty(t, lists)
+	}
+	assert.NoError(t, get(th.GetList, owner.ID, url.Values{"list_id": {listID}}, &show))
+
+	// メンバーを外すとタイムラインから消え、削除したリストは取得できない
+	if list, err = post(th.RemoveListMember, owner.ID, ListMemberRequest{ListID: list.ID, UserID: alice.ID}); assert.NoError(t, err) {
+		assert.Equal(t, 1, list.MemberCount)
+	}
+	_, err = post(th.DestroyList, owner.ID, ListIDRequest{ListID: list.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, code(get(th.GetList, owner.ID, url.Values{"list_id": {listID}}, &show)))
+}
+
+func TestListMembersReload(t *testing.T) {
+	owner := models.NewUser("streamcurator", "", "streamcurator@example.com", false)
+	viewer := models.NewUser("streamviewer", "", "streamviewer@example.com", false)
+	member := models.NewUser("streammember", "", "streammember@example.com", false)
+	setupTest(t, owner, viewer, member)
+	list := models.NewList(owner.ID, "Stream", "", models.ListPublic, time.Now())
+	if err := th.db.CreateList(list); err != nil {
+		t.Fatal(err)
+	}
+	post := models.PostResponse{User: models.UserResponse{ID: member.ID.Hex()}}
+	members := newListMembers(*list)
+	assert.False(t, members.filter(post))
+
+	// 接続中に追加されたメンバーの投稿も配信する
+	if err := th.db.AddListMember(list.ID, member.ID, listMaxMembers, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, th.reloadListMembers(members, list.ID, viewer.ID))
+	assert.True(t, members.filter(post))
+	assert.False(t, members.filter(models.PostResponse{User: models.UserResponse{ID: viewer.ID.Hex()}}))
+
+	// 非公開になったり削除されたりすると見えなくなる
+	if err := th.db.UpdateList(list.ID, bson.M{"mode": models.ListPrivate}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, th.reloadListMembers(members, list.ID, viewer.ID))
+	assert.NoError(t, th.reloadListMembers(members, list.ID, owner.ID))
+	if err := th.db.DeleteList(list.ID); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, th.reloadListMembers(members, list.ID, owner.ID))
+}
@@ -0,0 +1,63 @@
+package v1
+
+import (
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+)
+
+// checkLoginLock アカウントかIPアドレスがロック中であれば429を返す
+// パスワードの検証より前に呼び、ロック中はbcryptを実行しない
+func (h *APIHandler) checkLoginLock(c echo.Context, account string) error {
+	retryAfter, err := h.loginLimiter.Check(account, h.clientIP(c))
+	if err != nil {
+		// ストアの障害でログインできなくなるのは避ける
+		h.logger.Error("Failed to check login lock", zap.String("Reason", err.Error()))
+		return nil
+	}
+	if retryAfter > 0 {
+		return tooManyRequests(c, retryAfter, ErrTooManyAttempts)
+	}
+	return nil
+}
+
+// loginFailed 失敗を記録する。ロックされた場合は429、そうでなければfailureを返す
+// uは対象のアカウントが存在する場合のみ渡す
+func (h *APIHandler) loginFailed(c echo.Context, account string, u *models.User, failure error) error {
+	ip := h.clientIP(c)
+	h.logger.Info("Login failed", zap.String("Account", account), zap.String("IP", ip))
+
+	accountLock, ipLock, err := h.loginLimiter.Fail(account, ip)
+	if err != nil {
+		h.logger.Error("Failed to record login failure", zap.String("Reason", err.Error()))
+		return failure
+	}
+
+	if accountLock > 0 && u != nil {
+		// 本人に通知する
+		if _, err := h.db.InsertEvent(u.ID, u.ID, models.LoginFailedEvent); err != nil {
+			h.logger.Error("Failed to insert event", zap.String("Reason", err.Error()))
+		}
+	}
+
+	if ipLock > accountLock {
+		accountLock = ipLock
+	}
+	if accountLock > 0 {
+		return tooManyRequests(c, accountLock, ErrTooManyAttempts)
+	}
+	return failure
+}
+
+// clientIP 接続元のIPアドレスを返す。クライアントが書き換えられるヘッダは信頼しない(utils.ClientIPを参照)
+func (h *APIHandler) clientIP(c echo.Context) string {
+	return utils.ClientIP(c.Request(), h.proxyHops)
+}
+
+// loginSucceeded アカウントの失敗回数をリセットする
+func (h *APIHandler) loginSucceeded(account string) {
+	if err := h.loginLimiter.Success(account); err != nil {
+		h.logger.Error("Failed to reset login failures", zap.String("Reason", err.Error()))
+	}
+}
@@ -0,0 +1,128 @@
+package v1
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/TinyKitten/TimelineServer/config"
+	"github.com/TinyKitten/TimelineServer/mailer"
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/token"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// mailQueueSize 非同期に送信するメールを溜められる数
+const mailQueueSize = 256
+
+// VerifyEmail 確認メールのリンクからメールアドレスを確認済みにする
+func (h *APIHandler) VerifyEmail(c echo.Context) error {
+	id, email, err := token.ParseEmailVerificationToken(c.QueryParam("token"), h.clock.Now())
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrInvalidLink}
+	}
+
+	err = h.db.VerifyEmail(id, email)
+	if err == mgo.ErrNotFound {
+		// 確認メール送信後にアドレスが変更された
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrInvalidLink}
+	}
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	return c.JSON(http.StatusOK, &messageResponse{Message: "ok"})
+}
+
+// ResendVerification 確認メールを再送する
+func (h *APIHandler) ResendVerification(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+
+	u, err := h.db.FindUserByOID(bson.ObjectIdHex(idStr), false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if u.EmailVerified {
+		return c.JSON(http.StatusOK, &messageResponse{Message: "ok"})
+	}
+
+	if err := h.sendVerificationMail(*u); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+	}
+	return c.JSON(http.StatusOK, &messageResponse{Message: RespMailSent})
+}
+
+// apiURL APIサーバ上のパスの絶対URLを返す
+func apiURL(path string, query url.Values) string {
+	cfg := config.GetAPIConfig()
+	portStr := strconv.Itoa(cfg.Port)
+
+	scheme := "http://"
+	if cfg.Secure {
+		scheme = "https://"
+	}
+	u := scheme + cfg.Endpoint + ":" + portStr + "/" + cfg.Version + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// sendVerificationMail メールアドレス確認リンクを送信する
+func (h *APIHandler) sendVerificationMail(u models.User) error {
+	t, err := token.CreateEmailVerificationToken(u.ID, u.EMail, h.clock.Now())
+	if err != nil {
+		return err
+	}
+	link := apiURL("/account/verify_email.json", url.Values{"token": {t}})
+
+	return h.sendMail(mailer.Message{
+		To:      u.EMail,
+		Subject: "メールアドレスの確認",
+		Body: "@" + u.UserID + " さん\r\n\r\n" +
+			"以下のリンクを開いてメールアドレスの確認を完了してください。\r\n" +
+			link + "\r\n\r\n" +
+			"このリンクの有効期限は48時間です。",
+	})
+}
+
+// sendPasswordResetMail パスワード再設定用のトークンを送信待ちに追加する
+// 登録済みのアドレスかどうかが応答時間に表れないよう、送信は待たない
+func (h *APIHandler) sendPasswordResetMail(u models.User) error {
+	t, err := token.CreatePasswordResetToken(u.ID, u.TokenVersion, h.clock.Now())
+	if err != nil {
+		return err
+	}
+	link := apiURL("/account/password_reset.json", url.Values{"token": {t}})
+
+	err = h.mailQueue.Send(mailer.Message{
+		To:      u.EMail,
+		Subject: "パスワードの再設定",
+		Body: "@" + u.UserID + " さん\r\n\r\n" +
+			"パスワードの再設定が要求されました。\r\n" +
+			"以下のトークンを password_reset.json に新しいパスワードと一緒に送信してください。\r\n" +
+			link + "\r\n\r\n" +
+			"このリンクの有効期限は1時間です。心当たりがない場合はこのメールを無視してください。",
+	})
+	if err != nil {
+		h.logger.Error("Failed to queue mail", zap.String("Reason", err.Error()))
+	}
+	return err
+}
+
+func (h *APIHandler) sendMail(msg mailer.Message) error {
+	err := h.mailer.Send(msg)
+	if err != nil {
+		h.logger.Error("Failed to send mail", zap.String("Reason", err.Error()))
+	}
+	return err
+}
@@ -0,0 +1,192 @@
+package v1
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/TinyKitten/TimelineServer/imaging"
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/validation"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// mediaMaxAltText 代替テキストの最大文字数
+	mediaMaxAltText = 1000
+	// mediaPerPost 1つのポストに添付できるメディアの数
+	mediaPerPost = 4
+)
+
+// imageError 画像の検証エラーをレスポンスに変換する
+func imageError(err error) *echo.HTTPError {
+	switch err {
+	case imaging.ErrTooLarge, imaging.ErrTooManyPixels:
+		return &echo.HTTPError{Code: http.StatusRequestEntityTooLarge, Message: ErrTooLargeImage}
+	case imaging.ErrUnsupported:
+		return &echo.HTTPError{Code: http.StatusUnsupportedMediaType, Message: ErrMediaNotSupported}
+	case imaging.ErrAnimated:
+		return &echo.HTTPError{Code: http.StatusUnsupportedMediaType, Message: ErrAnimatedGIF}
+	default:
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+	}
+}
+
+// storedFile 保存先に書き出すファイル
+type storedFile struct {
+	data        []byte
+	contentType string
+}
+
+// putFiles 全てのファイルを保存先に書き出す。途中で失敗した場合は書き出したファイルを削除する
+func (h *APIHandler) putFiles(files map[string]storedFile) error {
+	written := []string{}
+	for key, f := range files {
+		if err := h.storage.Put(key, f.data, f.contentType); err != nil {
+			h.removeFiles(written)
+			return err
+		}
+		written = append(written, key)
+	}
+	return nil
+}
+
+// UploadMedia multipart/form-dataで画像をアップロードし、ポストに添付するためのmedia_idを返す
+// 画像はmediaフィールド、代替テキストはalt_textフィールドで受け取る
+// 画像は検証してメタデータを取り除いてから、縮小版(imaging.MediaVariants)とともに保存する
+func (h *APIHandler) UploadMedia(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	fh, err := c.FormFile("media")
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	altText := c.FormValue("alt_text")
+	if utf8.RuneCountInString(altText) > mediaMaxAltText {
+		return badFields(validation.FieldErrors{"alt_text": "too long"})
+	}
+	if fh.Size > h.images.MaxBytes {
+		return &echo.HTTPError{Code: http.StatusRequestEntityTooLarge, Message: ErrTooLargeImage}
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+	}
+	defer f.Close()
+	// ヘッダのサイズは信用せず、実際に読み込んだバイト数で確かめる
+	dat, err := ioutil.ReadAll(io.LimitReader(f, h.images.MaxBytes+1))
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+	}
+	img, err := h.images.Process(dat, imaging.MediaVariants)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return imageError(err)
+	}
+
+	media := &models.Media{
+		ID:          bson.NewObjectId(),
+		UserID:      id,
+		Type:        models.MediaPhoto,
+		ContentType: img.ContentType,
+		Width:       img.Width,
+		Height:      img.Height,
+		Size:        int64(len(img.Data)),
+		AltText:     altText,
+		Animated:    img.Animated,
+		Variants:    []models.MediaVariant{},
+		CreatedAt:   h.clock.Now(),
+	}
+	// 同じ内容の画像は同じキーになり、保存先には一つだけ残る
+	media.Key = contentKey(img.Data, img.Format)
+	media.URL = h.storage.URL(media.Key)
+	files := map[string]storedFile{media.Key: {img.Data, img.ContentType}}
+	hash := strings.TrimSuffix(media.Key, imaging.Ext(img.Format))
+	for _, v := range img.Variants {
+		// GIFの縮小版はPNGなので、拡張子は縮小版の形式に合わせる
+		key := imaging.VariantPath(hash+imaging.Ext(v.Format), v.Name)
+		media.Variants = append(media.Variants, models.MediaVariant{
+			Name:   v.Name,
+			Key:    key,
+			URL:    h.storage.URL(key),
+			Width:  v.Width,
+			Height: v.Height,
+		})
+		files[key] = storedFile{v.Data, v.ContentType}
+	}
+	if err := h.storeBlob(media.Key, files); err != nil {
+		h.logger.Error("Failed to save media", zap.String("Reason", err.Error()))
+		return storeBlobError(err)
+	}
+
+	if err := h.db.InsertMedia(media); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		h.releaseBlob(media.Key)
+		return handleMgoError(err)
+	}
+	return c.JSON(http.StatusCreated, media)
+}
+
+// removeFiles アップロードされたファイルを保存先から削除する
+func (h *APIHandler) removeFiles(keys []string) {
+	for _, key := range keys {
+		if err := h.storage.Delete(key); err != nil {
+			h.logger.Error("Failed to remove file", zap.String("Reason", err.Error()))
+		}
+	}
+}
+
+// attachMedia 自分がアップロードした未添付のメディアをポストに添付する
+// 一部の添付に失敗した場合は全ての添付を取り消す
+func (h *APIHandler) attachMedia(userID bson.ObjectId, post *models.Post, mediaIDs []string) error {
+	if len(mediaIDs) > mediaPerPost {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrTooManyMedia}
+	}
+	seen := map[string]bool{}
+	for _, v := range mediaIDs {
+		if !bson.IsObjectIdHex(v) || seen[v] {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrInvalidMedia}
+		}
+		seen[v] = true
+	}
+
+	for _, v := range mediaIDs {
+		mediaID := bson.ObjectIdHex(v)
+		err := h.db.AttachMedia(mediaID, userID, post.ID)
+		if err == nil {
+			var media *models.Media
+			if media, err = h.db.FindMedia(mediaID); err == nil {
+				post.Media = append(post.Media, media.Entity())
+				continue
+			}
+		}
+		h.detachMedia(post.ID)
+		if err == mgo.ErrNotFound {
+			// 存在しない・他人の・添付済みのメディア
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrInvalidMedia}
+		}
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return nil
+}
+
+// detachMedia ポストへの添付を取り消す。ポストを保存できなかった場合に使う
+func (h *APIHandler) detachMedia(postID bson.ObjectId) {
+	if err := h.db.DetachMedia(postID); err != nil {
+		h.logger.Error("Failed to detach media", zap.String("Reason", err.Error()))
+	}
+}
@@ -0,0 +1,127 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+// uploadRequest 画像をmultipart/form-dataで送るリクエストを作成する
+func uploadRequest(e *echo.Echo, dat []byte, altText, bearer string) (echo.Context, *httptest.ResponseRecorder) {
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	part, _ := w.CreateFormFile("media", "image.bin")
+	part.Write(dat)
+	if altText != "" {
+		w.WriteField("alt_text", altText)
+	}
+	w.Close()
+
+	req := httptest.NewRequest(echo.POST, "/1.0/media/upload.json", body)
+	req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %v", bearer))
+	rec := httptest.NewRecorder()
+	return e.NewContext(req, rec), rec
+}
+
+func testPNG(w, h int) []byte {
+	buf := new(bytes.Buffer)
+	png.Encode(buf, image.NewRGBA(image.Rect(0, 0, w, h)))
+	return buf.Bytes()
+}
+
+func TestMediaUpload(t *testing.T) {
+	owner := models.NewUser("photographer", "", "photographer@example.com", false)
+	other := models.NewUser("thief", "", "thief@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, owner, other)
+	session, otherSession := sessions[0], sessions[1]
+
+	upload := func(dat []byte, altText, bearer string) (*models.Media, error) {
+		c, rec := uploadRequest(e, dat, altText, bearer)
+		if err := jwtMiddleware(th.UploadMedia)(c); err != nil {
+			return nil, err
+		}
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		media := new(models.Media)
+		if err := json.Unmarshal(rec.Body.Bytes(), media); err != nil {
+			t.Fatal(err)
+		}
+		return media, nil
+	}
+
+	// 内容が画像でなければ拒否する
+	if _, err := upload(testPNG(5000, 1), "", session); err == nil || err.(*echo.HTTPError).Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("huge image should be rejected, actual %v", err)
+	}
+	if _, err := upload([]byte("not an image"), "", session); err == nil || err.(*echo.HTTPError).Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("non-image should be rejected, actual %v", err)
+	}
+
+	ids, urls := []string{}, []string{}
+	for i := 0; i < 5; i++ {
+		media, err := upload(testPNG(3+i, 2), "a cat", session)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 3+i, media.Width)
+		assert.Equal(t, 2, media.Height)
+		assert.Equal(t, "image/png", media.ContentType)
+		// 縮小版は元の画像より大きくならない
+		if assert.Equal(t, 2, len(media.Variants)) {
+			assert.Equal(t, "thumb", media.Variants[0].Name)
+			assert.Equal(t, 2, media.Variants[0].Width)
+			assert.Equal(t, 3+i, media.Variants[1].Width)
+			assert.True(t, strings.HasSuffix(media.Variants[1].URL, "_small.png"))
+		}
+		ids = append(ids, media.ID.Hex())
+		urls = append(urls, media.URL)
+		for _, u := range append([]string{media.URL}, media.Variants[0].URL, media.Variants[1].URL) {
+			key, _ := testStorage.Key(u)
+			defer testStorage.Delete(key)
+		}
+	}
+
+	post := func(req PostReq, bearer string) error {
+		c, _ := postJSON(e, "/1.0/statuses/update.json", req, bearer)
+		return jwtMiddleware(th.UpdateStatus)(c)
+	}
+	if he, ok := post(PostReq{Status: "too many", MediaIDs: ids}, session).(*echo.HTTPError); !ok || he.Message != ErrTooManyMedia {
+		t.Fatalf("five media should be rejected, actual %v", he)
+	}
+	if he, ok := post(PostReq{Status: "not mine", MediaIDs: ids[:1]}, otherSession).(*echo.HTTPError); !ok || he.Message != ErrInvalidMedia {
+		t.Fatalf("other user's media should be rejected, actual %v", he)
+	}
+	assert.NoError(t, post(PostReq{Status: "photos", MediaIDs: ids[:2]}, session))
+	// 添付済みのメディアは再利用できない
+	if he, ok := post(PostReq{Status: "again", MediaIDs: []string{ids[2], ids[1]}}, session).(*echo.HTTPError); !ok || he.Message != ErrInvalidMedia {
+		t.Fatalf("attached media should be rejected, actual %v", he)
+	}
+	// 失敗した添付は取り消され、別のポストに使える
+	assert.NoError(t, post(PostReq{Status: "retry", MediaIDs: ids[2:3]}, session))
+
+	posts, err := th.db.GetPostsByUser(owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 2, len(posts)) {
+		resp := models.PostToPostResponse(posts[0], *owner)
+		if assert.Equal(t, 2, len(resp.Media)) {
+			assert.Equal(t, ids[0], resp.Media[0].ID.Hex())
+			assert.Equal(t, 3, resp.Media[0].Width)
+			assert.Equal(t, "a cat", resp.Media[0].AltText)
+			assert.Equal(t, urls[0], resp.Media[0].URL)
+		}
+		assert.Equal(t, 1, len(posts[1].Media))
+	}
+}
@@ -0,0 +1,194 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/validation"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// officialMaxLinks 公式マーク申請に添付できるURLの数
+	officialMaxLinks = 5
+	// officialDefaultLimit 申請一覧の既定の取得件数
+	officialDefaultLimit = 50
+	// officialMaxLimit 申請一覧の最大取得件数
+	officialMaxLimit = 200
+)
+
+type (
+	OfficialApplicationRequest struct {
+		Name     string   `json:"name" validate:"required,max=50"`
+		Category string   `json:"category" validate:"required,max=30"`
+		Details  string   `json:"details" validate:"required,max=2000"`
+		Links    []string `json:"links"`
+	}
+
+	AOfficialReviewRequest struct {
+		ApplicationID bson.ObjectId `json:"application_id"`
+		Note          string        `json:"note"`
+	}
+)
+
+// ApplyOfficial 公式マークを申請する。審査待ちの申請は1件まで
+func (h *APIHandler) ApplyOfficial(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	req := new(OfficialApplicationRequest)
+	if err := c.Bind(req); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	errs := validation.FieldErrors{}
+	if err := c.Validate(req); err != nil {
+		errs = validation.FromValidator(req, err)
+	}
+	if len(req.Links) > officialMaxLinks {
+		errs.Add("links", errors.New("max "+strconv.Itoa(officialMaxLinks)))
+	}
+	for _, link := range req.Links {
+		if u, err := url.Parse(link); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.Add("links", errors.New("invalid url"))
+			break
+		}
+	}
+	if len(errs) != 0 {
+		return badFields(errs)
+	}
+
+	u, err := h.db.FindUserByOID(id, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if u.Official {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: ErrAlreadyOfficial}
+	}
+
+	app := &models.OfficialApplication{
+		ID:        bson.NewObjectId(),
+		UserID:    id,
+		Name:      req.Name,
+		Category:  req.Category,
+		Details:   req.Details,
+		Links:     req.Links,
+		CreatedAt: h.clock.Now(),
+	}
+	if app.Links == nil {
+		app.Links = []string{}
+	}
+	err = h.db.CreateOfficialApplication(app)
+	if mgo.IsDup(err) {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: ErrAlreadyApplied}
+	}
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	return c.JSON(http.StatusCreated, app)
+}
+
+// GetOfficialApplication 最新の公式マーク申請の審査状況を返す
+func (h *APIHandler) GetOfficialApplication(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	app, err := h.db.FindLatestOfficialApplication(id)
+	if err != nil {
+		return handleMgoError(err)
+	}
+	// 審査した管理者は明かさない
+	app.ReviewedBy = ""
+	return c.JSON(http.StatusOK, app)
+}
+
+// AGetOfficialApplications 公式マーク申請を古い順に返す。statusを指定しない場合は審査待ちを返す
+func (h *APIHandler) AGetOfficialApplications(c echo.Context) error {
+	status := models.OfficialPending
+	if v := c.QueryParam("status"); v != "" {
+		status = models.OfficialStatus(v)
+		switch status {
+		case models.OfficialPending, models.OfficialApproved, models.OfficialRejected:
+		default:
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+		}
+	}
+
+	limit, cursor, err := parsePage(c, "limit", officialDefaultLimit, officialMaxLimit)
+	if err != nil {
+		return err
+	}
+
+	apps, err := h.db.FindOfficialApplications(status, cursor, limit)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return c.JSON(http.StatusOK, apps)
+}
+
+// AApproveOfficial 公式マーク申請を承認し、申請者を公式アカウントにする
+func (h *APIHandler) AApproveOfficial(c echo.Context) error {
+	return h.reviewOfficial(c, models.OfficialApproved)
+}
+
+// ARejectOfficial 公式マーク申請を却下する
+func (h *APIHandler) ARejectOfficial(c echo.Context) error {
+	return h.reviewOfficial(c, models.OfficialRejected)
+}
+
+func (h *APIHandler) reviewOfficial(c echo.Context, status models.OfficialStatus) error {
+	req := new(AOfficialReviewRequest)
+	if err := c.Bind(req); err != nil || !req.ApplicationID.Valid() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+
+	app, err := h.db.ReviewOfficialApplication(req.ApplicationID, actor(c).ID, status, req.Note, h.clock.Now())
+	if err == mgo.ErrNotFound {
+		if _, err := h.db.FindOfficialApplication(req.ApplicationID); err != nil {
+			return handleMgoError(err)
+		}
+		return &echo.HTTPError{Code: http.StatusConflict, Message: ErrAlreadyReviewed}
+	}
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	u, err := h.db.FindUserByOID(app.UserID, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	action, eventType := models.AuditRejectOfficial, models.OfficialRejectedEvent
+	if status == models.OfficialApproved {
+		action, eventType = models.AuditApproveOfficial, models.OfficialApprovedEvent
+		if err := h.db.SetOfficial(u.ID, true); err != nil {
+			h.logger.Debug("API Error", zap.String("Error", err.Error()))
+			return handleMgoError(err)
+		}
+	}
+	h.audit(c, action, u, req.Note,
+		bson.M{"application_id": app.ID, "official": u.Official},
+		bson.M{"official": u.Official || status == models.OfficialApproved})
+
+	if _, err := h.db.InsertNoticeEvent(u.ID, eventType, req.Note); err != nil {
+		h.logger.Error("Failed to insert event", zap.String("Reason", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, app)
+}
@@ -0,0 +1,87 @@
+package v1
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOfficialApplication(t *testing.T) {
+	admin := models.NewUser("reviewer", "", "reviewer@example.com", false)
+	admin.Roles = []models.Role{models.RoleAdmin}
+	applicant := models.NewUser("famous", "", "famous@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, admin, applicant)
+	adminToken, session := sessions[0], sessions[1]
+	// 承認前のキャッシュ
+	if _, err := th.db.FindUser("famous", true); err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := postJSON(e, "/1.0/account/official_application.json", OfficialApplicationRequest{
+		Name: "Famous", Category: "company", Details: "we are famous", Links: []string{"javascript:alert(1)"},
+	}, session)
+	if he, ok := jwtMiddleware(th.ApplyOfficial)(c).(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("invalid link should be rejected, actual %v", he)
+	}
+
+	req := OfficialApplicationRequest{
+		Name: "Famous", Category: "company", Details: "we are famous", Links: []string{"https://example.com"},
+	}
+	c, rec := postJSON(e, "/1.0/account/official_application.json", req, session)
+	if assert.NoError(t, jwtMiddleware(th.ApplyOfficial)(c)) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+	c, _ = postJSON(e, "/1.0/account/official_application.json", req, session)
+	if he, ok := jwtMiddleware(th.ApplyOfficial)(c).(*echo.HTTPError); !ok || he.Message != ErrAlreadyApplied {
+		t.Fatalf("duplicate application should be rejected, actual %v", he)
+	}
+
+	apps, err := th.db.FindOfficialApplications(models.OfficialPending, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var app *models.OfficialApplication
+	for i := range apps {
+		if apps[i].UserID == applicant.ID {
+			app = &apps[i]
+		}
+	}
+	if app == nil {
+		t.Fatal("application should be pending")
+	}
+
+	review := jwtMiddleware(th.RequireRole(models.RoleAdmin)(th.AApproveOfficial))
+	c, rec = postJSON(e, "/1.0/super/official_applications/approve.json", AOfficialReviewRequest{ApplicationID: app.ID, Note: "welcome"}, adminToken)
+	if assert.NoError(t, review(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	c, _ = postJSON(e, "/1.0/super/official_applications/approve.json", AOfficialReviewRequest{ApplicationID: app.ID}, adminToken)
+	if he, ok := review(c).(*echo.HTTPError); !ok || he.Message != ErrAlreadyReviewed {
+		t.Fatalf("reviewed application should not be reviewed twice, actual %v", he)
+	}
+
+	// ObjectIDとスクリーンネームのどちらのキャッシュも更新される
+	byID, err := th.db.FindUserByOID(applicant.ID, true)
+	if assert.NoError(t, err) {
+		assert.True(t, byID.Official)
+	}
+	byName, err := th.db.FindUser("famous", true)
+	if assert.NoError(t, err) {
+		assert.True(t, byName.Official)
+	}
+
+	events, err := th.db.GetEvents(applicant.ID)
+	if assert.NoError(t, err) && assert.Len(t, *events, 1) {
+		assert.Equal(t, models.OfficialApprovedEvent, (*events)[0].Type)
+		assert.Equal(t, "welcome", (*events)[0].Message)
+	}
+
+	// 公式アカウントは再申請できない
+	c, _ = postJSON(e, "/1.0/account/official_application.json", req, session)
+	if he, ok := jwtMiddleware(th.ApplyOfficial)(c).(*echo.HTTPError); !ok || he.Message != ErrAlreadyOfficial {
+		t.Fatalf("official user should not apply again, actual %v", he)
+	}
+}
@@ -0,0 +1,177 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/token"
+	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/TinyKitten/TimelineServer/validation"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type (
+	PasswordResetRequestReq struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+	PasswordResetReq struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required"`
+	}
+	ChangePasswordReq struct {
+		CurrentPassword string `json:"current_password" validate:"required"`
+		NewPassword     string `json:"new_password" validate:"required"`
+	}
+)
+
+// RequestPasswordReset パスワード再設定メールを送信する
+// アドレスの登録有無が分からないよう、常に同じレスポンスを返す
+func (h *APIHandler) RequestPasswordReset(c echo.Context) error {
+	req := new(PasswordResetRequestReq)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if err := c.Validate(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return badFields(validation.FromValidator(req, err))
+	}
+
+	u, err := h.db.FindUserByEmail(req.Email)
+	if err != nil && err != mgo.ErrNotFound {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if err == nil {
+		h.sendPasswordResetMail(*u)
+	}
+
+	return c.JSON(http.StatusOK, &messageResponse{Message: RespMailSent})
+}
+
+// CheckPasswordReset 再設定リンクがまだ有効か確認する
+func (h *APIHandler) CheckPasswordReset(c echo.Context) error {
+	if _, err := h.passwordResetTarget(c.QueryParam("token")); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &messageResponse{Message: "ok"})
+}
+
+// ResetPassword 再設定トークンを使ってパスワードを変更し、既存のセッションを失効させる
+func (h *APIHandler) ResetPassword(c echo.Context) error {
+	req := new(PasswordResetReq)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if err := c.Validate(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return badFields(validation.FromValidator(req, err))
+	}
+
+	u, err := h.passwordResetTarget(req.Token)
+	if err != nil {
+		return err
+	}
+	if err := h.rules.Password(req.Password, u.UserID); err != nil {
+		return badFields(validation.FieldErrors{"password": err.Error()})
+	}
+
+	hashed, err := utils.HashPassword(req.Password)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+	}
+	err = h.db.ResetPassword(u.ID, u.TokenVersion, hashed)
+	if err == mgo.ErrNotFound {
+		// 同じリンクが並行して使われた
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrInvalidLink}
+	}
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	return c.JSON(http.StatusOK, &messageResponse{Message: "ok"})
+}
+
+// ChangePassword 現在のパスワードを確認して変更し、他のセッションを失効させる
+// 呼び出し元のセッションも失効するため、新しいトークンを返す
+func (h *APIHandler) ChangePassword(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	req := new(ChangePasswordReq)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if err := c.Validate(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return badFields(validation.FromValidator(req, err))
+	}
+
+	u, err := h.db.FindUserByOID(id, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if matched := utils.CheckPasswordHash(req.CurrentPassword, u.Password); !matched {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrLoginFailed}
+	}
+	if err := h.rules.Password(req.NewPassword, u.UserID); err != nil {
+		return badFields(validation.FieldErrors{"new_password": err.Error()})
+	}
+
+	hashed, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+	}
+	if err := h.db.SetPassword(id, hashed); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	updated, err := h.db.FindUserByOID(id, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	token, err := token.CreateToken(updated.ID, updated.TokenVersion)
+	if err != nil {
+		h.logger.Error("Failed to create jwt token", zap.String("Reason", err.Error()))
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+	}
+	resp := models.UserToLoginSucessResponse(*updated, token)
+	return c.JSON(http.StatusOK, resp)
+}
+
+// passwordResetTarget 再設定トークンを検証し、対象のユーザを返す
+func (h *APIHandler) passwordResetTarget(tokenStr string) (*models.User, error) {
+	id, version, err := token.ParsePasswordResetToken(tokenStr, h.clock.Now())
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return nil, &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrInvalidLink}
+	}
+
+	u, err := h.db.FindUserByOID(id, false)
+	if err == mgo.ErrNotFound {
+		return nil, &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrInvalidLink}
+	}
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return nil, handleMgoError(err)
+	}
+	// パスワード変更済み(使用済みのリンクを含む)
+	if u.TokenVersion != version {
+		return nil, &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrInvalidLink}
+	}
+	return u, nil
+}
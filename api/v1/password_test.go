@@ -0,0 +1,118 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+var linkPattern = regexp.MustCompile(`https?://\S+`)
+
+// lastMailLink 最後に送信されたメールに含まれるリンクのtokenパラメータを返す
+func lastMailLink(t *testing.T, to string) string {
+	th.mailQueue.Flush()
+	sent := testMailer.Sent()
+	for i := len(sent) - 1; i >= 0; i-- {
+		if sent[i].To != to {
+			continue
+		}
+		link := linkPattern.FindString(sent[i].Body)
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.Query().Get("token")
+	}
+	t.Fatalf("no mail sent to %s", to)
+	return ""
+}
+
+func TestPasswordReset(t *testing.T) {
+	hashed, err := utils.HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := models.NewUser("forgetful", hashed, "forgetful@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, u)
+	oldSession := sessions[0]
+
+	// 未登録のアドレスでも同じレスポンス
+	c, rec := postJSON(e, "/1.0/account/password_reset_request.json", PasswordResetRequestReq{Email: "nobody@example.com"}, "")
+	if assert.NoError(t, th.RequestPasswordReset(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	c, rec = postJSON(e, "/1.0/account/password_reset_request.json", PasswordResetRequestReq{Email: u.EMail}, "")
+	if assert.NoError(t, th.RequestPasswordReset(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	resetToken := lastMailLink(t, u.EMail)
+
+	c, rec = postJSON(e, "/1.0/account/password_reset.json", PasswordResetReq{Token: resetToken, Password: "new password"}, "")
+	if assert.NoError(t, th.ResetPassword(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// 同じリンクは二度使えない
+	c, _ = postJSON(e, "/1.0/account/password_reset.json", PasswordResetReq{Token: resetToken, Password: "another pass"}, "")
+	if err := th.ResetPassword(c); err == nil {
+		t.Fatal("used reset link should be rejected")
+	}
+
+	// 以前のセッションは失効している
+	c, _ = postJSON(e, "/1.0/account/resend_verification.json", nil, oldSession)
+	err = jwtMiddleware(th.RequireSession(th.ResendVerification))(c)
+	if he, ok := err.(*echo.HTTPError); !ok || he.Message != ErrSessionRevoked {
+		t.Fatalf("old session should be revoked, got %v", err)
+	}
+
+	c, rec = postJSON(e, "/1.0/account/login.json", LoginReq{ID: u.UserID, Password: "new password"}, "")
+	if assert.NoError(t, th.Login(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		resp := models.LoginSuccessResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		assert.NotEmpty(t, resp.SessionToken)
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	e := echo.New()
+	e.Validator = &CustomValidator{validator: validator.New()}
+
+	c, rec := postJSON(e, "/1.0/account/create.json", SignupReq{
+		ID:       "verifyme",
+		Email:    "verifyme@example.com",
+		Password: "password1",
+	}, "")
+	if assert.NoError(t, th.AccountCreate(c)) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+
+	q := make(url.Values)
+	q.Set("token", lastMailLink(t, "verifyme@example.com"))
+	req := httptest.NewRequest(echo.GET, "/1.0/account/verify_email.json?"+q.Encode(), nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	if assert.NoError(t, th.VerifyEmail(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	u, err := th.db.FindUser("verifyme", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.EmailVerified {
+		t.Fatal("email should be verified")
+	}
+}
@@ -0,0 +1,319 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TinyKitten/TimelineServer/imaging"
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/TinyKitten/TimelineServer/validation"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// profileFieldsMax プロフィールの任意の項目の最大数
+	profileFieldsMax = 4
+	// profileFieldNameMaxLength 任意の項目の名前の最大文字数
+	profileFieldNameMaxLength = 30
+	// profileFieldValueMaxLength 任意の項目の値の最大文字数
+	profileFieldValueMaxLength = 100
+	// birthdayMinYear 誕生日に設定できる最も古い年
+	birthdayMinYear = 1900
+)
+
+var errInvalidValue = errors.New("invalid")
+
+// patchValue JSON Merge Patch(RFC 7396)の1項目。省略・null・値の指定を区別する
+type patchValue struct {
+	set bool
+	raw json.RawMessage
+}
+
+// UnmarshalJSON キーが存在する場合だけ呼ばれる
+func (p *patchValue) UnmarshalJSON(b []byte) error {
+	p.set = true
+	p.raw = append(p.raw[:0], b...)
+	return nil
+}
+
+// null nullが指定されたか
+func (p patchValue) null() bool {
+	return bytes.Equal(bytes.TrimSpace(p.raw), []byte("null"))
+}
+
+// decode 指定された値をvに読み込む
+func (p patchValue) decode(v interface{}) error {
+	return json.Unmarshal(p.raw, v)
+}
+
+// profileText プロフィールの文字列の項目
+type profileText struct {
+	name     string // JSONのキー
+	field    string // DBのフィールド
+	value    patchValue
+	max      int
+	required bool // 削除できない
+	url      bool
+}
+
+// profileUpdate 変更を検証し、DBに設定する値と削除するフィールドを返す
+// skipEmptyの場合は以前のPOSTと同じく、nullや空の値を指定した項目を変更しない
+// それ以外の場合はnullを指定した項目だけを削除する
+func (h *APIHandler) profileUpdate(id bson.ObjectId, req *AccountSettingsRequest, skipEmpty bool) (bson.M, []string, validation.FieldErrors) {
+	set, unset, errs := bson.M{}, []string{}, validation.FieldErrors{}
+	// omitted 変更しない項目か
+	omitted := func(p patchValue) bool {
+		return !p.set || (skipEmpty && p.null())
+	}
+
+	texts := []profileText{
+		{name: "name", field: "displayName", value: req.Name, max: 50, required: true},
+		{name: "url", field: "websiteUrl", value: req.URL, max: 100, url: true},
+		{name: "location", field: "location", value: req.Location, max: 30},
+		{name: "description", field: "description", value: req.Description, max: 160},
+	}
+	for _, t := range texts {
+		if omitted(t.value) {
+			continue
+		}
+		if t.value.null() {
+			if t.required {
+				errs.Add(t.name, errors.New("required"))
+			} else {
+				unset = append(unset, t.field)
+			}
+			continue
+		}
+		var v string
+		if err := t.value.decode(&v); err != nil {
+			errs.Add(t.name, errInvalidValue)
+			continue
+		}
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "" && skipEmpty:
+			// 空の値は変更しない
+		case v == "" && t.required:
+			errs.Add(t.name, errors.New("required"))
+		case utf8.RuneCountInString(v) > t.max:
+			errs.Add(t.name, errors.New("must be at most "+strconv.Itoa(t.max)+" characters"))
+		case v != "" && t.url && !isWebURL(v):
+			errs.Add(t.name, errors.New("invalid url"))
+		default:
+			set[t.field] = v
+		}
+	}
+
+	if !omitted(req.Birthday) {
+		if req.Birthday.null() {
+			unset = append(unset, "birthday")
+		} else if b, err := h.parseBirthday(req.Birthday); err != nil {
+			errs.Add("birthday", err)
+		} else {
+			set["birthday"] = b
+		}
+	}
+
+	if !omitted(req.ProfileFields) {
+		fields := []models.ProfileField{}
+		if req.ProfileFields.null() {
+			unset = append(unset, "profileFields")
+		} else if err := req.ProfileFields.decode(&fields); err != nil {
+			errs.Add("profile_fields", errInvalidValue)
+		} else if err := validateProfileFields(fields); err != nil {
+			errs.Add("profile_fields", err)
+		} else if len(fields) != 0 || !skipEmpty {
+			set["profileFields"] = fields
+		}
+	}
+
+	if !omitted(req.PinnedPostID) {
+		var postID string
+		if req.PinnedPostID.null() {
+			unset = append(unset, "pinnedPostId")
+		} else if err := req.PinnedPostID.decode(&postID); err != nil {
+			errs.Add("pinned_post_id", errInvalidValue)
+		} else if postID != "" || !skipEmpty {
+			if err := h.checkPinnablePost(id, postID); err != nil {
+				errs.Add("pinned_post_id", err)
+			} else {
+				set["pinnedPostId"] = bson.ObjectIdHex(postID)
+			}
+		}
+	}
+
+	return set, unset, errs
+}
+
+// isWebURL http・httpsの絶対URLか
+func isWebURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// parseBirthday 誕生日を検証する。公開範囲を省略した場合は本人だけに表示する
+// 一部の項目だけを変更することはできず、常に全体を置き換える
+func (h *APIHandler) parseBirthday(p patchValue) (*models.Birthday, error) {
+	b := new(models.Birthday)
+	if err := p.decode(b); err != nil {
+		return nil, errInvalidValue
+	}
+	if b.Visibility == "" {
+		b.Visibility = models.BirthdayPrivate
+	}
+	if b.YearVisibility == "" {
+		b.YearVisibility = models.BirthdayPrivate
+	}
+	if !b.Visibility.Valid() || !b.YearVisibility.Valid() {
+		return nil, errors.New("invalid visibility")
+	}
+
+	now := h.clock.Now()
+	// 年を設定しない場合も2月29日を受け付けるよう、うるう年で確かめる
+	year := b.Year
+	if year == 0 {
+		year = 2000
+	} else if year < birthdayMinYear || year > now.Year() {
+		return nil, errors.New("invalid date")
+	}
+	d := time.Date(year, time.Month(b.Month), b.Day, 0, 0, 0, 0, time.UTC)
+	if b.Month < 1 || b.Month > 12 || d.Month() != time.Month(b.Month) || d.Day() != b.Day {
+		return nil, errors.New("invalid date")
+	}
+	if b.Year != 0 && d.After(now) {
+		return nil, errors.New("invalid date")
+	}
+	return b, nil
+}
+
+// validateProfileFields 任意の項目の数と長さを確かめ、前後の空白を取り除く
+func validateProfileFields(fields []models.ProfileField) error {
+	if len(fields) > profileFieldsMax {
+		return errors.New("max " + strconv.Itoa(profileFieldsMax))
+	}
+	for i := range fields {
+		fields[i].Name = strings.TrimSpace(fields[i].Name)
+		fields[i].Value = strings.TrimSpace(fields[i].Value)
+		if fields[i].Name == "" {
+			return errors.New("name is required")
+		}
+		if utf8.RuneCountInString(fields[i].Name) > profileFieldNameMaxLength ||
+			utf8.RuneCountInString(fields[i].Value) > profileFieldValueMaxLength {
+			return errors.New("too long")
+		}
+	}
+	return nil
+}
+
+// checkPinnablePost 自分のポストであれば固定できる
+func (h *APIHandler) checkPinnablePost(userID bson.ObjectId, postID string) error {
+	if !bson.IsObjectIdHex(postID) {
+		return errInvalidValue
+	}
+	post, err := h.db.FindPost(bson.ObjectIdHex(postID), true)
+	if err != nil || post.UserID != userID {
+		return errors.New("not found")
+	}
+	return nil
+}
+
+// storeProfileImage data URIの画像を検証して保存し、保存先のキーを返す
+// 元の画像は保存せず、variantsの最初の大きさを元の画像の代わりにする
+// アニメーションGIFは許可されている場合、アニメーションのまま縮小して保存する
+func (h *APIHandler) storeProfileImage(dataURI string, variants []imaging.VariantSpec) (string, error) {
+	dat, err := utils.DecodeImage(dataURI)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return "", &echo.HTTPError{Code: http.StatusUnsupportedMediaType, Message: ErrMediaNotSupported}
+	}
+	img, err := h.images.Process(dat, variants)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return "", imageError(err)
+	}
+
+	key := contentKey(img.Variants[0].Data, img.Variants[0].Format)
+	files := map[string]storedFile{key: {img.Variants[0].Data, img.Variants[0].ContentType}}
+	for _, v := range img.Variants[1:] {
+		files[imaging.VariantPath(key, v.Name)] = storedFile{v.Data, v.ContentType}
+	}
+	if err := h.storeBlob(key, files); err != nil {
+		h.logger.Error("Failed to save image", zap.String("Reason", err.Error()))
+		return "", storeBlobError(err)
+	}
+	return key, nil
+}
+
+// bannerKeys ヘッダー画像とその縮小版の保存先のキーを返す
+func bannerKeys(u models.User) []string {
+	if u.BannerKey == "" {
+		return nil
+	}
+	keys := []string{u.BannerKey}
+	for _, v := range imaging.BannerVariants[1:] {
+		keys = append(keys, imaging.VariantPath(u.BannerKey, v.Name))
+	}
+	return keys
+}
+
+// UpdateProfileBanner ヘッダー画像をdata URIで受け取って差し替える
+func (h *APIHandler) UpdateProfileBanner(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	req := new(AccountImageRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+
+	key, err := h.storeProfileImage(req.Image, imaging.BannerVariants)
+	if err != nil {
+		return err
+	}
+	if err := h.db.UpdateBanner(id, h.storage.URL(key), key); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		h.releaseBlob(key)
+		return handleMgoError(err)
+	}
+
+	u, err := h.db.FindUserByOID(id, true)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+	}
+	return c.JSON(http.StatusCreated, models.UserToUserResponseFor(*u, id))
+}
+
+// RemoveProfileBanner ヘッダー画像を外す。ファイルは参照がなくなった後に掃除で削除する
+func (h *APIHandler) RemoveProfileBanner(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	if err := h.db.UpdateBanner(id, "", ""); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	u, err := h.db.FindUserByOID(id, true)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+	}
+	return c.JSON(http.StatusOK, models.UserToUserResponseFor(*u, id))
+}
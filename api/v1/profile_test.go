@@ -0,0 +1,165 @@
+package v1
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchAccountSettings(t *testing.T) {
+	th.clock = &utils.FakeClock{Current: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+	defer func() { th.clock = utils.NewClock() }()
+
+	u := models.NewUser("patcher", "", "patcher@example.com", false)
+	follower := models.NewUser("patchfan", "", "patchfan@example.com", false)
+	u.Followers = append(u.Followers, follower.ID)
+	e, jwtMiddleware, sessions := setupTest(t, u, follower)
+	session := sessions[0]
+	post := models.NewPost(u.ID, "", "pinned")
+	other := models.NewPost(follower.ID, "", "not mine")
+	for _, p := range []*models.Post{post, other} {
+		if err := th.db.Insert("posts", p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send := func(method string, body map[string]interface{}) (*models.UserResponse, error) {
+		c, rec := postJSON(e, "/1.0/account/settings.json", body, session)
+		c.Request().Method = method
+		if err := jwtMiddleware(th.SetAccountSettings)(c); err != nil {
+			return nil, err
+		}
+		resp := new(models.UserResponse)
+		if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp, nil
+	}
+	patch := func(body map[string]interface{}) (*models.UserResponse, error) {
+		return send(echo.PATCH, body)
+	}
+
+	resp, err := patch(map[string]interface{}{
+		"name":           "Patcher",
+		"location":       "Tokyo",
+		"description":    "hello",
+		"birthday":       map[string]interface{}{"year": 2000, "month": 2, "day": 29, "visibility": "followers"},
+		"pinned_post_id": post.ID.Hex(),
+		"profile_fields": []models.ProfileField{{Name: "Pronouns", Value: "they/them"}},
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Patcher", resp.DisplayName)
+		assert.Equal(t, "Tokyo", resp.Location)
+		assert.Equal(t, &models.BirthdayResponse{Year: 2000, Month: 2, Day: 29}, resp.Birthday)
+		assert.Equal(t, post.ID, resp.PinnedPostID)
+		assert.Equal(t, 1, len(resp.ProfileFields))
+	}
+
+	// 年の公開範囲を省略すると本人以外には月日だけ見える
+	stored, _ := th.db.FindUserByOID(u.ID, true)
+	assert.Equal(t, &models.BirthdayResponse{Month: 2, Day: 29}, models.UserToUserResponseFor(*stored, follower.ID).Birthday)
+	assert.Nil(t, models.UserToUserResponseFor(*stored, "").Birthday)
+
+	// POSTでは空の値を指定した項目を変更しない
+	resp, err = send(echo.POST, map[string]interface{}{"name": "", "location": "", "description": " ", "url": "https://example.com", "pinned_post_id": "", "profile_fields": []models.ProfileField{}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Patcher", resp.DisplayName)
+		assert.Equal(t, "Tokyo", resp.Location)
+		assert.Equal(t, "hello", resp.Description)
+		assert.Equal(t, "https://example.com", resp.WebsiteURL)
+		assert.Equal(t, post.ID, resp.PinnedPostID)
+		assert.Equal(t, 1, len(resp.ProfileFields))
+	}
+	_, err = send(echo.POST, map[string]interface{}{"name": "", "location": nil})
+	if he, ok := err.(*echo.HTTPError); !ok || he.Message != ErrParamsRequired {
+		t.Fatalf("expected params required, actual %v", err)
+	}
+
+	// PATCHではnullを指定した項目だけを削除し、省略した項目はそのまま
+	resp, err = patch(map[string]interface{}{"location": nil, "url": nil, "description": "", "profile_fields": nil})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Patcher", resp.DisplayName)
+		assert.Empty(t, resp.Location)
+		assert.Empty(t, resp.WebsiteURL)
+		assert.Empty(t, resp.Description)
+		assert.Empty(t, resp.ProfileFields)
+		assert.Equal(t, post.ID, resp.PinnedPostID)
+		assert.NotNil(t, resp.Birthday)
+	}
+	_, err = patch(map[string]interface{}{"name": ""})
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("expected validation error, actual %v", err)
+	}
+
+	// 固定したポストを削除すると外れる
+	assert.NoError(t, th.db.DeletePost(post.ID))
+	stored, _ = th.db.FindUserByOID(u.ID, true)
+	assert.False(t, stored.PinnedPostID.Valid())
+
+	// 不正な値はまとめて項目ごとのエラーを返し、何も変更しない
+	_, err = patch(map[string]interface{}{
+		"name":           nil,
+		"url":            "javascript:alert(1)",
+		"birthday":       map[string]interface{}{"year": 2001, "month": 2, "day": 29},
+		"pinned_post_id": other.ID.Hex(),
+		"profile_fields": []models.ProfileField{{}, {}, {}, {}, {}},
+	})
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("expected validation error, actual %v", err)
+	}
+	fields := he.Message.(*fieldErrorResponse).Errors
+	for _, name := range []string{"name", "url", "birthday", "pinned_post_id", "profile_fields"} {
+		assert.Contains(t, fields, name)
+	}
+	stored, _ = th.db.FindUserByOID(u.ID, true)
+	assert.Equal(t, "Patcher", stored.DisplayName)
+
+	// 変更する項目がない
+	_, err = patch(map[string]interface{}{})
+	if he, ok := err.(*echo.HTTPError); !ok || he.Message != ErrParamsRequired {
+		t.Fatalf("expected params required, actual %v", err)
+	}
+}
+
+func TestProfileBanner(t *testing.T) {
+
+	u := models.NewUser("bannered", "", "bannered@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, u)
+	session := sessions[0]
+
+	req := AccountImageRequest{Image: "data:image/png;base64," + base64.StdEncoding.EncodeToString(testPNG(300, 200))}
+	c, rec := postJSON(e, "/1.0/account/update_profile_banner.json", req, session)
+	if assert.NoError(t, jwtMiddleware(th.UpdateProfileBanner)(c)) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+	resp := new(models.UserResponse)
+	if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+	key, ok := testStorage.Key(resp.BannerURL)
+	if !ok {
+		t.Fatalf("banner should be stored: %s", resp.BannerURL)
+	}
+	if blob, err := th.db.FindBlob(key); assert.NoError(t, err) {
+		assert.Equal(t, 1, blob.Refs)
+		assert.Equal(t, len(bannerKeys(models.User{BannerKey: key})), len(blob.Files))
+	}
+
+	c, rec = postJSON(e, "/1.0/account/remove_profile_banner.json", nil, session)
+	if assert.NoError(t, jwtMiddleware(th.RemoveProfileBanner)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	stored, _ := th.db.FindUserByOID(u.ID, true)
+	assert.Empty(t, stored.BannerURL)
+	if blob, err := th.db.FindBlob(key); assert.NoError(t, err) {
+		assert.Equal(t, 0, blob.Refs)
+	}
+}
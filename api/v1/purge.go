@@ -0,0 +1,87 @@
+package v1
+
+import (
+	"os"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/imaging"
+	"github.com/TinyKitten/TimelineServer/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// accountPurgeGrace 退会手続きから全データを削除するまでの猶予期間
+	accountPurgeGrace = 30 * 24 * time.Hour
+	// accountPurgeInterval 削除対象のアカウントを確認する間隔
+	accountPurgeInterval = time.Hour
+)
+
+// StartAccountPurger 削除日時を過ぎたアカウントを定期的に削除する
+func (h *APIHandler) StartAccountPurger(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := h.PurgeDeactivatedAccounts(); err != nil {
+				h.logger.Error("Failed to purge accounts", zap.String("Reason", err.Error()))
+			}
+		}
+	}()
+}
+
+// PurgeDeactivatedAccounts 削除日時を過ぎたアカウントの全データを削除する
+// 失敗したアカウントは次回に再試行する
+func (h *APIHandler) PurgeDeactivatedAccounts() error {
+	users, err := h.db.FindUsersToPurge(h.clock.Now())
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if err := h.purgeAccount(u); err != nil {
+			h.logger.Error("Failed to purge account",
+				zap.String("ID", u.ID.Hex()),
+				zap.String("Reason", err.Error()))
+		}
+	}
+	return nil
+}
+
+// purgeAccount DB上のデータとアップロードされたファイルを削除する
+func (h *APIHandler) purgeAccount(u models.User) error {
+	exports, err := h.db.FindExportJobsByUser(u.ID)
+	if err != nil {
+		return err
+	}
+	// メディアとプロフィール画像のファイルは、参照がなくなった後に掃除で削除する
+	if err := h.db.PurgeUser(u.ID); err != nil {
+		return err
+	}
+	for _, job := range exports {
+		if job.Path == "" {
+			continue
+		}
+		if err := os.Remove(job.Path); err != nil && !os.IsNotExist(err) {
+			h.logger.Error("Failed to remove file", zap.String("Reason", err.Error()))
+		}
+	}
+	if u.AvatarKey == "" {
+		// 重複排除の導入前の画像は参照の記録がないので、ここで削除する
+		h.removeFiles(h.avatarKeys(u.AvatarURL))
+	}
+	h.logger.Info("Account purged", zap.String("ID", u.ID.Hex()), zap.String("ScreenName", u.UserID))
+	return nil
+}
+
+// avatarKeys プロフィール画像とその縮小版の保存先のキーを返す
+// 保存先にアップロードされた画像でなければnil
+func (h *APIHandler) avatarKeys(avatarURL string) []string {
+	key, ok := h.storage.Key(avatarURL)
+	if !ok {
+		return nil
+	}
+	keys := []string{key}
+	for _, v := range imaging.AvatarVariants[1:] {
+		keys = append(keys, imaging.VariantPath(key, v.Name))
+	}
+	return keys
+}
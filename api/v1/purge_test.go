@@ -0,0 +1,134 @@
+package v1
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestDeactivateAndReactivate(t *testing.T) {
+	clock := &utils.FakeClock{Current: time.Now()}
+	th.clock = clock
+	defer func() { th.clock = utils.NewClock() }()
+
+	hashed, err := utils.HashPassword("password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := models.NewUser("leaving", hashed, "leaving@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, u)
+	session := sessions[0]
+
+	c, rec := postJSON(e, "/1.0/account/deactivate.json", DeactivateRequest{Password: "password1"}, session)
+	err = jwtMiddleware(th.DeactivateAccount)(c)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	if _, err := th.findUserByScreenName("leaving"); err != mgo.ErrNotFound {
+		t.Fatalf("deactivated user should be hidden, actual %v", err)
+	}
+
+	// 猶予期間内のログインで復帰する
+	clock.Advance(accountPurgeGrace / 2)
+	c, rec = postJSON(e, "/1.0/account/login.json", LoginReq{ID: "leaving", Password: "password1"}, "")
+	if assert.NoError(t, th.Login(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	if _, err := th.findUserByScreenName("leaving"); err != nil {
+		t.Fatalf("user should be reactivated, actual %v", err)
+	}
+
+	// 猶予期間を過ぎると削除される
+	if err := th.db.DeactivateUser(u.ID, clock.Now(), clock.Now().Add(accountPurgeGrace)); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(accountPurgeGrace)
+	c, _ = postJSON(e, "/1.0/account/login.json", LoginReq{ID: "leaving", Password: "password1"}, "")
+	if he, ok := th.Login(c).(*echo.HTTPError); !ok || he.Message != ErrLoginFailed {
+		t.Fatalf("expired account should not log in, actual %v", he)
+	}
+	if err := th.PurgeDeactivatedAccounts(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := th.db.FindUserByOID(u.ID, false); err != mgo.ErrNotFound {
+		t.Fatalf("user should be purged, actual %v", err)
+	}
+}
+
+func TestDeleteUserHandler(t *testing.T) {
+	gone := models.NewUser("gone", "", "gone@example.com", false)
+	friend := models.NewUser("friend", "", "friend@example.com", false)
+	friend.Roles = []models.Role{models.RoleAdmin}
+	e, jwtMiddleware, sessions := setupTest(t, gone, friend)
+	if err := th.db.FollowUser(friend.ID, gone.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := th.db.FollowUser(gone.ID, friend.ID); err != nil {
+		t.Fatal(err)
+	}
+	own := models.NewPost(gone.ID, "", "bye")
+	theirs := models.NewPost(friend.ID, "", "hi")
+	for _, p := range []*models.Post{own, theirs} {
+		if err := th.db.UpdatePost(*p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := th.db.CreateLike(theirs.ID, gone.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := th.db.InsertEvent(gone.ID, friend.ID, models.FollowEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	c, rec := postJSON(e, "/1.0/super/delete_user.json", ABasicRequest{UserID: gone.ID}, sessions[1])
+	err := jwtMiddleware(th.RequireRole(models.RoleAdmin)(th.ADeleteUserHandler))(c)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// 監査ログにはメールアドレスを残さない
+	logs, err := th.db.FindAuditLogs(models.AuditFilter{TargetID: gone.ID, Action: models.AuditDeleteUser}, 0, 1)
+	if assert.NoError(t, err) && assert.Equal(t, 1, len(logs)) {
+		assert.Equal(t, gone.UserID, logs[0].Before["screen_name"])
+		_, ok := logs[0].Before["email"]
+		assert.False(t, ok)
+	}
+
+	if _, err := th.db.FindUserByOID(gone.ID, true); err != mgo.ErrNotFound {
+		t.Fatalf("cached user should be removed, actual %v", err)
+	}
+	if _, err := th.db.FindPost(own.ID, true); err != mgo.ErrNotFound {
+		t.Fatalf("posts should be removed, actual %v", err)
+	}
+	f, err := th.db.FindUserByOID(friend.ID, true)
+	if assert.NoError(t, err) {
+		assert.False(t, containsID(f.Following, gone.ID))
+		assert.False(t, containsID(f.Followers, gone.ID))
+	}
+	p, err := th.db.FindPost(theirs.ID, true)
+	if assert.NoError(t, err) {
+		assert.False(t, containsID(p.FavoritedIds, gone.ID))
+	}
+	events, err := th.db.GetEvents(friend.ID)
+	if assert.NoError(t, err) {
+		for _, ev := range *events {
+			assert.NotEqual(t, gone.ID, ev.FromUserID)
+		}
+	}
+}
+
+func containsID(ids []bson.ObjectId, id bson.ObjectId) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
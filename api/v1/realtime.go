@@ -1,19 +1,21 @@
 package v1
 
 import (
-	"encoding/json"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/TinyKitten/TimelineServer/config"
 	"github.com/TinyKitten/TimelineServer/models"
-	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/TinyKitten/TimelineServer/realtime"
+	"github.com/TinyKitten/TimelineServer/validation"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo"
 	"go.uber.org/zap"
+	"gopkg.in/mgo.v2/bson"
 )
 
 var (
-	postChan chan models.PostResponse
 	upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
@@ -25,114 +27,160 @@ var (
 
 const (
 	loggerTopic = "Realtime Stream"
+	// listStreamRecheck リストのストリームでアクセス権とメンバーを確かめ直す間隔
+	listStreamRecheck = 30 * time.Second
 )
 
+// RealtimeHandler 自分と自分がフォローしている人の投稿を配信する
+// list_idを指定した場合はそのリストのメンバーの投稿のみ配信する(listMembersを参照)
 func (h *APIHandler) RealtimeHandler(c echo.Context) error {
-	config := config.GetAPIConfig()
-	tokenStr := c.QueryParam("token")
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Jwt), nil
-	})
+	claims, err := h.parseQueryToken(c)
 	if err != nil {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
-	}
-	if !token.Valid {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+		return err
 	}
-	claims := token.Claims.(jwt.MapClaims)
 	claimID := claims["id"].(string)
 
-	postChan = make(chan models.PostResponse)
+	if c.QueryParam("list_id") != "" {
+		viewerID := bson.ObjectIdHex(claimID)
+		list, err := h.findVisibleList(queryListID(c), viewerID)
+		if err != nil {
+			return err
+		}
+		members := newListMembers(*list)
+		return h.stream(c, members.filter, func() error {
+			return h.reloadListMembers(members, list.ID, viewerID)
+		})
+	}
 
-	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	return h.stream(c, func(post models.PostResponse) bool {
+		if post.User.ID == claimID {
+			return true
+		}
+		// 自分がフォローしている人の投稿
+		for _, follower := range post.User.Followers {
+			if claimID == follower.Hex() {
+				return true
+			}
+		}
+		return false
+	}, nil)
+}
+
+// UnionHandler 全ての投稿を配信する
+// trackを指定した場合はハッシュタグ・キーワードに一致する投稿のみ配信する(realtime.TrackFilterを参照)
+func (h *APIHandler) UnionHandler(c echo.Context) error {
+	_, err := h.parseQueryToken(c)
 	if err != nil {
 		return err
 	}
-	defer ws.Close()
-	defer close(postChan)
 
-	for {
-		go func(postChan chan models.PostResponse) {
-			for post := range postChan {
-				bytes, err := json.Marshal(post)
-				if err != nil {
-					c.Logger().Error(err)
-				}
-				if post.User.ID == claimID {
-					err := ws.WriteMessage(websocket.TextMessage, bytes)
-					if err != nil {
-						c.Logger().Error(err)
-					}
-				} else {
-					// 自分がフォローしている人の投稿
-					if len(post.User.Followers) != 0 {
-						for _, follower := range post.User.Followers {
-							if claimID == follower.Hex() {
-								err := ws.WriteMessage(websocket.TextMessage, bytes)
-								if err != nil {
-									c.Logger().Error(err)
-								}
-							}
-						}
-					}
-				}
-			}
-		}(postChan)
+	track, ok := c.QueryParams()["track"]
+	if !ok {
+		// 無条件で送信
+		return h.stream(c, nil, nil)
+	}
+	filter, err := realtime.TrackFilter(strings.Join(track, ","))
+	if err != nil {
+		return badFields(validation.FieldErrors{"track": err.Error()})
+	}
+	return h.stream(c, filter, nil)
+}
 
-		_, _, err := ws.ReadMessage()
-		if err != nil {
-			c.Logger().Error(err)
-		}
+// listMembers リストのストリームで配信するメンバー。接続中もreloadListMembersで読み直す
+type listMembers struct {
+	mu      sync.RWMutex
+	members map[string]struct{}
+}
+
+// newListMembers listのメンバーで作成する
+func newListMembers(list models.List) *listMembers {
+	m := new(listMembers)
+	m.set(list)
+	return m
+}
+
+// set メンバーをlistのものに置き換える
+func (m *listMembers) set(list models.List) {
+	members := make(map[string]struct{}, len(list.Members))
+	for _, id := range list.Members {
+		members[id.Hex()] = struct{}{}
 	}
+	m.mu.Lock()
+	m.members = members
+	m.mu.Unlock()
+}
 
-	return nil
+// filter リストのメンバーの投稿に一致するFilter
+func (m *listMembers) filter(post models.PostResponse) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.members[post.User.ID]
+	return ok
 }
 
-func (h *APIHandler) UnionHandler(c echo.Context) error {
-	config := config.GetAPIConfig()
-	tokenStr := c.QueryParam("token")
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Jwt), nil
-	})
-	if err != nil {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+// reloadListMembers リストを読み直してメンバーを更新する
+// リストが削除された場合や非公開になって見えなくなった場合はエラーを返す。一時的なエラーではそれまでのメンバーのまま配信を続ける
+func (h *APIHandler) reloadListMembers(m *listMembers, listID, viewerID bson.ObjectId) error {
+	list, err := h.findVisibleList(listID, viewerID)
+	if he, ok := err.(*echo.HTTPError); ok && he.Code == http.StatusNotFound {
+		return err
 	}
-	if !token.Valid {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+	if err != nil {
+		h.logger.Debug(loggerTopic, zap.String("Error", err.Error()))
+		return nil
 	}
+	m.set(*list)
+	return nil
+}
 
-	postChan = make(chan models.PostResponse)
-
+// stream WebSocketに接続し、filterに一致する投稿を切断されるまで配信する
+// recheckを指定した場合は一定の間隔で呼び出し、エラーを返したら切断する
+func (h *APIHandler) stream(c echo.Context, filter realtime.Filter, recheck func() error) error {
 	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
 	if err != nil {
 		return err
 	}
 	defer ws.Close()
 
-	for {
-		go func(postChan chan models.PostResponse) {
-			for post := range postChan {
-				bytes, err := json.Marshal(post)
-				if err != nil {
-					c.Logger().Error(err)
-				}
-				// 無条件で送信
-				err = ws.WriteMessage(websocket.TextMessage, bytes)
-				if err != nil {
-					c.Logger().Error(err)
-				}
-			}
-		}(postChan)
+	client := h.hub.Subscribe(filter)
+	defer h.hub.Unsubscribe(client)
 
-		_, _, err := ws.ReadMessage()
-		if err != nil {
-			c.Logger().Error(err)
+	// クライアントからのメッセージは使わないが、切断を検知するために読み続ける
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
 		}
+	}()
+
+	var tick <-chan time.Time
+	if recheck != nil {
+		ticker := time.NewTicker(listStreamRecheck)
+		defer ticker.Stop()
+		tick = ticker.C
 	}
 
-	return nil
+	for {
+		select {
+		case <-tick:
+			if err := recheck(); err != nil {
+				h.logger.Debug(loggerTopic, zap.String("Error", err.Error()))
+				ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, ErrNotFound))
+				return nil
+			}
+		case msg, ok := <-client.Messages():
+			if !ok {
+				return nil
+			}
+			if err := ws.WriteMessage(websocket.TextMessage, msg); err != nil {
+				h.logger.Debug(loggerTopic, zap.String("Error", err.Error()))
+				return nil
+			}
+		case <-closed:
+			return nil
+		}
+	}
 }
@@ -0,0 +1,260 @@
+package v1
+
+import (
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// reportCommentMaxLength 通報コメントの最大文字数
+	reportCommentMaxLength = 1000
+	// reportDefaultLimit 通報キューの既定の取得件数
+	reportDefaultLimit = 50
+	// reportMaxLimit 通報キューの最大取得件数
+	reportMaxLimit = 200
+)
+
+type (
+	ReportRequest struct {
+		UserID  bson.ObjectId       `json:"user_id"`
+		PostID  bson.ObjectId       `json:"post_id"`
+		Reason  models.ReportReason `json:"reason"`
+		Comment string              `json:"comment"`
+	}
+
+	AReportRequest struct {
+		ReportID     bson.ObjectId         `json:"report_id"`
+		Actions      []models.ReportAction `json:"actions"`
+		Note         string                `json:"note"`
+		SuspendUntil time.Time             `json:"suspend_until"`
+	}
+)
+
+// CreateReport ユーザまたはポストを通報する。post_idを指定した場合はその投稿者への通報になる
+func (h *APIHandler) CreateReport(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	req := new(ReportRequest)
+	if err := c.Bind(req); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if !req.Reason.Valid() || utf8.RuneCountInString(req.Comment) > reportCommentMaxLength {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+	}
+
+	targetID := req.UserID
+	if req.PostID.Valid() {
+		post, err := h.db.FindPost(req.PostID, true)
+		if err != nil {
+			return handleMgoError(err)
+		}
+		targetID = post.UserID
+	}
+	if !targetID.Valid() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if targetID == id {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrCannotReportSelf}
+	}
+	if _, err := h.db.FindUserByOID(targetID, true); err != nil {
+		return handleMgoError(err)
+	}
+
+	report := models.NewReport(id, targetID, req.PostID, req.Reason, req.Comment, h.clock.Now())
+	if err := h.db.CreateReport(*report); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	return c.JSON(http.StatusCreated, report)
+}
+
+// AGetReports 通報キューを古い順に返す。statusを指定しない場合は未処理の通報を返す
+func (h *APIHandler) AGetReports(c echo.Context) error {
+	statuses := []models.ReportStatus{models.ReportOpen, models.ReportClaimed}
+	if v := c.QueryParam("status"); v != "" {
+		status := models.ReportStatus(v)
+		switch status {
+		case models.ReportOpen, models.ReportClaimed, models.ReportResolved, models.ReportDismissed:
+			statuses = []models.ReportStatus{status}
+		default:
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+		}
+	}
+
+	limit, cursor, err := parsePage(c, "limit", reportDefaultLimit, reportMaxLimit)
+	if err != nil {
+		return err
+	}
+
+	reports, err := h.db.FindReports(statuses, cursor, limit)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return c.JSON(http.StatusOK, reports)
+}
+
+// AClaimReport 通報の対応を始める。他のモデレーターは対処・却下できなくなる
+func (h *APIHandler) AClaimReport(c echo.Context) error {
+	req := new(AReportRequest)
+	if err := c.Bind(req); err != nil || !req.ReportID.Valid() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+
+	report, err := h.db.ClaimReport(req.ReportID, actor(c).ID, h.clock.Now())
+	if err != nil {
+		return h.reportError(req.ReportID, err)
+	}
+	h.audit(c, models.AuditClaimReport, h.reportTarget(report), "", bson.M{"report_id": report.ID}, nil)
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// AResolveReport 通報に対処する。指定した対処を行い、通報者に通知する
+func (h *APIHandler) AResolveReport(c echo.Context) error {
+	req := new(AReportRequest)
+	if err := c.Bind(req); err != nil || !req.ReportID.Valid() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	for _, action := range req.Actions {
+		if !action.Valid() {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+		}
+	}
+	if !req.SuspendUntil.IsZero() && !req.SuspendUntil.After(h.clock.Now()) {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+	}
+
+	report, err := h.db.FindReport(req.ReportID)
+	if err != nil {
+		return handleMgoError(err)
+	}
+	for _, action := range req.Actions {
+		if action == models.ReportDeletePost && !report.TargetPostID.Valid() {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+		}
+	}
+
+	// 先に処理済みにして、同じ対処が二重に行われないようにする
+	// 対処に失敗した場合は対応中に戻し、もう一度処理できるようにする
+	report, err = h.db.CloseReport(req.ReportID, actor(c).ID, models.ReportResolved, req.Actions, req.Note, h.clock.Now())
+	if err != nil {
+		return h.reportError(req.ReportID, err)
+	}
+	target := h.reportTarget(report)
+	for _, action := range report.Actions {
+		if err := h.applyReportAction(c, report, target, action, req.SuspendUntil); err != nil {
+			h.logger.Error("Failed to apply report action",
+				zap.String("Report", report.ID.Hex()),
+				zap.String("Action", string(action)),
+				zap.String("Reason", err.Error()))
+			if err := h.db.ReopenReport(report.ID, actor(c).ID, h.clock.Now()); err != nil {
+				h.logger.Error("Failed to reopen report",
+					zap.String("Report", report.ID.Hex()),
+					zap.String("Reason", err.Error()))
+			}
+			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+		}
+	}
+	h.audit(c, models.AuditResolveReport, target, req.Note,
+		bson.M{"report_id": report.ID}, bson.M{"status": report.Status, "actions": report.Actions})
+
+	h.notifyReporter(report, models.ReportResolvedEvent)
+	return c.JSON(http.StatusOK, report)
+}
+
+// ADismissReport 通報を対処不要として却下し、通報者に通知する
+func (h *APIHandler) ADismissReport(c echo.Context) error {
+	req := new(AReportRequest)
+	if err := c.Bind(req); err != nil || !req.ReportID.Valid() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+
+	report, err := h.db.CloseReport(req.ReportID, actor(c).ID, models.ReportDismissed, nil, req.Note, h.clock.Now())
+	if err != nil {
+		return h.reportError(req.ReportID, err)
+	}
+	h.audit(c, models.AuditDismissReport, h.reportTarget(report), req.Note,
+		bson.M{"report_id": report.ID}, bson.M{"status": report.Status})
+
+	h.notifyReporter(report, models.ReportDismissedEvent)
+	return c.JSON(http.StatusOK, report)
+}
+
+// applyReportAction 通報の対処を1つ行い、監査ログに残す
+// untilは凍結の期限で、ゼロ値の場合は無期限
+func (h *APIHandler) applyReportAction(c echo.Context, report *models.Report, target *models.User, action models.ReportAction, until time.Time) error {
+	reason := "report " + report.ID.Hex()
+	switch action {
+	case models.ReportDeletePost:
+		err := h.db.DeletePost(report.TargetPostID)
+		if err == mgo.ErrNotFound {
+			// 既に削除されている
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		h.audit(c, models.AuditDeletePost, target, reason, bson.M{"post_id": report.TargetPostID}, nil)
+	case models.ReportSuspendUser:
+		if target == nil {
+			return nil
+		}
+		return h.suspend(c, target, until, reportReason(report))
+	case models.ReportWarnUser:
+		if target == nil {
+			return nil
+		}
+		return h.warn(c, target, report.ID, reportReason(report))
+	}
+	return nil
+}
+
+// reportReason 凍結や警告の理由として本人に表示する文面。メモがなければ通報理由の分類を使う
+func reportReason(report *models.Report) string {
+	if report.Note != "" {
+		return report.Note
+	}
+	return string(report.Reason)
+}
+
+// notifyReporter 通報者に処理結果を通知する
+func (h *APIHandler) notifyReporter(report *models.Report, eventType models.EventType) {
+	if _, err := h.db.InsertReportEvent(report.ReporterID, report.ID, eventType); err != nil {
+		h.logger.Error("Failed to insert event", zap.String("Reason", err.Error()))
+	}
+}
+
+// reportTarget 通報されたユーザを返す。既に削除されている場合はnil
+func (h *APIHandler) reportTarget(report *models.Report) *models.User {
+	u, err := h.db.FindUserByOID(report.TargetUserID, false)
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+// reportError 通報の状態更新に失敗した理由をレスポンスに変換する
+// 通報が存在するのに更新できなかった場合は処理済みか他のモデレーターが対応中
+func (h *APIHandler) reportError(reportID bson.ObjectId, err error) error {
+	if err != mgo.ErrNotFound {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if _, err := h.db.FindReport(reportID); err != nil {
+		return handleMgoError(err)
+	}
+	return &echo.HTTPError{Code: http.StatusConflict, Message: ErrReportClosed}
+}
@@ -0,0 +1,112 @@
+package v1
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+)
+
+func TestReportQueue(t *testing.T) {
+	reporter := models.NewUser("reporter", "", "reporter@example.com", false)
+	troll := models.NewUser("troll", "", "troll@example.com", false)
+	mod := models.NewUser("modone", "", "modone@example.com", false)
+	mod.Roles = []models.Role{models.RoleModerator}
+	other := models.NewUser("modtwo", "", "modtwo@example.com", false)
+	other.Roles = []models.Role{models.RoleModerator}
+	e, jwtMiddleware, sessions := setupTest(t, reporter, troll, mod, other)
+	post := models.NewPost(troll.ID, "", "abuse")
+	if err := th.db.UpdatePost(*post); err != nil {
+		t.Fatal(err)
+	}
+	tokens := map[string]string{}
+	for i, u := range []*models.User{reporter, troll, mod, other} {
+		tokens[u.UserID] = sessions[i]
+	}
+
+	c, _ := postJSON(e, "/1.0/reports/create.json", ReportRequest{UserID: troll.ID, Reason: "bogus"}, tokens["reporter"])
+	if he, ok := jwtMiddleware(th.CreateReport)(c).(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("unknown reason should be rejected, actual %v", he)
+	}
+	c, _ = postJSON(e, "/1.0/reports/create.json", ReportRequest{UserID: troll.ID, Reason: models.ReportSpam}, tokens["troll"])
+	if he, ok := jwtMiddleware(th.CreateReport)(c).(*echo.HTTPError); !ok || he.Message != ErrCannotReportSelf {
+		t.Fatalf("self report should be rejected, actual %v", he)
+	}
+
+	c, rec := postJSON(e, "/1.0/reports/create.json", ReportRequest{PostID: post.ID, Reason: models.ReportHarassment, Comment: "mean"}, tokens["reporter"])
+	if assert.NoError(t, jwtMiddleware(th.CreateReport)(c)) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+	reports, err := th.db.FindReports([]models.ReportStatus{models.ReportOpen}, 0, 10)
+	if err != nil || len(reports) != 1 {
+		t.Fatalf("report should be queued: %v %v", reports, err)
+	}
+	report := reports[0]
+	assert.Equal(t, troll.ID, report.TargetUserID)
+
+	moderate := func(h echo.HandlerFunc, by string, req AReportRequest) error {
+		c, _ := postJSON(e, "/1.0/super/reports.json", req, tokens[by])
+		return jwtMiddleware(th.RequireRole(models.RoleModerator)(h))(c)
+	}
+
+	// 対応中の通報は他のモデレーターが処理できない
+	assert.NoError(t, moderate(th.AClaimReport, "modone", AReportRequest{ReportID: report.ID}))
+	if he, ok := moderate(th.ADismissReport, "modtwo", AReportRequest{ReportID: report.ID}).(*echo.HTTPError); !ok || he.Code != http.StatusConflict {
+		t.Fatalf("claimed report should not be dismissed by others, actual %v", he)
+	}
+
+	actions := []models.ReportAction{models.ReportDeletePost, models.ReportSuspendUser, models.ReportWarnUser}
+	assert.NoError(t, moderate(th.AResolveReport, "modone", AReportRequest{ReportID: report.ID, Actions: actions, Note: "confirmed"}))
+	if he, ok := moderate(th.AResolveReport, "modone", AReportRequest{ReportID: report.ID}).(*echo.HTTPError); !ok || he.Message != ErrReportClosed {
+		t.Fatalf("resolved report should not be resolved twice, actual %v", he)
+	}
+
+	if _, err := th.db.FindPost(post.ID, true); err != mgo.ErrNotFound {
+		t.Fatalf("post should be deleted, actual %v", err)
+	}
+	u, err := th.db.FindUserByOID(troll.ID, false)
+	if assert.NoError(t, err) {
+		assert.True(t, u.Suspended)
+		assert.False(t, containsID(u.Posts, post.ID))
+	}
+	assert.True(t, hasReportEvent(t, troll, models.WarnedEvent))
+	assert.True(t, hasReportEvent(t, reporter, models.ReportResolvedEvent))
+
+	logs, err := th.db.FindAuditLogs(models.AuditFilter{ActorID: mod.ID, TargetID: troll.ID}, 0, 10)
+	if assert.NoError(t, err) {
+		assert.Len(t, logs, 5)
+	}
+
+	// 対処に失敗して対応中に戻した通報は、もう一度処理できる
+	retry := models.NewReport(reporter.ID, troll.ID, "", models.ReportSpam, "", time.Now())
+	if err := th.db.CreateReport(*retry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := th.db.CloseReport(retry.ID, mod.ID, models.ReportResolved, []models.ReportAction{models.ReportWarnUser}, "", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, mgo.ErrNotFound, th.db.ReopenReport(retry.ID, other.ID, time.Now()))
+	assert.NoError(t, th.db.ReopenReport(retry.ID, mod.ID, time.Now()))
+	if reopened, err := th.db.FindReport(retry.ID); assert.NoError(t, err) {
+		assert.Equal(t, models.ReportClaimed, reopened.Status)
+		assert.Empty(t, reopened.Actions)
+	}
+	assert.NoError(t, moderate(th.AResolveReport, "modone", AReportRequest{ReportID: retry.ID, Actions: []models.ReportAction{models.ReportWarnUser}}))
+}
+
+func hasReportEvent(t *testing.T, u *models.User, eventType models.EventType) bool {
+	events, err := th.db.GetEvents(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range *events {
+		if ev.Type == eventType && ev.ReportID.Valid() {
+			return true
+		}
+	}
+	return false
+}
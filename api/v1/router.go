@@ -2,6 +2,8 @@ package v1
 
 import (
 	"github.com/TinyKitten/TimelineServer/config"
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/storage"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	validator "gopkg.in/go-playground/validator.v9"
@@ -9,46 +11,122 @@ import (
 
 func NewV1Router() *echo.Echo {
 	h := NewHandler()
+	h.StartAccountPurger(accountPurgeInterval)
+	h.StartBlobSweeper(blobSweepInterval)
+	h.StartExportCleaner(exportCleanInterval)
+	h.StartSuspensionLifter(suspensionLiftInterval)
+	go h.IndexPosts()
+	go h.IndexUsers()
 
 	e := echo.New()
 	e.Validator = &CustomValidator{validator: validator.New()}
 
 	apiConfig := config.GetAPIConfig()
 	v1 := e.Group(apiConfig.Version)
+	jwtAuth := middleware.JWT([]byte(apiConfig.Jwt))
 
 	account := v1.Group("/account")
 	account.POST("/create.json", h.AccountCreate)
 	account.POST("/login.json", h.Login)
+	account.POST("/login_2fa.json", h.LoginTwoFactor)
 	account.GET("/settings.json", h.GetAccountSettings)
+	account.GET("/verify_email.json", h.VerifyEmail)
+	account.POST("/password_reset_request.json", h.RequestPasswordReset)
+	account.GET("/password_reset.json", h.CheckPasswordReset)
+	account.POST("/password_reset.json", h.ResetPassword)
+	account.GET("/export/download.json", h.DownloadExport)
 
 	account = v1.Group("/account")
-	account.Use(middleware.JWT([]byte(apiConfig.Jwt)))
+	account.Use(jwtAuth, h.RequireSession)
 	account.POST("/settings.json", h.SetAccountSettings)
+	account.PATCH("/settings.json", h.SetAccountSettings)
 	account.POST("/update_profile_image.json", h.UpdateAccountProfileImage)
+	account.POST("/update_profile_banner.json", h.UpdateProfileBanner)
+	account.POST("/remove_profile_banner.json", h.RemoveProfileBanner)
+	account.POST("/2fa/enroll.json", h.EnrollTwoFactor)
+	account.POST("/2fa/confirm.json", h.ConfirmTwoFactor)
+	account.POST("/2fa/disable.json", h.DisableTwoFactor)
+	account.POST("/resend_verification.json", h.ResendVerification)
+	account.POST("/change_password.json", h.ChangePassword)
+	account.POST("/update_screen_name.json", h.UpdateScreenName)
+	account.GET("/screen_name_history.json", h.GetScreenNameHistory)
+	account.POST("/deactivate.json", h.DeactivateAccount)
+	account.POST("/export.json", h.StartExport)
+	account.GET("/export.json", h.GetExport)
+	account.POST("/official_application.json", h.ApplyOfficial)
+	account.GET("/official_application.json", h.GetOfficialApplication)
+
+	reports := v1.Group("/reports")
+	reports.Use(jwtAuth, h.RequireSession)
+	reports.POST("/create.json", h.CreateReport)
 
 	users := v1.Group("/users")
 	users.GET("/show.json", h.GetUser)
+	users.GET("/suggestions.json", h.GetSuggestions)
 
 	// Administrator
 	super := v1.Group("/super")
-	super.Use(middleware.JWT([]byte(apiConfig.Jwt)))
-	super.POST("/update_suspend.json", h.AUserSuspendHandler)
-	super.POST("/update_official.json", h.ASetOfficialFlag)
-
-	// Static
-	v1.Static("/uploads", "uploads")
+	super.Use(jwtAuth, h.RequireSession, h.RequireRole(models.RoleAdmin, models.RoleModerator, models.RoleSupport))
+	moderatorOnly := h.RequireRole(models.RoleModerator)
+	adminOnly := h.RequireRole(models.RoleAdmin)
+	super.GET("/users/show.json", h.AGetUser)
+	super.POST("/update_suspend.json", h.AUserSuspendHandler, moderatorOnly)
+	super.POST("/unsuspend.json", h.AUnsuspendHandler, moderatorOnly)
+	super.POST("/warn.json", h.AWarnHandler, moderatorOnly)
+	super.POST("/update_official.json", h.ASetOfficialFlag, adminOnly)
+	super.POST("/delete_user.json", h.ADeleteUserHandler, adminOnly)
+	super.POST("/update_roles.json", h.ASetRoles, adminOnly)
+	super.GET("/official_applications.json", h.AGetOfficialApplications)
+	super.POST("/official_applications/approve.json", h.AApproveOfficial, adminOnly)
+	super.POST("/official_applications/reject.json", h.ARejectOfficial, adminOnly)
+	super.GET("/trends/denylist.json", h.AGetDeniedHashtags)
+	super.POST("/trends/deny.json", h.ADenyHashtag, moderatorOnly)
+	super.POST("/trends/allow.json", h.AAllowHashtag, moderatorOnly)
+	super.GET("/audit.json", h.GetAuditLogs)
+	super.GET("/stats.json", h.GetStats)
+	super.GET("/reports.json", h.AGetReports)
+	super.POST("/reports/claim.json", h.AClaimReport, moderatorOnly)
+	super.POST("/reports/resolve.json", h.AResolveReport, moderatorOnly)
+	super.POST("/reports/dismiss.json", h.ADismissReport, moderatorOnly)
+
+	// Static ローカルに保存する場合だけAPIサーバから配信する
+	if local, ok := h.storage.(*storage.Local); ok {
+		v1.Static(storage.ServePath(local.Dir()), local.Dir())
+	}
 
 	// Friendship
 	friendship := v1.Group("/friendships")
-	friendship.Use(middleware.JWT([]byte(apiConfig.Jwt)))
+	friendship.Use(jwtAuth, h.RequireSession)
 	friendship.POST("/create.json", h.Follow)
 	friendship.POST("/destroy.json", h.Unfollow)
 
 	like := v1.Group("/like")
-	like.Use(middleware.JWT([]byte(apiConfig.Jwt)))
+	like.Use(jwtAuth, h.RequireSession)
 	like.POST("/create.json", h.CreateLike)
 	like.POST("/destroy.json", h.DestroyLike)
 
+	bookmarks := v1.Group("/bookmarks")
+	bookmarks.Use(jwtAuth, h.RequireSession)
+	bookmarks.POST("/create.json", h.CreateBookmark)
+	bookmarks.POST("/destroy.json", h.DestroyBookmark)
+	bookmarks.GET("/list.json", h.GetBookmarks)
+
+	lists := v1.Group("/lists")
+	lists.GET("/show.json", h.GetList)
+	lists.GET("/list.json", h.GetLists)
+	lists.GET("/members.json", h.GetListMembers)
+	lists.GET("/statuses.json", h.GetListStatuses)
+
+	lists.Use(jwtAuth, h.RequireSession)
+	lists.POST("/create.json", h.CreateList)
+	lists.POST("/update.json", h.UpdateList)
+	lists.PATCH("/update.json", h.UpdateList)
+	lists.POST("/destroy.json", h.DestroyList)
+	lists.POST("/members/create.json", h.AddListMember)
+	lists.POST("/members/destroy.json", h.RemoveListMember)
+	lists.POST("/subscribers/create.json", h.SubscribeList)
+	lists.POST("/subscribers/destroy.json", h.UnsubscribeList)
+
 	friends := v1.Group("/friends")
 	friends.GET("/ids.json", h.GetFriendsID)
 	friends.GET("/list.json", h.GetFriendsList)
@@ -63,15 +141,24 @@ func NewV1Router() *echo.Echo {
 	statuses.GET("/list.json", h.GetUserPosts)
 	statuses.GET("/home.json", h.GetHomePosts)
 	statuses.GET("/single.json", h.GetSinglePost)
+	statuses.GET("/hashtag.json", h.GetHashtagPosts)
 
-	statuses.Use(middleware.JWT([]byte(apiConfig.Jwt)))
+	statuses.Use(jwtAuth, h.RequireSession)
 	statuses.POST("/update.json", h.UpdateStatus)
 
+	media := v1.Group("/media")
+	media.Use(jwtAuth, h.RequireSession)
+	media.POST("/upload.json", h.UploadMedia)
+
 	search := v1.Group("/search")
 	search.GET("/user.json", h.SearchUserHandler)
+	search.GET("/statuses.json", h.SearchStatusesHandler)
+
+	trends := v1.Group("/trends")
+	trends.GET("/place.json", h.GetTrends)
 
 	event := v1.Group("/event")
-	event.Use(middleware.JWT([]byte(apiConfig.Jwt)))
+	event.Use(jwtAuth, h.RequireSession)
 	event.GET("/list.json", h.EventListHandler)
 
 	return e
@@ -0,0 +1,151 @@
+package v1
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/validation"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// screenNameChangeInterval スクリーンネームを再び変更できるまでの期間
+	screenNameChangeInterval = 7 * 24 * time.Hour
+	// screenNameRedirectGrace 変更前のスクリーンネームで新しいアカウントを参照できる期間
+	// この間は他のユーザが変更前の名前を取得することもできない
+	screenNameRedirectGrace = 30 * 24 * time.Hour
+)
+
+type (
+	UpdateScreenNameRequest struct {
+		ScreenName string `json:"screen_name" validate:"required"`
+	}
+)
+
+// UpdateScreenName スクリーンネームを変更する
+func (h *APIHandler) UpdateScreenName(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	req := new(UpdateScreenNameRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if err := c.Validate(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return badFields(validation.FromValidator(req, err))
+	}
+
+	u, err := h.db.FindUserByOID(id, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if req.ScreenName == u.UserID {
+		return c.JSON(http.StatusOK, models.UserToUserResponse(*u))
+	}
+	if err := h.rules.ScreenName(req.ScreenName); err != nil {
+		return badFields(validation.FieldErrors{"screen_name": err.Error()})
+	}
+
+	now := h.clock.Now()
+	history, err := h.db.GetScreenNameHistory(id)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if len(history) != 0 {
+		if next := history[0].ChangedAt.Add(screenNameChangeInterval); now.Before(next) {
+			return tooManyRequests(c, next.Sub(now), ErrNameChangeTooSoon)
+		}
+	}
+
+	available, err := h.screenNameAvailable(req.ScreenName, id)
+	if err != nil {
+		return err
+	}
+	if !available {
+		return badFields(validation.FieldErrors{"screen_name": validation.ErrScreenNameTaken.Error()})
+	}
+
+	err = h.db.ChangeScreenName(id, u.UserID, req.ScreenName, now)
+	if mgo.IsDup(err) {
+		// 同時に同じ名前が取得された
+		return badFields(validation.FieldErrors{"screen_name": validation.ErrScreenNameTaken.Error()})
+	}
+	if err == mgo.ErrNotFound {
+		// 同時に別の名前へ変更された
+		return &echo.HTTPError{Code: http.StatusConflict, Message: ErrDuplicated}
+	}
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	updated, err := h.db.FindUserByOID(id, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return c.JSON(http.StatusOK, models.UserToUserResponse(*updated))
+}
+
+// GetScreenNameHistory 自分のスクリーンネーム変更履歴を返す
+func (h *APIHandler) GetScreenNameHistory(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	history, err := h.db.GetScreenNameHistory(id)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return c.JSON(http.StatusOK, history)
+}
+
+// findUserByScreenName スクリーンネームでユーザを検索する
+// 猶予期間中は変更前のスクリーンネームでも変更後のユーザを返す
+// 退会手続き中のユーザは見つからないものとして扱う
+func (h *APIHandler) findUserByScreenName(name string) (*models.User, error) {
+	u, err := h.db.ResolveScreenName(name, h.clock.Now().Add(-screenNameRedirectGrace))
+	if err != nil {
+		return nil, err
+	}
+	if u.Deactivated {
+		return nil, mgo.ErrNotFound
+	}
+	return u, nil
+}
+
+// screenNameAvailable 他のユーザが使用中でなく、猶予期間中の旧スクリーンネームでもないか
+// selfには自分のIDを指定する(新規登録時は空)。自分が手放した名前には戻せる
+func (h *APIHandler) screenNameAvailable(name string, self bson.ObjectId) (bool, error) {
+	taken, err := h.db.ScreenNameTaken(name, self)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return false, handleMgoError(err)
+	}
+	if taken {
+		return false, nil
+	}
+
+	redirect, err := h.db.FindScreenNameRedirect(name, h.clock.Now().Add(-screenNameRedirectGrace))
+	if err == mgo.ErrNotFound {
+		return true, nil
+	}
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return false, handleMgoError(err)
+	}
+	return redirect.UserID == self, nil
+}
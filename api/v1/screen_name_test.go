@@ -0,0 +1,64 @@
+package v1
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+)
+
+func TestUpdateScreenName(t *testing.T) {
+	clock := &utils.FakeClock{Current: time.Now()}
+	th.clock = clock
+	defer func() { th.clock = utils.NewClock() }()
+
+	u := models.NewUser("oldkitten", "", "oldkitten@example.com", false)
+	other := models.NewUser("otherkitten", "", "otherkitten@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, u, other)
+	// キャッシュに載せておく
+	if _, err := th.db.FindUser("oldkitten", true); err != nil {
+		t.Fatal(err)
+	}
+
+	session, otherSession := sessions[0], sessions[1]
+	update := func(bearer string, name string) error {
+		c, _ := postJSON(e, "/1.0/account/update_screen_name.json", UpdateScreenNameRequest{ScreenName: name}, bearer)
+		return jwtMiddleware(th.UpdateScreenName)(c)
+	}
+
+	assert.NoError(t, update(session, "newkitten"))
+
+	// 変更前の名前は新しいアカウントを指す
+	resolved, err := th.findUserByScreenName("OldKitten")
+	if assert.NoError(t, err) {
+		assert.Equal(t, u.ID, resolved.ID)
+		assert.Equal(t, "newkitten", resolved.UserID)
+	}
+
+	// 猶予期間中は他のユーザが取得できない
+	if he, ok := update(otherSession, "oldkitten").(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("old name should be held, actual %v", he)
+	}
+
+	// 続けて変更できない
+	if he, ok := update(session, "newerkitten").(*echo.HTTPError); !ok || he.Message != ErrNameChangeTooSoon {
+		t.Fatalf("expected rate limit, actual %v", he)
+	}
+
+	clock.Advance(screenNameRedirectGrace)
+	if _, err := th.findUserByScreenName("oldkitten"); err != mgo.ErrNotFound {
+		t.Fatalf("redirect should expire, actual %v", err)
+	}
+	assert.NoError(t, update(otherSession, "oldkitten"))
+
+	history, err := th.db.GetScreenNameHistory(u.ID)
+	if assert.NoError(t, err) && assert.Len(t, history, 1) {
+		assert.Equal(t, "oldkitten", history[0].OldName)
+		assert.Equal(t, "newkitten", history[0].NewName)
+	}
+}
@@ -2,44 +2,139 @@ package v1
 
 import (
 	"net/http"
+	"strings"
+	"unicode/utf8"
 
-	"github.com/TinyKitten/TimelineServer/config"
+	"github.com/TinyKitten/TimelineServer/db"
 	"github.com/TinyKitten/TimelineServer/models"
-	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/TinyKitten/TimelineServer/search"
+	"github.com/TinyKitten/TimelineServer/validation"
 	"github.com/labstack/echo"
+	"go.uber.org/zap"
 	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
+const (
+	// searchDefaultLimit ポスト検索の既定の取得件数
+	searchDefaultLimit = 20
+	// searchMaxLimit ポスト検索の最大取得件数
+	searchMaxLimit = 100
+	// userSearchDefault ユーザ検索の既定の取得件数
+	userSearchDefault = 20
+	// userSearchMaxQuery ユーザ検索の最大文字数
+	userSearchMaxQuery = 50
+)
+
+// SearchUserHandler スクリーンネームと表示名からユーザを検索する
+// 並び順はdb.SearchUserを参照。件数はcount、ページングはcursor(オフセット)で指定する
 func (h *APIHandler) SearchUserHandler(c echo.Context) error {
 	// Jwtチェック
-	config := config.GetAPIConfig()
-	tokenStr := c.QueryParam("token")
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Jwt), nil
-	})
+	_, err := h.parseQueryToken(c)
+	if err != nil {
+		return err
+	}
+
+	query := strings.TrimSpace(c.QueryParam("query"))
+	if query == "" || utf8.RuneCountInString(query) > userSearchMaxQuery {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+	}
+	count, cursor, err := parsePage(c, "count", userSearchDefault, searchMaxLimit)
 	if err != nil {
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+		return err
 	}
-	if !token.Valid {
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+
+	users, err := h.db.SearchUser(query, h.clock.Now(), cursor, count)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
 	}
 
-	query := c.QueryParam("query")
+	resp := models.UsersToUserResponseArray(users)
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// SearchStatusesHandler ポストを全文検索する
+// 演算子はsearch.ParseQueryを参照。凍結中・退会手続き中のユーザの投稿は含めない
+// (ブロックや非公開アカウントの仕組みはまだないため、それらによる除外は行わない)
+func (h *APIHandler) SearchStatusesHandler(c echo.Context) error {
+	// Jwtチェック
+	_, err := h.parseQueryToken(c)
+	if err != nil {
+		return err
+	}
+
+	q, err := search.ParseQuery(c.QueryParam("q"))
+	if err != nil {
+		return badFields(validation.FieldErrors{"q": err.Error()})
+	}
 
-	users, err := h.db.SearchUser(query, 5)
+	limit, cursor, err := parsePage(c, "limit", searchDefaultLimit, searchMaxLimit)
 	if err != nil {
+		return err
+	}
+
+	s := db.PostSearch{Query: q, HiddenAt: h.clock.Now()}
+	if q.From != "" {
+		u, err := h.findUserByScreenName(q.From)
 		if err == mgo.ErrNotFound {
-			return &echo.HTTPError{Code: http.StatusOK, Message: ErrNotFound}
+			return c.JSON(http.StatusOK, &[]models.PostResponse{})
+		}
+		if err != nil {
+			return handleMgoError(err)
 		}
+		s.FromID = u.ID
+	}
 
+	posts, err := h.db.SearchPosts(s, cursor, limit)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
 		return handleMgoError(err)
 	}
+	resp, err := h.postsToResponse(posts)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &resp)
+}
 
-	if len(*users) == 0 {
-		return c.JSON(http.StatusOK, &[]models.User{})
+// postsToResponse 投稿者を取得してレスポンスに変換する
+func (h *APIHandler) postsToResponse(posts []models.Post) ([]models.PostResponse, error) {
+	resp := []models.PostResponse{}
+	senders := map[bson.ObjectId]*models.User{}
+	for _, post := range posts {
+		s, ok := senders[post.UserID]
+		if !ok {
+			var err error
+			if s, err = h.db.FindUserByOID(post.UserID, true); err != nil {
+				return nil, handleMgoError(err)
+			}
+			senders[post.UserID] = s
+		}
+		resp = append(resp, models.PostToPostResponse(post, *s))
 	}
+	return resp, nil
+}
 
-	resp := models.UsersToUserResponseArray(*users)
+// IndexPosts 全文検索の導入前のポストに検索用のインデックスを設定する
+func (h *APIHandler) IndexPosts() {
+	n, err := h.db.IndexPosts()
+	if err != nil {
+		h.logger.Error("Failed to index posts", zap.String("Reason", err.Error()))
+	}
+	if n != 0 {
+		h.logger.Info("Indexed posts", zap.Int("Count", n))
+	}
+}
 
-	return c.JSON(http.StatusOK, resp)
+// IndexUsers 検索用のキーの導入前のユーザにキーを設定する
+func (h *APIHandler) IndexUsers() {
+	n, err := h.db.IndexUsers()
+	if err != nil {
+		h.logger.Error("Failed to index users", zap.String("Reason", err.Error()))
+	}
+	if n != 0 {
+		h.logger.Info("Indexed users", zap.Int("Count", n))
+	}
 }
@@ -0,0 +1,189 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestSearchStatuses(t *testing.T) {
+	author := models.NewUser("tokyoite", "", "tokyoite@example.com", false)
+	other := models.NewUser("kyotoite", "", "kyotoite@example.com", false)
+	banned := models.NewUser("bannedone", "", "bannedone@example.com", false)
+	banned.Suspended = true
+	e, _, sessions := setupTest(t, author, other, banned)
+	old := models.NewPost(author.ID, "", "東京都庁の展望台 #Tokyo")
+	old.CreatedAt = time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	posts := []*models.Post{
+		old,
+		models.NewPost(author.ID, "", "東京タワー https://example.com #tokyo"),
+		models.NewPost(other.ID, "", "京都東京間の新幹線"),
+		models.NewPost(banned.ID, "", "東京で宣伝"),
+	}
+	for _, p := range posts {
+		if err := th.db.UpdatePost(*p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session := sessions[0]
+
+	query := func(q string) []models.PostResponse {
+		v := url.Values{"q": {q}, "token": {session}}
+		req := httptest.NewRequest(echo.GET, "/1.0/search/statuses.json?"+v.Encode(), nil)
+		rec := httptest.NewRecorder()
+		if err := th.SearchStatusesHandler(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusOK, rec.Code)
+		resp := []models.PostResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	texts := func(resp []models.PostResponse) []string {
+		ts := []string{}
+		for _, p := range resp {
+			ts = append(ts, p.Text)
+		}
+		return ts
+	}
+
+	// 凍結中のユーザは含まず、新しい順に返す
+	assert.Equal(t, []string{"京都東京間の新幹線", "東京タワー https://example.com #tokyo", "東京都庁の展望台 #Tokyo"}, texts(query("東京")))
+	// バイグラムが揃っていても語を含まなければ一致しない
+	assert.Equal(t, []string{"東京都庁の展望台 #Tokyo"}, texts(query("東京都")))
+	assert.Equal(t, 2, len(query("#TOKYO from:tokyoite")))
+	assert.Equal(t, []string{"東京タワー https://example.com #tokyo"}, texts(query("東京 has:url")))
+	assert.Equal(t, []string{"東京都庁の展望台 #Tokyo"}, texts(query("from:tokyoite until:2017-01-02")))
+	assert.Equal(t, 0, len(query("from:nobody")))
+
+	req := httptest.NewRequest(echo.GET, "/1.0/search/statuses.json?"+url.Values{"q": {"since:tomorrow"}, "token": {session}}.Encode(), nil)
+	if he, ok := th.SearchStatusesHandler(e.NewContext(req, httptest.NewRecorder())).(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("invalid date should be rejected, actual %v", he)
+	}
+	req = httptest.NewRequest(echo.GET, "/1.0/search/statuses.json?"+url.Values{"q": {"has:url"}, "token": {session}}.Encode(), nil)
+	if he, ok := th.SearchStatusesHandler(e.NewContext(req, httptest.NewRecorder())).(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("broad query should be rejected, actual %v", he)
+	}
+}
+
+func TestSearchUser(t *testing.T) {
+	exact := models.NewUser("Gopher", "", "gopher@example.com", false)
+	popular := models.NewUser("gophers_fan", "", "gophers_fan@example.com", false)
+	official := models.NewUser("gopherco", "", "gopherco@example.com", true)
+	named := models.NewUser("someone", "", "someone@example.com", false)
+	named.DisplayName = "I love GOPHER"
+	named.DisplayNameKeys = models.DisplayNameKeys(named.DisplayName)
+	banned := models.NewUser("gopherbanned", "", "gopherbanned@example.com", false)
+	banned.Suspended = true
+	regex := models.NewUser("g0pher", "", "g0pher@example.com", false)
+	popular.Followers = []bson.ObjectId{exact.ID, named.ID}
+	e, _, sessions := setupTest(t, exact, popular, official, named, banned, regex)
+	session := sessions[0]
+
+	query := func(v url.Values) ([]string, error) {
+		v.Set("token", session)
+		req := httptest.NewRequest(echo.GET, "/1.0/search/user.json?"+v.Encode(), nil)
+		rec := httptest.NewRecorder()
+		if err := th.SearchUserHandler(e.NewContext(req, rec)); err != nil {
+			return nil, err
+		}
+		resp := []models.UserResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, u := range resp {
+			names = append(names, u.UserID)
+		}
+		return names, nil
+	}
+
+	// 完全一致・前方一致・公式・フォロワー数の順
+	names, err := query(url.Values{"query": {"GOPHER"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Gopher", "gopherco", "gophers_fan", "someone"}, names)
+
+	names, err = query(url.Values{"query": {"gopher"}, "count": {"2"}, "cursor": {"2"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gophers_fan", "someone"}, names)
+
+	// 表示名は単語の先頭から一致する
+	names, err = query(url.Values{"query": {"Love Go"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"someone"}, names)
+	names, err = query(url.Values{"query": {"ove"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, names)
+
+	// 正規表現として解釈しない
+	names, err = query(url.Values{"query": {"g.pher"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, names)
+
+	for _, v := range []url.Values{{"query": {""}}, {"query": {"gopher"}, "count": {"x"}}} {
+		_, err := query(v)
+		if he, ok := err.(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+			t.Fatalf("%v should be rejected, actual %v", v, err)
+		}
+	}
+}
+
+func TestHashtagTimeline(t *testing.T) {
+	author := models.NewUser("tagger", "", "tagger@example.com", false)
+	banned := models.NewUser("bannedtagger", "", "bannedtagger@example.com", false)
+	banned.Suspended = true
+	e, _, sessions := setupTest(t, author, banned)
+	texts := []string{"一つ目 #タグ付け", "二つ目 ＃タグ付け", "別のタグ #タグ付けない", "三つ目 #タグ付け"}
+	for i, text := range texts {
+		p := models.NewPost(author.ID, "", text)
+		p.CreatedAt = time.Date(2017, 1, 1, i, 0, 0, 0, time.UTC)
+		if err := th.db.UpdatePost(*p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := th.db.UpdatePost(*models.NewPost(banned.ID, "", "宣伝 #タグ付け")); err != nil {
+		t.Fatal(err)
+	}
+	session := sessions[0]
+
+	query := func(v url.Values) ([]string, error) {
+		v.Set("token", session)
+		req := httptest.NewRequest(echo.GET, "/1.0/statuses/hashtag.json?"+v.Encode(), nil)
+		rec := httptest.NewRecorder()
+		if err := th.GetHashtagPosts(e.NewContext(req, rec)); err != nil {
+			return nil, err
+		}
+		resp := []models.PostResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		ts := []string{}
+		for _, p := range resp {
+			ts = append(ts, p.Text)
+		}
+		return ts, nil
+	}
+
+	ts, err := query(url.Values{"tag": {"＃タグ付け"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"三つ目 #タグ付け", "二つ目 ＃タグ付け", "一つ目 #タグ付け"}, ts)
+
+	ts, err = query(url.Values{"tag": {"タグ付け"}, "limit": {"1"}, "cursor": {"1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"二つ目 ＃タグ付け"}, ts)
+
+	_, err = query(url.Values{"tag": {"not a tag"}})
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("invalid tag should be rejected, actual %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/TinyKitten/TimelineServer/config"
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/token"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// RequireSession JWTミドルウェアの後段に置き、パスワード変更などで失効したトークンを拒否する
+func (h *APIHandler) RequireSession(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		jwtUser, ok := c.Get("user").(*jwt.Token)
+		if !ok {
+			return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrInvalidJwt}
+		}
+		if err := h.checkSession(jwtUser.Claims.(jwt.MapClaims)); err != nil {
+			return err
+		}
+		return next(c)
+	}
+}
+
+// actorKey RequireRoleで確認した操作者を格納するコンテキストのキー
+const actorKey = "actor"
+
+// RequireRole JWTミドルウェアの後段に置き、DB上のユーザがいずれかのロールを持つか確認する
+// 確認したユーザは操作者としてコンテキストに格納し、後段のRequireRoleはそれを再利用する
+func (h *APIHandler) RequireRole(roles ...models.Role) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			u, ok := c.Get(actorKey).(*models.User)
+			if !ok {
+				jwtUser, ok := c.Get("user").(*jwt.Token)
+				if !ok {
+					return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrInvalidJwt}
+				}
+				idStr, _ := jwtUser.Claims.(jwt.MapClaims)["id"].(string)
+				if !bson.IsObjectIdHex(idStr) {
+					return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+				}
+
+				var err error
+				u, err = h.db.FindUserByOID(bson.ObjectIdHex(idStr), false)
+				if err == mgo.ErrNotFound {
+					return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrAdminOnly}
+				}
+				if err != nil {
+					h.logger.Debug("API Error", zap.String("Error", err.Error()))
+					return handleMgoError(err)
+				}
+			}
+
+			if u.IsSuspended(h.clock.Now()) || u.Deactivated || !u.HasRole(roles...) {
+				return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrAdminOnly}
+			}
+			c.Set(actorKey, u)
+			return next(c)
+		}
+	}
+}
+
+// actor RequireRoleで確認済みの操作者を返す
+func actor(c echo.Context) *models.User {
+	u, _ := c.Get(actorKey).(*models.User)
+	return u
+}
+
+// parseQueryToken クエリパラメータで渡されたトークンを検証し、クレームを返す
+func (h *APIHandler) parseQueryToken(c echo.Context) (jwt.MapClaims, error) {
+	config := config.GetAPIConfig()
+	tokenStr := c.QueryParam("token")
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		return []byte(config.Jwt), nil
+	})
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return nil, &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+	}
+	if !token.Valid {
+		h.logger.Debug("API Error", zap.String("Error", ErrInvalidJwt))
+		return nil, &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if err := h.checkSession(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkSession トークンの世代がユーザの現在の世代と一致するか確認する
+func (h *APIHandler) checkSession(claims jwt.MapClaims) error {
+	idStr, _ := claims["id"].(string)
+	if !bson.IsObjectIdHex(idStr) {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+	}
+
+	u, err := h.db.FindUserByOID(bson.ObjectIdHex(idStr), true)
+	if err == mgo.ErrNotFound {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrSessionRevoked}
+	}
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if token.Version(claims) != u.TokenVersion {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrSessionRevoked}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package v1
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/cache"
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/realtime"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+)
+
+const (
+	// statsDefaultDays 日ごとの集計の既定の日数
+	statsDefaultDays = 30
+	// statsMaxDays 日ごとの集計の最大日数
+	statsMaxDays = 365
+	// statsTopPosters 集計する投稿数上位のユーザ数
+	statsTopPosters = 10
+)
+
+type (
+	StatsResponse struct {
+		Totals        models.StatsTotals   `json:"totals"`
+		SignupsPerDay []models.DailyCount  `json:"signups_per_day"`
+		PostsPerDay   []models.DailyCount  `json:"posts_per_day"`
+		TopPosters    []models.PosterCount `json:"top_posters"`
+		Realtime      realtime.Stats       `json:"realtime"`
+		Cache         cache.Stats          `json:"cache"`
+		Since         time.Time            `json:"since"`
+	}
+)
+
+// GetStats 管理画面向けの統計情報を返す
+// 日ごとの集計と投稿数上位のユーザはdays日前(UTCの0時)以降が対象
+func (h *APIHandler) GetStats(c echo.Context) error {
+	days := statsDefaultDays
+	if v := c.QueryParam("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > statsMaxDays {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+		}
+		days = n
+	}
+	now := h.clock.Now().UTC()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1-days)
+
+	totals, err := h.db.CountTotals()
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	resp := &StatsResponse{
+		Totals:   *totals,
+		Realtime: h.hub.Stats(),
+		Cache:    h.db.CacheStats(),
+		Since:    since,
+	}
+	if resp.SignupsPerDay, err = h.db.CountSignupsPerDay(since); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if resp.PostsPerDay, err = h.db.CountPostsPerDay(since); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if resp.TopPosters, err = h.db.FindTopPosters(since, statsTopPosters); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,60 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	support := models.NewUser("statsdesk", "", "statsdesk@example.com", false)
+	support.Roles = []models.Role{models.RoleSupport}
+	poster := models.NewUser("chatty", "", "chatty@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, support, poster)
+	for i := 0; i < 3; i++ {
+		if err := th.db.UpdatePost(*models.NewPost(poster.ID, "", "chat")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := th.hub.Subscribe(nil)
+	defer th.hub.Unsubscribe(client)
+
+	req := httptest.NewRequest(echo.GET, "/1.0/super/stats.json?days=7", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+sessions[0])
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	err := jwtMiddleware(th.RequireRole(models.RoleSupport)(th.GetStats))(c)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	resp := StatsResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, resp.Totals.Users >= 2)
+	assert.True(t, resp.Totals.Posts >= 3)
+	assert.True(t, resp.Realtime.Clients >= 1)
+	today := time.Now().UTC().Format("2006-01-02")
+	found := false
+	for _, d := range resp.PostsPerDay {
+		if d.Date == today && d.Count >= 3 {
+			found = true
+		}
+	}
+	assert.True(t, found)
+	found = false
+	for _, p := range resp.TopPosters {
+		if p.UserID == poster.ID && p.ScreenName == "chatty" && p.Count == 3 {
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
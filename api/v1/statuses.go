@@ -8,8 +8,9 @@ import (
 	"go.uber.org/zap"
 	"gopkg.in/mgo.v2/bson"
 
-	"github.com/TinyKitten/TimelineServer/config"
+	"github.com/TinyKitten/TimelineServer/db"
 	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/search"
 	"github.com/TinyKitten/TimelineServer/utils"
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo"
@@ -17,8 +18,9 @@ import (
 
 type (
 	PostReq struct {
-		Status            string `json:"status" validate:"required"`
-		InReplyToStatusID string `json:"in_reply_to_status_id"`
+		Status            string   `json:"status" validate:"required"`
+		InReplyToStatusID string   `json:"in_reply_to_status_id"`
+		MediaIDs          []string `json:"media_ids"` // media/upload.jsonで取得したID(最大4件)
 	}
 )
 
@@ -47,34 +49,32 @@ func (h *APIHandler) UpdateStatus(c echo.Context) error {
 	}
 
 	newPost := models.NewPost(u.ID, bson.ObjectId(req.InReplyToStatusID), req.Status)
+	if err := h.attachMedia(u.ID, newPost, req.MediaIDs); err != nil {
+		return err
+	}
 
 	err = h.db.UpdatePost(*newPost)
 	if err != nil {
 		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		if len(newPost.Media) != 0 {
+			h.detachMedia(newPost.ID)
+		}
 		return handleMgoError(err)
 	}
 
-	go func(post models.Post, postChan chan<- models.PostResponse) {
-		resp := models.PostToPostResponse(post, *u)
-		postChan <- resp
-	}(*newPost, postChan)
+	h.recordTrends(*newPost)
+
+	if err := h.hub.Publish(models.PostToPostResponse(*newPost, *u)); err != nil {
+		h.logger.Error("Failed to publish post", zap.String("Reason", err.Error()))
+	}
 
 	return c.JSON(http.StatusOK, &messageResponse{Message: "ok"})
 }
 
 func (h *APIHandler) GetUserPosts(c echo.Context) error {
-	config := config.GetAPIConfig()
-	tokenStr := c.QueryParam("token")
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Jwt), nil
-	})
+	_, err := h.parseQueryToken(c)
 	if err != nil {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
-	}
-	if !token.Valid {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+		return err
 	}
 
 	limitStr := c.QueryParam("limit")
@@ -84,7 +84,7 @@ func (h *APIHandler) GetUserPosts(c echo.Context) error {
 
 	if screenName != "" {
 		// ScreenNameでの検索
-		user, err := h.db.FindUser(screenName, true)
+		user, err := h.findUserByScreenName(screenName)
 		if err != nil {
 			return handleMgoError(err)
 		}
@@ -214,20 +214,10 @@ func (h *APIHandler) GetUserPosts(c echo.Context) error {
 }
 
 func (h *APIHandler) GetHomePosts(c echo.Context) error {
-	config := config.GetAPIConfig()
-	tokenStr := c.QueryParam("token")
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Jwt), nil
-	})
+	claims, err := h.parseQueryToken(c)
 	if err != nil {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+		return err
 	}
-	if !token.Valid {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
-	}
-	claims := token.Claims.(jwt.MapClaims)
 	id := claims["id"].(string)
 
 	limitStr := c.QueryParam("limit")
@@ -321,18 +311,9 @@ func (h *APIHandler) GetHomePosts(c echo.Context) error {
 
 // GetSinglePost IDに一致する単一のポストを返す
 func (h *APIHandler) GetSinglePost(c echo.Context) error {
-	config := config.GetAPIConfig()
-	tokenStr := c.QueryParam("token")
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Jwt), nil
-	})
+	_, err := h.parseQueryToken(c)
 	if err != nil {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
-	}
-	if !token.Valid {
-		h.logger.Debug("API Error", zap.String("Error", err.Error()))
-		return &echo.HTTPError{Code: http.StatusForbidden, Message: ErrInvalidJwt}
+		return err
 	}
 	postID := c.QueryParam("id")
 	post, err := h.db.FindPost(bson.ObjectIdHex(postID), true)
@@ -349,3 +330,33 @@ func (h *APIHandler) GetSinglePost(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, &resp)
 }
+
+// GetHashtagPosts ハッシュタグtagが付いたポストを新しい順に返す
+// ページングはlimit, cursor(オフセット)で指定する。凍結中・退会手続き中のユーザの投稿は含めない
+func (h *APIHandler) GetHashtagPosts(c echo.Context) error {
+	_, err := h.parseQueryToken(c)
+	if err != nil {
+		return err
+	}
+
+	tag := normalizeHashtag(c.QueryParam("tag"))
+	if tag == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+	}
+	limit, cursor, err := parsePage(c, "limit", searchDefaultLimit, searchMaxLimit)
+	if err != nil {
+		return err
+	}
+
+	s := db.PostSearch{Query: search.Query{Hashtags: []string{tag}}, HiddenAt: h.clock.Now()}
+	posts, err := h.db.SearchPosts(s, cursor, limit)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	resp, err := h.postsToResponse(posts)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &resp)
+}
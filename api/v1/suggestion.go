@@ -0,0 +1,88 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// suggestionsDefault おすすめユーザの既定の取得件数
+	suggestionsDefault = 20
+	// suggestionsMax おすすめユーザの最大取得件数
+	suggestionsMax = 50
+)
+
+type (
+	SuggestionResponse struct {
+		User        models.UserResponse     `json:"user"`
+		Reason      models.SuggestionReason `json:"reason"`
+		MutualCount int                     `json:"mutual_count"`
+	}
+)
+
+// GetSuggestions フォローをおすすめするユーザを返す
+// スコアの計算はdb.GetSuggestionsを参照。件数はcount、ページングはcursor(オフセット)で指定する
+// (ブロックの仕組みはまだないため、ブロックによる除外は行わない)
+func (h *APIHandler) GetSuggestions(c echo.Context) error {
+	claims, err := h.parseQueryToken(c)
+	if err != nil {
+		return err
+	}
+	count, cursor, err := parsePage(c, "count", suggestionsDefault, suggestionsMax)
+	if err != nil {
+		return err
+	}
+
+	// フォロー状況はキャッシュを使わず最新のものを使う
+	u, err := h.db.FindUserByOID(bson.ObjectIdHex(claims["id"].(string)), false)
+	if err != nil {
+		return handleMgoError(err)
+	}
+	now := h.clock.Now()
+	suggestions, err := h.db.GetSuggestions(u, now)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	following := map[bson.ObjectId]bool{}
+	for _, id := range u.Following {
+		following[id] = true
+	}
+	resp := []SuggestionResponse{}
+	skipped := 0
+	for _, s := range suggestions {
+		if len(resp) >= count {
+			break
+		}
+		// 計算後にフォロー・凍結・退会したユーザを除く
+		if following[s.UserID] {
+			continue
+		}
+		candidate, err := h.db.FindUserByOID(s.UserID, true)
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return handleMgoError(err)
+		}
+		if candidate.IsSuspended(now) || candidate.Deactivated {
+			continue
+		}
+		if skipped < cursor {
+			skipped++
+			continue
+		}
+		resp = append(resp, SuggestionResponse{
+			User:        models.UserToUserResponse(*candidate),
+			Reason:      s.Reason,
+			MutualCount: s.MutualCount,
+		})
+	}
+	return c.JSON(http.StatusOK, &resp)
+}
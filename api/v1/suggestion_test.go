@@ -0,0 +1,93 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestSuggestions(t *testing.T) {
+	me := models.NewUser("newcomer", "", "newcomer@example.com", false)
+	friends := []*models.User{
+		models.NewUser("friend_a", "", "friend_a@example.com", false),
+		models.NewUser("friend_b", "", "friend_b@example.com", false),
+		models.NewUser("friend_c", "", "friend_c@example.com", false),
+	}
+	ids := func(us ...*models.User) []bson.ObjectId {
+		ids := []bson.ObjectId{}
+		for _, u := range us {
+			ids = append(ids, u.ID)
+		}
+		return ids
+	}
+	me.Following = ids(friends...)
+	// 友達3人がフォロー
+	popular := models.NewUser("fof_popular", "", "fof_popular@example.com", false)
+	popular.Followers = ids(friends...)
+	// 友達2人がフォロー
+	known := models.NewUser("fof_known", "", "fof_known@example.com", false)
+	known.Followers = ids(friends[:2]...)
+	// 友達1人がフォローし、自分をフォローしている
+	fan := models.NewUser("fof_fan", "", "fof_fan@example.com", false)
+	fan.Followers = ids(friends[0])
+	fan.Following = ids(me)
+	me.Followers = ids(fan)
+	banned := models.NewUser("fof_banned", "", "fof_banned@example.com", false)
+	banned.Followers = ids(friends...)
+	banned.Suspended = true
+	// 候補は友達のフォロー先から集める
+	friends[0].Following = ids(popular, known, fan, banned)
+	friends[1].Following = ids(popular, known, banned)
+	friends[2].Following = ids(popular, banned)
+	e, _, sessions := setupTest(t, append([]*models.User{me, popular, known, fan, banned}, friends...)...)
+	session := sessions[0]
+
+	query := func(v url.Values) []SuggestionResponse {
+		v.Set("token", session)
+		req := httptest.NewRequest(echo.GET, "/1.0/users/suggestions.json?"+v.Encode(), nil)
+		rec := httptest.NewRecorder()
+		if err := th.GetSuggestions(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusOK, rec.Code)
+		resp := []SuggestionResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	names := func(resp []SuggestionResponse) []string {
+		ns := []string{}
+		for _, s := range resp {
+			ns = append(ns, s.User.UserID)
+		}
+		return ns
+	}
+
+	resp := query(url.Values{"count": {"3"}})
+	assert.Equal(t, []string{"fof_popular", "fof_fan", "fof_known"}, names(resp))
+	if len(resp) == 3 {
+		assert.Equal(t, models.SuggestFollowedByFriends, resp[0].Reason)
+		assert.Equal(t, 3, resp[0].MutualCount)
+	}
+	for _, s := range query(url.Values{"count": {"50"}}) {
+		switch s.User.UserID {
+		case "newcomer", "friend_a", "friend_b", "friend_c", "fof_banned":
+			t.Fatalf("%s should not be suggested", s.User.UserID)
+		}
+	}
+
+	// キャッシュされた結果からもフォロー済みのユーザは除く
+	if err := th.db.FollowUser(me.ID, popular.ID); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"fof_fan", "fof_known"}, names(query(url.Values{"count": {"2"}})))
+	assert.Equal(t, []string{"fof_known"}, names(query(url.Values{"count": {"1"}, "cursor": {"1"}})))
+}
@@ -0,0 +1,81 @@
+package v1
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// suspensionLiftInterval 期限を過ぎた凍結を解除する間隔
+// 期限を過ぎた凍結はIsSuspendedで解除済みとして扱うため、この間隔は表示上のずれにしか影響しない
+const suspensionLiftInterval = 10 * time.Minute
+
+// StartSuspensionLifter 期限を過ぎた凍結を定期的に解除する
+func (h *APIHandler) StartSuspensionLifter(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := h.LiftExpiredSuspensions(); err != nil {
+				h.logger.Error("Failed to lift suspensions", zap.String("Reason", err.Error()))
+			}
+		}
+	}()
+}
+
+// LiftExpiredSuspensions 期限を過ぎた凍結を解除する
+func (h *APIHandler) LiftExpiredSuspensions() error {
+	lifted, err := h.db.LiftExpiredSuspensions(h.clock.Now())
+	for _, id := range lifted {
+		h.logger.Info("Suspension lifted", zap.String("ID", id.Hex()))
+	}
+	return err
+}
+
+// suspendedError 凍結中のアカウントでのログインに対し、理由と期限を含む403を返す
+func (h *APIHandler) suspendedError(u *models.User) error {
+	resp := &models.SuspendedResponse{
+		Message: ErrSuspended,
+		Reason:  u.SuspensionReason,
+	}
+	if !u.SuspendedUntil.IsZero() {
+		resp.Until = &u.SuspendedUntil
+	}
+	return &echo.HTTPError{Code: http.StatusForbidden, Message: resp}
+}
+
+// suspend ユーザを凍結して監査ログに残す。untilがゼロ値の場合は無期限
+func (h *APIHandler) suspend(c echo.Context, target *models.User, until time.Time, reason string) error {
+	now := h.clock.Now()
+	if err := h.db.SuspendUser(target.ID, now, until, reason); err != nil {
+		return err
+	}
+
+	before := bson.M{"suspended": target.IsSuspended(now)}
+	if !target.SuspendedUntil.IsZero() {
+		before["suspended_until"] = target.SuspendedUntil
+	}
+	after := bson.M{"suspended": true}
+	if !until.IsZero() {
+		after["suspended_until"] = until
+	}
+	h.audit(c, models.AuditSuspend, target, reason, before, after)
+	return nil
+}
+
+// warn ユーザに警告を通知して監査ログに残す。通報に基づかない場合reportIDは空にする
+func (h *APIHandler) warn(c echo.Context, target *models.User, reportID bson.ObjectId, reason string) error {
+	if _, err := h.db.InsertWarningEvent(target.ID, reportID, reason); err != nil {
+		return err
+	}
+	if err := h.db.AddWarning(target.ID); err != nil {
+		return err
+	}
+	h.audit(c, models.AuditWarn, target, reason,
+		bson.M{"warning_count": target.WarningCount}, bson.M{"warning_count": target.WarningCount + 1})
+	return nil
+}
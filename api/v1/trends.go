@@ -0,0 +1,160 @@
+package v1
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/search"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// trendsDefaultLimit トレンドの既定の取得件数
+	trendsDefaultLimit = 10
+	// trendsMaxLimit トレンドの最大取得件数
+	trendsMaxLimit = 50
+)
+
+type (
+	TrendResponse struct {
+		Name        string `json:"name"`         // #付きのハッシュタグ
+		Query       string `json:"query"`        // URLエンコード済みの検索クエリ
+		URL         string `json:"url"`          // ハッシュタグのタイムライン
+		TweetVolume int64  `json:"tweet_volume"` // 集計期間内の出現数
+	}
+	TrendsResponse struct {
+		Trends []TrendResponse `json:"trends"`
+		AsOf   time.Time       `json:"as_of"`
+	}
+	ADenyHashtagRequest struct {
+		Tag    string `json:"tag"`
+		Reason string `json:"reason"`
+	}
+)
+
+// recordTrends 投稿のハッシュタグをトレンドの集計に加える
+// 投稿は既に完了しているため、失敗してもエラーログのみ出す
+func (h *APIHandler) recordTrends(p models.Post) {
+	if len(p.Hashtags) == 0 {
+		return
+	}
+	if err := h.trends.Record(p.UserID.Hex(), p.Hashtags, p.CreatedAt); err != nil {
+		h.logger.Error("Failed to record trends", zap.String("Reason", err.Error()))
+	}
+}
+
+// GetTrends 現在トレンド入りしているハッシュタグを返す
+// 件数はcountで指定する。除外リストに含まれるハッシュタグは返さない
+func (h *APIHandler) GetTrends(c echo.Context) error {
+	// Jwtチェック
+	_, err := h.parseQueryToken(c)
+	if err != nil {
+		return err
+	}
+
+	count, _, err := parsePage(c, "count", trendsDefaultLimit, trendsMaxLimit)
+	if err != nil {
+		return err
+	}
+
+	deny, err := h.db.FindDeniedHashtagSet()
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	now := h.clock.Now()
+	top, err := h.trends.Top(now, count, deny)
+	if err != nil {
+		h.logger.Error("Failed to get trends", zap.String("Reason", err.Error()))
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+	}
+
+	resp := TrendsResponse{Trends: []TrendResponse{}, AsOf: now}
+	for _, t := range top {
+		name := "#" + t.Name
+		resp.Trends = append(resp.Trends, TrendResponse{
+			Name:        name,
+			Query:       url.QueryEscape(name),
+			URL:         apiURL("/statuses/hashtag.json", url.Values{"tag": {t.Name}}),
+			TweetVolume: t.Volume,
+		})
+	}
+	// Twitterのtrends/place.jsonと同じく場所ごとの配列で返す
+	return c.JSON(http.StatusOK, []TrendsResponse{resp})
+}
+
+// normalizeHashtag 入力されたハッシュタグを投稿から抽出したものと同じ形に揃える
+// ハッシュタグとして扱えない文字列の場合は空文字を返す
+func normalizeHashtag(tag string) string {
+	tag = strings.TrimLeft(strings.TrimSpace(tag), "#＃")
+	tags := search.ExtractHashtags("#" + tag)
+	if len(tags) != 1 || tags[0] != search.Normalize(tag) {
+		return ""
+	}
+	return tags[0]
+}
+
+// AGetDeniedHashtags トレンドから除外しているハッシュタグを返す
+func (h *APIHandler) AGetDeniedHashtags(c echo.Context) error {
+	tags, err := h.db.GetDeniedHashtags()
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return c.JSON(http.StatusOK, tags)
+}
+
+// ADenyHashtag ハッシュタグをトレンドから除外する
+func (h *APIHandler) ADenyHashtag(c echo.Context) error {
+	req := new(ADenyHashtagRequest)
+	if err := c.Bind(req); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	tag := normalizeHashtag(req.Tag)
+	if tag == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+	}
+
+	d := models.DeniedHashtag{
+		Tag:       tag,
+		Reason:    req.Reason,
+		CreatedBy: actor(c).ID,
+		CreatedAt: h.clock.Now(),
+	}
+	if err := h.db.DenyHashtag(d); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	h.audit(c, models.AuditDenyHashtag, nil, req.Reason, nil, bson.M{"tag": tag})
+
+	return c.JSON(http.StatusOK, d)
+}
+
+// AAllowHashtag ハッシュタグのトレンドからの除外を取り消す
+func (h *APIHandler) AAllowHashtag(c echo.Context) error {
+	req := new(ADenyHashtagRequest)
+	if err := c.Bind(req); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	tag := normalizeHashtag(req.Tag)
+	if tag == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+	}
+
+	if err := h.db.AllowHashtag(tag); err != nil {
+		if err == mgo.ErrNotFound {
+			return &echo.HTTPError{Code: http.StatusNotFound, Message: ErrNotFound}
+		}
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	h.audit(c, models.AuditAllowHashtag, nil, req.Reason, bson.M{"tag": tag}, nil)
+
+	return c.JSON(http.StatusOK, &messageResponse{Message: RespDeleted})
+}
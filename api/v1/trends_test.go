@@ -0,0 +1,73 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrends(t *testing.T) {
+	moderator := models.NewUser("trendmod", "", "trendmod@example.com", false)
+	moderator.Roles = []models.Role{models.RoleModerator}
+	e, jwtMiddleware, sessions := setupTest(t, moderator)
+	session := sessions[0]
+
+	for _, text := range []string{"#TrendTest #TrendSpam", "＃trendtest", "#trendtest #trendspam", "#trendspam"} {
+		th.recordTrends(*models.NewPost(models.NewUser("", "", "", false).ID, "", text))
+	}
+
+	trends := func() []TrendResponse {
+		req := httptest.NewRequest(echo.GET, "/1.0/trends/place.json?"+url.Values{"token": {session}}.Encode(), nil)
+		rec := httptest.NewRecorder()
+		if err := th.GetTrends(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusOK, rec.Code)
+		resp := []TrendsResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp[0].Trends
+	}
+
+	resp := trends()
+	if assert.Equal(t, 2, len(resp)) {
+		assert.Equal(t, "#trendspam", resp[0].Name)
+		assert.Equal(t, "#trendtest", resp[1].Name)
+		assert.Equal(t, int64(3), resp[1].TweetVolume)
+		assert.Equal(t, "%23trendtest", resp[1].Query)
+		assert.Contains(t, resp[1].URL, "/statuses/hashtag.json?tag=trendtest")
+	}
+
+	deny := jwtMiddleware(th.RequireRole(models.RoleModerator)(th.ADenyHashtag))
+	c, _ := postJSON(e, "/1.0/super/trends/deny.json", ADenyHashtagRequest{Tag: "not a tag"}, session)
+	if he, ok := deny(c).(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("invalid tag should be rejected, actual %v", he)
+	}
+	c, _ = postJSON(e, "/1.0/super/trends/deny.json", ADenyHashtagRequest{Tag: "#TrendSpam", Reason: "spam"}, session)
+	assert.NoError(t, deny(c))
+
+	resp = trends()
+	if assert.Equal(t, 1, len(resp)) {
+		assert.Equal(t, "#trendtest", resp[0].Name)
+	}
+	logs, err := th.db.FindAuditLogs(models.AuditFilter{ActorID: moderator.ID, Action: models.AuditDenyHashtag}, 0, 10)
+	if assert.NoError(t, err) && assert.Equal(t, 1, len(logs)) {
+		assert.Equal(t, "trendspam", logs[0].After["tag"])
+	}
+
+	allow := jwtMiddleware(th.RequireRole(models.RoleModerator)(th.AAllowHashtag))
+	c, _ = postJSON(e, "/1.0/super/trends/allow.json", ADenyHashtagRequest{Tag: "trendspam"}, session)
+	assert.NoError(t, allow(c))
+	c, _ = postJSON(e, "/1.0/super/trends/allow.json", ADenyHashtagRequest{Tag: "trendspam"}, session)
+	if he, ok := allow(c).(*echo.HTTPError); !ok || he.Code != http.StatusNotFound {
+		t.Fatalf("allowing twice should be not found, actual %v", he)
+	}
+	assert.Equal(t, 2, len(trends()))
+}
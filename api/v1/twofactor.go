@@ -0,0 +1,251 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/token"
+	"github.com/TinyKitten/TimelineServer/totp"
+	"github.com/TinyKitten/TimelineServer/utils"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// recoveryCodesCount 二段階認証有効化時に発行するリカバリーコードの数
+	recoveryCodesCount = 10
+)
+
+type (
+	TwoFactorCodeRequest struct {
+		Code string `json:"code" validate:"required"`
+	}
+	TwoFactorDisableRequest struct {
+		Password string `json:"password" validate:"required"`
+		Code     string `json:"code" validate:"required"`
+	}
+	TwoFactorLoginRequest struct {
+		ChallengeToken string `json:"challenge_token" validate:"required"`
+		Code           string `json:"code" validate:"required"`
+	}
+	TwoFactorEnrollResponse struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"` // QRコード用URI
+	}
+	RecoveryCodesResponse struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+)
+
+// EnrollTwoFactor TOTPシークレットを発行する。confirm.json で確認するまで有効にはならない
+func (h *APIHandler) EnrollTwoFactor(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	u, err := h.db.FindUserByOID(id, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if u.TwoFactorEnabled {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: Err2FAAlreadyEnabled}
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+	}
+	if err := h.db.SetTwoFactorSecret(id, secret); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	return c.JSON(http.StatusOK, &TwoFactorEnrollResponse{
+		Secret: secret,
+		URI:    totp.URI(token.Issuer, u.UserID, secret),
+	})
+}
+
+// ConfirmTwoFactor 認証アプリのコードを確認して二段階認証を有効にし、リカバリーコードを返す
+func (h *APIHandler) ConfirmTwoFactor(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	req := new(TwoFactorCodeRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if err := c.Validate(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+	}
+
+	u, err := h.db.FindUserByOID(id, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if u.TwoFactorEnabled {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: Err2FAAlreadyEnabled}
+	}
+	if u.TwoFactorSecret == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: Err2FANotEnrolled}
+	}
+
+	step, ok := totp.Verify(u.TwoFactorSecret, req.Code, h.clock.Now())
+	if !ok {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrInvalidCode}
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrUnknown}
+	}
+	hashed := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashed = append(hashed, totp.HashRecoveryCode(code))
+	}
+
+	if err := h.db.EnableTwoFactor(id, step, hashed); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	return c.JSON(http.StatusOK, &RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor パスワードと二段階認証コードを確認して二段階認証を無効にする
+func (h *APIHandler) DisableTwoFactor(c echo.Context) error {
+	jwtUser := c.Get("user").(*jwt.Token)
+	claims := jwtUser.Claims.(jwt.MapClaims)
+	idStr := claims["id"].(string)
+	id := bson.ObjectIdHex(idStr)
+
+	req := new(TwoFactorDisableRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if err := c.Validate(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+	}
+
+	u, err := h.db.FindUserByOID(id, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	if !u.TwoFactorEnabled {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: Err2FANotEnrolled}
+	}
+	if matched := utils.CheckPasswordHash(req.Password, u.Password); !matched {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrLoginFailed}
+	}
+	if err := h.verifySecondFactor(u, req.Code); err != nil {
+		return err
+	}
+
+	if err := h.db.DisableTwoFactor(id); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+
+	return c.JSON(http.StatusOK, &messageResponse{Message: "ok"})
+}
+
+// LoginTwoFactor login.json が返したチャレンジトークンと二段階認証コードでログインを完了する
+func (h *APIHandler) LoginTwoFactor(c echo.Context) error {
+	req := new(TwoFactorLoginRequest)
+	if err := c.Bind(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrParamsRequired}
+	}
+	if err := c.Validate(req); err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: ErrBadFormat}
+	}
+
+	id, err := token.ParseChallengeToken(req.ChallengeToken, h.clock.Now())
+	if err == token.ErrTokenExpired {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrChallengeExpired}
+	}
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrLoginFailed}
+	}
+
+	u, err := h.db.FindUserByOID(id, false)
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		if err == mgo.ErrNotFound {
+			return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrLoginFailed}
+		}
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrLoginFailed}
+	}
+	if !u.TwoFactorEnabled || (u.Deactivated && !h.clock.Now().Before(u.PurgeAt)) {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrLoginFailed}
+	}
+	if u.IsSuspended(h.clock.Now()) {
+		return h.suspendedError(u)
+	}
+
+	// コードの総当たりもパスワードと同じく制限する
+	if err := h.checkLoginLock(c, u.UserID); err != nil {
+		return err
+	}
+	if err := h.verifySecondFactor(u, req.Code); err != nil {
+		if he, ok := err.(*echo.HTTPError); ok && he.Message == ErrInvalidCode {
+			return h.loginFailed(c, u.UserID, u, err)
+		}
+		return err
+	}
+	h.loginSucceeded(u.UserID)
+	if err := h.reactivate(u); err != nil {
+		return err
+	}
+
+	token, err := token.CreateToken(u.ID, u.TokenVersion)
+	if err != nil {
+		h.logger.Error("Failed to create jwt token", zap.String("Reason", err.Error()))
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: ErrLoginFailed}
+	}
+	resp := models.UserToLoginSucessResponse(*u, token)
+	return c.JSON(http.StatusOK, resp)
+}
+
+// verifySecondFactor TOTPコードかリカバリーコードを検証し、使用済みとして記録する
+func (h *APIHandler) verifySecondFactor(u *models.User, code string) error {
+	if step, ok := totp.Verify(u.TwoFactorSecret, code, h.clock.Now()); ok {
+		err := h.db.ConsumeTwoFactorStep(u.ID, step)
+		if err == mgo.ErrNotFound {
+			// 同じコードの再利用
+			return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrInvalidCode}
+		}
+		if err != nil {
+			h.logger.Debug("API Error", zap.String("Error", err.Error()))
+			return handleMgoError(err)
+		}
+		return nil
+	}
+
+	err := h.db.ConsumeRecoveryCode(u.ID, totp.HashRecoveryCode(code))
+	if err == mgo.ErrNotFound {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: ErrInvalidCode}
+	}
+	if err != nil {
+		h.logger.Debug("API Error", zap.String("Error", err.Error()))
+		return handleMgoError(err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/token"
+	"github.com/TinyKitten/TimelineServer/totp"
+	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func postJSON(e *echo.Echo, path string, body interface{}, bearer string) (echo.Context, *httptest.ResponseRecorder) {
+	j, _ := json.Marshal(body)
+	req := httptest.NewRequest(echo.POST, path, strings.NewReader(string(j)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if bearer != "" {
+		req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %v", bearer))
+	}
+	rec := httptest.NewRecorder()
+	return e.NewContext(req, rec), rec
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	clock := &utils.FakeClock{Current: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+	th.clock = clock
+	defer func() { th.clock = utils.NewClock() }()
+
+	hashed, err := utils.HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := models.NewUser("twofactor", hashed, "twofactor@example.com", false)
+	e, jwtMiddleware, sessions := setupTest(t, u)
+	session := sessions[0]
+
+	// 登録
+	c, rec := postJSON(e, "/1.0/account/2fa/enroll.json", nil, session)
+	if assert.NoError(t, jwtMiddleware(th.EnrollTwoFactor)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	enroll := TwoFactorEnrollResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &enroll); err != nil {
+		t.Fatal(err)
+	}
+
+	// 確認
+	code, _ := totp.Code(enroll.Secret, clock.Now())
+	c, rec = postJSON(e, "/1.0/account/2fa/confirm.json", TwoFactorCodeRequest{Code: code}, session)
+	if assert.NoError(t, jwtMiddleware(th.ConfirmTwoFactor)(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	recovery := RecoveryCodesResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &recovery); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, recoveryCodesCount, len(recovery.RecoveryCodes))
+
+	// パスワードだけではセッションを得られない
+	c, rec = postJSON(e, "/1.0/account/login.json", LoginReq{ID: u.UserID, Password: "password"}, "")
+	if assert.NoError(t, th.Login(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	challenge := models.TwoFactorChallengeResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &challenge); err != nil {
+		t.Fatal(err)
+	}
+	if !challenge.TwoFactorRequired || challenge.ChallengeToken == "" {
+		t.Fatal("challenge expected")
+	}
+
+	// 確認に使ったコードは再利用できない
+	c, _ = postJSON(e, "/1.0/account/login_2fa.json",
+		TwoFactorLoginRequest{ChallengeToken: challenge.ChallengeToken, Code: code}, "")
+	if err := th.LoginTwoFactor(c); err == nil {
+		t.Fatal("replayed code should be rejected")
+	}
+
+	clock.Advance(totp.Period * time.Second)
+	code, _ = totp.Code(enroll.Secret, clock.Now())
+	c, rec = postJSON(e, "/1.0/account/login_2fa.json",
+		TwoFactorLoginRequest{ChallengeToken: challenge.ChallengeToken, Code: code}, "")
+	if assert.NoError(t, th.LoginTwoFactor(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// リカバリーコードは一度だけ使える
+	c, rec = postJSON(e, "/1.0/account/login_2fa.json",
+		TwoFactorLoginRequest{ChallengeToken: challenge.ChallengeToken, Code: recovery.RecoveryCodes[0]}, "")
+	if assert.NoError(t, th.LoginTwoFactor(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	c, _ = postJSON(e, "/1.0/account/login_2fa.json",
+		TwoFactorLoginRequest{ChallengeToken: challenge.ChallengeToken, Code: recovery.RecoveryCodes[0]}, "")
+	if err := th.LoginTwoFactor(c); err == nil {
+		t.Fatal("used recovery code should be rejected")
+	}
+
+	// チャレンジトークンの期限切れ
+	clock.Advance(token.ChallengeTTL)
+	code, _ = totp.Code(enroll.Secret, clock.Now())
+	c, _ = postJSON(e, "/1.0/account/login_2fa.json",
+		TwoFactorLoginRequest{ChallengeToken: challenge.ChallengeToken, Code: code}, "")
+	if err := th.LoginTwoFactor(c); err == nil {
+		t.Fatal("expired challenge should be rejected")
+	}
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// IncrEx keyの値を1増やして有効期限をttlにし、増やした後の値を返す
+// 期限のないキーが残らないよう、MULTIで不可分に実行する
+func (r *RedisInstance) IncrEx(key string, ttl time.Duration) (int64, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("INCR", key)
+	conn.Send("PEXPIRE", key, int64(ttl/time.Millisecond))
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int64(replies[0], nil)
+}
+
+// Expire keyの有効期限を設定する
+func (r *RedisInstance) Expire(key string, ttl time.Duration) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PEXPIRE", key, int64(ttl/time.Millisecond))
+	return err
+}
+
+// SetEx ttlの間だけ存在するkeyを作成する
+func (r *RedisInstance) SetEx(key string, ttl time.Duration) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SET", key, 1, "PX", int64(ttl/time.Millisecond))
+	return err
+}
+
+// TTL keyの残り時間を返す。存在しないか期限がない場合は0
+func (r *RedisInstance) TTL(key string) (time.Duration, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	ms, err := redis.Int64(conn.Do("PTTL", key))
+	if err != nil {
+		return 0, err
+	}
+	if ms < 0 {
+		return 0, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// Del keyを削除する
+func (r *RedisInstance) Del(keys ...string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	_, err := conn.Do("DEL", args...)
+	return err
+}
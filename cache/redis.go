@@ -11,7 +11,8 @@ import (
 )
 
 type RedisInstance struct {
-	pool *redis.Pool
+	pool  *redis.Pool
+	stats *counters
 }
 
 func newPool(conf config.CacheConfig) *redis.Pool {
@@ -27,7 +28,8 @@ func newPool(conf config.CacheConfig) *redis.Pool {
 func NewRedisInstance(conf config.CacheConfig) RedisInstance {
 	pool := newPool(conf)
 	ins := RedisInstance{
-		pool: pool,
+		pool:  pool,
+		stats: &counters{},
 	}
 	return ins
 }
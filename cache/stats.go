@@ -0,0 +1,35 @@
+package cache
+
+import "sync/atomic"
+
+// Stats GetStructのヒット数とミス数。プロセスの起動からの累計
+type Stats struct {
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hit_rate"` // 0-1。まだ参照がなければ0
+}
+
+type counters struct {
+	hits   uint64
+	misses uint64
+}
+
+func (c *counters) hit() {
+	atomic.AddUint64(&c.hits, 1)
+}
+
+func (c *counters) miss() {
+	atomic.AddUint64(&c.misses, 1)
+}
+
+// Stats キャッシュの統計情報を返す
+func (r *RedisInstance) Stats() Stats {
+	s := Stats{
+		Hits:   atomic.LoadUint64(&r.stats.hits),
+		Misses: atomic.LoadUint64(&r.stats.misses),
+	}
+	if total := s.Hits + s.Misses; total != 0 {
+		s.HitRate = float64(s.Hits) / float64(total)
+	}
+	return s
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
@@ -22,6 +23,19 @@ func (r *RedisInstance) SetStruct(key string, data interface{}) (interface{}, er
 	return conn.Do("SET", key, serialized)
 }
 
+// SetStructEx ttlの間だけ保持するキャッシュを設定する
+func (r *RedisInstance) SetStructEx(key string, data interface{}, ttl time.Duration) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	serialized, err := r.serialize(data)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("SET", key, serialized, "PX", int64(ttl/time.Millisecond))
+	return err
+}
+
 func (r *RedisInstance) SetStructArray(key string, data []interface{}) (interface{}, error) {
 	conn := r.pool.Get()
 	defer conn.Close()
@@ -38,6 +52,11 @@ func (r *RedisInstance) GetStruct(key string) ([]byte, error) {
 	defer conn.Close()
 
 	data, err := redis.Bytes(conn.Do("GET", key))
+	if err == redis.ErrNil {
+		r.stats.miss()
+	} else if err == nil {
+		r.stats.hit()
+	}
 	if err != nil {
 		return nil, handleError(err)
 	}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// IncrScore keyのソート済み集合でmemberのスコアをby増やし、keyの有効期限をttlにする
+func (r *RedisInstance) IncrScore(key, member string, by float64, ttl time.Duration) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("ZINCRBY", key, by, member)
+	conn.Send("PEXPIRE", key, int64(ttl/time.Millisecond))
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// Scores keysのソート済み集合それぞれの全メンバーとスコアを返す
+// 往復を減らすため、全てのkeyをまとめて送信する
+func (r *RedisInstance) Scores(keys ...string) ([]map[string]float64, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	for _, key := range keys {
+		conn.Send("ZRANGE", key, 0, -1, "WITHSCORES")
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	result := make([]map[string]float64, len(keys))
+	for i := range keys {
+		values, err := redis.Strings(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+		scores := make(map[string]float64, len(values)/2)
+		for j := 0; j+1 < len(values); j += 2 {
+			score, err := redis.Float64(values[j+1], nil)
+			if err != nil {
+				return nil, err
+			}
+			scores[values[j]] = score
+		}
+		result[i] = scores
+	}
+	return result, nil
+}
+
+// AddDistinct keyのHyperLogLogにmemberを加え、keyの有効期限をttlにする
+func (r *RedisInstance) AddDistinct(key, member string, ttl time.Duration) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("PFADD", key, member)
+	conn.Send("PEXPIRE", key, int64(ttl/time.Millisecond))
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// CountDistinct setsの各要素について、keysのHyperLogLogの和集合の要素数の近似値を返す
+// 往復を減らすため、全てのPFCOUNTをまとめて送信する
+func (r *RedisInstance) CountDistinct(sets ...[]string) ([]int64, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	for _, keys := range sets {
+		args := make([]interface{}, len(keys))
+		for i, key := range keys {
+			args[i] = key
+		}
+		conn.Send("PFCOUNT", args...)
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	counts := make([]int64, len(sets))
+	for i := range sets {
+		n, err := redis.Int64(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+		counts[i] = n
+	}
+	return counts, nil
+}
@@ -0,0 +1,89 @@
+// create_admin 最初の管理者を作成する
+//
+// 既存のユーザを指定した場合は管理者権限を付与し、存在しない場合は新しく作成する
+//
+//	go run cmd/create_admin/main.go -screen_name kitten -email kitten@example.com -password ********
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/TinyKitten/TimelineServer/config"
+	"github.com/TinyKitten/TimelineServer/db"
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/utils"
+	"github.com/TinyKitten/TimelineServer/validation"
+	mgo "gopkg.in/mgo.v2"
+)
+
+func main() {
+	screenName := flag.String("screen_name", "", "screen name of the admin")
+	email := flag.String("email", "", "email address (only for new users)")
+	password := flag.String("password", "", "password (only for new users)")
+	flag.Parse()
+
+	if *screenName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*screenName, *email, *password); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(screenName, email, password string) error {
+	m, err := db.NewMongoInstance(config.GetDBConfig(), config.GetCacheConfig())
+	if err != nil {
+		return err
+	}
+
+	u, err := m.FindUser(screenName, false)
+	if err == nil {
+		if u.HasRole(models.RoleAdmin) {
+			fmt.Printf("%s is already an admin\n", u.UserID)
+			return nil
+		}
+		if err := m.SetRoles(u.ID, append(u.Roles, models.RoleAdmin)); err != nil {
+			return err
+		}
+		fmt.Printf("granted admin to %s\n", u.UserID)
+		return nil
+	}
+	if err != mgo.ErrNotFound {
+		return err
+	}
+
+	if email == "" || password == "" {
+		return fmt.Errorf("%s does not exist. -email and -password are required to create it", screenName)
+	}
+	rules := validation.NewRules(config.GetValidationConfig())
+	if err := rules.ScreenName(screenName); err != nil {
+		return fmt.Errorf("screen_name: %v", err)
+	}
+	if err := rules.Password(password, screenName); err != nil {
+		return fmt.Errorf("password: %v", err)
+	}
+	taken, err := m.ScreenNameTaken(screenName, "")
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("screen_name: %v", validation.ErrScreenNameTaken)
+	}
+
+	hashed, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	u = models.NewUser(screenName, hashed, strings.TrimSpace(email), false)
+	u.Roles = []models.Role{models.RoleAdmin}
+	if err := m.Insert("users", u); err != nil {
+		return err
+	}
+	fmt.Printf("created admin %s\n", u.UserID)
+	return nil
+}
@@ -15,6 +15,9 @@ type Config struct {
 	DB          DBConfig
 	Cache       CacheConfig
 	UploadImage UploadImageConfig
+	Mail        MailConfig
+	Validation  ValidationConfig
+	Export      ExportConfig
 }
 
 // APIConfig API設定構造体
@@ -25,6 +28,8 @@ type APIConfig struct {
 	Endpoint string `toml:"endpoint"`
 	Secure   bool   `toml:"secure"`
 	Jwt      string `toml:"jwt"`
+
+	TrustedProxyHops int `toml:"trusted_proxy_hops"` // X-Forwarded-Forを追加する信頼できるプロキシの段数(0は直接接続)
 }
 
 // DBConfig MongoDB設定構造体
@@ -37,8 +42,52 @@ type CacheConfig struct {
 	Server string `toml:"server"`
 }
 
+// UploadImageConfig アップロード画像の保存先と受け入れ条件。未設定の項目は既定値を使う
 type UploadImageConfig struct {
-	Path string `toml:"path"`
+	Driver       string   `toml:"driver"` // local, s3
+	Path         string   `toml:"path"`   // driverがlocalのときの保存先
+	BaseURL      string   `toml:"base_url"`
+	MaxBytes     int64    `toml:"max_bytes"`      // デコード前の最大バイト数
+	MaxDimension int      `toml:"max_dimension"`  // 幅・高さの最大ピクセル数
+	AnimatedGIF  string   `toml:"animated_gif"`   // アニメーションGIFの扱い(preserve, reject)
+	MaxGIFFrames int      `toml:"max_gif_frames"` // アニメーションGIFの最大フレーム数
+	MaxGIFPixels int64    `toml:"max_gif_pixels"` // アニメーションGIFの全フレームの面積の合計の上限
+	S3           S3Config `toml:"s3"`
+}
+
+// S3Config S3互換ストレージの接続設定
+type S3Config struct {
+	Endpoint  string `toml:"endpoint"` // https://s3.ap-northeast-1.amazonaws.com など
+	Region    string `toml:"region"`
+	Bucket    string `toml:"bucket"`
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+	PathStyle bool   `toml:"path_style"` // MinIOなど、バケット名をパスに含める場合
+}
+
+// MailConfig メール送信設定構造体
+type MailConfig struct {
+	Driver   string `toml:"driver"` // smtp, log, file
+	Host     string `toml:"host"`
+	Port     int    `toml:"port"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	From     string `toml:"from"`
+	Path     string `toml:"path"` // driverがfileのときの出力先
+}
+
+// ExportConfig データエクスポートの保存先設定構造体
+type ExportConfig struct {
+	Path string `toml:"path"` // 公開ディレクトリ(uploads)の外に置く
+}
+
+// ValidationConfig スクリーンネームとパスワードの入力規則。未設定の項目は既定値を使う
+type ValidationConfig struct {
+	ScreenNameMinLength int      `toml:"screen_name_min_length"`
+	ScreenNameMaxLength int      `toml:"screen_name_max_length"`
+	ReservedScreenNames []string `toml:"reserved_screen_names"` // 既定の予約語に追加する
+	PasswordMinLength   int      `toml:"password_min_length"`
+	PasswordMinClasses  int      `toml:"password_min_classes"` // 英小文字・英大文字・数字・記号のうち含むべき種類数
 }
 
 const (
@@ -64,13 +113,22 @@ func GetConfig() Config {
 			Server: "redis://localhost",
 		}
 		mockUploadImage := UploadImageConfig{
-			Path: "",
+			Path: os.TempDir() + "/timeline-uploads/",
+		}
+		mockMail := MailConfig{
+			Driver: "log",
+			From:   "noreply@example.com",
+		}
+		mockExport := ExportConfig{
+			Path: os.TempDir() + "/",
 		}
 		return Config{
 			API:         mockAPIConfig,
 			DB:          mockDBConfig,
 			Cache:       mockCacheConfig,
 			UploadImage: mockUploadImage,
+			Mail:        mockMail,
+			Export:      mockExport,
 		}
 	}
 
@@ -80,6 +138,11 @@ func GetConfig() Config {
 	}
 	if os.Getenv("ENV") == "heroku" {
 		port, _ := strconv.Atoi(os.Getenv("PORT"))
+		// Herokuのルーターが1段目のプロキシになる
+		proxyHops, err := strconv.Atoi(os.Getenv("TRUSTED_PROXY_HOPS"))
+		if err != nil {
+			proxyHops = 1
+		}
 		herokuAPIConfig := APIConfig{
 			Port:     port,
 			Version:  "1.0",
@@ -87,6 +150,8 @@ func GetConfig() Config {
 			Endpoint: "kittentlapi.herokuapp.com",
 			Jwt:      os.Getenv("JWT_TOKEN"),
 			Secure:   false,
+
+			TrustedProxyHops: proxyHops,
 		}
 		herokuDBConfig := DBConfig{
 			Server:   os.Getenv("MONGO_HOST"),
@@ -95,14 +160,39 @@ func GetConfig() Config {
 		herokuCacheConfig := CacheConfig{
 			Server: os.Getenv("REDIS_URL"),
 		}
+		// dynoのファイルシステムは再起動で消えるため、S3を設定して使う
 		herokuUploadImage := UploadImageConfig{
-			Path: "uploads/img/",
+			Driver:  os.Getenv("STORAGE_DRIVER"),
+			Path:    "uploads/img/",
+			BaseURL: os.Getenv("STORAGE_BASE_URL"),
+			S3: S3Config{
+				Endpoint:  os.Getenv("S3_ENDPOINT"),
+				Region:    os.Getenv("S3_REGION"),
+				Bucket:    os.Getenv("S3_BUCKET"),
+				AccessKey: os.Getenv("S3_ACCESS_KEY"),
+				SecretKey: os.Getenv("S3_SECRET_KEY"),
+				PathStyle: os.Getenv("S3_PATH_STYLE") == "true",
+			},
+		}
+		smtpPort, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		herokuMail := MailConfig{
+			Driver:   os.Getenv("MAIL_DRIVER"),
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     smtpPort,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+		herokuExport := ExportConfig{
+			Path: "exports/",
 		}
 		return Config{
 			API:         herokuAPIConfig,
 			DB:          herokuDBConfig,
 			Cache:       herokuCacheConfig,
 			UploadImage: herokuUploadImage,
+			Mail:        herokuMail,
+			Export:      herokuExport,
 		}
 	}
 
@@ -126,7 +216,31 @@ func GetCacheConfig() CacheConfig {
 	return baseConfig.Cache
 }
 
+// GetUploadImageConfig TOML設定ファイルからアップロード画像の設定を取得
+func GetUploadImageConfig() UploadImageConfig {
+	baseConfig := GetConfig()
+	return baseConfig.UploadImage
+}
+
 func GetUploadImagePath() string {
 	baseConfig := GetConfig().UploadImage
 	return baseConfig.Path
 }
+
+// GetMailConfig TOML設定ファイルからメール送信設定を取得
+func GetMailConfig() MailConfig {
+	baseConfig := GetConfig()
+	return baseConfig.Mail
+}
+
+// GetValidationConfig TOML設定ファイルから入力規則を取得
+func GetValidationConfig() ValidationConfig {
+	baseConfig := GetConfig()
+	return baseConfig.Validation
+}
+
+// GetExportPath TOML設定ファイルからエクスポートの保存先を取得
+func GetExportPath() string {
+	baseConfig := GetConfig().Export
+	return baseConfig.Path
+}
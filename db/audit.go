@@ -0,0 +1,58 @@
+package db
+
+import (
+	"github.com/TinyKitten/TimelineServer/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// AuditCol DB上の監査ログ用カラム
+	AuditCol = "audit_log"
+)
+
+// InsertAuditLog 監査ログを追記する
+// 監査ログは更新・削除しないため、ユーザの削除時にも残す
+func (m *MongoInstance) InsertAuditLog(log models.AuditLog) error {
+	if !log.ID.Valid() {
+		log.ID = bson.NewObjectId()
+	}
+	return m.Insert(AuditCol, log)
+}
+
+// FindAuditLogs 条件に一致する監査ログを新しい順に取得する
+func (m *MongoInstance) FindAuditLogs(filter models.AuditFilter, skip, limit int) ([]models.AuditLog, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	selector := bson.M{}
+	if filter.ActorID.Valid() {
+		selector["actor_id"] = filter.ActorID
+	}
+	if filter.TargetID.Valid() {
+		selector["target_id"] = filter.TargetID
+	}
+	if filter.Action != "" {
+		selector["action"] = filter.Action
+	}
+	createdAt := bson.M{}
+	if !filter.Since.IsZero() {
+		createdAt["$gte"] = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		createdAt["$lt"] = filter.Until
+	}
+	if len(createdAt) != 0 {
+		selector["created_at"] = createdAt
+	}
+
+	logs := []models.AuditLog{}
+	if err := sess.DB(m.db()).C(AuditCol).
+		Find(selector).
+		Sort("-created_at", "-_id").
+		Skip(skip).
+		Limit(limit).
+		All(&logs); err != nil {
+		return nil, handleError(err)
+	}
+	return logs, nil
+}
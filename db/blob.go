@@ -0,0 +1,227 @@
+package db
+
+import (
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// BlobsCol DB上の重複排除したファイル用カラム
+	BlobsCol = "blobs"
+)
+
+// RetainBlob ファイルの参照を1つ増やす。初めて保存する内容の場合は作成する
+// 既にある場合も、後から増えた縮小版などのファイルを記録に加える
+// 掃除で削除している途中の場合は重複エラー(mgo.IsDup)を返す
+func (m *MongoInstance) RetainBlob(blob *models.Blob) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	_, err := sess.DB(m.db()).C(BlobsCol).Upsert(
+		bson.M{"_id": blob.Key, "deleting": bson.M{"$ne": true}},
+		bson.M{
+			"$inc":      bson.M{"refs": 1},
+			"$addToSet": bson.M{"files": bson.M{"$each": blob.Files}},
+			"$setOnInsert": bson.M{
+				"size":       blob.Size,
+				"created_at": blob.CreatedAt,
+			},
+		})
+	return err
+}
+
+// ReleaseBlob ファイルの参照を1つ減らす
+// 存在しない(重複排除の導入前のファイルなど)場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) ReleaseBlob(key string) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return releaseBlob(sess.DB(m.db()), key)
+}
+
+func releaseBlob(db *mgo.Database, key string) error {
+	return db.C(BlobsCol).Update(bson.M{"_id": key, "refs": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"refs": -1}})
+}
+
+// FindUnreferencedBlobs 参照がなくなったファイルを古い順に最大limit件取得する。削除中のものは除く
+func (m *MongoInstance) FindUnreferencedBlobs(limit int) ([]models.Blob, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	blobs := []models.Blob{}
+	if err := sess.DB(m.db()).C(BlobsCol).
+		Find(bson.M{"refs": bson.M{"$lte": 0}, "deleting": bson.M{"$ne": true}}).
+		Sort("created_at").
+		Limit(limit).
+		All(&blobs); err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+// MarkBlobDeleting 参照がないファイルをnowの時点で削除中にし、以降の参照を拒否する
+// 既に参照し直されていた場合や、別の掃除が削除中にしていた場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) MarkBlobDeleting(key string, now time.Time) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(BlobsCol).Update(
+		bson.M{"_id": key, "refs": bson.M{"$lte": 0}, "deleting": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"deleting": true, "deleting_at": now}})
+}
+
+// UnmarkBlobDeleting 保存先のファイルを削除できなかったファイルを削除中から戻し、参照を受け付ける
+// 参照されなければ次回の掃除で再び削除する
+func (m *MongoInstance) UnmarkBlobDeleting(key string) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(BlobsCol).Update(
+		bson.M{"_id": key, "deleting": true},
+		bson.M{"$unset": bson.M{"deleting": "", "deleting_at": ""}})
+}
+
+// RecoverStaleBlobs before以前に削除中にしたまま残っているファイルを削除中から戻し、更新した件数を返す
+// 掃除が途中で止まった場合に、同じ内容の保存を拒否し続けないようにする
+// 参照されなければ、戻した後の掃除で再び削除する
+func (m *MongoInstance) RecoverStaleBlobs(before time.Time) (int, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	info, err := sess.DB(m.db()).C(BlobsCol).UpdateAll(
+		bson.M{"deleting": true, "$or": []bson.M{
+			{"deleting_at": bson.M{"$lt": before}},
+			{"deleting_at": bson.M{"$exists": false}},
+		}},
+		bson.M{"$unset": bson.M{"deleting": "", "deleting_at": ""}})
+	if err != nil {
+		return 0, err
+	}
+	return info.Updated, nil
+}
+
+// RemoveBlob 削除中のファイルの記録を消す。保存先のファイルを削除した後に使う
+func (m *MongoInstance) RemoveBlob(key string) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(BlobsCol).Remove(bson.M{"_id": key, "deleting": true})
+}
+
+// FindBlob キーに一致したファイルの記録を取得する
+func (m *MongoInstance) FindBlob(key string) (*models.Blob, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	blob := new(models.Blob)
+	if err := sess.DB(m.db()).C(BlobsCol).FindId(key).One(blob); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// UpdateAvatar プロフィール画像を差し替え、以前の画像の参照を外す
+// 以前の画像が重複排除の導入前のもの(参照の記録がない)場合は、そのURLを返す。ファイルは呼び出し側で削除する
+// 同時に差し替えられて以前の画像が変わっていた場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) UpdateAvatar(objectID bson.ObjectId, avatarURL, key string) (string, error) {
+	return m.replaceUserImage(objectID, "avatarUrl", "avatarKey", avatarURL, key)
+}
+
+// UpdateBanner ヘッダー画像を差し替え、以前の画像の参照を外す。keyが空の場合はヘッダー画像を外す
+func (m *MongoInstance) UpdateBanner(objectID bson.ObjectId, bannerURL, key string) error {
+	_, err := m.replaceUserImage(objectID, "bannerUrl", "bannerKey", bannerURL, key)
+	return err
+}
+
+// replaceUserImage ユーザの画像のURLとキーを差し替え、以前の画像の参照を外す
+// 以前の画像に参照の記録がない場合はそのURLを返す
+func (m *MongoInstance) replaceUserImage(objectID bson.ObjectId, urlField, keyField, imageURL, key string) (string, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	current := bson.M{}
+	if err := sess.DB(m.db()).C(UsersCol).FindId(objectID).Select(bson.M{urlField: 1, keyField: 1}).One(current); err != nil {
+		return "", err
+	}
+	oldURL, _ := current[urlField].(string)
+	oldKey, _ := current[keyField].(string)
+
+	selector := bson.M{"_id": objectID, keyField: oldKey}
+	if oldKey == "" {
+		selector[keyField] = bson.M{"$exists": false}
+	}
+	update := bson.M{"$set": bson.M{urlField: imageURL, keyField: key}}
+	if key == "" {
+		update = bson.M{"$unset": bson.M{urlField: "", keyField: ""}}
+	}
+	if err := m.updateUserWhere(selector, objectID, update); err != nil {
+		return "", err
+	}
+
+	if oldKey == "" {
+		return oldURL, nil
+	}
+	if err := releaseBlob(sess.DB(m.db()), oldKey); err != nil && err != mgo.ErrNotFound {
+		return "", err
+	}
+	return "", nil
+}
+
+// FindUnattachedMedia before以前にアップロードされ、ポストに添付されていないメディアを取得する
+func (m *MongoInstance) FindUnattachedMedia(before time.Time) ([]models.Media, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	media := []models.Media{}
+	if err := sess.DB(m.db()).C(MediaCol).
+		Find(bson.M{"post_id": bson.M{"$exists": false}, "created_at": bson.M{"$lt": before}}).
+		All(&media); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// RemoveUnattachedMedia 添付されていないメディアを削除し、ファイルの参照を外す
+// 削除する前に添付された場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) RemoveUnattachedMedia(media models.Media) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+	db := sess.DB(m.db())
+
+	if err := db.C(MediaCol).Remove(bson.M{"_id": media.ID, "post_id": bson.M{"$exists": false}}); err != nil {
+		return err
+	}
+	return releaseMediaBlob(db, media)
+}
+
+// removeMediaWhere 条件に一致したメディアを削除し、ファイルの参照を外す
+// 1件ずつ削除してから参照を外すため、途中で失敗して再実行しても参照を二重に外さない
+func removeMediaWhere(db *mgo.Database, selector bson.M) error {
+	media := []models.Media{}
+	if err := db.C(MediaCol).Find(selector).All(&media); err != nil {
+		return err
+	}
+	for _, v := range media {
+		if err := db.C(MediaCol).RemoveId(v.ID); err != nil {
+			if err == mgo.ErrNotFound {
+				continue
+			}
+			return err
+		}
+		if err := releaseMediaBlob(db, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseMediaBlob メディアのファイルの参照を外す。重複排除の導入前のメディアは記録がないので無視する
+func releaseMediaBlob(db *mgo.Database, media models.Media) error {
+	if err := releaseBlob(db, media.Key); err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// BookmarksCol DB上のブックマーク用カラム
+	BookmarksCol = "bookmarks"
+)
+
+// CreateBookmark ポストをブックマークする
+// 既にブックマークしている場合は重複エラー(mgo.IsDup)を返す
+func (m *MongoInstance) CreateBookmark(userID, postID bson.ObjectId, now time.Time) error {
+	return m.Insert(BookmarksCol, models.Bookmark{
+		ID:        bson.NewObjectId(),
+		UserID:    userID,
+		PostID:    postID,
+		CreatedAt: now,
+	})
+}
+
+// DestroyBookmark ブックマークを外す。ブックマークしていない場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) DestroyBookmark(userID, postID bson.ObjectId) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(BookmarksCol).Remove(bson.M{"user_id": userID, "post_id": postID})
+}
+
+// GetBookmarkedPosts ブックマークしたポストをブックマークした日時の新しい順に取得する
+// ページングはcursor(オフセット)とlimitで指定し、limitが0の場合は全件を返す。削除されたポストは含めない
+func (m *MongoInstance) GetBookmarkedPosts(userID bson.ObjectId, cursor, limit int) ([]models.Post, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+	db := sess.DB(m.db())
+
+	bookmarks := []models.Bookmark{}
+	if err := db.C(BookmarksCol).
+		Find(bson.M{"user_id": userID}).
+		Sort("-created_at", "-_id").
+		Skip(cursor).
+		Limit(limit).
+		All(&bookmarks); err != nil {
+		return nil, err
+	}
+	ids := make([]bson.ObjectId, len(bookmarks))
+	for i, b := range bookmarks {
+		ids[i] = b.PostID
+	}
+
+	found := []models.Post{}
+	if err := db.C(PostsCol).Find(bson.M{"_id": bson.M{"$in": ids}}).All(&found); err != nil {
+		return nil, err
+	}
+	byID := make(map[bson.ObjectId]models.Post, len(found))
+	for _, p := range found {
+		byID[p.ID] = p
+	}
+	posts := []models.Post{}
+	for _, id := range ids {
+		if p, ok := byID[id]; ok {
+			posts = append(posts, p)
+		}
+	}
+	return posts, nil
+}
@@ -66,6 +66,66 @@ func (m *MongoInstance) InsertPostEvent(fromID, toID, postID bson.ObjectId, even
 	return &event, err
 }
 
+// InsertReportEvent 通報に関するイベントをDBに挿入する
+// 通知先にモデレーターを明かさないよう、通知元は通知先と同じユーザにする
+func (m *MongoInstance) InsertReportEvent(toID, reportID bson.ObjectId, eventType models.EventType) (*models.Event, error) {
+	event := models.Event{
+		ID:          bson.NewObjectId(),
+		FromUserID:  toID,
+		ToUserID:    toID,
+		Type:        eventType,
+		AlreadyRead: false,
+		CreatedAt:   time.Now(),
+		ReportID:    reportID,
+	}
+
+	err := m.Insert(EventCol, event)
+	if err != nil {
+		return nil, err
+	}
+	return &event, err
+}
+
+// InsertWarningEvent 警告のイベントをDBに挿入する。通報に基づかない警告ではreportIDは空にする
+func (m *MongoInstance) InsertWarningEvent(toID, reportID bson.ObjectId, message string) (*models.Event, error) {
+	event := models.Event{
+		ID:          bson.NewObjectId(),
+		FromUserID:  toID,
+		ToUserID:    toID,
+		Type:        models.WarnedEvent,
+		AlreadyRead: false,
+		CreatedAt:   time.Now(),
+		ReportID:    reportID,
+		Message:     message,
+	}
+
+	err := m.Insert(EventCol, event)
+	if err != nil {
+		return nil, err
+	}
+	return &event, err
+}
+
+// InsertNoticeEvent 運営からのお知らせのイベントをDBに挿入する
+// 通知元は通知先と同じユーザにする
+func (m *MongoInstance) InsertNoticeEvent(toID bson.ObjectId, eventType models.EventType, message string) (*models.Event, error) {
+	event := models.Event{
+		ID:          bson.NewObjectId(),
+		FromUserID:  toID,
+		ToUserID:    toID,
+		Type:        eventType,
+		AlreadyRead: false,
+		CreatedAt:   time.Now(),
+		Message:     message,
+	}
+
+	err := m.Insert(EventCol, event)
+	if err != nil {
+		return nil, err
+	}
+	return &event, err
+}
+
 // DeleteEvent イベントをDBから削除
 func (m *MongoInstance) DeleteEvent(id bson.ObjectId) error {
 	sess := m.session.Clone()
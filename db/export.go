@@ -0,0 +1,130 @@
+package db
+
+import (
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// ExportsCol DB上のデータエクスポート用カラム
+	ExportsCol = "exports"
+	// exportStaleAfter これより長く実行中のジョブはサーバの停止などで中断したものとみなす
+	exportStaleAfter = time.Hour
+)
+
+// CreateExportJob 実行中のエクスポートジョブを作成する
+// 同じユーザのジョブが実行中の場合は重複エラー(mgo.IsDup)を返す
+func (m *MongoInstance) CreateExportJob(userID bson.ObjectId, now time.Time) (*models.ExportJob, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+	c := sess.DB(m.db()).C(ExportsCol)
+
+	// 中断したジョブのロックを外す
+	if _, err := c.UpdateAll(
+		bson.M{"lock": userID.Hex(), "created_at": bson.M{"$lt": now.Add(-exportStaleAfter)}},
+		bson.M{"$set": bson.M{"status": models.ExportFailed}, "$unset": bson.M{"lock": ""}}); err != nil {
+		return nil, err
+	}
+
+	job := &models.ExportJob{
+		ID:        bson.NewObjectId(),
+		UserID:    userID,
+		Status:    models.ExportRunning,
+		Lock:      userID.Hex(),
+		CreatedAt: now,
+	}
+	if err := c.Insert(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// FinishExportJob ジョブを完了にする
+func (m *MongoInstance) FinishExportJob(jobID bson.ObjectId, path string, now, expiresAt time.Time) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(ExportsCol).UpdateId(jobID, bson.M{
+		"$set": bson.M{
+			"status":       models.ExportDone,
+			"path":         path,
+			"completed_at": now,
+			"expires_at":   expiresAt,
+		},
+		"$unset": bson.M{"lock": ""},
+	})
+}
+
+// FailExportJob ジョブを失敗にする
+func (m *MongoInstance) FailExportJob(jobID bson.ObjectId, now time.Time) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(ExportsCol).UpdateId(jobID, bson.M{
+		"$set":   bson.M{"status": models.ExportFailed, "completed_at": now},
+		"$unset": bson.M{"lock": ""},
+	})
+}
+
+// FindExportJob IDでジョブを取得する
+func (m *MongoInstance) FindExportJob(jobID bson.ObjectId) (*models.ExportJob, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	job := new(models.ExportJob)
+	if err := sess.DB(m.db()).C(ExportsCol).FindId(jobID).One(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// FindLatestExportJob ユーザの最新のジョブを取得する
+func (m *MongoInstance) FindLatestExportJob(userID bson.ObjectId) (*models.ExportJob, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	job := new(models.ExportJob)
+	if err := sess.DB(m.db()).C(ExportsCol).
+		Find(bson.M{"user_id": userID}).
+		Sort("-created_at").
+		One(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// FindExportJobsByUser ユーザの全てのジョブを取得する
+func (m *MongoInstance) FindExportJobsByUser(userID bson.ObjectId) ([]models.ExportJob, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	jobs := []models.ExportJob{}
+	if err := sess.DB(m.db()).C(ExportsCol).Find(bson.M{"user_id": userID}).All(&jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// FindExpiredExportJobs ダウンロード期限を過ぎたジョブを取得する
+func (m *MongoInstance) FindExpiredExportJobs(now time.Time) ([]models.ExportJob, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	jobs := []models.ExportJob{}
+	if err := sess.DB(m.db()).C(ExportsCol).
+		Find(bson.M{"status": models.ExportDone, "expires_at": bson.M{"$lte": now}}).
+		All(&jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// RemoveExportJob ジョブを削除する
+func (m *MongoInstance) RemoveExportJob(jobID bson.ObjectId) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(ExportsCol).RemoveId(jobID)
+}
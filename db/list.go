@@ -0,0 +1,153 @@
+package db
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// ListsCol DB上のリスト用カラム
+	ListsCol = "lists"
+)
+
+// CreateList リストを作成する
+func (m *MongoInstance) CreateList(list *models.List) error {
+	return m.Insert(ListsCol, list)
+}
+
+// FindList IDに一致したリストを取得する
+func (m *MongoInstance) FindList(listID bson.ObjectId) (*models.List, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	list := new(models.List)
+	if err := sess.DB(m.db()).C(ListsCol).FindId(listID).One(list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// CountListsByOwner ユーザが作成したリストの数を返す
+func (m *MongoInstance) CountListsByOwner(ownerID bson.ObjectId) (int, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(ListsCol).Find(bson.M{"owner_id": ownerID}).Count()
+}
+
+// GetListsByOwner ユーザが作成したリストを作成した順に取得する。publicOnlyの場合は公開リストのみ
+func (m *MongoInstance) GetListsByOwner(ownerID bson.ObjectId, publicOnly bool) ([]models.List, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	selector := bson.M{"owner_id": ownerID}
+	if publicOnly {
+		selector["mode"] = models.ListPublic
+	}
+	lists := []models.List{}
+	if err := sess.DB(m.db()).C(ListsCol).Find(selector).Sort("created_at").All(&lists); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+// GetSubscribedLists ユーザが購読している公開リストを作成された順に取得する
+func (m *MongoInstance) GetSubscribedLists(userID bson.ObjectId) ([]models.List, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	lists := []models.List{}
+	if err := sess.DB(m.db()).C(ListsCol).
+		Find(bson.M{"subscribers": userID, "mode": models.ListPublic}).
+		Sort("created_at").
+		All(&lists); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+// UpdateList リストの名前・説明・公開範囲を変更する
+// 非公開にした場合は作成者以外の購読を外す
+func (m *MongoInstance) UpdateList(listID bson.ObjectId, set bson.M, now time.Time) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	update := bson.M{}
+	for k, v := range set {
+		update[k] = v
+	}
+	update["updated_at"] = now
+	if set["mode"] == models.ListPrivate {
+		update["subscribers"] = []bson.ObjectId{}
+	}
+	return sess.DB(m.db()).C(ListsCol).UpdateId(listID, bson.M{"$set": update})
+}
+
+// DeleteList リストを削除する
+func (m *MongoInstance) DeleteList(listID bson.ObjectId) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(ListsCol).RemoveId(listID)
+}
+
+// AddListMember メンバーを追加する。メンバーがmax人に達している場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) AddListMember(listID, userID bson.ObjectId, max int, now time.Time) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(ListsCol).Update(
+		bson.M{"_id": listID, "members." + strconv.Itoa(max-1): bson.M{"$exists": false}},
+		bson.M{"$addToSet": bson.M{"members": userID}, "$set": bson.M{"updated_at": now}})
+}
+
+// RemoveListMember メンバーを外す
+func (m *MongoInstance) RemoveListMember(listID, userID bson.ObjectId, now time.Time) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(ListsCol).UpdateId(listID,
+		bson.M{"$pull": bson.M{"members": userID}, "$set": bson.M{"updated_at": now}})
+}
+
+// SubscribeList 公開リストを購読する。非公開になっていた場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) SubscribeList(listID, userID bson.ObjectId) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(ListsCol).Update(
+		bson.M{"_id": listID, "mode": models.ListPublic},
+		bson.M{"$addToSet": bson.M{"subscribers": userID}})
+}
+
+// UnsubscribeList 購読を外す
+func (m *MongoInstance) UnsubscribeList(listID, userID bson.ObjectId) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(ListsCol).UpdateId(listID, bson.M{"$pull": bson.M{"subscribers": userID}})
+}
+
+// GetPostsByUsers ユーザたちのポストを新しい順に取得する
+// ページングはcursor(オフセット)とlimitで指定する
+func (m *MongoInstance) GetPostsByUsers(userIDs []bson.ObjectId, cursor, limit int) ([]models.Post, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	posts := []models.Post{}
+	if len(userIDs) == 0 {
+		return posts, nil
+	}
+	if err := sess.DB(m.db()).C(PostsCol).
+		Find(bson.M{"user_id": bson.M{"$in": userIDs}}).
+		Sort("-createdAt", "-_id").
+		Skip(cursor).
+		Limit(limit).
+		All(&posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
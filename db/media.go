@@ -0,0 +1,66 @@
+package db
+
+import (
+	"github.com/TinyKitten/TimelineServer/models"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// MediaCol DB上のメディア用カラム
+	MediaCol = "media"
+)
+
+// InsertMedia アップロードされたメディアを保存する
+func (m *MongoInstance) InsertMedia(media *models.Media) error {
+	return m.Insert(MediaCol, media)
+}
+
+// FindMedia IDに一致したメディアを取得する
+func (m *MongoInstance) FindMedia(mediaID bson.ObjectId) (*models.Media, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	media := new(models.Media)
+	if err := sess.DB(m.db()).C(MediaCol).FindId(mediaID).One(media); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// GetMediaByUser ユーザがアップロードした全てのメディアを取得する
+func (m *MongoInstance) GetMediaByUser(userID bson.ObjectId) ([]models.Media, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	media := []models.Media{}
+	if err := sess.DB(m.db()).C(MediaCol).Find(bson.M{"user_id": userID}).Sort("created_at").All(&media); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// AttachMedia 未添付のメディアをポストに添付済みにする
+// 既に他のポストに添付されている場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) AttachMedia(mediaID, userID, postID bson.ObjectId) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(MediaCol).Update(
+		bson.M{"_id": mediaID, "user_id": userID, "post_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"post_id": postID}})
+}
+
+// DetachMedia ポストへの添付を取り消す。ポストの保存に失敗した場合に使う
+func (m *MongoInstance) DetachMedia(postID bson.ObjectId) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	_, err := sess.DB(m.db()).C(MediaCol).UpdateAll(
+		bson.M{"post_id": postID},
+		bson.M{"$unset": bson.M{"post_id": ""}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
@@ -41,6 +41,142 @@ func setIndex(s *mgo.Database) (err error) {
 		Sparse:     true, // nilのデータはインデックスしない
 	}
 	err = s.C("users").EnsureIndex(usersIndex)
+	if err != nil {
+		return
+	}
+	// 大文字小文字を区別しないユーザ名の一意性
+	err = s.C("users").EnsureIndex(mgo.Index{
+		Key:        []string{"userIdLower"},
+		Unique:     true,
+		Background: true,
+		Sparse:     true,
+	})
+	if err != nil {
+		return
+	}
+
+	// ユーザ検索・おすすめの公式ユーザ
+	for _, key := range [][]string{{"displayNameKeys"}, {"official", "-_id"}} {
+		err = s.C("users").EnsureIndex(mgo.Index{
+			Key:        key,
+			Background: true,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	// posts
+	for _, key := range [][]string{{"tokens"}, {"-createdAt", "-_id"}, {"hashtags", "-createdAt"}, {"user_id", "-createdAt"}} {
+		err = s.C(PostsCol).EnsureIndex(mgo.Index{
+			Key:        key,
+			Background: true,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	// screen_name_history
+	err = s.C(ScreenNameHistoryCol).EnsureIndex(mgo.Index{
+		Key:        []string{"old_name_lower", "-changed_at"},
+		Background: true,
+	})
+	if err != nil {
+		return
+	}
+
+	// exports
+	err = s.C(ExportsCol).EnsureIndex(mgo.Index{
+		Key:        []string{"lock"},
+		Unique:     true,
+		Background: true,
+		Sparse:     true,
+	})
+	if err != nil {
+		return
+	}
+
+	// official_applications
+	err = s.C(OfficialApplicationsCol).EnsureIndex(mgo.Index{
+		Key:        []string{"lock"},
+		Unique:     true,
+		Background: true,
+		Sparse:     true,
+	})
+	if err != nil {
+		return
+	}
+
+	// reports
+	err = s.C(ReportsCol).EnsureIndex(mgo.Index{
+		Key:        []string{"status", "created_at"},
+		Background: true,
+	})
+	if err != nil {
+		return
+	}
+
+	// media
+	for _, key := range [][]string{{"user_id", "created_at"}, {"post_id"}} {
+		err = s.C(MediaCol).EnsureIndex(mgo.Index{
+			Key:        key,
+			Background: true,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	// blobs
+	err = s.C(BlobsCol).EnsureIndex(mgo.Index{
+		Key:        []string{"refs", "created_at"},
+		Background: true,
+	})
+	if err != nil {
+		return
+	}
+
+	// bookmarks
+	err = s.C(BookmarksCol).EnsureIndex(mgo.Index{
+		Key:        []string{"user_id", "post_id"},
+		Unique:     true,
+		Background: true,
+	})
+	if err != nil {
+		return
+	}
+	for _, key := range [][]string{{"user_id", "-created_at"}, {"post_id"}} {
+		err = s.C(BookmarksCol).EnsureIndex(mgo.Index{
+			Key:        key,
+			Background: true,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	// lists
+	for _, key := range [][]string{{"owner_id", "created_at"}, {"members"}, {"subscribers"}} {
+		err = s.C(ListsCol).EnsureIndex(mgo.Index{
+			Key:        key,
+			Background: true,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	// audit_log
+	for _, key := range [][]string{{"actor_id", "-created_at"}, {"target_id", "-created_at"}, {"action", "-created_at"}} {
+		err = s.C(AuditCol).EnsureIndex(mgo.Index{
+			Key:        key,
+			Background: true,
+		})
+		if err != nil {
+			return
+		}
+	}
 
 	return
 }
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// OfficialApplicationsCol DB上の公式マーク申請用カラム
+	OfficialApplicationsCol = "official_applications"
+)
+
+// CreateOfficialApplication 審査待ちの申請を保存する
+// 同じユーザの申請が審査待ちの場合は重複エラー(mgo.IsDup)を返す
+func (m *MongoInstance) CreateOfficialApplication(app *models.OfficialApplication) error {
+	app.Status = models.OfficialPending
+	app.Lock = app.UserID.Hex()
+	return m.Insert(OfficialApplicationsCol, app)
+}
+
+// FindOfficialApplication IDに一致した申請を取得する
+func (m *MongoInstance) FindOfficialApplication(appID bson.ObjectId) (*models.OfficialApplication, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	app := new(models.OfficialApplication)
+	if err := sess.DB(m.db()).C(OfficialApplicationsCol).FindId(appID).One(app); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// FindLatestOfficialApplication ユーザの最新の申請を取得する
+func (m *MongoInstance) FindLatestOfficialApplication(userID bson.ObjectId) (*models.OfficialApplication, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	app := new(models.OfficialApplication)
+	if err := sess.DB(m.db()).C(OfficialApplicationsCol).
+		Find(bson.M{"user_id": userID}).
+		Sort("-created_at", "-_id").
+		One(app); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// GetOfficialApplicationsByUser ユーザの全ての申請を取得する
+func (m *MongoInstance) GetOfficialApplicationsByUser(userID bson.ObjectId) ([]models.OfficialApplication, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	apps := []models.OfficialApplication{}
+	if err := sess.DB(m.db()).C(OfficialApplicationsCol).
+		Find(bson.M{"user_id": userID}).
+		Sort("created_at").
+		All(&apps); err != nil {
+		return nil, handleError(err)
+	}
+	return apps, nil
+}
+
+// FindOfficialApplications 審査状況が一致する申請を古い順に取得する
+func (m *MongoInstance) FindOfficialApplications(status models.OfficialStatus, skip, limit int) ([]models.OfficialApplication, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	apps := []models.OfficialApplication{}
+	if err := sess.DB(m.db()).C(OfficialApplicationsCol).
+		Find(bson.M{"status": status}).
+		Sort("created_at", "_id").
+		Skip(skip).
+		Limit(limit).
+		All(&apps); err != nil {
+		return nil, handleError(err)
+	}
+	return apps, nil
+}
+
+// ReviewOfficialApplication 審査待ちの申請を承認または却下する
+// 既に審査済みの場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) ReviewOfficialApplication(appID, reviewerID bson.ObjectId, status models.OfficialStatus, note string, now time.Time) (*models.OfficialApplication, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	app := new(models.OfficialApplication)
+	_, err := sess.DB(m.db()).C(OfficialApplicationsCol).
+		Find(bson.M{"_id": appID, "status": models.OfficialPending}).
+		Apply(mgo.Change{
+			Update: bson.M{
+				"$set": bson.M{
+					"status":      status,
+					"reviewed_by": reviewerID,
+					"reviewed_at": now,
+					"review_note": note,
+				},
+				"$unset": bson.M{"lock": ""},
+			},
+			ReturnNew: true,
+		}, app)
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
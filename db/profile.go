@@ -0,0 +1,29 @@
+package db
+
+import (
+	"github.com/TinyKitten/TimelineServer/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// UpdateProfile プロフィールの変更を1回の更新でまとめて反映する
+// setのフィールドは値を設定し、unsetのフィールドは削除する
+func (m *MongoInstance) UpdateProfile(objectID bson.ObjectId, set bson.M, unset []string) error {
+	if name, ok := set["displayName"].(string); ok {
+		set["displayNameKeys"] = models.DisplayNameKeys(name)
+	}
+	update := bson.M{}
+	if len(set) != 0 {
+		update["$set"] = set
+	}
+	if len(unset) != 0 {
+		fields := bson.M{}
+		for _, f := range unset {
+			fields[f] = ""
+		}
+		update["$unset"] = fields
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	return m.updateUserFields(objectID, update)
+}
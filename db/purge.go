@@ -0,0 +1,192 @@
+package db
+
+import (
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DeactivateUser ユーザを退会手続き中にし、既存のセッションを失効させる
+// purgeAtを過ぎるとPurgeUserで全データが削除される
+func (m *MongoInstance) DeactivateUser(objectID bson.ObjectId, now, purgeAt time.Time) error {
+	return m.updateUserFields(objectID, bson.M{
+		"$set": bson.M{
+			"deactivated":   true,
+			"deactivatedAt": now,
+			"purgeAt":       purgeAt,
+		},
+		"$inc": bson.M{"tokenVersion": 1},
+	})
+}
+
+// ReactivateUser 退会手続きを取り消す
+func (m *MongoInstance) ReactivateUser(objectID bson.ObjectId) error {
+	return m.updateUserFields(objectID, bson.M{
+		"$set":   bson.M{"deactivated": false},
+		"$unset": bson.M{"deactivatedAt": "", "purgeAt": ""},
+	})
+}
+
+// FindUsersToPurge 削除日時を過ぎた退会手続き中のユーザを取得する
+func (m *MongoInstance) FindUsersToPurge(now time.Time) ([]models.User, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	users := []models.User{}
+	if err := sess.DB(m.db()).C(UsersCol).
+		Find(bson.M{"deactivated": true, "purgeAt": bson.M{"$lte": now}}).
+		All(&users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// PurgeUser ユーザに関するデータを全て削除する
+// 他のドキュメントからの参照を先に消し、ユーザ本体は最後に消すため、途中で失敗しても再実行できる
+func (m *MongoInstance) PurgeUser(objectID bson.ObjectId) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+	db := sess.DB(m.db())
+
+	u := new(models.User)
+	if err := db.C(UsersCol).FindId(objectID).One(u); err != nil {
+		return err
+	}
+
+	// 他のユーザのフォロー・フォロワー
+	related := []models.User{}
+	relatedSelector := bson.M{"$or": []bson.M{{"following": objectID}, {"followers": objectID}}}
+	if err := db.C(UsersCol).Find(relatedSelector).Select(bson.M{"_id": 1}).All(&related); err != nil {
+		return handleError(err)
+	}
+	if _, err := db.C(UsersCol).UpdateAll(relatedSelector,
+		bson.M{"$pull": bson.M{"following": objectID, "followers": objectID}}); err != nil {
+		return handleError(err)
+	}
+	for _, r := range related {
+		// DBから取得し直してキャッシュを更新する
+		if _, err := m.FindUserByOID(r.ID, false); err != nil {
+			return handleError(err)
+		}
+	}
+
+	// 他のユーザのポストへのいいね・シェア・メンション
+	touched := []models.Post{}
+	touchedSelector := bson.M{"$or": []bson.M{
+		{"favoritedIds": objectID}, {"shared": objectID}, {"mentionsId": objectID},
+	}}
+	if err := db.C(PostsCol).Find(touchedSelector).Select(bson.M{"_id": 1}).All(&touched); err != nil {
+		return handleError(err)
+	}
+	if _, err := db.C(PostsCol).UpdateAll(touchedSelector,
+		bson.M{"$pull": bson.M{"favoritedIds": objectID, "shared": objectID, "mentionsId": objectID}}); err != nil {
+		return handleError(err)
+	}
+	for _, p := range touched {
+		if _, err := m.FindPost(p.ID, false); err != nil {
+			return handleError(err)
+		}
+	}
+
+	// 本人のポスト
+	own := []models.Post{}
+	if err := db.C(PostsCol).Find(bson.M{"user_id": objectID}).Select(bson.M{"_id": 1}).All(&own); err != nil {
+		return handleError(err)
+	}
+	// 本人のブックマークと、本人のポストへの他のユーザのブックマーク
+	ownIDs := make([]bson.ObjectId, len(own))
+	for i, p := range own {
+		ownIDs[i] = p.ID
+	}
+	if _, err := db.C(BookmarksCol).RemoveAll(bson.M{"$or": []bson.M{
+		{"user_id": objectID}, {"post_id": bson.M{"$in": ownIDs}},
+	}}); err != nil {
+		return handleError(err)
+	}
+	if _, err := db.C(PostsCol).RemoveAll(bson.M{"user_id": objectID}); err != nil {
+		return handleError(err)
+	}
+	postKeys := make([]string, len(own))
+	for i, p := range own {
+		postKeys[i] = p.ID.Hex()
+	}
+	if len(postKeys) != 0 {
+		if err := m.cache.Del(postKeys...); err != nil {
+			m.logger.Debug("Redis Error", zap.String("Error", err.Error()))
+			return err
+		}
+	}
+
+	// 本人が作成したリストと、他のユーザのリストのメンバー・購読
+	if _, err := db.C(ListsCol).RemoveAll(bson.M{"owner_id": objectID}); err != nil {
+		return handleError(err)
+	}
+	if _, err := db.C(ListsCol).UpdateAll(
+		bson.M{"$or": []bson.M{{"members": objectID}, {"subscribers": objectID}}},
+		bson.M{"$pull": bson.M{"members": objectID, "subscribers": objectID}}); err != nil {
+		return handleError(err)
+	}
+
+	// 本人が送った・受け取ったイベント
+	if _, err := db.C(EventCol).RemoveAll(bson.M{"$or": []bson.M{
+		{"from_user_id": objectID}, {"to_user_id": objectID},
+	}}); err != nil {
+		return handleError(err)
+	}
+
+	// データエクスポート(ファイルは呼び出し側で削除する)
+	if _, err := db.C(ExportsCol).RemoveAll(bson.M{"user_id": objectID}); err != nil {
+		return handleError(err)
+	}
+
+	// 本人が行った・受けた通報
+	if _, err := db.C(ReportsCol).RemoveAll(bson.M{"$or": []bson.M{
+		{"reporter_id": objectID}, {"target_user_id": objectID},
+	}}); err != nil {
+		return handleError(err)
+	}
+
+	// 公式マークの申請
+	if _, err := db.C(OfficialApplicationsCol).RemoveAll(bson.M{"user_id": objectID}); err != nil {
+		return handleError(err)
+	}
+
+	// アップロードしたメディア(ファイルは参照がなくなった後に掃除で削除する)
+	if err := removeMediaWhere(db, bson.M{"user_id": objectID}); err != nil {
+		return handleError(err)
+	}
+
+	// スクリーンネームの変更履歴(旧スクリーンネームを解放する)
+	if _, err := db.C(ScreenNameHistoryCol).RemoveAll(bson.M{"user_id": objectID}); err != nil {
+		return handleError(err)
+	}
+
+	// プロフィール画像・ヘッダー画像の参照。先に記録を消してから参照を外し、再実行で二重に外さないようにする
+	for field, key := range map[string]string{"avatarKey": u.AvatarKey, "bannerKey": u.BannerKey} {
+		if key == "" {
+			continue
+		}
+		err := db.C(UsersCol).Update(bson.M{"_id": objectID, field: key}, bson.M{"$unset": bson.M{field: ""}})
+		if err != nil && err != mgo.ErrNotFound {
+			return handleError(err)
+		}
+		if err == nil {
+			if err := releaseBlob(db, key); err != nil && err != mgo.ErrNotFound {
+				return handleError(err)
+			}
+		}
+	}
+
+	if err := db.C(UsersCol).RemoveId(objectID); err != nil {
+		return handleError(err)
+	}
+	if err := m.cache.Del(objectID.Hex(), u.UserID); err != nil {
+		m.logger.Debug("Redis Error", zap.String("Error", err.Error()))
+		return err
+	}
+
+	return nil
+}
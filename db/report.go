@@ -0,0 +1,143 @@
+package db
+
+import (
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// ReportsCol DB上の通報用カラム
+	ReportsCol = "reports"
+)
+
+// CreateReport 通報を保存する
+func (m *MongoInstance) CreateReport(report models.Report) error {
+	return m.Insert(ReportsCol, report)
+}
+
+// FindReport IDに一致した通報を取得する
+func (m *MongoInstance) FindReport(reportID bson.ObjectId) (*models.Report, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	report := new(models.Report)
+	if err := sess.DB(m.db()).C(ReportsCol).FindId(reportID).One(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// FindReports 処理状況が一致する通報を古い順に取得する
+func (m *MongoInstance) FindReports(statuses []models.ReportStatus, skip, limit int) ([]models.Report, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	reports := []models.Report{}
+	if err := sess.DB(m.db()).C(ReportsCol).
+		Find(bson.M{"status": bson.M{"$in": statuses}}).
+		Sort("created_at", "_id").
+		Skip(skip).
+		Limit(limit).
+		All(&reports); err != nil {
+		return nil, handleError(err)
+	}
+	return reports, nil
+}
+
+// GetReportsByReporter ユーザが行った通報を取得する
+func (m *MongoInstance) GetReportsByReporter(userID bson.ObjectId) ([]models.Report, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	reports := []models.Report{}
+	if err := sess.DB(m.db()).C(ReportsCol).
+		Find(bson.M{"reporter_id": userID}).
+		Sort("created_at").
+		All(&reports); err != nil {
+		return nil, handleError(err)
+	}
+	return reports, nil
+}
+
+// ClaimReport 未対応の通報をモデレーターの対応中にする
+// 他のモデレーターが対応中か処理済みの場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) ClaimReport(reportID, moderatorID bson.ObjectId, now time.Time) (*models.Report, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	report := new(models.Report)
+	_, err := sess.DB(m.db()).C(ReportsCol).Find(bson.M{
+		"_id": reportID,
+		"$or": []bson.M{
+			{"status": models.ReportOpen},
+			{"status": models.ReportClaimed, "claimed_by": moderatorID},
+		},
+	}).Apply(mgo.Change{
+		Update: bson.M{"$set": bson.M{
+			"status":     models.ReportClaimed,
+			"claimed_by": moderatorID,
+			"claimed_at": now,
+		}},
+		ReturnNew: true,
+	}, report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// CloseReport 通報を対処済みまたは却下にする
+// 処理済みか、他のモデレーターが対応中の場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) CloseReport(reportID, moderatorID bson.ObjectId, status models.ReportStatus, actions []models.ReportAction, note string, now time.Time) (*models.Report, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	set := bson.M{
+		"status":    status,
+		"closed_by": moderatorID,
+		"closed_at": now,
+		"note":      note,
+	}
+	if len(actions) != 0 {
+		set["actions"] = actions
+	}
+
+	report := new(models.Report)
+	_, err := sess.DB(m.db()).C(ReportsCol).Find(bson.M{
+		"_id": reportID,
+		"$or": []bson.M{
+			{"status": models.ReportOpen},
+			{"status": models.ReportClaimed, "claimed_by": moderatorID},
+		},
+	}).Apply(mgo.Change{
+		Update:    bson.M{"$set": set},
+		ReturnNew: true,
+	}, report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// ReopenReport 対処に失敗した通報を、処理したモデレーターの対応中に戻す
+// 他のモデレーターが処理した通報や既に戻した通報の場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) ReopenReport(reportID, moderatorID bson.ObjectId, now time.Time) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(ReportsCol).Update(bson.M{
+		"_id":       reportID,
+		"status":    models.ReportResolved,
+		"closed_by": moderatorID,
+	}, bson.M{
+		"$set": bson.M{
+			"status":     models.ReportClaimed,
+			"claimed_by": moderatorID,
+			"claimed_at": now,
+		},
+		"$unset": bson.M{"closed_by": "", "closed_at": "", "actions": ""},
+	})
+}
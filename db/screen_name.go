@@ -0,0 +1,94 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// ScreenNameHistoryCol DB上のスクリーンネーム変更履歴用カラム
+	ScreenNameHistoryCol = "screen_name_history"
+)
+
+// ChangeScreenName スクリーンネームを変更して履歴に残す
+// 変更前の名前のキャッシュは削除する。oldNameが現在の名前と一致しない場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) ChangeScreenName(objectID bson.ObjectId, oldName, newName string, now time.Time) error {
+	err := m.updateUserWhere(
+		bson.M{"_id": objectID, "userId": oldName},
+		objectID,
+		bson.M{"$set": bson.M{
+			"userId":      newName,
+			"userIdLower": strings.ToLower(newName),
+			"updatedDate": now,
+		}})
+	if err != nil {
+		return err
+	}
+
+	if err := m.cache.Del(oldName); err != nil {
+		m.logger.Debug("Redis Error", zap.String("Error", err.Error()))
+		return err
+	}
+
+	return m.Insert(ScreenNameHistoryCol, models.ScreenNameHistory{
+		ID:           bson.NewObjectId(),
+		UserID:       objectID,
+		OldName:      oldName,
+		OldNameLower: strings.ToLower(oldName),
+		NewName:      newName,
+		ChangedAt:    now,
+	})
+}
+
+// GetScreenNameHistory ユーザのスクリーンネーム変更履歴を新しい順に取得する
+func (m *MongoInstance) GetScreenNameHistory(objectID bson.ObjectId) ([]models.ScreenNameHistory, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	history := []models.ScreenNameHistory{}
+	if err := sess.DB(m.db()).C(ScreenNameHistoryCol).
+		Find(bson.M{"user_id": objectID}).
+		Sort("-changed_at").
+		All(&history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// FindScreenNameRedirect since以降に手放されたスクリーンネームの直近の変更履歴を取得する
+func (m *MongoInstance) FindScreenNameRedirect(name string, since time.Time) (*models.ScreenNameHistory, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	h := new(models.ScreenNameHistory)
+	if err := sess.DB(m.db()).C(ScreenNameHistoryCol).
+		Find(bson.M{
+			"old_name_lower": strings.ToLower(name),
+			"changed_at":     bson.M{"$gte": since},
+		}).
+		Sort("-changed_at").
+		One(h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ResolveScreenName スクリーンネームでユーザを検索する
+// 見つからない場合、since以降に変更された旧スクリーンネームであれば変更後のユーザを返す
+func (m *MongoInstance) ResolveScreenName(name string, since time.Time) (*models.User, error) {
+	u, err := m.FindUser(name, true)
+	if err != mgo.ErrNotFound {
+		return u, err
+	}
+
+	h, err := m.FindScreenNameRedirect(name, since)
+	if err != nil {
+		return nil, err
+	}
+	return m.FindUserByOID(h.UserID, true)
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/search"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// PostSearch ポスト検索の条件
+type PostSearch struct {
+	Query search.Query
+	// FromID 投稿者のID。Query.Fromを解決したもの
+	FromID bson.ObjectId
+	// HiddenAt この日時に凍結中・退会手続き中のユーザの投稿を除く。ゼロ値の場合は除かない
+	HiddenAt time.Time
+}
+
+// SearchPosts 条件に一致するポストを新しい順に取得する
+// 転置インデックス(tokens)で候補を絞り、正規化した本文(searchText)で語を含むか確かめる
+// 並べ替えと件数の制限をまとめて行えるよう、1つのクエリで取得する
+func (m *MongoInstance) SearchPosts(s PostSearch, skip, limit int) ([]models.Post, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	and := []bson.M{}
+	if tokens := s.Query.Tokens(); len(tokens) != 0 {
+		and = append(and, bson.M{"tokens": bson.M{"$all": tokens}})
+	}
+	for _, term := range s.Query.Terms {
+		and = append(and, bson.M{"searchText": bson.RegEx{Pattern: regexp.QuoteMeta(term)}})
+	}
+	if len(s.Query.Hashtags) != 0 {
+		and = append(and, bson.M{"hashtags": bson.M{"$all": s.Query.Hashtags}})
+	}
+	if s.Query.From != "" {
+		and = append(and, bson.M{"user_id": s.FromID})
+	}
+	if s.Query.HasURL {
+		and = append(and, bson.M{"urls.0": bson.M{"$exists": true}})
+	}
+	if !s.Query.Since.IsZero() {
+		and = append(and, bson.M{"createdAt": bson.M{"$gte": s.Query.Since}})
+	}
+	if !s.Query.Until.IsZero() {
+		and = append(and, bson.M{"createdAt": bson.M{"$lt": s.Query.Until}})
+	}
+
+	if !s.HiddenAt.IsZero() {
+		// 凍結の期限があるためポスト側には状態を持たせず、先に対象のユーザを求めて除く
+		hidden, err := m.hiddenUserIDs(s.HiddenAt)
+		if err != nil {
+			return nil, err
+		}
+		if len(hidden) != 0 {
+			and = append(and, bson.M{"user_id": bson.M{"$nin": hidden}})
+		}
+	}
+
+	selector := bson.M{}
+	if len(and) != 0 {
+		selector["$and"] = and
+	}
+	posts := []models.Post{}
+	if err := sess.DB(m.db()).C(PostsCol).
+		Find(selector).
+		Sort("-createdAt", "-_id").
+		Skip(skip).
+		Limit(limit).
+		All(&posts); err != nil {
+		return nil, handleError(err)
+	}
+	return posts, nil
+}
+
+// hiddenUserIDs 凍結中・退会手続き中のユーザのIDを取得する
+func (m *MongoInstance) hiddenUserIDs(now time.Time) ([]bson.ObjectId, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	users := []models.User{}
+	if err := sess.DB(m.db()).C(UsersCol).
+		Find(bson.M{"$or": hiddenUserConditions(now)}).
+		Select(bson.M{"_id": 1}).
+		All(&users); err != nil {
+		return nil, handleError(err)
+	}
+	ids := make([]bson.ObjectId, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	return ids, nil
+}
+
+// FindVisibleUsers idsのユーザのうち凍結中・退会手続き中でないユーザをDBから取得する
+// 順序はidsに従い、存在しないユーザは含めない
+func (m *MongoInstance) FindVisibleUsers(ids []bson.ObjectId, now time.Time) ([]models.User, error) {
+	return m.findVisibleUsers(ids, now, nil)
+}
+
+// FindVisibleUserIDs idsのうち凍結中・退会手続き中でないユーザのIDを返す
+func (m *MongoInstance) FindVisibleUserIDs(ids []bson.ObjectId, now time.Time) ([]bson.ObjectId, error) {
+	users, err := m.findVisibleUsers(ids, now, bson.M{"_id": 1})
+	if err != nil {
+		return nil, err
+	}
+	visible := make([]bson.ObjectId, len(users))
+	for i, u := range users {
+		visible[i] = u.ID
+	}
+	return visible, nil
+}
+
+func (m *MongoInstance) findVisibleUsers(ids []bson.ObjectId, now time.Time, fields bson.M) ([]models.User, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	q := sess.DB(m.db()).C(UsersCol).Find(bson.M{"_id": bson.M{"$in": append([]bson.ObjectId{}, ids...)}, "$nor": hiddenUserConditions(now)})
+	if fields != nil {
+		q = q.Select(fields)
+	}
+	found := []models.User{}
+	if err := q.All(&found); err != nil {
+		return nil, handleError(err)
+	}
+	byID := make(map[bson.ObjectId]models.User, len(found))
+	for _, u := range found {
+		byID[u.ID] = u
+	}
+	users := make([]models.User, 0, len(found))
+	for _, id := range ids {
+		if u, ok := byID[id]; ok {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+// hiddenUserConditions 凍結中・退会手続き中のユーザに一致する条件。$orで一致、$norで除外に使う
+func hiddenUserConditions(now time.Time) []bson.M {
+	return []bson.M{
+		{"deactivated": true},
+		{"suspended": true, "suspendedUntil": bson.M{"$exists": false}},
+		{"suspended": true, "suspendedUntil": bson.M{"$gt": now}},
+	}
+}
+
+// IndexPosts 検索用のインデックスがないポスト(全文検索の導入前の投稿)にインデックスを設定する
+func (m *MongoInstance) IndexPosts() (int, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+	c := sess.DB(m.db()).C(PostsCol)
+
+	n := 0
+	post := models.Post{}
+	iter := c.Find(bson.M{"tokens": bson.M{"$exists": false}}).Iter()
+	for iter.Next(&post) {
+		post.Index()
+		if err := c.UpdateId(post.ID, bson.M{"$set": bson.M{
+			"hashtags":   post.Hashtags,
+			"urls":       post.URLs,
+			"tokens":     post.Tokens,
+			"searchText": post.SearchText,
+		}}); err != nil {
+			iter.Close()
+			return n, handleError(err)
+		}
+		n++
+	}
+	if err := iter.Close(); err != nil {
+		return n, handleError(err)
+	}
+	return n, nil
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/cache"
+	"github.com/TinyKitten/TimelineServer/models"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// CountTotals ユーザ・ポスト・いいね・フォローの総数を集計する
+func (m *MongoInstance) CountTotals() (*models.StatsTotals, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+	db := sess.DB(m.db())
+
+	totals := new(models.StatsTotals)
+	var err error
+	if totals.Users, err = db.C(UsersCol).Count(); err != nil {
+		return nil, handleError(err)
+	}
+	if totals.Posts, err = db.C(PostsCol).Count(); err != nil {
+		return nil, handleError(err)
+	}
+	if totals.Likes, err = m.sumArraySizes(PostsCol, "favoritedIds"); err != nil {
+		return nil, err
+	}
+	if totals.Follows, err = m.sumArraySizes(UsersCol, "following"); err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// sumArraySizes コレクションの全ドキュメントについて配列フィールドの要素数を合計する
+func (m *MongoInstance) sumArraySizes(col, field string) (int, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	result := []struct {
+		Total int `bson:"total"`
+	}{}
+	err := sess.DB(m.db()).C(col).Pipe([]bson.M{
+		{"$project": bson.M{"n": bson.M{"$size": bson.M{"$ifNull": []interface{}{"$" + field, []interface{}{}}}}}},
+		{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$n"}}},
+	}).All(&result)
+	if err != nil {
+		return 0, handleError(err)
+	}
+	if len(result) == 0 {
+		return 0, nil
+	}
+	return result[0].Total, nil
+}
+
+// CountSignupsPerDay since以降の日ごとの登録数を集計する
+func (m *MongoInstance) CountSignupsPerDay(since time.Time) ([]models.DailyCount, error) {
+	return m.countPerDay(UsersCol, "createdDate", since)
+}
+
+// CountPostsPerDay since以降の日ごとの投稿数を集計する
+func (m *MongoInstance) CountPostsPerDay(since time.Time) ([]models.DailyCount, error) {
+	return m.countPerDay(PostsCol, "createdAt", since)
+}
+
+func (m *MongoInstance) countPerDay(col, field string, since time.Time) ([]models.DailyCount, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	counts := []models.DailyCount{}
+	err := sess.DB(m.db()).C(col).Pipe([]bson.M{
+		{"$match": bson.M{field: bson.M{"$gte": since}}},
+		{"$group": bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$" + field}},
+			"count": bson.M{"$sum": 1},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	}).All(&counts)
+	if err != nil {
+		return nil, handleError(err)
+	}
+	return counts, nil
+}
+
+// FindTopPosters since以降の投稿数が多いユーザを集計する
+func (m *MongoInstance) FindTopPosters(since time.Time, limit int) ([]models.PosterCount, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+	db := sess.DB(m.db())
+
+	posters := []models.PosterCount{}
+	err := db.C(PostsCol).Pipe([]bson.M{
+		{"$match": bson.M{"createdAt": bson.M{"$gte": since}}},
+		{"$group": bson.M{"_id": "$user_id", "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.D{{Name: "count", Value: -1}, {Name: "_id", Value: 1}}},
+		{"$limit": limit},
+	}).All(&posters)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	ids := make([]bson.ObjectId, len(posters))
+	for i, p := range posters {
+		ids[i] = p.UserID
+	}
+	users := []models.User{}
+	if err := db.C(UsersCol).
+		Find(bson.M{"_id": bson.M{"$in": ids}}).
+		Select(bson.M{"userId": 1}).
+		All(&users); err != nil {
+		return nil, handleError(err)
+	}
+	names := map[bson.ObjectId]string{}
+	for _, u := range users {
+		names[u.ID] = u.UserID
+	}
+	for i := range posters {
+		posters[i].ScreenName = names[posters[i].UserID]
+	}
+	return posters, nil
+}
+
+// CacheStats キャッシュの統計情報を返す
+func (m *MongoInstance) CacheStats() cache.Stats {
+	return m.cache.Stats()
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/TinyKitten/TimelineServer/models"
 	"github.com/garyburd/redigo/redis"
 	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -57,6 +58,46 @@ func (m *MongoInstance) UpdatePost(post models.Post) error {
 	return err
 }
 
+// DeletePost ポストと添付されたメディアを削除し、投稿者のポスト一覧とキャッシュから取り除く
+func (m *MongoInstance) DeletePost(postID bson.ObjectId) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+	db := sess.DB(m.db())
+
+	post := new(models.Post)
+	if err := db.C(PostsCol).FindId(postID).One(post); err != nil {
+		return err
+	}
+
+	if err := m.updateUserFields(post.UserID, bson.M{"$pull": bson.M{"posts": postID}}); err != nil {
+		return err
+	}
+	// プロフィールに固定されていれば外す
+	err := m.updateUserWhere(bson.M{"_id": post.UserID, "pinnedPostId": postID}, post.UserID,
+		bson.M{"$unset": bson.M{"pinnedPostId": ""}})
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	if _, err := db.C(EventCol).RemoveAll(bson.M{"post_id": postID}); err != nil {
+		return handleError(err)
+	}
+	if _, err := db.C(BookmarksCol).RemoveAll(bson.M{"post_id": postID}); err != nil {
+		return handleError(err)
+	}
+	// 添付されたメディア(ファイルは参照がなくなった後に掃除で削除する)
+	if err := removeMediaWhere(db, bson.M{"post_id": postID}); err != nil {
+		return handleError(err)
+	}
+	if err := db.C(PostsCol).RemoveId(postID); err != nil {
+		return handleError(err)
+	}
+	if err := m.cache.Del(postID.Hex()); err != nil {
+		m.logger.Debug("Redis Error", zap.String("Error", err.Error()))
+		return err
+	}
+	return nil
+}
+
 func (m *MongoInstance) GetAllPosts() (*[]models.Post, error) {
 	sess := m.session.Clone()
 	defer sess.Close()
@@ -117,6 +158,36 @@ func (m *MongoInstance) GetPostsByOIDArray(objectIds []bson.ObjectId) ([]models.
 	return array, nil
 }
 
+// GetPostsByUser ユーザの全ての投稿を古い順に取得する
+func (m *MongoInstance) GetPostsByUser(userID bson.ObjectId) ([]models.Post, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	posts := []models.Post{}
+	if err := sess.DB(m.db()).C(PostsCol).
+		Find(bson.M{"user_id": userID}).
+		Sort("createdAt").
+		All(&posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// GetLikedPosts ユーザがいいねした投稿を取得する
+func (m *MongoInstance) GetLikedPosts(userID bson.ObjectId) ([]models.Post, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	posts := []models.Post{}
+	if err := sess.DB(m.db()).C(PostsCol).
+		Find(bson.M{"favoritedIds": userID}).
+		Sort("createdAt").
+		All(&posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
 func (m *MongoInstance) CreateLike(postID, userID bson.ObjectId) error {
 	sess := m.session.Clone()
 	defer sess.Close()
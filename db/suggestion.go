@@ -0,0 +1,224 @@
+package db
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"go.uber.org/zap"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// suggestionCachePrefix おすすめユーザのキャッシュキーの接頭辞
+	suggestionCachePrefix = "suggestions:"
+	// suggestionCacheTTL おすすめユーザを再計算するまでの時間
+	suggestionCacheTTL = time.Hour
+	// suggestionPool スコアを計算する候補の数
+	suggestionPool = 200
+	// suggestionCandidates 友達の友達・フォロワーから集める候補のそれぞれの最大数
+	suggestionCandidates = 1000
+	// suggestionMax キャッシュするおすすめユーザの数
+	suggestionMax = 100
+)
+
+// 各要素の重み
+const (
+	weightMutual     = 3.0
+	weightFollowsYou = 4.0
+	weightOfficial   = 2.0
+	weightActiveWeek = 2.0
+	weightActiveMon  = 1.0
+)
+
+type suggestionCandidate struct {
+	ID             bson.ObjectId `bson:"_id"`
+	Mutual         int           `bson:"mutual"`
+	FollowsYou     bool          `bson:"followsYou"`
+	Official       bool          `bson:"official"`
+	FollowersCount int           `bson:"followersCount"`
+	LastPost       bson.ObjectId `bson:"lastPost"`
+}
+
+// GetSuggestions ユーザにフォローをおすすめするユーザをスコアの高い順に取得する
+// 結果はユーザごとにsuggestionCacheTTLの間キャッシュし、期限が切れたら再計算する
+// キャッシュ後にフォロー・凍結されたユーザが含まれうるため、呼び出し側で除外すること
+func (m *MongoInstance) GetSuggestions(u *models.User, now time.Time) ([]models.Suggestion, error) {
+	key := suggestionCachePrefix + u.ID.Hex()
+
+	data, err := m.cache.GetStruct(key)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		suggestions := []models.Suggestion{}
+		if err := json.Unmarshal(data, &suggestions); err != nil {
+			return nil, err
+		}
+		return suggestions, nil
+	}
+
+	suggestions, err := m.computeSuggestions(u, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.cache.SetStructEx(key, suggestions, suggestionCacheTTL); err != nil {
+		m.logger.Debug("Redis Error", zap.String("Error", err.Error()))
+		return nil, err
+	}
+	return suggestions, nil
+}
+
+// computeSuggestions フォローしているユーザがフォローしている数(友達の友達)・自分をフォローしているか・公式・
+// 最近の投稿・フォロワー数からスコアを計算する。フォロー済み・凍結中・退会手続き中のユーザは含めない
+func (m *MongoInstance) computeSuggestions(u *models.User, now time.Time) ([]models.Suggestion, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	following := u.Following
+	if following == nil {
+		following = []bson.ObjectId{}
+	}
+	ids, err := m.suggestionCandidateIDs(sess, u)
+	if err != nil {
+		return nil, err
+	}
+	pipeline := []bson.M{
+		{"$match": bson.M{
+			"_id":  bson.M{"$in": ids},
+			"$nor": hiddenUserConditions(now),
+		}},
+		{"$project": bson.M{
+			"mutual":         bson.M{"$size": bson.M{"$setIntersection": []interface{}{bson.M{"$ifNull": []interface{}{"$followers", []interface{}{}}}, following}}},
+			"followsYou":     bson.M{"$in": []interface{}{u.ID, bson.M{"$ifNull": []interface{}{"$following", []interface{}{}}}}},
+			"official":       1,
+			"followersCount": bson.M{"$size": bson.M{"$ifNull": []interface{}{"$followers", []interface{}{}}}},
+			"lastPost":       bson.M{"$arrayElemAt": []interface{}{"$posts", -1}},
+		}},
+		// 候補を絞ってからGo側で最近の投稿を加味したスコアを計算する
+		{"$sort": bson.D{
+			{Name: "mutual", Value: -1},
+			{Name: "followsYou", Value: -1},
+			{Name: "official", Value: -1},
+			{Name: "followersCount", Value: -1},
+			{Name: "_id", Value: -1},
+		}},
+		{"$limit": suggestionPool},
+	}
+
+	candidates := []suggestionCandidate{}
+	if err := sess.DB(m.db()).C(UsersCol).Pipe(pipeline).All(&candidates); err != nil {
+		return nil, handleError(err)
+	}
+
+	suggestions := make([]models.Suggestion, 0, len(candidates))
+	for _, c := range candidates {
+		suggestions = append(suggestions, scoreSuggestion(c, now))
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].Score > suggestions[j].Score
+	})
+	if len(suggestions) > suggestionMax {
+		suggestions = suggestions[:suggestionMax]
+	}
+	return suggestions, nil
+}
+
+// suggestionCandidateIDs フォローしているユーザのフォロー先・フォロワー・公式ユーザから候補を集める
+// 全ユーザを対象にしないよう、友達の友達はフォローしている友達が多い順に上限まで絞る
+func (m *MongoInstance) suggestionCandidateIDs(sess *mgo.Session, u *models.User) ([]bson.ObjectId, error) {
+	users := sess.DB(m.db()).C(UsersCol)
+	exclude := map[bson.ObjectId]bool{u.ID: true}
+	for _, id := range u.Following {
+		exclude[id] = true
+	}
+
+	friends := []models.User{}
+	if err := users.Find(bson.M{"_id": bson.M{"$in": append([]bson.ObjectId{}, u.Following...)}}).
+		Select(bson.M{"following": 1}).
+		All(&friends); err != nil {
+		return nil, handleError(err)
+	}
+	mutual := map[bson.ObjectId]int{}
+	for _, f := range friends {
+		for _, id := range f.Following {
+			if !exclude[id] {
+				mutual[id]++
+			}
+		}
+	}
+	fof := make([]bson.ObjectId, 0, len(mutual))
+	for id := range mutual {
+		fof = append(fof, id)
+	}
+	sort.Slice(fof, func(i, j int) bool {
+		if mutual[fof[i]] != mutual[fof[j]] {
+			return mutual[fof[i]] > mutual[fof[j]]
+		}
+		return fof[i] > fof[j]
+	})
+	if len(fof) > suggestionCandidates {
+		fof = fof[:suggestionCandidates]
+	}
+
+	// フォロワーは新しい順に、公式ユーザは新しく登録した順に加える
+	official := []models.User{}
+	if err := users.Find(bson.M{"official": true, "_id": bson.M{"$ne": u.ID}}).
+		Select(bson.M{"_id": 1}).
+		Sort("-_id").
+		Limit(suggestionPool).
+		All(&official); err != nil {
+		return nil, handleError(err)
+	}
+	ids := fof
+	seen := map[bson.ObjectId]bool{}
+	for _, id := range fof {
+		seen[id] = true
+	}
+	add := func(id bson.ObjectId) {
+		if !exclude[id] && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for i, n := len(u.Followers)-1, 0; i >= 0 && n < suggestionCandidates; i, n = i-1, n+1 {
+		add(u.Followers[i])
+	}
+	for _, o := range official {
+		add(o.ID)
+	}
+	return ids, nil
+}
+
+func scoreSuggestion(c suggestionCandidate, now time.Time) models.Suggestion {
+	s := models.Suggestion{UserID: c.ID, MutualCount: c.Mutual, Reason: models.SuggestPopular}
+	s.Score = weightMutual*float64(c.Mutual) + math.Log10(1+float64(c.FollowersCount))
+	if c.FollowsYou {
+		s.Score += weightFollowsYou
+	}
+	if c.Official {
+		s.Score += weightOfficial
+	}
+	// 最後の投稿の日時はObjectIDから求める
+	if c.LastPost.Valid() {
+		switch age := now.Sub(c.LastPost.Time()); {
+		case age < 7*24*time.Hour:
+			s.Score += weightActiveWeek
+		case age < 30*24*time.Hour:
+			s.Score += weightActiveMon
+		}
+	}
+
+	switch {
+	case c.Mutual != 0:
+		s.Reason = models.SuggestFollowedByFriends
+	case c.FollowsYou:
+		s.Reason = models.SuggestFollowsYou
+	case c.Official:
+		s.Reason = models.SuggestOfficial
+	}
+	return s
+}
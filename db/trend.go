@@ -0,0 +1,50 @@
+package db
+
+import "github.com/TinyKitten/TimelineServer/models"
+
+const (
+	// DeniedHashtagsCol DB上のトレンド除外ハッシュタグ用カラム
+	DeniedHashtagsCol = "denied_hashtags"
+)
+
+// DenyHashtag ハッシュタグをトレンドから除外する。既に除外されている場合は理由を更新する
+func (m *MongoInstance) DenyHashtag(d models.DeniedHashtag) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	_, err := sess.DB(m.db()).C(DeniedHashtagsCol).UpsertId(d.Tag, d)
+	return err
+}
+
+// AllowHashtag ハッシュタグの除外を取り消す。除外されていなければmgo.ErrNotFoundを返す
+func (m *MongoInstance) AllowHashtag(tag string) error {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	return sess.DB(m.db()).C(DeniedHashtagsCol).RemoveId(tag)
+}
+
+// GetDeniedHashtags 除外されているハッシュタグを新しい順に取得する
+func (m *MongoInstance) GetDeniedHashtags() ([]models.DeniedHashtag, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	tags := []models.DeniedHashtag{}
+	if err := sess.DB(m.db()).C(DeniedHashtagsCol).Find(nil).Sort("-created_at").All(&tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// FindDeniedHashtagSet 除外されているハッシュタグの集合を取得する
+func (m *MongoInstance) FindDeniedHashtagSet() (map[string]bool, error) {
+	tags, err := m.GetDeniedHashtags()
+	if err != nil {
+		return nil, err
+	}
+	deny := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		deny[t.Tag] = true
+	}
+	return deny, nil
+}
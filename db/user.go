@@ -3,17 +3,28 @@ package db
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/garyburd/redigo/redis"
 	"go.uber.org/zap"
 
 	"github.com/TinyKitten/TimelineServer/models"
+	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
 const (
 	// UsersCol DB上のUser用カラム
 	UsersCol = "users"
+	// userSearchCachePrefix ユーザ検索結果のキャッシュキーの接頭辞
+	// スクリーンネームに使えない文字を含め、ユーザのキャッシュと衝突しないようにする
+	userSearchCachePrefix = "search:user:"
+	// userSearchCacheTTL ユーザ検索結果をキャッシュする時間
+	userSearchCacheTTL = 30 * time.Second
 )
 
 // FindUserByOID ObjectIDでユーザを検索する
@@ -48,6 +59,7 @@ func (m *MongoInstance) FindUserByOID(objectID bson.ObjectId, cached bool) (*mod
 }
 
 // FindUserByOIDArray ObjectIDの配列でユーザーを一括検索し一致したユーザの配列を返す
+// キャッシュにないユーザはDBから取得する。存在しないユーザは結果に含めない
 func (m *MongoInstance) FindUserByOIDArray(objectIds []bson.ObjectId, cached bool) ([]models.User, error) {
 	sess := m.session.Clone()
 	defer sess.Close()
@@ -55,25 +67,41 @@ func (m *MongoInstance) FindUserByOIDArray(objectIds []bson.ObjectId, cached boo
 	if &objectIds == nil {
 		return nil, errors.New("empty array")
 	}
-	array := []models.User{}
 
-	for _, objectID := range objectIds {
-		if cached {
+	found := map[bson.ObjectId]models.User{}
+	missing := objectIds
+	if cached {
+		missing = []bson.ObjectId{}
+		for _, objectID := range objectIds {
 			data, err := m.cache.GetStruct(objectID.Hex())
 			if err != nil && err != redis.ErrNil {
 				return nil, err
 			}
-			if data != nil {
-				u := m.deserializeUser(data)
-				array = append(array, *u)
+			if data == nil {
+				missing = append(missing, objectID)
+				continue
 			}
-		} else {
-			u := models.User{}
-			err := sess.DB(m.db()).C(UsersCol).
-				Find(bson.M{"_id": objectID}).One(&u)
-			if err != nil {
-				return nil, err
+			found[objectID] = *m.deserializeUser(data)
+		}
+	}
+	if len(missing) != 0 {
+		users := []models.User{}
+		err := sess.DB(m.db()).C(UsersCol).
+			Find(bson.M{"_id": bson.M{"$in": missing}}).All(&users)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			found[u.ID] = u
+			if cached {
+				m.updateUserCache(u)
 			}
+		}
+	}
+
+	array := []models.User{}
+	for _, objectID := range objectIds {
+		if u, ok := found[objectID]; ok {
 			array = append(array, u)
 		}
 	}
@@ -111,21 +139,106 @@ func (m *MongoInstance) FindUser(userid string, cached bool) (*models.User, erro
 	return u, nil
 }
 
-// DeleteUser userid(displayName)に一致したユーザを削除する
-func (m *MongoInstance) DeleteUser(userid string) error {
+// ScreenNameTaken 大文字小文字を区別せずにユーザ名が他のユーザに使われているか調べる
+// userIdLowerを持たない古いユーザも対象にする。exceptに指定したユーザは除く
+func (m *MongoInstance) ScreenNameTaken(userid string, except bson.ObjectId) (bool, error) {
 	sess := m.session.Clone()
 	defer sess.Close()
 
-	return sess.DB(m.db()).C(UsersCol).Remove(bson.M{"userId": userid})
+	selector := bson.M{"$or": []bson.M{
+		{"userIdLower": strings.ToLower(userid)},
+		{"userId": bson.RegEx{Pattern: "^" + regexp.QuoteMeta(userid) + "$", Options: "i"}},
+	}}
+	if except.Valid() {
+		selector["_id"] = bson.M{"$ne": except}
+	}
+	n, err := sess.DB(m.db()).C(UsersCol).Find(selector).Count()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// FindUserByEmail メールアドレスでユーザーを検索する
+func (m *MongoInstance) FindUserByEmail(email string) (*models.User, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+
+	u := new(models.User)
+	if err := sess.DB(m.db()).C(UsersCol).
+		Find(bson.M{"email": email}).One(&u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// SetRoles ユーザの管理権限を設定する
+func (m *MongoInstance) SetRoles(objectID bson.ObjectId, roles []models.Role) error {
+	return m.updateUserFields(objectID, bson.M{"$set": bson.M{"roles": roles}})
+}
+
+// SuspendUser ObjectIDに一致したユーザを凍結する。untilがゼロ値の場合は無期限
+func (m *MongoInstance) SuspendUser(objectID bson.ObjectId, now, until time.Time, reason string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"suspended":        true,
+			"suspendedAt":      now,
+			"suspensionReason": reason,
+		},
+	}
+	if until.IsZero() {
+		update["$unset"] = bson.M{"suspendedUntil": ""}
+	} else {
+		update["$set"].(bson.M)["suspendedUntil"] = until
+	}
+	return m.updateUserFields(objectID, update)
+}
+
+// UnsuspendUser ObjectIDに一致したユーザの凍結を解除する
+func (m *MongoInstance) UnsuspendUser(objectID bson.ObjectId) error {
+	return m.updateUserFields(objectID, bson.M{
+		"$set":   bson.M{"suspended": false, "suspensionReason": ""},
+		"$unset": bson.M{"suspendedAt": "", "suspendedUntil": ""},
+	})
 }
 
-// SuspendUser ObjectIDに一致したユーザを凍結する
-func (m *MongoInstance) SuspendUser(objectID bson.ObjectId, flag bool) error {
+// LiftExpiredSuspensions 期限を過ぎた凍結を解除し、解除したユーザのIDを返す
+func (m *MongoInstance) LiftExpiredSuspensions(now time.Time) ([]bson.ObjectId, error) {
 	sess := m.session.Clone()
 	defer sess.Close()
 
-	return sess.DB(m.db()).C(UsersCol).
-		Update(bson.M{"_id": objectID}, bson.M{"$set": bson.M{"suspended": flag}})
+	users := []models.User{}
+	if err := sess.DB(m.db()).C(UsersCol).
+		Find(bson.M{"suspended": true, "suspendedUntil": bson.M{"$lte": now}}).
+		Select(bson.M{"_id": 1}).
+		All(&users); err != nil {
+		return nil, handleError(err)
+	}
+
+	lifted := []bson.ObjectId{}
+	for _, u := range users {
+		// 解除までの間に期限が延長されていれば対象外にする
+		err := m.updateUserWhere(
+			bson.M{"_id": u.ID, "suspended": true, "suspendedUntil": bson.M{"$lte": now}},
+			u.ID,
+			bson.M{
+				"$set":   bson.M{"suspended": false, "suspensionReason": ""},
+				"$unset": bson.M{"suspendedAt": "", "suspendedUntil": ""},
+			})
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return lifted, err
+		}
+		lifted = append(lifted, u.ID)
+	}
+	return lifted, nil
+}
+
+// AddWarning ユーザの警告回数を増やす
+func (m *MongoInstance) AddWarning(objectID bson.ObjectId) error {
+	return m.updateUserFields(objectID, bson.M{"$inc": bson.M{"warningCount": 1}})
 }
 
 // FollowUser fromOIDのユーザからtoOIDのユーザをフォローする
@@ -216,12 +329,9 @@ func (m *MongoInstance) UnfollowUser(fromOID, toOID bson.ObjectId) error {
 }
 
 // SetOfficial ユーザにを公式アカウントに設定するか、剥奪する
+// ObjectIDとスクリーンネームの両方のキャッシュを最新の状態に置き換える
 func (m *MongoInstance) SetOfficial(objectID bson.ObjectId, flag bool) error {
-	sess := m.session.Clone()
-	defer sess.Close()
-
-	return sess.DB(m.db()).C(UsersCol).
-		Update(bson.M{"_id": objectID}, bson.M{"$set": bson.M{"official": flag}})
+	return m.updateUserFields(objectID, bson.M{"$set": bson.M{"official": flag}})
 }
 
 func (m *MongoInstance) deserializeUser(serialized []byte) *models.User {
@@ -230,12 +340,6 @@ func (m *MongoInstance) deserializeUser(serialized []byte) *models.User {
 	return deserialized
 }
 
-func (m *MongoInstance) deserializeUserArray(serialized []byte) *[]models.User {
-	deserialized := new([]models.User)
-	json.Unmarshal(serialized, &deserialized)
-	return deserialized
-}
-
 func (m *MongoInstance) AppendUserPost(userID bson.ObjectId, postID bson.ObjectId) error {
 	sess := m.session.Clone()
 	defer sess.Close()
@@ -256,11 +360,21 @@ func (m *MongoInstance) AppendUserPost(userID bson.ObjectId, postID bson.ObjectI
 }
 
 func (m *MongoInstance) UpdateUser(objectID bson.ObjectId, key string, value interface{}) error {
+	return m.updateUserFields(objectID, bson.M{"$set": bson.M{key: value}})
+}
+
+// updateUserFields ユーザドキュメントを更新し、キャッシュを最新の状態に置き換える
+func (m *MongoInstance) updateUserFields(objectID bson.ObjectId, update bson.M) error {
+	return m.updateUserWhere(bson.M{"_id": objectID}, objectID, update)
+}
+
+// updateUserWhere 条件付きでユーザドキュメントを更新し、キャッシュを最新の状態に置き換える
+// 条件に一致しなかった場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) updateUserWhere(selector bson.M, objectID bson.ObjectId, update bson.M) error {
 	sess := m.session.Clone()
 	defer sess.Close()
 
-	err := sess.DB(m.db()).C(UsersCol).
-		Update(bson.M{"_id": objectID}, bson.M{"$set": bson.M{key: value}})
+	err := sess.DB(m.db()).C(UsersCol).Update(selector, update)
 	if err != nil {
 		m.logger.Debug("MongoDB Error", zap.String("Error", err.Error()))
 		return err
@@ -269,8 +383,6 @@ func (m *MongoInstance) UpdateUser(objectID bson.ObjectId, key string, value int
 	var u models.User
 	if err := sess.DB(m.db()).C(UsersCol).
 		FindId(objectID).One(&u); err != nil {
-	}
-	if err != nil {
 		m.logger.Debug("MongoDB Error", zap.String("Error", err.Error()))
 		return err
 	}
@@ -284,12 +396,82 @@ func (m *MongoInstance) UpdateUser(objectID bson.ObjectId, key string, value int
 	return nil
 }
 
-func (m *MongoInstance) setUserArrayCache(key string, user []models.User) error {
-	_, err := m.cache.SetStruct(key, user)
-	if err != nil {
-		return err
-	}
-	return nil
+// VerifyEmail メールアドレスが確認対象のものから変わっていなければ確認済みにする
+// 一致しなかった場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) VerifyEmail(objectID bson.ObjectId, email string) error {
+	return m.updateUserWhere(
+		bson.M{"_id": objectID, "email": email},
+		objectID,
+		bson.M{"$set": bson.M{"emailVerified": true}})
+}
+
+// SetPassword パスワードを更新し、トークン世代を進めて既存のセッションを失効させる
+func (m *MongoInstance) SetPassword(objectID bson.ObjectId, hashed string) error {
+	return m.updateUserFields(objectID, bson.M{
+		"$set": bson.M{"password": hashed},
+		"$inc": bson.M{"tokenVersion": 1},
+	})
+}
+
+// ResetPassword トークン世代が一致する場合のみパスワードを更新する
+// 再設定リンクの使い回しを防ぐため、一致しなかった場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) ResetPassword(objectID bson.ObjectId, version int, hashed string) error {
+	selector := bson.M{"_id": objectID, "tokenVersion": version}
+	if version == 0 {
+		// tokenVersionを持たない既存ドキュメント
+		selector["tokenVersion"] = bson.M{"$in": []interface{}{0, nil}}
+	}
+	return m.updateUserWhere(selector, objectID, bson.M{
+		"$set": bson.M{"password": hashed},
+		"$inc": bson.M{"tokenVersion": 1},
+	})
+}
+
+// SetTwoFactorSecret 確認待ちのTOTPシークレットを保存する
+func (m *MongoInstance) SetTwoFactorSecret(objectID bson.ObjectId, secret string) error {
+	return m.updateUserFields(objectID, bson.M{"$set": bson.M{
+		"twoFactorEnabled":  false,
+		"twoFactorSecret":   secret,
+		"twoFactorLastStep": 0,
+		"recoveryCodes":     []string{},
+	}})
+}
+
+// EnableTwoFactor 二段階認証を有効にし、ハッシュ化済みリカバリーコードを保存する
+func (m *MongoInstance) EnableTwoFactor(objectID bson.ObjectId, step int64, hashedCodes []string) error {
+	return m.updateUserFields(objectID, bson.M{"$set": bson.M{
+		"twoFactorEnabled":  true,
+		"twoFactorLastStep": step,
+		"recoveryCodes":     hashedCodes,
+	}})
+}
+
+// DisableTwoFactor 二段階認証を無効にし、シークレットとリカバリーコードを破棄する
+func (m *MongoInstance) DisableTwoFactor(objectID bson.ObjectId) error {
+	return m.updateUserFields(objectID, bson.M{"$set": bson.M{
+		"twoFactorEnabled":  false,
+		"twoFactorSecret":   "",
+		"twoFactorLastStep": 0,
+		"recoveryCodes":     []string{},
+	}})
+}
+
+// ConsumeTwoFactorStep 使用済みのTOTPステップを記録する
+// 同じかそれ以降のステップが既に使われていた場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) ConsumeTwoFactorStep(objectID bson.ObjectId, step int64) error {
+	return m.updateUserWhere(
+		bson.M{"_id": objectID, "twoFactorLastStep": bson.M{"$lt": step}},
+		objectID,
+		bson.M{"$set": bson.M{"twoFactorLastStep": step}})
+}
+
+// ConsumeRecoveryCode ハッシュ化済みリカバリーコードを取り除く
+// 既に使われていた場合はmgo.ErrNotFoundを返す
+func (m *MongoInstance) ConsumeRecoveryCode(objectID bson.ObjectId, hashedCode string) error {
+	return m.updateUserWhere(
+		bson.M{"_id": objectID, "recoveryCodes": hashedCode},
+		objectID,
+		bson.M{"$pull": bson.M{"recoveryCodes": hashedCode}})
 }
 
 func (m *MongoInstance) updateUserCache(u models.User) (err error) {
@@ -304,36 +486,127 @@ func (m *MongoInstance) updateUserCache(u models.User) (err error) {
 	return
 }
 
-func (m *MongoInstance) SearchUser(query string, limit int) (*[]models.User, error) {
-	sess := m.session.Clone()
-	defer sess.Close()
+// SearchUser スクリーンネームか表示名の単語の前方一致でユーザを検索する
+// 大文字小文字は区別せず、完全一致・スクリーンネームの前方一致・公式・フォロワー数の順に並べる
+// 凍結中・退会手続き中のユーザは含めない。結果のIDはuserSearchCacheTTLの間キャッシュする
+// キャッシュは補助的なもので、読み書きに失敗してもDBから検索する
+func (m *MongoInstance) SearchUser(query string, now time.Time, skip, limit int) ([]models.User, error) {
+	q := strings.ToLower(query)
+	key := fmt.Sprintf("%s%d:%d:%s", userSearchCachePrefix, skip, limit, q)
 
-	/*
-		data, err := m.cache.GetStruct(query)
-		if err != nil && err != redis.ErrNil {
+	var ids []bson.ObjectId
+	data, err := m.cache.GetStruct(key)
+	if err != nil {
+		m.logger.Debug("Redis Error", zap.String("Error", err.Error()))
+	}
+	if data != nil {
+		if err := json.Unmarshal(data, &ids); err != nil {
+			m.logger.Debug("Redis Error", zap.String("Error", err.Error()))
+			ids = nil
+		}
+	}
+	if ids == nil {
+		if ids, err = m.searchUserIDs(q, now, skip, limit); err != nil {
 			return nil, err
 		}
-		if data != nil {
-			u := m.deserializeUserArray(data)
-			return u, nil
+		if err := m.cache.SetStructEx(key, ids, userSearchCacheTTL); err != nil {
+			m.logger.Debug("Redis Error", zap.String("Error", err.Error()))
 		}
-	*/
-
-	u := []models.User{}
-	if err := sess.DB(m.db()).C(UsersCol).
-		Find(bson.M{"userId": bson.M{"$regex": bson.RegEx{Pattern: `^` + query + `.*`, Options: "m"}}}).
-		Limit(limit).
-		All(&u); err != nil {
-		return nil, err
 	}
 
-	/*
-		err = m.setUserArrayCache(query, u)
+	// プロフィールはキャッシュされたユーザから最新のものを返す
+	users := []models.User{}
+	for _, id := range ids {
+		u, err := m.FindUserByOID(id, true)
+		if err == mgo.ErrNotFound {
+			continue
+		}
 		if err != nil {
-			m.logger.Debug("Redis Error", zap.String("Error", err.Error()))
 			return nil, err
 		}
-	*/
+		users = append(users, *u)
+	}
+	return users, nil
+}
+
+// IndexUsers 検索用のキーがないユーザ(キーの導入前に登録・変更したユーザ)にキーを設定する
+func (m *MongoInstance) IndexUsers() (int, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
+	c := sess.DB(m.db()).C(UsersCol)
+
+	n := 0
+	u := models.User{}
+	iter := c.Find(bson.M{"$or": []bson.M{
+		{"userIdLower": bson.M{"$exists": false}},
+		{"displayNameKeys": bson.M{"$exists": false}},
+	}}).Select(bson.M{"userId": 1, "displayName": 1}).Iter()
+	for iter.Next(&u) {
+		set := bson.M{
+			"userIdLower":     strings.ToLower(u.UserID),
+			"displayNameKeys": models.DisplayNameKeys(u.DisplayName),
+		}
+		err := c.UpdateId(u.ID, bson.M{"$set": set})
+		if mgo.IsDup(err) {
+			// 大文字小文字だけが異なる古いユーザ同士は、スクリーンネームでは検索できないままにする
+			delete(set, "userIdLower")
+			err = c.UpdateId(u.ID, bson.M{"$set": set})
+		}
+		if err != nil {
+			iter.Close()
+			return n, handleError(err)
+		}
+		n++
+	}
+	if err := iter.Close(); err != nil {
+		return n, handleError(err)
+	}
+	return n, nil
+}
+
+func (m *MongoInstance) searchUserIDs(q string, now time.Time, skip, limit int) ([]bson.ObjectId, error) {
+	sess := m.session.Clone()
+	defer sess.Close()
 
-	return &u, nil
+	quoted := regexp.QuoteMeta(q)
+	screenName := "$userIdLower"
+	pipeline := []bson.M{
+		{"$match": bson.M{
+			"$or": []bson.M{
+				{"userIdLower": bson.RegEx{Pattern: "^" + quoted}},
+				{"displayNameKeys": bson.RegEx{Pattern: "^" + quoted}},
+			},
+			"$nor": hiddenUserConditions(now),
+		}},
+		{"$addFields": bson.M{
+			"_exact": bson.M{"$or": []interface{}{
+				bson.M{"$eq": []interface{}{screenName, q}},
+				bson.M{"$eq": []interface{}{bson.M{"$toLower": "$displayName"}, q}},
+			}},
+			"_prefix":    bson.M{"$eq": []interface{}{bson.M{"$substrCP": []interface{}{screenName, 0, utf8.RuneCountInString(q)}}, q}},
+			"_followers": bson.M{"$size": bson.M{"$ifNull": []interface{}{"$followers", []interface{}{}}}},
+		}},
+		{"$sort": bson.D{
+			{Name: "_exact", Value: -1},
+			{Name: "_prefix", Value: -1},
+			{Name: "official", Value: -1},
+			{Name: "_followers", Value: -1},
+			{Name: "_id", Value: 1},
+		}},
+		{"$skip": skip},
+		{"$limit": limit},
+		{"$project": bson.M{"_id": 1}},
+	}
+
+	result := []struct {
+		ID bson.ObjectId `bson:"_id"`
+	}{}
+	if err := sess.DB(m.db()).C(UsersCol).Pipe(pipeline).All(&result); err != nil {
+		return nil, handleError(err)
+	}
+	ids := make([]bson.ObjectId, len(result))
+	for i, r := range result {
+		ids[i] = r.ID
+	}
+	return ids, nil
 }
@@ -0,0 +1,175 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/models"
+)
+
+// Profile エクスポートするプロフィール。パスワードや二段階認証のシークレットは含めない
+type Profile struct {
+	ID               string    `json:"id"`
+	ScreenName       string    `json:"screen_name"`
+	Name             string    `json:"name"`
+	Description      string    `json:"description"`
+	Email            string    `json:"email"`
+	EmailVerified    bool      `json:"email_verified"`
+	Location         string    `json:"location"`
+	URL              string    `json:"url"`
+	ProfileImageURL  string    `json:"profile_image_url"`
+	Official         bool      `json:"official"`
+	Suspended        bool      `json:"suspended"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+
+	BannerURL     string                `json:"profile_banner_url,omitempty"`
+	Birthday      *models.Birthday      `json:"birthday,omitempty"`
+	PinnedPostID  string                `json:"pinned_post_id,omitempty"`
+	ProfileFields []models.ProfileField `json:"profile_fields,omitempty"`
+}
+
+// NewProfile Userからエクスポート用のプロフィールを作る
+func NewProfile(u models.User) Profile {
+	pinnedPostID := ""
+	if u.PinnedPostID.Valid() {
+		pinnedPostID = u.PinnedPostID.Hex()
+	}
+	return Profile{
+		ID:               u.ID.Hex(),
+		ScreenName:       u.UserID,
+		Name:             u.DisplayName,
+		Description:      u.Description,
+		Email:            u.EMail,
+		EmailVerified:    u.EmailVerified,
+		Location:         u.Location,
+		URL:              u.WebsiteURL,
+		ProfileImageURL:  u.AvatarURL,
+		Official:         u.Official,
+		Suspended:        u.Suspended,
+		TwoFactorEnabled: u.TwoFactorEnabled,
+		CreatedAt:        u.CreatedDate,
+		UpdatedAt:        u.UpdatedDate,
+		BannerURL:        u.BannerURL,
+		Birthday:         u.Birthday,
+		PinnedPostID:     pinnedPostID,
+		ProfileFields:    u.ProfileFields,
+	}
+}
+
+// Archive エクスポートするデータ一式
+type Archive struct {
+	Profile           Profile
+	Posts             []models.Post
+	Following         []models.UserResponse
+	Followers         []models.UserResponse
+	Likes             []models.Post
+	Bookmarks         []models.Post
+	Events            []models.Event
+	ScreenNameHistory []models.ScreenNameHistory
+	Reports           []models.Report
+	Official          []models.OfficialApplication
+	Lists             []models.List
+	// Files アーカイブに含めるファイル。キーはアーカイブ内のパス、値はOpenに渡すパス
+	Files map[string]string
+	// Open Filesのファイルを開く。nilの場合はローカルのファイルを開く
+	// 存在しないファイルはos.IsNotExistで判定できるエラーを返せば読み飛ばす
+	Open func(path string) (io.ReadCloser, error)
+}
+
+// Write アーカイブをzip形式で書き出す
+func Write(w io.Writer, a Archive) error {
+	z := zip.NewWriter(w)
+
+	entries := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", a.Profile},
+		{"posts.json", a.Posts},
+		{"following.json", a.Following},
+		{"followers.json", a.Followers},
+		{"likes.json", a.Likes},
+		{"bookmarks.json", a.Bookmarks},
+		{"events.json", a.Events},
+		{"screen_name_history.json", a.ScreenNameHistory},
+		{"reports.json", a.Reports},
+		{"official_applications.json", a.Official},
+		{"lists.json", a.Lists},
+	}
+	for _, e := range entries {
+		if err := writeJSON(z, e.name, e.data); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(a.Files))
+	for name := range a.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	open := a.Open
+	if open == nil {
+		open = func(path string) (io.ReadCloser, error) { return os.Open(path) }
+	}
+	for _, name := range names {
+		if err := writeFile(z, name, a.Files[name], open); err != nil {
+			return err
+		}
+	}
+
+	return z.Close()
+}
+
+// WriteFile アーカイブをpathに書き出す。書き込み途中のファイルは残さない
+func WriteFile(path string, a Archive) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := Write(f, a); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func writeJSON(z *zip.Writer, name string, data interface{}) error {
+	w, err := z.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(data)
+}
+
+func writeFile(z *zip.Writer, name, path string, open func(string) (io.ReadCloser, error)) error {
+	f, err := open(path)
+	if os.IsNotExist(err) {
+		// プロフィール画像が既に削除されている場合など
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := z.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,89 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TinyKitten/TimelineServer/models"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	avatar := filepath.Join(dir, "avatar.png")
+	if err := ioutil.WriteFile(avatar, []byte("png"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	u := models.NewUser("kitten", "hashed password", "kitten@example.com", false)
+	u.TwoFactorSecret = "SECRET"
+	a := Archive{
+		Profile: NewProfile(*u),
+		Posts:   []models.Post{*models.NewPost(u.ID, "", "hello")},
+		Files: map[string]string{
+			"media/avatar.png":  avatar,
+			"media/missing.png": filepath.Join(dir, "missing.png"),
+		},
+	}
+	path := filepath.Join(dir, "export.zip")
+	if err := WriteFile(path, a); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	files := map[string]string{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dat, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(dat)
+	}
+
+	for _, name := range []string{"profile.json", "posts.json", "following.json", "likes.json", "events.json"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("%s missing", name)
+		}
+	}
+	if strings.Contains(files["profile.json"], "hashed password") || strings.Contains(files["profile.json"], "SECRET") {
+		t.Fatalf("profile should not contain secrets: %s", files["profile.json"])
+	}
+	profile := Profile{}
+	if err := json.Unmarshal([]byte(files["profile.json"]), &profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.ScreenName != "kitten" || profile.Email != "kitten@example.com" {
+		t.Fatalf("unexpected profile: %v", profile)
+	}
+	if !strings.Contains(files["posts.json"], "hello") {
+		t.Fatalf("posts missing: %s", files["posts.json"])
+	}
+	if files["media/avatar.png"] != "png" {
+		t.Fatalf("avatar missing: %v", files)
+	}
+	if _, ok := files["media/missing.png"]; ok {
+		t.Fatal("missing files should be skipped")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("temporary file should be removed")
+	}
+}
@@ -0,0 +1,104 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"image"
+)
+
+// orientationTag EXIFの向き(Orientation)のタグ番号
+const orientationTag = 0x0112
+
+// jpegOrientation JPEGのEXIFから向き(1-8)を読み取る。見つからない・壊れている場合は1
+func jpegOrientation(dat []byte) int {
+	if len(dat) < 4 || dat[0] != 0xFF || dat[1] != 0xD8 {
+		return 1
+	}
+	i := 2
+	for i+4 <= len(dat) && dat[i] == 0xFF {
+		marker := dat[i+1]
+		size := int(binary.BigEndian.Uint16(dat[i+2:]))
+		// SOS以降は画像データ
+		if marker == 0xDA || size < 2 || i+2+size > len(dat) {
+			return 1
+		}
+		seg := dat[i+4 : i+2+size]
+		if marker == 0xE1 && len(seg) > 6 && string(seg[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(seg[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// tiffOrientation EXIFのTIFF構造の最初のIFDから向きを読み取る
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for k := 0; k < n; k++ {
+		e := ifd + 2 + k*12
+		if e+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[e:]) == orientationTag {
+			o := int(order.Uint16(tiff[e+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+	return 1
+}
+
+// orient EXIFの向きに従って画像を回転・反転する
+func orient(src image.Image, o int) image.Image {
+	if o <= 1 || o > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch o {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, src.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,121 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+)
+
+var errGIFFormat = errors.New("malformed gif")
+
+// gifFrames 展開せずにGIFのブロックを読み、フレーム数と各フレームの面積の合計を返す
+// maxFramesかmaxPixelsを超えた時点で読むのをやめる
+func gifFrames(dat []byte, maxFrames int, maxPixels int64) (int, int64, error) {
+	// ヘッダー(6)と論理画面記述子(7)
+	if len(dat) < 13 {
+		return 0, 0, errGIFFormat
+	}
+	pos := 13
+	if flags := dat[10]; flags&0x80 != 0 {
+		pos += 3 << (uint(flags&0x07) + 1)
+	}
+
+	// skipSubBlocks 長さ付きのサブブロックの並びを終端(長さ0)まで読み飛ばす
+	skipSubBlocks := func() error {
+		for {
+			if pos >= len(dat) {
+				return errGIFFormat
+			}
+			n := int(dat[pos])
+			pos += 1 + n
+			if n == 0 {
+				return nil
+			}
+		}
+	}
+
+	frames, pixels := 0, int64(0)
+	for pos < len(dat) {
+		switch dat[pos] {
+		case 0x21: // 拡張ブロック
+			pos += 2
+			if err := skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+		case 0x2C: // 画像記述子
+			if pos+10 > len(dat) {
+				return 0, 0, errGIFFormat
+			}
+			w := int64(binary.LittleEndian.Uint16(dat[pos+5:]))
+			h := int64(binary.LittleEndian.Uint16(dat[pos+7:]))
+			flags := dat[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (uint(flags&0x07) + 1)
+			}
+			frames++
+			pixels += w * h
+			if frames > maxFrames || pixels > maxPixels {
+				return frames, pixels, nil
+			}
+			// LZWの最小コードサイズと画像データ
+			pos++
+			if err := skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+		case 0x3B: // 終端
+			return frames, pixels, nil
+		default:
+			return 0, 0, errGIFFormat
+		}
+	}
+	return frames, pixels, nil
+}
+
+// makeAnimatedVariant アニメーションGIFの各フレームを合成してから縮小し、アニメーションのまま書き出す
+// 縮小後のフレームは全て画面全体を描き直すため、破棄方法は指定しない
+func makeAnimatedVariant(g *gif.GIF, spec VariantSpec) (*Variant, error) {
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	out := &gif.GIF{LoopCount: g.LoopCount}
+	for i, frame := range g.Image {
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvas.Bounds())
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		scaled := scale(canvas, spec)
+		p := image.NewPaletted(scaled.Bounds(), frame.Palette)
+		draw.Draw(p, p.Bounds(), scaled, scaled.Bounds().Min, draw.Src)
+		out.Image = append(out.Image, p)
+		delay := 0
+		if i < len(g.Delay) {
+			delay = g.Delay[i]
+		}
+		out.Delay = append(out.Delay, delay)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	b := out.Image[0].Bounds()
+	v := &Variant{Name: spec.Name, Width: b.Dx(), Height: b.Dy(), Format: "gif", ContentType: contentTypes["gif"]}
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, out); err != nil {
+		return nil, err
+	}
+	v.Data = buf.Bytes()
+	return v, nil
+}
@@ -0,0 +1,264 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"strings"
+
+	"github.com/TinyKitten/TimelineServer/config"
+)
+
+var (
+	// ErrTooLarge データのバイト数が上限を超えている
+	ErrTooLarge = errors.New("image is too large")
+	// ErrTooManyPixels 画像の幅か高さ、アニメーションのフレーム数か全フレームの面積が上限を超えている
+	ErrTooManyPixels = errors.New("image dimensions are too large")
+	// ErrUnsupported 対応していない形式か、画像として読み込めない
+	ErrUnsupported = errors.New("unsupported image format")
+	// ErrAnimated アニメーションGIFが許可されていない
+	ErrAnimated = errors.New("animated gif is not allowed")
+)
+
+// jpegQuality JPEGを書き出すときの品質
+const jpegQuality = 90
+
+// contentTypes 対応する形式(image.DecodeConfigの形式名)とMIMEタイプ
+var contentTypes = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"gif":  "image/gif",
+}
+
+// Options 画像の受け入れ条件
+type Options struct {
+	// MaxBytes デコード前のデータの最大バイト数
+	MaxBytes int64
+	// MaxDimension 幅・高さの最大ピクセル数
+	MaxDimension int
+	// AllowAnimated アニメーションGIFをそのまま保存するか。falseの場合は拒否する
+	AllowAnimated bool
+	// MaxFrames アニメーションGIFの最大フレーム数
+	MaxFrames int
+	// MaxFramePixels アニメーションGIFの全フレームの面積の合計の上限
+	MaxFramePixels int64
+}
+
+// DefaultOptions 標準の受け入れ条件
+var DefaultOptions = Options{
+	MaxBytes:       5 << 20,
+	MaxDimension:   4096,
+	AllowAnimated:  true,
+	MaxFrames:      300,
+	MaxFramePixels: 64 << 20,
+}
+
+// NewOptions 設定から受け入れ条件を生成する。未設定の項目はDefaultOptionsの値を使う
+func NewOptions(conf config.UploadImageConfig) Options {
+	o := DefaultOptions
+	if conf.MaxBytes > 0 {
+		o.MaxBytes = conf.MaxBytes
+	}
+	if conf.MaxDimension > 0 {
+		o.MaxDimension = conf.MaxDimension
+	}
+	if conf.AnimatedGIF != "" {
+		o.AllowAnimated = conf.AnimatedGIF == "preserve"
+	}
+	if conf.MaxGIFFrames > 0 {
+		o.MaxFrames = conf.MaxGIFFrames
+	}
+	if conf.MaxGIFPixels > 0 {
+		o.MaxFramePixels = conf.MaxGIFPixels
+	}
+	return o
+}
+
+// VariantSpec 生成する縮小画像の大きさ
+type VariantSpec struct {
+	Name   string
+	Width  int
+	Height int
+	// Crop trueの場合は中央を切り抜いてWidth×Heightちょうどにする。falseの場合は収まるよう縮小する
+	Crop bool
+	// KeepAnimation trueの場合、アニメーションGIFはアニメーションのまま縮小する
+	KeepAnimation bool
+}
+
+var (
+	// AvatarVariants プロフィール画像の大きさ。最初のものを元の画像の代わりに保存する
+	AvatarVariants = []VariantSpec{
+		{Name: "400x400", Width: 400, Height: 400, Crop: true, KeepAnimation: true},
+		{Name: "normal", Width: 48, Height: 48, Crop: true, KeepAnimation: true},
+	}
+	// BannerVariants ヘッダー画像の大きさ。最初のものを元の画像の代わりに保存する
+	BannerVariants = []VariantSpec{
+		{Name: "1500x500", Width: 1500, Height: 500, Crop: true, KeepAnimation: true},
+		{Name: "600x200", Width: 600, Height: 200, Crop: true, KeepAnimation: true},
+	}
+	// MediaVariants 添付画像の縮小版の大きさ
+	MediaVariants = []VariantSpec{
+		{Name: "thumb", Width: 150, Height: 150, Crop: true},
+		{Name: "small", Width: 680, Height: 680},
+	}
+)
+
+// Variant 生成した縮小画像
+type Variant struct {
+	Name        string
+	Width       int
+	Height      int
+	Format      string
+	ContentType string
+	Data        []byte
+}
+
+// Image 検証し、メタデータを取り除いて書き出し直した画像
+type Image struct {
+	Format      string // png, jpeg, gif
+	ContentType string
+	Width       int
+	Height      int
+	Animated    bool
+	Data        []byte
+	Variants    []Variant
+}
+
+// Process 画像を検証し、メタデータ(EXIFなど)を取り除いて書き出し直し、縮小画像を生成する
+// 形式は拡張子などではなく内容から判定する。JPEGはEXIFの向きを反映してから書き出す
+func (o Options) Process(dat []byte, variants []VariantSpec) (*Image, error) {
+	if int64(len(dat)) > o.MaxBytes {
+		return nil, ErrTooLarge
+	}
+	// 展開する前に大きさを確かめ、巨大な画像でメモリを使い切らないようにする
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(dat))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > o.MaxDimension || cfg.Height > o.MaxDimension {
+		return nil, ErrTooManyPixels
+	}
+
+	img := &Image{Format: format, ContentType: contentType}
+	var src image.Image
+	var anim *gif.GIF
+	buf := new(bytes.Buffer)
+	switch format {
+	case "gif":
+		// 全フレームを展開する前にフレーム数と面積を確かめる
+		frames, pixels, err := gifFrames(dat, o.MaxFrames, o.MaxFramePixels)
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		if frames > o.MaxFrames || pixels > o.MaxFramePixels {
+			return nil, ErrTooManyPixels
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(dat))
+		if err != nil || len(g.Image) == 0 {
+			return nil, ErrUnsupported
+		}
+		if len(g.Image) > 1 {
+			if !o.AllowAnimated {
+				return nil, ErrAnimated
+			}
+			img.Animated = true
+			anim = g
+		}
+		// コメントなどの拡張ブロックはデコード時に捨てられる
+		if err := gif.EncodeAll(buf, g); err != nil {
+			return nil, err
+		}
+		// 縮小画像は最初のフレームから作る
+		first := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+		draw.Draw(first, g.Image[0].Bounds(), g.Image[0], g.Image[0].Bounds().Min, draw.Src)
+		src = first
+	case "jpeg":
+		decoded, err := jpeg.Decode(bytes.NewReader(dat))
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		src = orient(decoded, jpegOrientation(dat))
+		if err := jpeg.Encode(buf, src, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, err
+		}
+	case "png":
+		decoded, err := png.Decode(bytes.NewReader(dat))
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		src = decoded
+		if err := png.Encode(buf, src); err != nil {
+			return nil, err
+		}
+	}
+	img.Data = buf.Bytes()
+	img.Width, img.Height = src.Bounds().Dx(), src.Bounds().Dy()
+
+	for _, spec := range variants {
+		var v *Variant
+		var err error
+		if anim != nil && spec.KeepAnimation {
+			v, err = makeAnimatedVariant(anim, spec)
+		} else {
+			v, err = makeVariant(src, format, spec)
+		}
+		if err != nil {
+			return nil, err
+		}
+		img.Variants = append(img.Variants, *v)
+	}
+	return img, nil
+}
+
+// makeVariant 縮小画像を生成する。JPEGはJPEG、それ以外はPNGで書き出す
+func makeVariant(src image.Image, format string, spec VariantSpec) (*Variant, error) {
+	dst := scale(src, spec)
+	v := &Variant{Name: spec.Name, Width: dst.Bounds().Dx(), Height: dst.Bounds().Dy()}
+	buf := new(bytes.Buffer)
+	if format == "jpeg" {
+		v.Format = "jpeg"
+		if err := jpeg.Encode(buf, dst, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, err
+		}
+	} else {
+		v.Format = "png"
+		if err := png.Encode(buf, dst); err != nil {
+			return nil, err
+		}
+	}
+	v.ContentType = contentTypes[v.Format]
+	v.Data = buf.Bytes()
+	return v, nil
+}
+
+// scale specの大きさに切り抜き・縮小する
+func scale(src image.Image, spec VariantSpec) *image.RGBA {
+	if spec.Crop {
+		return resize(cropCenter(src, spec.Width, spec.Height), spec.Width, spec.Height)
+	}
+	w, h := fit(src.Bounds().Dx(), src.Bounds().Dy(), spec.Width, spec.Height)
+	return resize(src, w, h)
+}
+
+// VariantPath 元の画像のパスに縮小画像の名前を付けたパスを返す(img.png → img_thumb.png)
+func VariantPath(path, name string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "_" + name + ext
+}
+
+// Ext 形式に対応する拡張子を返す
+func Ext(format string) string {
+	if format == "jpeg" {
+		return ".jpg"
+	}
+	return "." + format
+}
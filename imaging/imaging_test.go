@@ -0,0 +1,212 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(img image.Image) []byte {
+	buf := new(bytes.Buffer)
+	png.Encode(buf, img)
+	return buf.Bytes()
+}
+
+// withOrientation EXIFの向きだけを持つAPP1セグメントをJPEGに挿入する
+func withOrientation(dat []byte, o uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	entry := make([]byte, 12)
+	binary.BigEndian.PutUint16(entry[0:], orientationTag)
+	binary.BigEndian.PutUint16(entry[2:], 3) // SHORT
+	binary.BigEndian.PutUint32(entry[4:], 1)
+	binary.BigEndian.PutUint16(entry[8:], o)
+	tiff = append(append(tiff, entry...), 0, 0, 0, 0)
+	seg := append([]byte("Exif\x00\x00"), tiff...)
+
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(seg)+2))
+	out := append([]byte{}, dat[:2]...)
+	out = append(append(out, app1...), seg...)
+	return append(out, dat[2:]...)
+}
+
+func TestProcessValidates(t *testing.T) {
+	o := Options{MaxBytes: 1 << 20, MaxDimension: 100}
+
+	if _, err := o.Process([]byte("not an image"), nil); err != ErrUnsupported {
+		t.Fatalf("expected %v, actual %v", ErrUnsupported, err)
+	}
+	if _, err := o.Process(encodePNG(image.NewRGBA(image.Rect(0, 0, 101, 10))), nil); err != ErrTooManyPixels {
+		t.Fatalf("expected %v, actual %v", ErrTooManyPixels, err)
+	}
+	small := Options{MaxBytes: 10, MaxDimension: 100}
+	if _, err := small.Process(encodePNG(image.NewRGBA(image.Rect(0, 0, 10, 10))), nil); err != ErrTooLarge {
+		t.Fatalf("expected %v, actual %v", ErrTooLarge, err)
+	}
+}
+
+func TestProcessVariants(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	// 左半分を白にして、中央を切り抜いても左右の色が保たれることを確かめる
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 150; x++ {
+			src.Set(x, y, color.White)
+		}
+	}
+	img, err := DefaultOptions.Process(encodePNG(src), []VariantSpec{
+		{Name: "square", Width: 10, Height: 10, Crop: true},
+		{Name: "fit", Width: 60, Height: 60},
+		{Name: "large", Width: 1000, Height: 1000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Format != "png" || img.Width != 300 || img.Height != 100 {
+		t.Fatalf("unexpected image: %s %dx%d", img.Format, img.Width, img.Height)
+	}
+
+	sizes := map[string][2]int{"square": {10, 10}, "fit": {60, 20}, "large": {300, 100}}
+	for _, v := range img.Variants {
+		decoded, err := png.Decode(bytes.NewReader(v.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := decoded.Bounds()
+		if expected := sizes[v.Name]; b.Dx() != expected[0] || b.Dy() != expected[1] || v.Width != b.Dx() || v.Height != b.Dy() {
+			t.Fatalf("%s: expected %v, actual %v", v.Name, expected, b.Size())
+		}
+		if v.Name == "square" {
+			if r, _, _, _ := decoded.At(0, 5).RGBA(); r>>8 != 255 {
+				t.Fatalf("left edge should be white, actual %d", r>>8)
+			}
+			if r, _, _, _ := decoded.At(9, 5).RGBA(); r>>8 != 0 {
+				t.Fatalf("right edge should be black, actual %d", r>>8)
+			}
+		}
+	}
+}
+
+func TestProcessJPEGOrientationAndMetadata(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	buf := new(bytes.Buffer)
+	jpeg.Encode(buf, src, nil)
+	dat := withOrientation(buf.Bytes(), 6)
+	if o := jpegOrientation(dat); o != 6 {
+		t.Fatalf("expected orientation 6, actual %d", o)
+	}
+
+	img, err := DefaultOptions.Process(dat, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 90度回転して縦長になり、EXIFは取り除かれる
+	if img.Width != 20 || img.Height != 40 {
+		t.Fatalf("expected 20x40, actual %dx%d", img.Width, img.Height)
+	}
+	if bytes.Contains(img.Data, []byte("Exif")) {
+		t.Fatal("exif should be stripped")
+	}
+	if o := jpegOrientation(img.Data); o != 1 {
+		t.Fatalf("orientation should be reset, actual %d", o)
+	}
+}
+
+func TestProcessAnimatedGIF(t *testing.T) {
+	g := &gif.GIF{}
+	for i := 0; i < 2; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 8, 8), palette.Plan9))
+		g.Delay = append(g.Delay, 10)
+	}
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	reject := DefaultOptions
+	reject.AllowAnimated = false
+	if _, err := reject.Process(buf.Bytes(), nil); err != ErrAnimated {
+		t.Fatalf("expected %v, actual %v", ErrAnimated, err)
+	}
+
+	img, err := DefaultOptions.Process(buf.Bytes(), MediaVariants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !img.Animated || img.Format != "gif" {
+		t.Fatalf("animation should be preserved: %+v", img.Format)
+	}
+	preserved, err := gif.DecodeAll(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preserved.Image) != 2 {
+		t.Fatalf("expected 2 frames, actual %d", len(preserved.Image))
+	}
+	if img.Variants[0].Format != "png" {
+		t.Fatalf("variants should be png, actual %s", img.Variants[0].Format)
+	}
+}
+
+func TestProcessAnimatedProfileImage(t *testing.T) {
+	g := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 60, 30), palette.Plan9))
+		g.Delay = append(g.Delay, 10)
+	}
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := DefaultOptions.Process(buf.Bytes(), AvatarVariants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range img.Variants {
+		if v.Format != "gif" {
+			t.Fatalf("%s should stay animated, actual %s", v.Name, v.Format)
+		}
+		scaled, err := gif.DecodeAll(bytes.NewReader(v.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(scaled.Image) != 3 || scaled.Image[0].Bounds().Dx() != v.Width || v.Width != v.Height {
+			t.Fatalf("%s: unexpected animation %d frames %v", v.Name, len(scaled.Image), scaled.Image[0].Bounds())
+		}
+	}
+}
+
+func TestProcessGIFLimits(t *testing.T) {
+	g := &gif.GIF{}
+	for i := 0; i < 4; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 10, 10), palette.Plan9))
+		g.Delay = append(g.Delay, 10)
+	}
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatal(err)
+	}
+	if frames, pixels, err := gifFrames(buf.Bytes(), 100, 1<<20); err != nil || frames != 4 || pixels != 400 {
+		t.Fatalf("expected 4 frames and 400 pixels, actual %d %d %v", frames, pixels, err)
+	}
+
+	frames := DefaultOptions
+	frames.MaxFrames = 3
+	if _, err := frames.Process(buf.Bytes(), nil); err != ErrTooManyPixels {
+		t.Fatalf("expected %v, actual %v", ErrTooManyPixels, err)
+	}
+	pixels := DefaultOptions
+	pixels.MaxFramePixels = 399
+	if _, err := pixels.Process(buf.Bytes(), nil); err != ErrTooManyPixels {
+		t.Fatalf("expected %v, actual %v", ErrTooManyPixels, err)
+	}
+	if _, err := DefaultOptions.Process(buf.Bytes(), nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,95 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+)
+
+// fit 縦横比を保ったままw×hに収まる大きさを返す。元の画像より大きくはしない
+func fit(srcW, srcH, w, h int) (int, int) {
+	if srcW <= w && srcH <= h {
+		return srcW, srcH
+	}
+	if srcW*h > srcH*w {
+		return w, maxInt(1, srcH*w/srcW)
+	}
+	return maxInt(1, srcW*h/srcH), h
+}
+
+// cropCenter w:hの縦横比になるよう中央を切り抜く
+func cropCenter(src image.Image, w, h int) image.Image {
+	b := src.Bounds()
+	cw, ch := b.Dx(), b.Dy()
+	if cw*h > ch*w {
+		cw = maxInt(1, ch*w/h)
+	} else {
+		ch = maxInt(1, cw*h/w)
+	}
+	x0 := b.Min.X + (b.Dx()-cw)/2
+	y0 := b.Min.Y + (b.Dy()-ch)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, cw, ch))
+	draw.Draw(dst, dst.Bounds(), src, image.Pt(x0, y0), draw.Src)
+	return dst
+}
+
+// resize 領域平均法でw×hに縮小する。元の画像より大きい場合は拡大せずそのままの大きさにする
+func resize(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if w > sw {
+		w = sw
+	}
+	if h > sh {
+		h = sh
+	}
+
+	// 直接Pixを読めるよう、乗算済みアルファのRGBAに揃える
+	s, ok := src.(*image.RGBA)
+	if !ok || s.Bounds().Min != (image.Point{}) {
+		s = image.NewRGBA(image.Rect(0, 0, sw, sh))
+		draw.Draw(s, s.Bounds(), src, b.Min, draw.Src)
+	}
+	if w == sw && h == sh {
+		return s
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, (y+1)*sh/h
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, (x+1)*sw/w
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				i := s.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(s.Pix[i])
+					g += uint64(s.Pix[i+1])
+					bl += uint64(s.Pix[i+2])
+					a += uint64(s.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(bl / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
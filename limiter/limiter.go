@@ -0,0 +1,141 @@
+package limiter
+
+import (
+	"strings"
+	"time"
+)
+
+// Store 失敗回数とロック状態を保持するストア
+type Store interface {
+	// IncrEx keyの値を1増やして有効期限をttlにし、増やした後の値を返す。増加と期限の設定は不可分に行う
+	IncrEx(key string, ttl time.Duration) (int64, error)
+	// Expire keyの有効期限を設定する
+	Expire(key string, ttl time.Duration) error
+	// SetEx ttlの間だけ存在するkeyを作成する
+	SetEx(key string, ttl time.Duration) error
+	// TTL keyの残り時間を返す。存在しない場合は0
+	TTL(key string) (time.Duration, error)
+	// Del keyを削除する
+	Del(keys ...string) error
+}
+
+// Policy ロックの条件
+type Policy struct {
+	// AccountThreshold アカウントごとの許容失敗回数
+	AccountThreshold int64
+	// IPThreshold IPアドレスごとの許容失敗回数
+	IPThreshold int64
+	// Window 失敗回数を保持する期間
+	Window time.Duration
+	// BaseLockout 初回のロック時間。以降失敗するたびに倍になる
+	BaseLockout time.Duration
+	// MaxLockout ロック時間の上限
+	MaxLockout time.Duration
+}
+
+// DefaultPolicy 標準のロック条件
+var DefaultPolicy = Policy{
+	AccountThreshold: 5,
+	IPThreshold:      50,
+	Window:           15 * time.Minute,
+	BaseLockout:      time.Minute,
+	MaxLockout:       time.Hour,
+}
+
+const (
+	failPrefix = "login:fail:"
+	lockPrefix = "login:lock:"
+)
+
+// LoginLimiter ログイン失敗を数え、総当たり攻撃に対してアカウントとIPアドレスをロックする
+type LoginLimiter struct {
+	store  Store
+	policy Policy
+}
+
+// NewLoginLimiter LoginLimiterを生成する
+func NewLoginLimiter(store Store, policy Policy) *LoginLimiter {
+	return &LoginLimiter{store: store, policy: policy}
+}
+
+// Check アカウントかIPアドレスがロック中であれば、解除までの時間を返す
+func (l *LoginLimiter) Check(account, ip string) (time.Duration, error) {
+	var retryAfter time.Duration
+	for _, key := range l.keys(account, ip) {
+		ttl, err := l.store.TTL(lockPrefix + key)
+		if err != nil {
+			return 0, err
+		}
+		if ttl > retryAfter {
+			retryAfter = ttl
+		}
+	}
+	return retryAfter, nil
+}
+
+// Fail 失敗を記録する。ロックされた場合はそれぞれのロック時間を返す
+func (l *LoginLimiter) Fail(account, ip string) (accountLock, ipLock time.Duration, err error) {
+	accountLock, err = l.fail(accountKey(account), l.policy.AccountThreshold)
+	if err != nil {
+		return 0, 0, err
+	}
+	if ip != "" {
+		ipLock, err = l.fail(ipKey(ip), l.policy.IPThreshold)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return accountLock, ipLock, nil
+}
+
+// Success ログインに成功したアカウントの失敗回数をリセットする
+// IPアドレスの失敗回数は攻撃者自身のアカウントでリセットされないよう残す
+func (l *LoginLimiter) Success(account string) error {
+	key := accountKey(account)
+	return l.store.Del(failPrefix+key, lockPrefix+key)
+}
+
+func (l *LoginLimiter) fail(key string, threshold int64) (time.Duration, error) {
+	count, err := l.store.IncrEx(failPrefix+key, l.policy.Window)
+	if err != nil {
+		return 0, err
+	}
+	if count < threshold {
+		return 0, nil
+	}
+
+	lockout := l.lockout(count - threshold)
+	if err := l.store.SetEx(lockPrefix+key, lockout); err != nil {
+		return 0, err
+	}
+	// ロック解除後の失敗でロック時間が延びるよう、ロック中は失敗回数を保持する
+	return lockout, l.store.Expire(failPrefix+key, lockout+l.policy.Window)
+}
+
+// lockout 閾値を超えた回数に応じた指数的なロック時間
+func (l *LoginLimiter) lockout(exceeded int64) time.Duration {
+	d := l.policy.BaseLockout
+	for i := int64(0); i < exceeded; i++ {
+		d *= 2
+		if d >= l.policy.MaxLockout {
+			return l.policy.MaxLockout
+		}
+	}
+	return d
+}
+
+func (l *LoginLimiter) keys(account, ip string) []string {
+	keys := []string{accountKey(account)}
+	if ip != "" {
+		keys = append(keys, ipKey(ip))
+	}
+	return keys
+}
+
+func accountKey(account string) string {
+	return "account:" + strings.ToLower(account)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
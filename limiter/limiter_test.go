@@ -0,0 +1,99 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/utils"
+)
+
+var testPolicy = Policy{
+	AccountThreshold: 3,
+	IPThreshold:      5,
+	Window:           10 * time.Minute,
+	BaseLockout:      time.Minute,
+	MaxLockout:       5 * time.Minute,
+}
+
+func newTestLimiter() (*LoginLimiter, *utils.FakeClock) {
+	clock := &utils.FakeClock{Current: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+	return NewLoginLimiter(NewMemoryStore(clock), testPolicy), clock
+}
+
+func failN(t *testing.T, l *LoginLimiter, account, ip string, n int) (accountLock, ipLock time.Duration) {
+	for i := 0; i < n; i++ {
+		var err error
+		accountLock, ipLock, err = l.Fail(account, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+func TestAccountLockout(t *testing.T) {
+	l, clock := newTestLimiter()
+
+	if lock, _ := failN(t, l, "Kitten", "", 2); lock != 0 {
+		t.Fatalf("should not be locked yet: %v", lock)
+	}
+	if lock, _ := failN(t, l, "kitten", "", 1); lock != time.Minute {
+		t.Fatalf("expected lockout %v, actual %v", time.Minute, lock)
+	}
+
+	retryAfter, err := l.Check("KITTEN", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retryAfter != time.Minute {
+		t.Fatalf("expected retry after %v, actual %v", time.Minute, retryAfter)
+	}
+
+	// ロック解除後の失敗でロック時間が倍になる
+	clock.Advance(time.Minute)
+	if retryAfter, _ := l.Check("kitten", ""); retryAfter != 0 {
+		t.Fatalf("lock should be released: %v", retryAfter)
+	}
+	if lock, _ := failN(t, l, "kitten", "", 1); lock != 2*time.Minute {
+		t.Fatalf("expected lockout %v, actual %v", 2*time.Minute, lock)
+	}
+	clock.Advance(2 * time.Minute)
+	failN(t, l, "kitten", "", 1)
+	clock.Advance(4 * time.Minute)
+	if lock, _ := failN(t, l, "kitten", "", 1); lock != testPolicy.MaxLockout {
+		t.Fatalf("lockout should be capped at %v, actual %v", testPolicy.MaxLockout, lock)
+	}
+}
+
+func TestFailuresExpire(t *testing.T) {
+	l, clock := newTestLimiter()
+
+	failN(t, l, "kitten", "", 2)
+	clock.Advance(testPolicy.Window)
+	if lock, _ := failN(t, l, "kitten", "", 2); lock != 0 {
+		t.Fatalf("old failures should be forgotten: %v", lock)
+	}
+}
+
+func TestSuccessResetsAccount(t *testing.T) {
+	l, _ := newTestLimiter()
+
+	failN(t, l, "kitten", "192.0.2.1", 3)
+	if err := l.Success("kitten"); err != nil {
+		t.Fatal(err)
+	}
+	if retryAfter, _ := l.Check("kitten", ""); retryAfter != 0 {
+		t.Fatalf("account should be unlocked: %v", retryAfter)
+	}
+
+	// IPアドレスの失敗回数は残る
+	if _, lock := failN(t, l, "other", "192.0.2.1", 2); lock != time.Minute {
+		t.Fatalf("expected ip lockout %v, actual %v", time.Minute, lock)
+	}
+	if retryAfter, _ := l.Check("someone", "192.0.2.1"); retryAfter != time.Minute {
+		t.Fatalf("ip should be locked for any account: %v", retryAfter)
+	}
+	if retryAfter, _ := l.Check("someone", "192.0.2.2"); retryAfter != 0 {
+		t.Fatalf("other ip should not be locked: %v", retryAfter)
+	}
+}
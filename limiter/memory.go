@@ -0,0 +1,96 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/utils"
+)
+
+type memoryEntry struct {
+	value    int64
+	expireAt time.Time
+}
+
+// MemoryStore メモリ上のStore。テストやRedisを使わない単一プロセスでの利用向け
+type MemoryStore struct {
+	mu      sync.Mutex
+	clock   utils.Clock
+	entries map[string]*memoryEntry
+}
+
+// NewMemoryStore MemoryStoreを生成する
+func NewMemoryStore(clock utils.Clock) *MemoryStore {
+	return &MemoryStore{clock: clock, entries: make(map[string]*memoryEntry)}
+}
+
+// IncrEx keyの値を1増やし、有効期限をttlにする
+func (m *MemoryStore) IncrEx(key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.get(key)
+	if e == nil {
+		e = &memoryEntry{}
+		m.entries[key] = e
+	}
+	e.value++
+	e.expireAt = m.clock.Now().Add(ttl)
+	return e.value, nil
+}
+
+// Expire keyの有効期限を設定する
+func (m *MemoryStore) Expire(key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e := m.get(key); e != nil {
+		e.expireAt = m.clock.Now().Add(ttl)
+	}
+	return nil
+}
+
+// SetEx ttlの間だけ存在するkeyを作成する
+func (m *MemoryStore) SetEx(key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = &memoryEntry{value: 1, expireAt: m.clock.Now().Add(ttl)}
+	return nil
+}
+
+// TTL keyの残り時間を返す
+func (m *MemoryStore) TTL(key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.get(key)
+	if e == nil || e.expireAt.IsZero() {
+		return 0, nil
+	}
+	return e.expireAt.Sub(m.clock.Now()), nil
+}
+
+// Del keyを削除する
+func (m *MemoryStore) Del(keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+// get 期限切れのエントリを取り除いてから返す
+func (m *MemoryStore) get(key string) *memoryEntry {
+	e, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	if !e.expireAt.IsZero() && !m.clock.Now().Before(e.expireAt) {
+		delete(m.entries, key)
+		return nil
+	}
+	return e
+}
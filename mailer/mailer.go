@@ -0,0 +1,132 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/config"
+)
+
+var (
+	// ErrUnknownDriver 設定されたドライバが存在しない
+	ErrUnknownDriver = errors.New("unknown mail driver")
+
+	// ヘッダインジェクション対策
+	headerSanitizer = strings.NewReplacer("\r", "", "\n", "")
+)
+
+// Message 送信するメール
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer メール送信の抽象
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// NewMailer 設定に応じたMailerを生成する
+func NewMailer(conf config.MailConfig) (Mailer, error) {
+	switch conf.Driver {
+	case "smtp":
+		return NewSMTPMailer(conf), nil
+	case "file":
+		return NewFileMailer(conf.Path, conf.From)
+	case "log", "":
+		return NewLogMailer(os.Stdout, conf.From), nil
+	default:
+		return nil, ErrUnknownDriver
+	}
+}
+
+// SMTPMailer SMTPサーバ経由でメールを送信する
+type SMTPMailer struct {
+	conf config.MailConfig
+}
+
+// NewSMTPMailer SMTPMailerを生成する
+func NewSMTPMailer(conf config.MailConfig) *SMTPMailer {
+	return &SMTPMailer{conf: conf}
+}
+
+// Send メールを送信する
+func (s *SMTPMailer) Send(msg Message) error {
+	addr := net.JoinHostPort(s.conf.Host, strconv.Itoa(s.conf.Port))
+
+	var auth smtp.Auth
+	if s.conf.Username != "" {
+		auth = smtp.PlainAuth("", s.conf.Username, s.conf.Password, s.conf.Host)
+	}
+
+	return smtp.SendMail(addr, auth, s.conf.From, []string{msg.To}, Format(s.conf.From, msg, time.Now()))
+}
+
+// LogMailer メールを送信せずWriterに書き出す。送信済みのメールはSentで参照できる
+type LogMailer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+	sent []Message
+}
+
+// NewLogMailer LogMailerを生成する
+func NewLogMailer(w io.Writer, from string) *LogMailer {
+	return &LogMailer{w: w, from: from}
+}
+
+// NewFileMailer pathのファイルに追記するLogMailerを生成する
+func NewFileMailer(path, from string) (*LogMailer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewLogMailer(f, from), nil
+}
+
+// Send メールを書き出す
+func (l *LogMailer) Send(msg Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sent = append(l.sent, msg)
+	_, err := l.w.Write(append(Format(l.from, msg, time.Now()), '\n'))
+	return err
+}
+
+// Sent これまでに送信されたメールを返す
+func (l *LogMailer) Sent() []Message {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sent := make([]Message, len(l.sent))
+	copy(sent, l.sent)
+	return sent
+}
+
+// Format RFC 5322形式のメールを組み立てる
+func Format(from string, msg Message, date time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", headerSanitizer.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerSanitizer.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
@@ -0,0 +1,77 @@
+package mailer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/config"
+)
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf, "noreply@example.com")
+
+	msg := Message{To: "kitten@example.com", Subject: "確認", Body: "https://example.com/verify"}
+	if err := m.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := m.Sent()
+	if len(sent) != 1 || sent[0] != msg {
+		t.Fatalf("unexpected sent messages: %v", sent)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "To: kitten@example.com\r\n") {
+		t.Fatalf("recipient missing: %s", out)
+	}
+	if !strings.Contains(out, "https://example.com/verify") {
+		t.Fatalf("body missing: %s", out)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := NewMailer(config.MailConfig{Driver: "file", Path: filepath.Join(dir, "mail.log"), From: "noreply@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(Message{To: "kitten@example.com", Subject: "subject", Body: "body"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dat, err := ioutil.ReadFile(filepath.Join(dir, "mail.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(dat), "Subject: ") {
+		t.Fatalf("unexpected file content: %s", dat)
+	}
+}
+
+func TestFormatSanitizesHeaders(t *testing.T) {
+	msg := Message{To: "kitten@example.com\r\nBcc: evil@example.com", Subject: "件名", Body: "本文"}
+	out := string(Format("noreply@example.com", msg, time.Unix(0, 0)))
+
+	if strings.Contains(out, "\r\nBcc:") {
+		t.Fatalf("header injection: %s", out)
+	}
+	if !strings.Contains(out, "Subject: =?UTF-8?b?") {
+		t.Fatalf("subject should be encoded: %s", out)
+	}
+}
+
+func TestUnknownDriver(t *testing.T) {
+	if _, err := NewMailer(config.MailConfig{Driver: "pigeon"}); err != ErrUnknownDriver {
+		t.Fatalf("expected ErrUnknownDriver, actual %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package mailer
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrQueueFull 送信待ちのメールが溢れた
+var ErrQueueFull = errors.New("mail queue is full")
+
+// Queue メールをバックグラウンドで順番に送信する
+// 送信の有無で応答時間が変わらないよう、宛先の存在を明かしたくない送信に使う
+type Queue struct {
+	mailer  Mailer
+	msgs    chan Message
+	onError func(Message, error)
+	pending sync.WaitGroup
+}
+
+// NewQueue 最大size通を溜められるQueueを生成し、送信を開始する
+// 送信に失敗したメールはonError(nilの場合は破棄)に渡す
+func NewQueue(m Mailer, size int, onError func(Message, error)) *Queue {
+	q := &Queue{
+		mailer:  m,
+		msgs:    make(chan Message, size),
+		onError: onError,
+	}
+	go q.run()
+	return q
+}
+
+// Send 送信待ちに追加する。溢れた場合はErrQueueFullを返す
+func (q *Queue) Send(msg Message) error {
+	q.pending.Add(1)
+	select {
+	case q.msgs <- msg:
+		return nil
+	default:
+		q.pending.Done()
+		return ErrQueueFull
+	}
+}
+
+// Flush 送信待ちのメールを全て送信し終えるまで待つ
+func (q *Queue) Flush() {
+	q.pending.Wait()
+}
+
+func (q *Queue) run() {
+	for msg := range q.msgs {
+		if err := q.mailer.Send(msg); err != nil && q.onError != nil {
+			q.onError(msg, err)
+		}
+		q.pending.Done()
+	}
+}
@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+// gateMailer releaseが閉じられるまで送信を止める
+type gateMailer struct {
+	release chan struct{}
+	sent    []Message
+}
+
+func (g *gateMailer) Send(msg Message) error {
+	<-g.release
+	if msg.To == "" {
+		return errors.New("no recipient")
+	}
+	g.sent = append(g.sent, msg)
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	g := &gateMailer{release: make(chan struct{})}
+	failed := []Message{}
+	q := NewQueue(g, 1, func(msg Message, err error) { failed = append(failed, msg) })
+
+	// 送信が止まっている間に送信待ちが埋まる
+	var err error
+	queued := 0
+	for i := 0; i < 3 && err == nil; i++ {
+		if err = q.Send(Message{To: "queued@example.com"}); err == nil {
+			queued++
+		}
+	}
+	if err != ErrQueueFull {
+		t.Fatalf("expected queue full, actual %v", err)
+	}
+
+	close(g.release)
+	q.Flush()
+	if len(g.sent) != queued {
+		t.Fatalf("unexpected sent messages: %v", g.sent)
+	}
+
+	// 送信に失敗したメールはonErrorに渡す
+	if err := q.Send(Message{}); err != nil {
+		t.Fatal(err)
+	}
+	q.Flush()
+	if len(failed) != 1 {
+		t.Fatalf("failed message should be reported: %v", failed)
+	}
+}
+
+func TestQueueDelivers(t *testing.T) {
+	m := NewLogMailer(ioutil.Discard, "noreply@example.com")
+	q := NewQueue(m, 4, nil)
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		if err := q.Send(Message{To: to}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Flush()
+	if len(m.Sent()) != 2 {
+		t.Fatalf("unexpected sent messages: %v", m.Sent())
+	}
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// AuditAction 監査ログに記録する操作の種類
+type AuditAction string
+
+const (
+	// AuditSuspend アカウントの凍結
+	AuditSuspend AuditAction = "suspend"
+	// AuditSetOfficial 公式マークの変更
+	AuditSetOfficial AuditAction = "set_official"
+	// AuditDeleteUser ユーザの即時削除
+	AuditDeleteUser AuditAction = "delete_user"
+	// AuditSetRoles 管理権限の変更
+	AuditSetRoles AuditAction = "set_roles"
+	// AuditDeletePost ポストの削除
+	AuditDeletePost AuditAction = "delete_post"
+	// AuditUnsuspend アカウントの凍結解除
+	AuditUnsuspend AuditAction = "unsuspend"
+	// AuditWarn ユーザへの警告
+	AuditWarn AuditAction = "warn"
+	// AuditClaimReport 通報への対応開始
+	AuditClaimReport AuditAction = "claim_report"
+	// AuditResolveReport 通報への対処
+	AuditResolveReport AuditAction = "resolve_report"
+	// AuditDismissReport 通報の却下
+	AuditDismissReport AuditAction = "dismiss_report"
+	// AuditApproveOfficial 公式マーク申請の承認
+	AuditApproveOfficial AuditAction = "approve_official"
+	// AuditRejectOfficial 公式マーク申請の却下
+	AuditRejectOfficial AuditAction = "reject_official"
+	// AuditDenyHashtag ハッシュタグのトレンドからの除外
+	AuditDenyHashtag AuditAction = "deny_hashtag"
+	// AuditAllowHashtag ハッシュタグの除外の取り消し
+	AuditAllowHashtag AuditAction = "allow_hashtag"
+)
+
+// AuditLog 管理者・モデレーターの操作記録。追記のみで更新・削除はしない
+type AuditLog struct {
+	// ID 識別用ID
+	ID bson.ObjectId `bson:"_id" json:"id"`
+	// ActorID 操作したユーザのID
+	ActorID bson.ObjectId `bson:"actor_id" json:"actor_id"`
+	// ActorName 操作時の操作者のスクリーンネーム
+	ActorName string `bson:"actor_name" json:"actor_name"`
+	// TargetID 操作対象のID
+	TargetID bson.ObjectId `bson:"target_id,omitempty" json:"target_id,omitempty"`
+	// TargetName 操作時の対象のスクリーンネーム(対象が削除されても残すため)
+	TargetName string `bson:"target_name,omitempty" json:"target_name,omitempty"`
+	// Action 操作の種類
+	Action AuditAction `bson:"action" json:"action"`
+	// Reason 操作者が入力した理由
+	Reason string `bson:"reason" json:"reason"`
+	// Before 操作前の値
+	Before bson.M `bson:"before,omitempty" json:"before,omitempty"`
+	// After 操作後の値
+	After bson.M `bson:"after,omitempty" json:"after,omitempty"`
+	// CreatedAt 操作日時
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// AuditFilter 監査ログの検索条件。ゼロ値の項目は条件に含めない
+type AuditFilter struct {
+	ActorID  bson.ObjectId
+	TargetID bson.ObjectId
+	Action   AuditAction
+	Since    time.Time
+	Until    time.Time
+}
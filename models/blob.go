@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// Blob 内容のハッシュをキーにして保存したファイルと、その参照数
+// 同じ内容のファイルは一度だけ保存し、プロフィール画像やメディアから参照する
+type Blob struct {
+	// Key 元の画像のキー(<sha256>.<拡張子>)
+	Key string `bson:"_id" json:"key"`
+	// Files 縮小版を含む、保存先に書き出した全てのキー
+	Files []string `bson:"files" json:"files"`
+	// Size Filesの合計バイト数
+	Size int64 `bson:"size" json:"size"`
+	// Refs 参照しているプロフィール画像・メディアの数。0になったものは掃除で削除する
+	Refs int `bson:"refs" json:"refs"`
+	// Deleting 掃除でファイルを削除している途中か。この間は新しく参照できない
+	Deleting bool `bson:"deleting,omitempty" json:"deleting"`
+	// DeletingAt 削除中にした日時。掃除が途中で止まった記録を見つけるのに使う
+	DeletingAt time.Time `bson:"deleting_at,omitempty" json:"deleting_at,omitempty"`
+	// CreatedAt 初めて保存した日時
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Bookmark ポストのブックマーク。本人にだけ見え、投稿者には通知しない
+type Bookmark struct {
+	// ID 識別用ID
+	ID bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	// UserID ブックマークしたユーザのID
+	UserID bson.ObjectId `bson:"user_id" json:"user_id"`
+	// PostID ブックマークしたポストのID
+	PostID bson.ObjectId `bson:"post_id" json:"post_id"`
+	// CreatedAt ブックマークした日時
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
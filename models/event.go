@@ -22,6 +22,18 @@ const (
 	SharedEvent
 	// ReceivedReplyEvent ポストに返信された
 	ReceivedReplyEvent
+	// LoginFailedEvent ログインの失敗が続きアカウントがロックされた
+	LoginFailedEvent
+	// WarnedEvent モデレーターから警告を受けた
+	WarnedEvent
+	// ReportResolvedEvent 通報が対処された
+	ReportResolvedEvent
+	// ReportDismissedEvent 通報が対処不要として却下された
+	ReportDismissedEvent
+	// OfficialApprovedEvent 公式マークの申請が承認された
+	OfficialApprovedEvent
+	// OfficialRejectedEvent 公式マークの申請が却下された
+	OfficialRejectedEvent
 )
 
 // Event イベント
@@ -40,4 +52,8 @@ type Event struct {
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 	// TargetPostID イベント対象のポストID
 	TargetPostID bson.ObjectId `bson:"post_id,omitempty" json:"post_id"`
+	// ReportID イベント対象の通報ID
+	ReportID bson.ObjectId `bson:"report_id,omitempty" json:"report_id,omitempty"`
+	// Message 通知先に表示する文面(警告の理由など)
+	Message string `bson:"message,omitempty" json:"message,omitempty"`
 }
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ExportStatus データエクスポートの状態
+type ExportStatus string
+
+const (
+	// ExportRunning アーカイブを作成中
+	ExportRunning ExportStatus = "running"
+	// ExportDone ダウンロード可能
+	ExportDone ExportStatus = "done"
+	// ExportFailed 作成に失敗した
+	ExportFailed ExportStatus = "failed"
+)
+
+// ExportJob データエクスポートのジョブ
+type ExportJob struct {
+	// ID 識別用ID
+	ID bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	// UserID エクスポートを要求したユーザのID
+	UserID bson.ObjectId `bson:"user_id" json:"user_id"`
+	// Status 状態
+	Status ExportStatus `bson:"status" json:"status"`
+	// Lock 実行中のみユーザIDを入れ、一意インデックスで同時実行を防ぐ
+	Lock string `bson:"lock,omitempty" json:"-"`
+	// Path 作成したアーカイブのパス
+	Path string `bson:"path,omitempty" json:"-"`
+	// CreatedAt 要求された日時
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	// CompletedAt 完了した日時
+	CompletedAt time.Time `bson:"completed_at,omitempty" json:"completed_at"`
+	// ExpiresAt ダウンロードできなくなる日時
+	ExpiresAt time.Time `bson:"expires_at,omitempty" json:"expires_at"`
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ListMode リストの公開範囲
+type ListMode string
+
+const (
+	// ListPublic 誰でも閲覧・購読できる
+	ListPublic ListMode = "public"
+	// ListPrivate 作成者だけが閲覧できる
+	ListPrivate ListMode = "private"
+)
+
+// Valid 定義済みの公開範囲か
+func (m ListMode) Valid() bool {
+	return m == ListPublic || m == ListPrivate
+}
+
+// List ユーザが作成したリスト。メンバーの投稿をタイムラインとして閲覧できる
+type List struct {
+	// ID 識別用ID
+	ID bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	// OwnerID 作成したユーザのID
+	OwnerID bson.ObjectId `bson:"owner_id" json:"owner_id"`
+	// Name リスト名
+	Name string `bson:"name" json:"name"`
+	// Description 説明
+	Description string `bson:"description" json:"description"`
+	// Mode 公開範囲
+	Mode ListMode `bson:"mode" json:"mode"`
+	// Members メンバーのID
+	Members []bson.ObjectId `bson:"members" json:"members"`
+	// Subscribers 購読しているユーザのID
+	Subscribers []bson.ObjectId `bson:"subscribers" json:"subscribers"`
+	// CreatedAt 作成日時
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	// UpdatedAt 更新日時
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// NewList メンバーのいないリストを作成する
+func NewList(ownerID bson.ObjectId, name, description string, mode ListMode, now time.Time) *List {
+	return &List{
+		ID:          bson.NewObjectId(),
+		OwnerID:     ownerID,
+		Name:        name,
+		Description: description,
+		Mode:        mode,
+		Members:     []bson.ObjectId{},
+		Subscribers: []bson.ObjectId{},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// VisibleTo 閲覧できるか。非公開のリストは作成者だけが閲覧できる
+func (l *List) VisibleTo(viewerID bson.ObjectId) bool {
+	return l.Mode != ListPrivate || l.OwnerID == viewerID
+}
+
+// HasMember メンバーに含まれるか
+func (l *List) HasMember(userID bson.ObjectId) bool {
+	return containsID(l.Members, userID)
+}
+
+// HasSubscriber 購読しているか
+func (l *List) HasSubscriber(userID bson.ObjectId) bool {
+	return containsID(l.Subscribers, userID)
+}
+
+func containsID(ids []bson.ObjectId, id bson.ObjectId) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// ListResponse API用リスト構造体。メンバーと購読者は人数だけを返す
+type ListResponse struct {
+	ID              bson.ObjectId `json:"id"`
+	Name            string        `json:"name"`
+	Description     string        `json:"description"`
+	Mode            ListMode      `json:"mode"`
+	User            UserResponse  `json:"user"` // 作成者
+	MemberCount     int           `json:"member_count"`
+	SubscriberCount int           `json:"subscriber_count"`
+	Following       bool          `json:"following"` // 閲覧者が購読しているか
+	CreatedAt       time.Time     `json:"created_at"`
+}
+
+// ListToListResponse 閲覧者から見たAPI用リスト構造体に変換する
+func ListToListResponse(l List, owner User, viewerID bson.ObjectId) ListResponse {
+	return ListResponse{
+		ID:              l.ID,
+		Name:            l.Name,
+		Description:     l.Description,
+		Mode:            l.Mode,
+		User:            UserToUserResponseFor(owner, viewerID),
+		MemberCount:     len(l.Members),
+		SubscriberCount: len(l.Subscribers),
+		Following:       l.HasSubscriber(viewerID),
+		CreatedAt:       l.CreatedAt,
+	}
+}
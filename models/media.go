@@ -0,0 +1,99 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MediaType 添付メディアの種類
+type MediaType string
+
+const (
+	// MediaPhoto 静止画
+	MediaPhoto MediaType = "photo"
+)
+
+// Media アップロードされたメディア
+type Media struct {
+	// ID 識別用ID(media_id)
+	ID bson.ObjectId `bson:"_id" json:"media_id"`
+	// UserID アップロードしたユーザのID
+	UserID bson.ObjectId `bson:"user_id" json:"user_id"`
+	// PostID 添付されたポストのID。未添付の場合は空
+	PostID bson.ObjectId `bson:"post_id,omitempty" json:"post_id,omitempty"`
+	// Type メディアの種類
+	Type MediaType `bson:"type" json:"type"`
+	// ContentType MIMEタイプ
+	ContentType string `bson:"content_type" json:"content_type"`
+	// Key 保存先(storage.Storage)のキー
+	Key string `bson:"key" json:"-"`
+	// URL 公開URL
+	URL string `bson:"url" json:"url"`
+	// Width 幅(px)
+	Width int `bson:"width" json:"width"`
+	// Height 高さ(px)
+	Height int `bson:"height" json:"height"`
+	// Size バイト数
+	Size int64 `bson:"size" json:"size"`
+	// AltText 代替テキスト
+	AltText string `bson:"alt_text" json:"alt_text"`
+	// Animated アニメーションGIFか
+	Animated bool `bson:"animated" json:"animated"`
+	// Variants 縮小版(imaging.MediaVariants)
+	Variants []MediaVariant `bson:"variants" json:"variants"`
+	// CreatedAt アップロードした日時
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// MediaVariant メディアの縮小版
+type MediaVariant struct {
+	Name   string `bson:"name" json:"name"` // thumb, small
+	Key    string `bson:"key,omitempty" json:"-"`
+	URL    string `bson:"url" json:"url"`
+	Width  int    `bson:"width" json:"width"`
+	Height int    `bson:"height" json:"height"`
+}
+
+// Keys 元の画像と縮小版の保存先のキーを返す
+func (m Media) Keys() []string {
+	keys := []string{m.Key}
+	for _, v := range m.Variants {
+		keys = append(keys, v.Key)
+	}
+	return keys
+}
+
+// MediaEntity ポストに埋め込むメディアの情報
+type MediaEntity struct {
+	ID      bson.ObjectId `bson:"id" json:"id"`
+	Type    MediaType     `bson:"type" json:"type"`
+	URL     string        `bson:"url" json:"url"`
+	Width   int           `bson:"width" json:"width"`
+	Height  int           `bson:"height" json:"height"`
+	AltText string        `bson:"alt_text" json:"alt_text"`
+
+	Animated bool           `bson:"animated,omitempty" json:"animated"`
+	Variants []MediaVariant `bson:"variants,omitempty" json:"variants"`
+}
+
+// Entity ポストに埋め込む形式に変換する
+func (m Media) Entity() MediaEntity {
+	variants := make([]MediaVariant, len(m.Variants))
+	for i, v := range m.Variants {
+		// 保存先のキーはポストに埋め込まない
+		v.Key = ""
+		variants[i] = v
+	}
+	return MediaEntity{
+		ID:      m.ID,
+		Type:    m.Type,
+		URL:     m.URL,
+		Width:   m.Width,
+		Height:  m.Height,
+		AltText: m.AltText,
+
+		Animated: m.Animated,
+		Variants: variants,
+	}
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// OfficialStatus 公式マーク申請の審査状況
+type OfficialStatus string
+
+const (
+	// OfficialPending 審査待ち
+	OfficialPending OfficialStatus = "pending"
+	// OfficialApproved 承認済み
+	OfficialApproved OfficialStatus = "approved"
+	// OfficialRejected 却下
+	OfficialRejected OfficialStatus = "rejected"
+)
+
+// OfficialApplication 公式マークの申請
+type OfficialApplication struct {
+	// ID 識別用ID
+	ID bson.ObjectId `bson:"_id" json:"id"`
+	// UserID 申請したユーザのID
+	UserID bson.ObjectId `bson:"user_id" json:"user_id"`
+	// Lock 審査待ちの間のみユーザIDを入れ、一意インデックスで重複申請を防ぐ
+	Lock string `bson:"lock,omitempty" json:"-"`
+	// Name 公式として表示する組織・個人の名前
+	Name string `bson:"name" json:"name"`
+	// Category 組織・個人の種類(企業、報道機関、著名人など)
+	Category string `bson:"category" json:"category"`
+	// Details 申請理由や本人確認のための説明
+	Details string `bson:"details" json:"details"`
+	// Links 本人確認のためのURL(公式サイトなど)
+	Links []string `bson:"links" json:"links"`
+	// Status 審査状況
+	Status OfficialStatus `bson:"status" json:"status"`
+	// ReviewedBy 審査した管理者のID
+	ReviewedBy bson.ObjectId `bson:"reviewed_by,omitempty" json:"reviewed_by,omitempty"`
+	// ReviewedAt 審査した日時
+	ReviewedAt time.Time `bson:"reviewed_at,omitempty" json:"reviewed_at,omitempty"`
+	// ReviewNote 申請者に伝える審査結果のコメント
+	ReviewNote string `bson:"review_note,omitempty" json:"review_note,omitempty"`
+	// CreatedAt 申請日時
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BirthdayVisibility 誕生日を公開する範囲
+type BirthdayVisibility string
+
+const (
+	// BirthdayPublic 全員に公開する
+	BirthdayPublic BirthdayVisibility = "public"
+	// BirthdayFollowers フォロワーにだけ公開する
+	BirthdayFollowers BirthdayVisibility = "followers"
+	// BirthdayPrivate 本人にだけ表示する
+	BirthdayPrivate BirthdayVisibility = "private"
+)
+
+// Valid 定義済みの公開範囲か
+func (v BirthdayVisibility) Valid() bool {
+	switch v {
+	case BirthdayPublic, BirthdayFollowers, BirthdayPrivate:
+		return true
+	}
+	return false
+}
+
+// visibleTo 閲覧者が公開範囲に含まれるか
+func (v BirthdayVisibility) visibleTo(self, follower bool) bool {
+	switch v {
+	case BirthdayPublic:
+		return true
+	case BirthdayFollowers:
+		return self || follower
+	default:
+		return self
+	}
+}
+
+// Birthday 誕生日と公開範囲。月日と年の公開範囲は別々に設定する
+type Birthday struct {
+	Year           int                `json:"year" bson:"year"` // 0は年を設定しない
+	Month          int                `json:"month" bson:"month"`
+	Day            int                `json:"day" bson:"day"`
+	Visibility     BirthdayVisibility `json:"visibility" bson:"visibility"`          // 月日の公開範囲
+	YearVisibility BirthdayVisibility `json:"year_visibility" bson:"yearVisibility"` // 年の公開範囲
+}
+
+// BirthdayResponse 閲覧者に見える範囲の誕生日
+type BirthdayResponse struct {
+	Year  int `json:"year,omitempty"`
+	Month int `json:"month,omitempty"`
+	Day   int `json:"day,omitempty"`
+}
+
+// For 閲覧者に見える範囲の誕生日を返す。何も見えない場合はnil
+func (b *Birthday) For(self, follower bool) *BirthdayResponse {
+	if b == nil {
+		return nil
+	}
+	resp := &BirthdayResponse{}
+	if b.Visibility.visibleTo(self, follower) {
+		resp.Month, resp.Day = b.Month, b.Day
+	}
+	if b.YearVisibility.visibleTo(self, follower) {
+		resp.Year = b.Year
+	}
+	if *resp == (BirthdayResponse{}) {
+		return nil
+	}
+	return resp
+}
+
+// ProfileField プロフィールに表示する任意の項目(名前と値の組)
+type ProfileField struct {
+	Name  string `json:"name" bson:"name"`
+	Value string `json:"value" bson:"value"`
+}
+
+// UserToUserResponseFor 閲覧者との関係に応じた公開範囲でAPI用ユーザ構造体に変換する
+func UserToUserResponseFor(user User, viewerID bson.ObjectId) UserResponse {
+	resp := UserToUserResponse(user)
+	follower := false
+	for _, id := range user.Followers {
+		if id == viewerID {
+			follower = true
+			break
+		}
+	}
+	resp.Birthday = user.Birthday.For(user.ID == viewerID, follower)
+	return resp
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ReportReason 通報理由の分類
+type ReportReason string
+
+const (
+	// ReportSpam スパム
+	ReportSpam ReportReason = "spam"
+	// ReportHarassment 嫌がらせ
+	ReportHarassment ReportReason = "harassment"
+	// ReportHate 差別・ヘイト
+	ReportHate ReportReason = "hate"
+	// ReportViolence 暴力的な内容
+	ReportViolence ReportReason = "violence"
+	// ReportSexual 性的な内容
+	ReportSexual ReportReason = "sexual"
+	// ReportImpersonation なりすまし
+	ReportImpersonation ReportReason = "impersonation"
+	// ReportOther その他
+	ReportOther ReportReason = "other"
+)
+
+// ReportReasons 定義済みの通報理由
+var ReportReasons = []ReportReason{
+	ReportSpam, ReportHarassment, ReportHate, ReportViolence, ReportSexual, ReportImpersonation, ReportOther,
+}
+
+// Valid 定義済みの通報理由か
+func (r ReportReason) Valid() bool {
+	for _, reason := range ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// ReportStatus 通報の処理状況
+type ReportStatus string
+
+const (
+	// ReportOpen 未対応
+	ReportOpen ReportStatus = "open"
+	// ReportClaimed モデレーターが対応中
+	ReportClaimed ReportStatus = "claimed"
+	// ReportResolved 対処済み
+	ReportResolved ReportStatus = "resolved"
+	// ReportDismissed 対処不要として却下
+	ReportDismissed ReportStatus = "dismissed"
+)
+
+// ReportAction 通報の対処として行う操作
+type ReportAction string
+
+const (
+	// ReportDeletePost 通報されたポストを削除する
+	ReportDeletePost ReportAction = "delete_post"
+	// ReportSuspendUser 通報されたユーザを凍結する
+	ReportSuspendUser ReportAction = "suspend"
+	// ReportWarnUser 通報されたユーザに警告を通知する
+	ReportWarnUser ReportAction = "warn"
+)
+
+// Valid 定義済みの対処か
+func (a ReportAction) Valid() bool {
+	switch a {
+	case ReportDeletePost, ReportSuspendUser, ReportWarnUser:
+		return true
+	}
+	return false
+}
+
+// Report ユーザまたはポストへの通報
+type Report struct {
+	// ID 識別用ID
+	ID bson.ObjectId `bson:"_id" json:"id"`
+	// ReporterID 通報したユーザのID
+	ReporterID bson.ObjectId `bson:"reporter_id" json:"reporter_id"`
+	// TargetUserID 通報されたユーザのID
+	TargetUserID bson.ObjectId `bson:"target_user_id" json:"target_user_id"`
+	// TargetPostID 通報されたポストのID(アカウントへの通報では空)
+	TargetPostID bson.ObjectId `bson:"target_post_id,omitempty" json:"target_post_id,omitempty"`
+	// Reason 通報理由の分類
+	Reason ReportReason `bson:"reason" json:"reason"`
+	// Comment 通報者のコメント
+	Comment string `bson:"comment" json:"comment"`
+	// Status 処理状況
+	Status ReportStatus `bson:"status" json:"status"`
+	// ClaimedBy 対応中のモデレーターのID
+	ClaimedBy bson.ObjectId `bson:"claimed_by,omitempty" json:"claimed_by,omitempty"`
+	// ClaimedAt 対応を始めた日時
+	ClaimedAt time.Time `bson:"claimed_at,omitempty" json:"claimed_at,omitempty"`
+	// ClosedBy 対処・却下したモデレーターのID
+	ClosedBy bson.ObjectId `bson:"closed_by,omitempty" json:"closed_by,omitempty"`
+	// ClosedAt 対処・却下した日時
+	ClosedAt time.Time `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+	// Actions 行った対処
+	Actions []ReportAction `bson:"actions,omitempty" json:"actions,omitempty"`
+	// Note モデレーターのメモ
+	Note string `bson:"note,omitempty" json:"note,omitempty"`
+	// CreatedAt 通報日時
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// NewReport 未対応の通報を作る
+func NewReport(reporterID, targetUserID, targetPostID bson.ObjectId, reason ReportReason, comment string, now time.Time) *Report {
+	return &Report{
+		ID:           bson.NewObjectId(),
+		ReporterID:   reporterID,
+		TargetUserID: targetUserID,
+		TargetPostID: targetPostID,
+		Reason:       reason,
+		Comment:      comment,
+		Status:       ReportOpen,
+		CreatedAt:    now,
+	}
+}
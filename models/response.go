@@ -22,6 +22,31 @@ type UserResponse struct {
 	Official    bool            `json:"official"`          // 公式
 	Description string          `json:"description"`
 	jwt.StandardClaims
+
+	BannerURL     string            `json:"profile_banner_url,omitempty"` // ヘッダー画像
+	Birthday      *BirthdayResponse `json:"birthday,omitempty"`           // 閲覧者に公開されている誕生日
+	PinnedPostID  bson.ObjectId     `json:"pinned_post_id,omitempty"`     // プロフィールに固定したポスト
+	ProfileFields []ProfileField    `json:"profile_fields,omitempty"`     // プロフィールの任意の項目
+
+	Moderation *ModerationResponse `json:"moderation,omitempty"` // 管理者向けの情報(管理APIのみ)
+}
+
+// ModerationResponse 管理者向けのユーザの状態
+type ModerationResponse struct {
+	Suspended        bool       `json:"suspended"`                   // 凍結中か(期限切れの凍結は含まない)
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty"`      // 凍結した日時
+	SuspendedUntil   *time.Time `json:"suspended_until,omitempty"`   // 凍結の期限(無期限の場合は省略)
+	SuspensionReason string     `json:"suspension_reason,omitempty"` // 凍結の理由
+	WarningCount     int        `json:"warning_count"`               // 警告を受けた回数
+	Deactivated      bool       `json:"deactivated"`                 // 退会手続き中か
+	Roles            []Role     `json:"roles"`                       // 管理権限
+}
+
+// SuspendedResponse 凍結中のアカウントでログインしたときのレスポンス
+type SuspendedResponse struct {
+	Message string     `json:"message"`
+	Reason  string     `json:"reason"`          // 凍結の理由
+	Until   *time.Time `json:"until,omitempty"` // 凍結の期限(無期限の場合は省略)
 }
 
 // LoginSuccessResponse POST /auth が成功したときのレスポンス
@@ -39,6 +64,12 @@ type LoginSuccessResponse struct {
 	SessionToken string          `json:"session_token"`     // JWTセッショントークン(RS256_JWT_TOKEN)
 }
 
+// TwoFactorChallengeResponse 二段階認証が必要なアカウントでPOST /auth が成功したときのレスポンス
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"` // 常にtrue
+	ChallengeToken    string `json:"challenge_token"`     // account/login_2fa.json に渡す短命トークン
+}
+
 // ErrorResponse リクエストの処理中にエラーが発生したときのレスポンス
 type ErrorResponse struct {
 	Error string `json:"error"`
@@ -56,6 +87,7 @@ type PostResponse struct {
 	Text            string          `json:"text"`
 	Shared          []bson.ObjectId `json:"shared"`
 	User            UserResponse    `json:"user"`
+	Media           []MediaEntity   `json:"media"`
 }
 
 func PostToPostResponse(post Post, user User) PostResponse {
@@ -70,6 +102,7 @@ func PostToPostResponse(post Post, user User) PostResponse {
 		Text:            post.Text,
 		Shared:          post.Shared,
 		User:            UserToUserResponse(user),
+		Media:           post.Media,
 	}
 }
 
@@ -103,7 +136,37 @@ func UserToUserResponse(user User) UserResponse {
 		AvatarURL:   user.AvatarURL,
 		Official:    user.Official,
 		Description: user.Description,
+
+		BannerURL:     user.BannerURL,
+		Birthday:      user.Birthday.For(false, false),
+		PinnedPostID:  user.PinnedPostID,
+		ProfileFields: user.ProfileFields,
+	}
+}
+
+// UserToAdminUserResponse Userを管理者向けの情報を含むAPI用ユーザ構造体に変換する
+func UserToAdminUserResponse(user User, now time.Time) UserResponse {
+	resp := UserToUserResponse(user)
+	m := &ModerationResponse{
+		Suspended:    user.IsSuspended(now),
+		WarningCount: user.WarningCount,
+		Deactivated:  user.Deactivated,
+		Roles:        user.Roles,
+	}
+	if m.Suspended {
+		m.SuspensionReason = user.SuspensionReason
+		if !user.SuspendedAt.IsZero() {
+			m.SuspendedAt = &user.SuspendedAt
+		}
+		if !user.SuspendedUntil.IsZero() {
+			m.SuspendedUntil = &user.SuspendedUntil
+		}
+	}
+	if m.Roles == nil {
+		m.Roles = []Role{}
 	}
+	resp.Moderation = m
+	return resp
 }
 
 func UserToLoginSucessResponse(user User, token string) LoginSuccessResponse {
@@ -0,0 +1,41 @@
+package models
+
+// Role 管理権限の種類
+type Role string
+
+const (
+	// RoleAdmin 全ての管理操作ができる
+	RoleAdmin Role = "admin"
+	// RoleModerator 凍結や通報の処理ができる
+	RoleModerator Role = "moderator"
+	// RoleSupport 管理画面の閲覧ができる
+	RoleSupport Role = "support"
+)
+
+// Roles 定義済みのロール
+var Roles = []Role{RoleAdmin, RoleModerator, RoleSupport}
+
+// Valid 定義済みのロールか
+func (r Role) Valid() bool {
+	for _, role := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole いずれかのロールを持つか。adminは全てのロールを持つものとして扱う
+func (u User) HasRole(roles ...Role) bool {
+	for _, have := range u.Roles {
+		if have == RoleAdmin {
+			return true
+		}
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
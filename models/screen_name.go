@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ScreenNameHistory スクリーンネームの変更履歴
+type ScreenNameHistory struct {
+	// ID 識別用ID
+	ID bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	// UserID 変更したユーザのID
+	UserID bson.ObjectId `bson:"user_id" json:"user_id"`
+	// OldName 変更前のスクリーンネーム
+	OldName string `bson:"old_name" json:"old_name"`
+	// OldNameLower 小文字化した変更前のスクリーンネーム(検索用)
+	OldNameLower string `bson:"old_name_lower" json:"-"`
+	// NewName 変更後のスクリーンネーム
+	NewName string `bson:"new_name" json:"new_name"`
+	// ChangedAt 変更日時
+	ChangedAt time.Time `bson:"changed_at" json:"changed_at"`
+}
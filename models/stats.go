@@ -0,0 +1,24 @@
+package models
+
+import "gopkg.in/mgo.v2/bson"
+
+// StatsTotals 各コレクションの総数
+type StatsTotals struct {
+	Users   int `json:"users"`
+	Posts   int `json:"posts"`
+	Likes   int `json:"likes"`
+	Follows int `json:"follows"`
+}
+
+// DailyCount 日ごとの件数
+type DailyCount struct {
+	Date  string `bson:"_id" json:"date"` // UTCの日付(2006-01-02)
+	Count int    `bson:"count" json:"count"`
+}
+
+// PosterCount ユーザごとの投稿数
+type PosterCount struct {
+	UserID     bson.ObjectId `bson:"_id" json:"user_id"`
+	ScreenName string        `bson:"-" json:"screen_name"`
+	Count      int           `bson:"count" json:"count"`
+}
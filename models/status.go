@@ -3,6 +3,7 @@ package models
 import (
 	"time"
 
+	"github.com/TinyKitten/TimelineServer/search"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -10,7 +11,7 @@ import (
 type Post struct {
 	FavoritedIds    []bson.ObjectId `bson:"favoritedIds" json:"favorited_ids"`
 	CreatedAt       time.Time       `bson:"createdAt" json:"created_at"`
-	ID          	bson.ObjectId   `json:"id" bson:"_id,omitempty"`   // BSON ObjectID
+	ID              bson.ObjectId   `json:"id" bson:"_id,omitempty"` // BSON ObjectID
 	MentionsID      []bson.ObjectId `bson:"mentionsId" json:"mentions_id"`
 	URLs            []string        `bson:"urls" json:"urls"`
 	Hashtags        []string        `bson:"hashtags" json:"hashtags"`
@@ -18,6 +19,11 @@ type Post struct {
 	Text            string          `bson:"text" json:"text"`
 	Shared          []bson.ObjectId `bson:"shared" json:"shared"`
 	UserID          bson.ObjectId   `bson:"user_id" json:"user_id"`
+
+	Tokens     []string `bson:"tokens,omitempty" json:"-"`     // 全文検索用のトークン(search.Tokenize)
+	SearchText string   `bson:"searchText,omitempty" json:"-"` // 全文検索用に正規化した本文(search.Normalize)
+
+	Media []MediaEntity `bson:"media,omitempty" json:"media"` // 添付メディア(最大4件)
 }
 
 type PostEntity struct {
@@ -26,12 +32,23 @@ type PostEntity struct {
 	UserMentions []Post   `json:"user_mentions"`
 }
 
+// NewPost 本文からハッシュタグ・URL・検索用のインデックスを設定したPost構造体を返す
 func NewPost(uid, inReplyToStatusID bson.ObjectId, text string) *Post {
-	return &Post{
+	p := &Post{
 		UserID:          uid,
 		ID:              bson.NewObjectId(),
 		Text:            text,
 		CreatedAt:       time.Now(),
 		InReplyToUserID: inReplyToStatusID,
 	}
+	p.Index()
+	return p
+}
+
+// Index 本文からハッシュタグ・URL・検索用のインデックスを設定する
+func (p *Post) Index() {
+	p.Hashtags = search.ExtractHashtags(p.Text)
+	p.URLs = search.ExtractURLs(p.Text)
+	p.Tokens = search.Tokenize(p.Text)
+	p.SearchText = search.Normalize(p.Text)
 }
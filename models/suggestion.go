@@ -0,0 +1,29 @@
+package models
+
+import "gopkg.in/mgo.v2/bson"
+
+// SuggestionReason おすすめした主な理由
+type SuggestionReason string
+
+const (
+	// SuggestFollowedByFriends フォローしているユーザにフォローされている
+	SuggestFollowedByFriends SuggestionReason = "followed_by_friends"
+	// SuggestFollowsYou 自分をフォローしている
+	SuggestFollowsYou SuggestionReason = "follows_you"
+	// SuggestOfficial 公式アカウント
+	SuggestOfficial SuggestionReason = "official"
+	// SuggestPopular フォロワーが多い
+	SuggestPopular SuggestionReason = "popular"
+)
+
+// Suggestion フォローをおすすめするユーザ
+type Suggestion struct {
+	// UserID おすすめするユーザのID
+	UserID bson.ObjectId `json:"user_id"`
+	// Score 並び替えに使うスコア
+	Score float64 `json:"score"`
+	// Reason おすすめした主な理由
+	Reason SuggestionReason `json:"reason"`
+	// MutualCount このユーザをフォローしている、自分がフォローしているユーザの数
+	MutualCount int `json:"mutual_count"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DeniedHashtag トレンドに表示しないハッシュタグ
+type DeniedHashtag struct {
+	// Tag #を除いた正規化済みのハッシュタグ
+	Tag string `bson:"_id" json:"tag"`
+	// Reason 表示しない理由
+	Reason string `bson:"reason" json:"reason"`
+	// CreatedBy 追加した管理者のID
+	CreatedBy bson.ObjectId `bson:"created_by" json:"created_by"`
+	// CreatedAt 追加した日時
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
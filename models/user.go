@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"gopkg.in/mgo.v2/bson"
@@ -24,6 +25,39 @@ type User struct {
 	CreatedDate time.Time       `json:"created_at" bson:"createdDate"`      // ユーザ登録日時
 	UpdatedDate time.Time       `json:"updated_at" bson:"updatedDate"`      // 最終更新日
 	Official    bool            `json:"official" bson:"official"`           // 公式
+
+	AvatarKey string `json:"-" bson:"avatarKey,omitempty"` // プロフィール画像の保存先のキー(重複排除したファイルへの参照)
+
+	BannerURL string `json:"profile_banner_url" bson:"bannerUrl,omitempty"` // ヘッダー画像
+	BannerKey string `json:"-" bson:"bannerKey,omitempty"`                  // ヘッダー画像の保存先のキー
+
+	Birthday      *Birthday      `json:"birthday" bson:"birthday,omitempty"`            // 誕生日と公開範囲
+	PinnedPostID  bson.ObjectId  `json:"pinned_post_id" bson:"pinnedPostId,omitempty"`  // プロフィールに固定したポスト
+	ProfileFields []ProfileField `json:"profile_fields" bson:"profileFields,omitempty"` // プロフィールの任意の項目
+
+	EmailVerified bool `json:"email_verified" bson:"emailVerified"` // メールアドレス確認済みフラグ
+	TokenVersion  int  `json:"token_version" bson:"tokenVersion"`   // セッショントークンの世代(パスワード変更で進む)。セッションの確認でキャッシュから読むためJSONに含める
+
+	UserIDLower     string   `json:"screen_name_lower" bson:"userIdLower,omitempty"`               // 小文字化したユーザ名(大文字小文字を区別しない一意性の確保用)
+	DisplayNameKeys []string `json:"display_name_keys,omitempty" bson:"displayNameKeys,omitempty"` // 表示名の検索用キー(DisplayNameKeysを参照)
+
+	Deactivated   bool      `json:"deactivated" bson:"deactivated"`                // 退会手続き中フラグ
+	DeactivatedAt time.Time `json:"deactivated_at" bson:"deactivatedAt,omitempty"` // 退会手続きの日時
+	PurgeAt       time.Time `json:"purge_at" bson:"purgeAt,omitempty"`             // 全データを削除する日時(それまではログインで復帰できる)
+
+	Roles []Role `json:"roles" bson:"roles,omitempty"` // 管理権限
+
+	SuspendedAt      time.Time `json:"suspended_at" bson:"suspendedAt,omitempty"`       // 凍結した日時
+	SuspendedUntil   time.Time `json:"suspended_until" bson:"suspendedUntil,omitempty"` // 凍結の期限(ゼロ値は無期限)
+	SuspensionReason string    `json:"suspension_reason" bson:"suspensionReason"`       // 凍結の理由(ログイン時に本人に表示する)
+	WarningCount     int       `json:"warning_count" bson:"warningCount"`               // 警告を受けた回数
+
+	TwoFactorEnabled bool `json:"two_factor_enabled" bson:"twoFactorEnabled"` // 二段階認証の有効フラグ
+
+	// 二段階認証の秘密情報はキャッシュ(JSON)に載せない。使う場合はDBから取得する
+	TwoFactorSecret   string   `json:"-" bson:"twoFactorSecret"`   // TOTP共有シークレット(Base32)
+	TwoFactorLastStep int64    `json:"-" bson:"twoFactorLastStep"` // 最後に使用されたTOTPステップ(再利用防止)
+	RecoveryCodes     []string `json:"-" bson:"recoveryCodes"`     // ハッシュ化済みリカバリーコード
 }
 
 // NewUser 初期化されたUser構造体を返す
@@ -31,6 +65,7 @@ func NewUser(id, password, mail string, isOfficial bool) *User {
 	return &User{
 		ID:          bson.NewObjectId(),
 		UserID:      id,
+		UserIDLower: strings.ToLower(id),
 		DisplayName: id,
 		Password:    password,
 		EMail:       mail,
@@ -44,5 +79,23 @@ func NewUser(id, password, mail string, isOfficial bool) *User {
 		CreatedDate: time.Now(),
 		UpdatedDate: time.Now(),
 		Official:    isOfficial,
+
+		DisplayNameKeys: DisplayNameKeys(id),
+	}
+}
+
+// DisplayNameKeys 表示名を前方一致で検索するためのキーを返す
+// 小文字にした表示名の各単語から末尾までを1つのキーにし、単語の途中からは一致させない
+func DisplayNameKeys(name string) []string {
+	words := strings.Fields(strings.ToLower(name))
+	keys := make([]string, len(words))
+	for i := range words {
+		keys[i] = strings.Join(words[i:], " ")
 	}
+	return keys
+}
+
+// IsSuspended nowの時点で凍結中か。期限を過ぎた凍結は解除済みとして扱う
+func (u User) IsSuspended(now time.Time) bool {
+	return u.Suspended && (u.SuspendedUntil.IsZero() || now.Before(u.SuspendedUntil))
 }
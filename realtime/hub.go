@@ -0,0 +1,109 @@
+package realtime
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	"github.com/TinyKitten/TimelineServer/models"
+)
+
+// clientBuffer クライアントごとに溜められるメッセージ数。溢れた分は破棄する
+const clientBuffer = 64
+
+// Filter クライアントに配信するポストか判定する
+type Filter func(post models.PostResponse) bool
+
+// Client ストリームの購読者
+type Client struct {
+	send   chan []byte
+	filter Filter
+}
+
+// Messages 配信されたメッセージ。Unsubscribeで閉じられる
+func (c *Client) Messages() <-chan []byte {
+	return c.send
+}
+
+// Stats ハブの統計情報
+type Stats struct {
+	Clients   int    `json:"clients"`   // 接続中のクライアント数
+	Published uint64 `json:"published"` // 配信したポスト数
+	Delivered uint64 `json:"delivered"` // クライアントに届けたメッセージ数
+	Dropped   uint64 `json:"dropped"`   // クライアントの受信が追いつかず破棄したメッセージ数
+}
+
+// Hub 投稿をストリームの購読者に配信する
+type Hub struct {
+	mu      sync.RWMutex
+	clients map[*Client]struct{}
+
+	published uint64
+	delivered uint64
+	dropped   uint64
+}
+
+// NewHub 購読者のいないハブを返す
+func NewHub() *Hub {
+	return &Hub{clients: map[*Client]struct{}{}}
+}
+
+// Subscribe filterに一致するポストを受け取るクライアントを登録する
+func (h *Hub) Subscribe(filter Filter) *Client {
+	c := &Client{
+		send:   make(chan []byte, clientBuffer),
+		filter: filter,
+	}
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+	return c
+}
+
+// Unsubscribe クライアントの登録を解除し、Messagesを閉じる
+func (h *Hub) Unsubscribe(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// Publish ポストを一致するクライアントに配信する
+// 遅いクライアントで他の配信や投稿が止まらないよう、送信待ちが溢れたクライアントには届けない
+func (h *Hub) Publish(post models.PostResponse) error {
+	msg, err := json.Marshal(post)
+	if err != nil {
+		return err
+	}
+	atomic.AddUint64(&h.published, 1)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.clients {
+		if c.filter != nil && !c.filter(post) {
+			continue
+		}
+		select {
+		case c.send <- msg:
+			atomic.AddUint64(&h.delivered, 1)
+		default:
+			atomic.AddUint64(&h.dropped, 1)
+		}
+	}
+	return nil
+}
+
+// Stats 起動してからの統計情報を返す
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	clients := len(h.clients)
+	h.mu.RUnlock()
+	return Stats{
+		Clients:   clients,
+		Published: atomic.LoadUint64(&h.published),
+		Delivered: atomic.LoadUint64(&h.delivered),
+		Dropped:   atomic.LoadUint64(&h.dropped),
+	}
+}
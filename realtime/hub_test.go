@@ -0,0 +1,65 @@
+package realtime
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/TinyKitten/TimelineServer/models"
+)
+
+func TestHub(t *testing.T) {
+	h := NewHub()
+	all := h.Subscribe(nil)
+	kitten := h.Subscribe(func(p models.PostResponse) bool { return p.User.UserID == "kitten" })
+
+	if err := h.Publish(models.PostResponse{Text: "hello", User: models.UserResponse{UserID: "kitten"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Publish(models.PostResponse{Text: "bye", User: models.UserResponse{UserID: "puppy"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(all.Messages()); n != 2 {
+		t.Fatalf("expected 2 messages, actual %d", n)
+	}
+	if n := len(kitten.Messages()); n != 1 {
+		t.Fatalf("expected 1 message, actual %d", n)
+	}
+	post := models.PostResponse{}
+	if err := json.Unmarshal(<-kitten.Messages(), &post); err != nil {
+		t.Fatal(err)
+	}
+	if post.Text != "hello" {
+		t.Fatalf("unexpected post: %v", post)
+	}
+
+	s := h.Stats()
+	if s.Clients != 2 || s.Published != 2 || s.Delivered != 3 || s.Dropped != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+
+	h.Unsubscribe(kitten)
+	h.Unsubscribe(kitten)
+	if _, ok := <-kitten.Messages(); ok {
+		t.Fatal("messages should be closed")
+	}
+	if h.Stats().Clients != 1 {
+		t.Fatal("client should be removed")
+	}
+}
+
+func TestHubDropsSlowClients(t *testing.T) {
+	h := NewHub()
+	c := h.Subscribe(nil)
+	for i := 0; i < clientBuffer+1; i++ {
+		if err := h.Publish(models.PostResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(c.Messages()) != clientBuffer {
+		t.Fatalf("buffer should be full: %d", len(c.Messages()))
+	}
+	if s := h.Stats(); s.Dropped != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
@@ -0,0 +1,95 @@
+package realtime
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/search"
+)
+
+const (
+	// maxTrackPhrases trackに指定できるフレーズの数
+	maxTrackPhrases = 100
+	// maxPhraseLength フレーズの最大文字数
+	maxPhraseLength = 60
+)
+
+var (
+	// ErrEmptyTrack trackにフレーズがない
+	ErrEmptyTrack = errors.New("empty track")
+	// ErrTooManyPhrases trackのフレーズが多すぎる
+	ErrTooManyPhrases = errors.New("too many track phrases")
+	// ErrPhraseTooLong trackのフレーズが長すぎる
+	ErrPhraseTooLong = errors.New("track phrase too long")
+)
+
+// phrase 全ての語を含むポストに一致する条件
+type phrase struct {
+	hashtags []string
+	keywords []string
+}
+
+// TrackFilter Twitterのstatuses/filterのtrackと同じ形式でFilterを作成する
+// カンマ区切りのフレーズのいずれかに一致するポストを配信する。フレーズ内の空白区切りの語は全てを含む必要がある
+// #で始まる語はハッシュタグ、それ以外は本文に含むキーワードとして扱う。大文字小文字・全角半角は区別しない
+func TrackFilter(track string) (Filter, error) {
+	phrases := []phrase{}
+	for _, p := range strings.Split(track, ",") {
+		if utf8.RuneCountInString(p) > maxPhraseLength {
+			return nil, ErrPhraseTooLong
+		}
+		ph := phrase{}
+		for _, term := range strings.Fields(search.Normalize(p)) {
+			if strings.HasPrefix(term, "#") {
+				if tag := strings.TrimLeft(term, "#"); tag != "" {
+					ph.hashtags = append(ph.hashtags, tag)
+				}
+				continue
+			}
+			ph.keywords = append(ph.keywords, term)
+		}
+		if len(ph.hashtags) == 0 && len(ph.keywords) == 0 {
+			continue
+		}
+		phrases = append(phrases, ph)
+	}
+	if len(phrases) == 0 {
+		return nil, ErrEmptyTrack
+	}
+	if len(phrases) > maxTrackPhrases {
+		return nil, ErrTooManyPhrases
+	}
+
+	return func(post models.PostResponse) bool {
+		text := search.Normalize(post.Text)
+		for _, ph := range phrases {
+			if ph.match(text, post.Hashtags) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func (ph phrase) match(text string, hashtags []string) bool {
+	for _, k := range ph.keywords {
+		if !strings.Contains(text, k) {
+			return false
+		}
+	}
+	for _, tag := range ph.hashtags {
+		found := false
+		for _, h := range hashtags {
+			if h == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
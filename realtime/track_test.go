@@ -0,0 +1,48 @@
+package realtime
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TinyKitten/TimelineServer/models"
+	"github.com/TinyKitten/TimelineServer/search"
+)
+
+func post(text string) models.PostResponse {
+	return models.PostResponse{Text: text, Hashtags: search.ExtractHashtags(text)}
+}
+
+func TestTrackFilter(t *testing.T) {
+	f, err := TrackFilter("ＧＯ言語 gopher, #Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		text  string
+		match bool
+	}{
+		{"Go言語のgopherくん", true},
+		{"gopherだけ", false},
+		{"今日は ＃tokyo", true},
+		{"tokyoは文字だけ", false},
+		{"#tokyotower", false},
+	} {
+		if actual := f(post(tc.text)); actual != tc.match {
+			t.Errorf("%q: expected %v, actual %v", tc.text, tc.match, actual)
+		}
+	}
+}
+
+func TestTrackFilterErrors(t *testing.T) {
+	for track, expected := range map[string]error{
+		"":                                      ErrEmptyTrack,
+		" , #":                                  ErrEmptyTrack,
+		strings.Repeat("a", maxPhraseLength+1):  ErrPhraseTooLong,
+		strings.Repeat("a,", maxTrackPhrases+1): ErrTooManyPhrases,
+	} {
+		if _, err := TrackFilter(track); err != expected {
+			t.Errorf("%q: expected %v, actual %v", track, expected, err)
+		}
+	}
+}
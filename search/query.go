@@ -0,0 +1,139 @@
+package search
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrEmptyQuery 検索条件がない
+	ErrEmptyQuery = errors.New("empty query")
+	// ErrInvalidDate since:/until:の日付の形式が不正
+	ErrInvalidDate = errors.New("invalid date. use YYYY-MM-DD")
+	// ErrBroadQuery インデックスで絞り込めない。2文字以上の語か、from:・#・since:・until:が必要
+	ErrBroadQuery = errors.New("query too broad. add a longer word, from:, a hashtag, since: or until:")
+)
+
+// dateLayout since:/until:の日付の形式
+const dateLayout = "2006-01-02"
+
+// Query ポスト検索の条件
+type Query struct {
+	// Terms 本文に含む語(正規化済み)。全てを含むポストに一致する
+	Terms []string
+	// Hashtags 付いているハッシュタグ(正規化済み、#なし)
+	Hashtags []string
+	// From 投稿者のスクリーンネーム
+	From string
+	// Since この日時以降の投稿(UTCの0時)
+	Since time.Time
+	// Until この日時より前の投稿(UTCの0時)
+	Until time.Time
+	// HasURL URLを含む投稿のみ
+	HasURL bool
+}
+
+// Tokens 転置インデックスで候補を絞り込むためのトークン
+func (q Query) Tokens() []string {
+	tokens := []string{}
+	seen := map[string]bool{}
+	for _, term := range q.Terms {
+		for _, t := range termTokens(term) {
+			if !seen[t] {
+				seen[t] = true
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	return tokens
+}
+
+// ParseQuery 検索文字列を解析する
+// 空白で区切った語の全てを含むポストに一致する。"..."で囲むと空白を含む語として扱う
+// 演算子: from:スクリーンネーム, #ハッシュタグ, since:YYYY-MM-DD, until:YYYY-MM-DD, has:url
+// 解釈できない演算子は通常の語として扱う。インデックスで絞り込めない条件だけの場合はErrBroadQueryを返す
+func ParseQuery(s string) (Query, error) {
+	q := Query{}
+	for _, f := range fields(s) {
+		if f.quoted {
+			if term := strings.TrimSpace(Normalize(f.text)); term != "" {
+				q.Terms = append(q.Terms, term)
+			}
+			continue
+		}
+
+		word := f.text
+		lower := Normalize(word)
+		switch {
+		case strings.HasPrefix(lower, "from:") && len(lower) > len("from:"):
+			// スクリーンネームは大文字小文字を保ったまま、全角だけを半角にする
+			q.From = strings.TrimPrefix(operand(foldWidth(word), "from:"), "@")
+		case strings.HasPrefix(lower, "since:"):
+			t, err := time.Parse(dateLayout, operand(lower, "since:"))
+			if err != nil {
+				return Query{}, ErrInvalidDate
+			}
+			q.Since = t
+		case strings.HasPrefix(lower, "until:"):
+			t, err := time.Parse(dateLayout, operand(lower, "until:"))
+			if err != nil {
+				return Query{}, ErrInvalidDate
+			}
+			q.Until = t
+		case lower == "has:url" || lower == "has:link":
+			q.HasURL = true
+		case strings.HasPrefix(lower, "#") && len(lower) > 1:
+			q.Hashtags = append(q.Hashtags, lower[1:])
+		default:
+			q.Terms = append(q.Terms, lower)
+		}
+	}
+
+	if len(q.Terms) == 0 && len(q.Hashtags) == 0 && q.From == "" && !q.HasURL && q.Since.IsZero() && q.Until.IsZero() {
+		return Query{}, ErrEmptyQuery
+	}
+	// 1文字の語やhas:urlだけでは全件を調べることになるため受け付けない
+	if len(q.Tokens()) == 0 && len(q.Hashtags) == 0 && q.From == "" && q.Since.IsZero() && q.Until.IsZero() {
+		return Query{}, ErrBroadQuery
+	}
+	return q, nil
+}
+
+// operand 正規化した語から演算子の後ろの値を取り出す
+// 全角で書かれた演算子は元の語とバイト数が異なるため、文字数で切り出す
+func operand(s, op string) string {
+	return string([]rune(s)[utf8.RuneCountInString(op):])
+}
+
+type field struct {
+	text   string
+	quoted bool
+}
+
+// fields 空白で区切る。"..."の中の空白では区切らない
+func fields(s string) []field {
+	fs := []field{}
+	var cur []rune
+	quoted := false
+	flush := func(q bool) {
+		if len(cur) != 0 {
+			fs = append(fs, field{text: string(cur), quoted: q})
+		}
+		cur = nil
+	}
+	for _, r := range s {
+		switch {
+		case r == '"':
+			flush(quoted)
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '　' || r == '\t' || r == '\n'):
+			flush(false)
+		default:
+			cur = append(cur, r)
+		}
+	}
+	flush(quoted)
+	return fs
+}
@@ -0,0 +1,92 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	cases := []struct {
+		text   string
+		tokens []string
+	}{
+		{"東京都", []string{"東京", "京都"}},
+		{"Ｈｅｌｌｏ, a 猫!", []string{"he", "el", "ll", "lo", "a", "猫"}},
+		{"haha haha", []string{"ha", "ah"}},
+	}
+	for _, c := range cases {
+		if actual := Tokenize(c.text); !reflect.DeepEqual(actual, c.tokens) {
+			t.Errorf("%q: expected %v, actual %v", c.text, c.tokens, actual)
+		}
+	}
+}
+
+func TestQueryTokensAreSubsetOfDocument(t *testing.T) {
+	doc := map[string]bool{}
+	for _, token := range Tokenize("今日は東京都庁に行った kitten") {
+		doc[token] = true
+	}
+	q, err := ParseQuery("東京 都庁 kitt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range q.Tokens() {
+		if !doc[token] {
+			t.Fatalf("token %q should be in the document", token)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery(`猫 "Good Morning" from:@Kitten #Cats ＃犬 since:2017-10-01 until:2017-10-08 has:url lang:ja`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Query{
+		Terms:    []string{"猫", "good morning", "lang:ja"},
+		Hashtags: []string{"cats", "犬"},
+		From:     "Kitten",
+		Since:    time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC),
+		Until:    time.Date(2017, 10, 8, 0, 0, 0, 0, time.UTC),
+		HasURL:   true,
+	}
+	if !reflect.DeepEqual(q, expected) {
+		t.Fatalf("expected %+v, actual %+v", expected, q)
+	}
+
+	// 全角で書いた演算子も解釈する
+	q, err = ParseQuery("ｆｒｏｍ：＠Ｋｉｔｔｅｎ ｓｉｎｃｅ：２０１７－１０－０１")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = Query{From: "Kitten", Since: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+	if !reflect.DeepEqual(q, expected) {
+		t.Fatalf("expected %+v, actual %+v", expected, q)
+	}
+
+	if _, err := ParseQuery("since:yesterday"); err != ErrInvalidDate {
+		t.Fatalf("expected ErrInvalidDate, actual %v", err)
+	}
+	if _, err := ParseQuery(`  "" `); err != ErrEmptyQuery {
+		t.Fatalf("expected ErrEmptyQuery, actual %v", err)
+	}
+	for _, s := range []string{"has:url", "猫", "a has:url"} {
+		if _, err := ParseQuery(s); err != ErrBroadQuery {
+			t.Fatalf("%q: expected ErrBroadQuery, actual %v", s, err)
+		}
+	}
+	if _, err := ParseQuery("猫 since:2017-10-01"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	text := "#Go と ＃ゴー言語 https://example.com/#anchor http://a.b a#b #go"
+	if tags := ExtractHashtags(text); !reflect.DeepEqual(tags, []string{"go", "ゴー言語"}) {
+		t.Fatalf("unexpected hashtags: %v", tags)
+	}
+	if urls := ExtractURLs(text); !reflect.DeepEqual(urls, []string{"https://example.com/#anchor", "http://a.b"}) {
+		t.Fatalf("unexpected urls: %v", urls)
+	}
+}
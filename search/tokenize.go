@@ -0,0 +1,115 @@
+package search
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&/])[#＃]([\p{L}\p{N}_]+)`)
+	urlPattern     = regexp.MustCompile(`https?://[^\s　]+`)
+)
+
+// Normalize 検索用に文字列を正規化する
+// 英字を小文字にし、全角英数字・記号を半角にする
+func Normalize(s string) string {
+	return strings.Map(unicode.ToLower, foldWidth(s))
+}
+
+// foldWidth 全角の英数字・記号・空白を半角にする
+func foldWidth(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '！' && r <= '～' {
+			r -= '！' - '!'
+		}
+		if r == '　' {
+			r = ' '
+		}
+		return r
+	}, s)
+}
+
+// Tokenize 正規化した文字列を転置インデックス用のトークンに分割する
+// 分かち書きをしない日本語でも部分一致で検索できるよう、単語の区切りごとにバイグラムにする
+// 1文字だけの区切りはそのままトークンにする。結果に重複は含まない
+func Tokenize(s string) []string {
+	tokens := []string{}
+	seen := map[string]bool{}
+	add := func(t string) {
+		if !seen[t] {
+			seen[t] = true
+			tokens = append(tokens, t)
+		}
+	}
+
+	for _, seg := range segments(Normalize(s)) {
+		if len(seg) == 1 {
+			add(string(seg))
+			continue
+		}
+		for i := 0; i+1 < len(seg); i++ {
+			add(string(seg[i : i+2]))
+		}
+	}
+	return tokens
+}
+
+// termTokens 検索語に一致するドキュメントが必ず持つトークン
+// 1文字の区切りは、それを含む長い区切りのバイグラムに現れないため条件にしない
+func termTokens(term string) []string {
+	tokens := []string{}
+	for _, seg := range segments(term) {
+		for i := 0; i+1 < len(seg); i++ {
+			tokens = append(tokens, string(seg[i:i+2]))
+		}
+	}
+	return tokens
+}
+
+// segments 文字・数字の連続ごとに区切る
+func segments(s string) [][]rune {
+	segs := [][]rune{}
+	var cur []rune
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r) || r == '_' {
+			cur = append(cur, r)
+			continue
+		}
+		if len(cur) != 0 {
+			segs = append(segs, cur)
+			cur = nil
+		}
+	}
+	if len(cur) != 0 {
+		segs = append(segs, cur)
+	}
+	return segs
+}
+
+// ExtractHashtags 本文からハッシュタグを#を除いて抽出する。正規化済みで重複は含まない
+func ExtractHashtags(text string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, m := range hashtagPattern.FindAllStringSubmatch(urlPattern.ReplaceAllString(text, " "), -1) {
+		tag := Normalize(m[1])
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ExtractURLs 本文からURLを抽出する。重複は含まない
+func ExtractURLs(text string) []string {
+	urls := []string{}
+	seen := map[string]bool{}
+	for _, u := range urlPattern.FindAllString(text, -1) {
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FakeS3 テスト用のS3互換サーバ(MinIOの代わり)。パス形式のPUT・GET・DELETEだけに対応する
+// 署名はクライアントと同じsignatureで検証するため、署名処理自体の正しさはTestSignatureで確かめる
+type FakeS3 struct {
+	accessKey string
+	secretKey string
+	region    string
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+}
+
+// NewFakeS3 FakeS3を生成する。httptest.NewServerに渡して使う
+func NewFakeS3(accessKey, secretKey, region string) *FakeS3 {
+	return &FakeS3{
+		accessKey: accessKey,
+		secretKey: secretKey,
+		region:    region,
+		objects:   map[string]fakeObject{},
+	}
+}
+
+// Object 保存されているオブジェクトを返す。pathは"バケット名/キー"
+func (f *FakeS3) Object(path string) ([]byte, string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o, ok := f.objects[path]
+	return o.data, o.contentType, ok
+}
+
+// Len 保存されているオブジェクトの数を返す
+func (f *FakeS3) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.objects)
+}
+
+func (f *FakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fakeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	if code := f.verify(r, body); code != "" {
+		fakeS3Error(w, http.StatusForbidden, code)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if strings.Count(path, "/") < 1 {
+		fakeS3Error(w, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[path] = fakeObject{data: body, contentType: r.Header.Get("Content-Type")}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		o, ok := f.objects[path]
+		if !ok {
+			fakeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", o.contentType)
+		w.Write(o.data)
+	case http.MethodDelete:
+		delete(f.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// verify 署名を検証し、失敗した場合はS3のエラーコードを返す
+func (f *FakeS3) verify(r *http.Request, body []byte) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, signAlgorithm+" ") {
+		return "AccessDenied"
+	}
+	params := map[string]string{}
+	for _, p := range strings.Split(strings.TrimPrefix(auth, signAlgorithm+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = kv[1]
+		}
+	}
+	cred := strings.Split(params["Credential"], "/")
+	if len(cred) != 5 || cred[0] != f.accessKey {
+		return "InvalidAccessKeyId"
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != len(amzDateFormat) || cred[1] != amzDate[:8] || cred[2] != f.region {
+		return "AuthorizationHeaderMalformed"
+	}
+	if r.Header.Get("X-Amz-Content-Sha256") != hashHex(body) {
+		return "XAmzContentSHA256Mismatch"
+	}
+	expected := signature(r, strings.Split(params["SignedHeaders"], ";"), f.secretKey, f.region, amzDate)
+	if !hmac.Equal([]byte(expected), []byte(params["Signature"])) {
+		return "SignatureDoesNotMatch"
+	}
+	return ""
+}
+
+func fakeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code></Error>", code)
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Local ローカルのディレクトリに保存する。公開はAPIサーバの静的ファイル配信に任せる
+type Local struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocal dirに保存し、baseURL以下で公開するLocalを生成する
+func NewLocal(dir, baseURL string) *Local {
+	return &Local{dir: dir, baseURL: withSlash(baseURL)}
+}
+
+// Dir 保存先のディレクトリを返す
+func (l *Local) Dir() string {
+	return l.dir
+}
+
+// ServePath ローカルの保存先dirをAPIサーバから配信するパスを返す
+// 以前の公開URLを保てるよう、保存先の中を指す相対パスはそのまま使い、それ以外は/uploadsにする
+func ServePath(dir string) string {
+	rel := path.Clean(filepath.ToSlash(dir))
+	if filepath.IsAbs(dir) || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "/uploads"
+	}
+	return "/" + rel
+}
+
+func (l *Local) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+// Put データをファイルに書き出す
+func (l *Local) Put(key string, dat []byte, contentType string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, dat, 0644)
+}
+
+// Open ファイルを開く
+func (l *Local) Open(key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Delete ファイルを削除する
+func (l *Local) Delete(key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// URL ファイルの公開URLを返す
+func (l *Local) URL(key string) string {
+	return l.baseURL + key
+}
+
+// Key 公開URLからキーを返す
+func (l *Local) Key(fileURL string) (string, bool) {
+	return keyFromURL(l.baseURL, fileURL)
+}
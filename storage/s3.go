@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/config"
+	"github.com/TinyKitten/TimelineServer/utils"
+)
+
+const (
+	// amzDateFormat 署名に使う日時の形式
+	amzDateFormat = "20060102T150405Z"
+	// signAlgorithm 署名方式(AWS Signature Version 4)
+	signAlgorithm = "AWS4-HMAC-SHA256"
+)
+
+// S3 S3互換のオブジェクトストレージ(AWS S3、MinIOなど)に保存する
+type S3 struct {
+	conf     config.S3Config
+	endpoint *url.URL
+	baseURL  string
+	client   *http.Client
+	clock    utils.Clock
+}
+
+// NewS3 S3を生成する。baseURLが空の場合はバケットのURLから公開する
+func NewS3(conf config.S3Config, baseURL string, clock utils.Clock) (*S3, error) {
+	if conf.Endpoint == "" || conf.Region == "" || conf.Bucket == "" {
+		return nil, ErrMissingS3Config
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(conf.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	s := &S3{
+		conf:     conf,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		clock:    clock,
+	}
+	if baseURL == "" {
+		baseURL = s.objectURL("")
+	}
+	s.baseURL = withSlash(baseURL)
+	return s, nil
+}
+
+// objectURL オブジェクトのURLを返す。PathStyleの場合はエンドポイントのパスにバケット名を含める
+func (s *S3) objectURL(key string) string {
+	if s.conf.PathStyle {
+		return s.endpoint.Scheme + "://" + s.endpoint.Host + "/" + escapePath(s.conf.Bucket) + "/" + escapePath(key)
+	}
+	return s.endpoint.Scheme + "://" + s.conf.Bucket + "." + s.endpoint.Host + "/" + escapePath(key)
+}
+
+// do 署名したリクエストを送る
+func (s *S3) do(method, key string, body []byte, contentType string) (*http.Response, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	req, err := http.NewRequest(method, s.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sign(req, body, s.conf.AccessKey, s.conf.SecretKey, s.conf.Region, s.clock.Now())
+	return s.client.Do(req)
+}
+
+// Put オブジェクトをアップロードする
+func (s *S3) Put(key string, dat []byte, contentType string) error {
+	resp, err := s.do(http.MethodPut, key, dat, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return responseError(resp)
+}
+
+// Open オブジェクトを取得する
+func (s *S3) Open(key string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, &os.PathError{Op: "open", Path: key, Err: os.ErrNotExist}
+	}
+	if err := responseError(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete オブジェクトを削除する。S3は存在しないオブジェクトの削除も成功として返す
+func (s *S3) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return responseError(resp)
+}
+
+// URL オブジェクトの公開URLを返す
+func (s *S3) URL(key string) string {
+	return s.baseURL + escapePath(key)
+}
+
+// Key 公開URLからキーを返す
+func (s *S3) Key(fileURL string) (string, bool) {
+	escaped, ok := keyFromURL(s.baseURL, fileURL)
+	if !ok {
+		return "", false
+	}
+	key, err := url.PathUnescape(escaped)
+	if err != nil || !validKey(key) {
+		return "", false
+	}
+	return key, true
+}
+
+// responseError 失敗したレスポンスをエラーにする
+func responseError(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// escapePath パスをURIエンコードする。SigV4の正規化に合わせ、非予約文字と"/"以外は全てエスケープする
+func escapePath(path string) string {
+	return uriEncode(path, false)
+}
+
+// uriEncode SigV4のUriEncode。非予約文字以外を%XXにし、encodeSlashがfalseの場合は"/"を残す
+func uriEncode(s string, encodeSlash bool) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hashHex(dat []byte) string {
+	sum := sha256.Sum256(dat)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// canonicalQuery SigV4の正規化したクエリ文字列を返す
+// url.Values.Encodeは空白を"+"にするため使わず、名前と値をuriEncodeしてから名前順に並べる
+func canonicalQuery(query url.Values) string {
+	type param struct{ name, value string }
+	params := []param{}
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, param{uriEncode(name, true), uriEncode(value, true)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].name != params[j].name {
+			return params[i].name < params[j].name
+		}
+		return params[i].value < params[j].value
+	})
+	pairs := make([]string, len(params))
+	for i, p := range params {
+		pairs[i] = p.name + "=" + p.value
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sign リクエストにAWS Signature Version 4の署名を付ける
+func sign(req *http.Request, body []byte, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hashHex(body))
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		signed = append(signed, "content-type")
+	}
+	sort.Strings(signed)
+
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	sig := signature(req, signed, secretKey, region, amzDate)
+	req.Header.Set("Authorization", signAlgorithm+" Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+strings.Join(signed, ";")+", Signature="+sig)
+}
+
+// signature 署名対象のヘッダ(小文字、ソート済み)からリクエストの署名を計算する
+func signature(req *http.Request, signed []string, secretKey, region, amzDate string) string {
+	headers := new(bytes.Buffer)
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		headers.String(),
+		strings.Join(signed, ";"),
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	date := amzDate[:8]
+	scope := date + "/" + region + "/s3/aws4_request"
+	toSign := signAlgorithm + "\n" + amzDate + "\n" + scope + "\n" + hashHex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
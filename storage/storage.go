@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/TinyKitten/TimelineServer/config"
+	"github.com/TinyKitten/TimelineServer/utils"
+)
+
+var (
+	// ErrUnknownDriver 設定されたドライバが存在しない
+	ErrUnknownDriver = errors.New("unknown storage driver")
+	// ErrInvalidKey キーが空か、保存先の外を指している
+	ErrInvalidKey = errors.New("invalid storage key")
+	// ErrMissingS3Config S3の接続先が設定されていない
+	ErrMissingS3Config = errors.New("s3 endpoint, region and bucket are required")
+)
+
+// Storage アップロードされたファイルの保存先の抽象
+// キーは"/"区切りの相対パスで、保存先の外を指すもの(..を含むものなど)は受け付けない
+type Storage interface {
+	// Put データを保存する。同じキーのファイルは上書きする
+	Put(key string, dat []byte, contentType string) error
+	// Open ファイルを開く。存在しない場合のエラーはos.IsNotExistで判定できる
+	Open(key string) (io.ReadCloser, error)
+	// Delete ファイルを削除する。存在しない場合もエラーにしない
+	Delete(key string) error
+	// URL ファイルを公開するURLを返す
+	URL(key string) string
+	// Key URLからキーを返す。この保存先のURLでなければfalse
+	Key(fileURL string) (string, bool)
+}
+
+// New 設定に応じたStorageを生成する
+// ローカルの保存先でbase_urlが未設定の場合はdefaultBaseURLから公開する
+func New(conf config.UploadImageConfig, defaultBaseURL string, clock utils.Clock) (Storage, error) {
+	switch conf.Driver {
+	case "local", "":
+		baseURL := conf.BaseURL
+		if baseURL == "" {
+			baseURL = defaultBaseURL
+		}
+		return NewLocal(conf.Path, baseURL), nil
+	case "s3":
+		return NewS3(conf.S3, conf.BaseURL, clock)
+	default:
+		return nil, ErrUnknownDriver
+	}
+}
+
+// validKey キーが保存先の中を指しているか
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// keyFromURL baseURL以下のURLからキーを返す
+func keyFromURL(baseURL, fileURL string) (string, bool) {
+	if baseURL == "" || !strings.HasPrefix(fileURL, baseURL) {
+		return "", false
+	}
+	key := strings.TrimPrefix(fileURL, baseURL)
+	if !validKey(key) {
+		return "", false
+	}
+	return key, true
+}
+
+// withSlash キーを繋げられるようbaseURLの末尾に"/"を補う
+func withSlash(baseURL string) string {
+	if baseURL == "" || strings.HasSuffix(baseURL, "/") {
+		return baseURL
+	}
+	return baseURL + "/"
+}
@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/config"
+	"github.com/TinyKitten/TimelineServer/utils"
+)
+
+func readAll(t *testing.T, s Storage, key string) string {
+	rc, err := s.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	dat, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(dat)
+}
+
+// testStorage 保存先に共通する振る舞いを確かめる
+func testStorage(t *testing.T, s Storage) {
+	if err := s.Put("a/b c.png", []byte("png"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if actual := readAll(t, s, "a/b c.png"); actual != "png" {
+		t.Fatalf("expected png, actual %s", actual)
+	}
+
+	key, ok := s.Key(s.URL("a/b c.png"))
+	if !ok || key != "a/b c.png" {
+		t.Fatalf("key should be restored from url, actual %q %v", key, ok)
+	}
+	if _, ok := s.Key("https://example.com/a.png"); ok {
+		t.Fatal("foreign url should not be accepted")
+	}
+	if _, ok := s.Key(s.URL("") + "../secret"); ok {
+		t.Fatal("url outside the storage should not be accepted")
+	}
+	for _, key := range []string{"", "../a.png", "/a.png", "a//b.png"} {
+		if err := s.Put(key, []byte("x"), "image/png"); err != ErrInvalidKey {
+			t.Fatalf("%q: expected %v, actual %v", key, ErrInvalidKey, err)
+		}
+	}
+
+	if err := s.Delete("a/b c.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open("a/b c.png"); !os.IsNotExist(err) {
+		t.Fatalf("deleted file should not exist, actual %v", err)
+	}
+	if err := s.Delete("a/b c.png"); err != nil {
+		t.Fatalf("deleting a missing file should succeed, actual %v", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewLocal(dir, "http://localhost/uploads")
+	if u := s.URL("a.png"); u != "http://localhost/uploads/a.png" {
+		t.Fatalf("unexpected url: %s", u)
+	}
+	testStorage(t, s)
+}
+
+func TestS3(t *testing.T) {
+	fake := NewFakeS3("access", "secret", "us-east-1")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	conf := config.S3Config{
+		Endpoint:  server.URL,
+		Region:    "us-east-1",
+		Bucket:    "media",
+		AccessKey: "access",
+		SecretKey: "secret",
+		PathStyle: true,
+	}
+	clock := &utils.FakeClock{Current: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	s, err := NewS3(conf, "", clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := s.URL("a b.png"); u != server.URL+"/media/a%20b.png" {
+		t.Fatalf("unexpected url: %s", u)
+	}
+
+	if err := s.Put("x.png", []byte("png"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if dat, contentType, ok := fake.Object("media/x.png"); !ok || string(dat) != "png" || contentType != "image/png" {
+		t.Fatalf("object should be stored: %q %q %v", dat, contentType, ok)
+	}
+	testStorage(t, s)
+	if fake.Len() != 1 {
+		t.Fatalf("expected 1 object, actual %d", fake.Len())
+	}
+
+	// 署名が一致しなければ拒否される
+	conf.SecretKey = "wrong"
+	wrong, err := NewS3(conf, "https://cdn.example.com/", clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wrong.Put("y.png", []byte("png"), "image/png"); err == nil {
+		t.Fatal("request with a wrong signature should fail")
+	}
+	if u := wrong.URL("y.png"); u != "https://cdn.example.com/y.png" {
+		t.Fatalf("base url should be used, actual %s", u)
+	}
+}
+
+// TestSignature AWSのドキュメントに掲載されているS3の署名の計算例と一致するか確かめる
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func TestSignature(t *testing.T) {
+	const (
+		secretKey   = "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"
+		amzDate     = "20130524T000000Z"
+		emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	)
+	cases := []struct {
+		name     string
+		method   string
+		url      string
+		headers  map[string]string
+		expected string
+	}{
+		{
+			name:   "GET Object",
+			method: http.MethodGet,
+			url:    "https://examplebucket.s3.amazonaws.com/test.txt",
+			headers: map[string]string{
+				"Range":                "bytes=0-9",
+				"X-Amz-Content-Sha256": emptySHA256,
+			},
+			expected: "f0e8bdb87c964420e857bd35b5d6ed310bd44f0170aba48dd91039c6036bdb41",
+		},
+		{
+			name:   "PUT Object",
+			method: http.MethodPut,
+			url:    "https://examplebucket.s3.amazonaws.com/test$file.text",
+			headers: map[string]string{
+				"Date":                 "Fri, 24 May 2013 00:00:00 GMT",
+				"X-Amz-Storage-Class":  "REDUCED_REDUNDANCY",
+				"X-Amz-Content-Sha256": hashHex([]byte("Welcome to Amazon S3.")),
+			},
+			expected: "98ad721746da40c64f1a55b78f14c238d841ea1380cd77a1b5971af0ece108bd",
+		},
+		{
+			name:     "GET Bucket Lifecycle",
+			method:   http.MethodGet,
+			url:      "https://examplebucket.s3.amazonaws.com/?lifecycle",
+			headers:  map[string]string{"X-Amz-Content-Sha256": emptySHA256},
+			expected: "fea454ca298b7da1c68078a5d1bdbfbbe0d65c699e0f91ac7a200a0136783543",
+		},
+		{
+			name:     "GET Bucket (List Objects)",
+			method:   http.MethodGet,
+			url:      "https://examplebucket.s3.amazonaws.com/?max-keys=2&prefix=J",
+			headers:  map[string]string{"X-Amz-Content-Sha256": emptySHA256},
+			expected: "34b48302e7b5fa45bde8084f4b7868a86f0a534bc59db6670ed5711ef69dc6f7",
+		},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest(c.method, c.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Amz-Date", amzDate)
+		signed := []string{"host", "x-amz-date"}
+		for name, value := range c.headers {
+			req.Header.Set(name, value)
+			signed = append(signed, strings.ToLower(name))
+		}
+		sort.Strings(signed)
+
+		if actual := signature(req, signed, secretKey, "us-east-1", amzDate); actual != c.expected {
+			t.Errorf("%s: expected %s, actual %s", c.name, c.expected, actual)
+		}
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	q := url.Values{"prefix": {"a b/c+d"}, "list-type": {"2"}, "list": {"b", "a"}, "marker": {"~x*"}, "acl": {""}}
+	if actual := canonicalQuery(q); actual != "acl=&list=a&list=b&list-type=2&marker=~x%2A&prefix=a%20b%2Fc%2Bd" {
+		t.Fatalf("unexpected canonical query: %s", actual)
+	}
+}
+
+func TestServePath(t *testing.T) {
+	for dir, expected := range map[string]string{
+		"uploads/img/":   "/uploads/img",
+		"./media":        "/media",
+		"/var/lib/media": "/uploads",
+		"../media":       "/uploads",
+		".":              "/uploads",
+	} {
+		if actual := ServePath(dir); actual != expected {
+			t.Errorf("%s: expected %s, actual %s", dir, expected, actual)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	s, err := New(config.UploadImageConfig{Path: "uploads/img/"}, "http://localhost/1.0/uploads/img/", utils.NewClock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(*Local); !ok {
+		t.Fatalf("local storage should be the default, actual %T", s)
+	}
+	if _, err := New(config.UploadImageConfig{Driver: "s3"}, "", utils.NewClock()); err != ErrMissingS3Config {
+		t.Fatalf("expected %v, actual %v", ErrMissingS3Config, err)
+	}
+	if _, err := New(config.UploadImageConfig{Driver: "ftp"}, "", utils.NewClock()); err != ErrUnknownDriver {
+		t.Fatalf("expected %v, actual %v", ErrUnknownDriver, err)
+	}
+}
@@ -1,6 +1,7 @@
 package token
 
 import (
+	"errors"
 	"time"
 
 	"gopkg.in/mgo.v2/bson"
@@ -9,6 +10,31 @@ import (
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
+const (
+	// Issuer トークンの発行者
+	Issuer = "KittenTimeline"
+	// ChallengeTTL 二段階認証チャレンジトークンの有効期間
+	ChallengeTTL = 5 * time.Minute
+	// EmailVerificationTTL メールアドレス確認リンクの有効期間
+	EmailVerificationTTL = 48 * time.Hour
+	// PasswordResetTTL パスワード再設定リンクの有効期間
+	PasswordResetTTL = time.Hour
+	// ExportDownloadTTL データエクスポートのダウンロードリンクの有効期間
+	ExportDownloadTTL = 24 * time.Hour
+
+	scopeChallenge      = "2fa_challenge"
+	scopeVerifyEmail    = "verify_email"
+	scopePasswordReset  = "password_reset"
+	scopeExportDownload = "export_download"
+)
+
+var (
+	// ErrInvalidToken トークンの署名や用途が不正
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired トークンの有効期限切れ
+	ErrTokenExpired = errors.New("token expired")
+)
+
 // JWTClaim JWTのクレーム
 type JWTClaim struct {
 	ID string `json:"id"`
@@ -16,12 +42,14 @@ type JWTClaim struct {
 }
 
 // CreateToken JWTトークンを生成する
-func CreateToken(id bson.ObjectId, adminFlag bool) (string, error) {
+// versionはユーザのトークン世代で、パスワード変更時に世代を進めると既存のトークンが失効する
+// 管理権限はトークンに含めず、都度DB上のロールで確認する
+func CreateToken(id bson.ObjectId, version int) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 	claims := token.Claims.(jwt.MapClaims)
 	claims["id"] = id
-	claims["iss"] = "KittenTimeline"
-	claims["admin"] = adminFlag
+	claims["iss"] = Issuer
+	claims["ver"] = version
 	claims["exp"] = time.Now().Add(time.Hour * 72).Unix()
 
 	key := config.GetAPIConfig().Jwt
@@ -33,3 +61,122 @@ func CreateToken(id bson.ObjectId, adminFlag bool) (string, error) {
 
 	return signed, nil
 }
+
+// Version セッショントークンのクレームからトークン世代を取り出す
+func Version(claims jwt.MapClaims) int {
+	// JSONの数値はfloat64としてデコードされる
+	if v, ok := claims["ver"].(float64); ok {
+		return int(v)
+	}
+	return 0
+}
+
+// CreateChallengeToken 二段階認証の途中で発行する短命トークンを生成する
+func CreateChallengeToken(id bson.ObjectId, now time.Time) (string, error) {
+	return createScopedToken(id, scopeChallenge, now.Add(ChallengeTTL), nil)
+}
+
+// ParseChallengeToken チャレンジトークンを検証しユーザのObjectIDを返す
+func ParseChallengeToken(tokenStr string, now time.Time) (bson.ObjectId, error) {
+	claims, err := parseScopedToken(tokenStr, scopeChallenge, now)
+	if err != nil {
+		return "", err
+	}
+	return bson.ObjectIdHex(claims["id"].(string)), nil
+}
+
+// CreateEmailVerificationToken メールアドレス確認リンク用のトークンを生成する
+func CreateEmailVerificationToken(id bson.ObjectId, email string, now time.Time) (string, error) {
+	return createScopedToken(id, scopeVerifyEmail, now.Add(EmailVerificationTTL), jwt.MapClaims{
+		"email": email,
+	})
+}
+
+// ParseEmailVerificationToken メールアドレス確認トークンを検証し、ユーザのObjectIDと確認対象のアドレスを返す
+func ParseEmailVerificationToken(tokenStr string, now time.Time) (bson.ObjectId, string, error) {
+	claims, err := parseScopedToken(tokenStr, scopeVerifyEmail, now)
+	if err != nil {
+		return "", "", err
+	}
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return "", "", ErrInvalidToken
+	}
+	return bson.ObjectIdHex(claims["id"].(string)), email, nil
+}
+
+// CreatePasswordResetToken パスワード再設定リンク用のトークンを生成する
+// トークン世代を埋め込むため、パスワードが変わった時点で使えなくなる
+func CreatePasswordResetToken(id bson.ObjectId, version int, now time.Time) (string, error) {
+	return createScopedToken(id, scopePasswordReset, now.Add(PasswordResetTTL), jwt.MapClaims{
+		"ver": version,
+	})
+}
+
+// ParsePasswordResetToken パスワード再設定トークンを検証し、ユーザのObjectIDとトークン世代を返す
+func ParsePasswordResetToken(tokenStr string, now time.Time) (bson.ObjectId, int, error) {
+	claims, err := parseScopedToken(tokenStr, scopePasswordReset, now)
+	if err != nil {
+		return "", 0, err
+	}
+	return bson.ObjectIdHex(claims["id"].(string)), Version(claims), nil
+}
+
+// CreateExportDownloadToken データエクスポートのダウンロードリンク用のトークンを生成する
+func CreateExportDownloadToken(jobID bson.ObjectId, expiresAt time.Time) (string, error) {
+	return createScopedToken(jobID, scopeExportDownload, expiresAt, nil)
+}
+
+// ParseExportDownloadToken ダウンロード用トークンを検証し、エクスポートジョブのObjectIDを返す
+func ParseExportDownloadToken(tokenStr string, now time.Time) (bson.ObjectId, error) {
+	claims, err := parseScopedToken(tokenStr, scopeExportDownload, now)
+	if err != nil {
+		return "", err
+	}
+	return bson.ObjectIdHex(claims["id"].(string)), nil
+}
+
+// scopedKey 用途ごとに署名鍵を分け、セッショントークンとして流用できないようにする
+func scopedKey(scope string) []byte {
+	return []byte(config.GetAPIConfig().Jwt + "." + scope)
+}
+
+func createScopedToken(id bson.ObjectId, scope string, expiresAt time.Time, extra jwt.MapClaims) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	for k, v := range extra {
+		claims[k] = v
+	}
+	claims["id"] = id.Hex()
+	claims["iss"] = Issuer
+	claims["scope"] = scope
+	claims["exp"] = expiresAt.Unix()
+
+	return token.SignedString(scopedKey(scope))
+}
+
+func parseScopedToken(tokenStr, scope string, now time.Time) (jwt.MapClaims, error) {
+	// 有効期限は渡された時刻で検証する
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidToken
+		}
+		return scopedKey(scope), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if s, _ := claims["scope"].(string); s != scope {
+		return nil, ErrInvalidToken
+	}
+	if !claims.VerifyExpiresAt(now.Unix(), true) {
+		return nil, ErrTokenExpired
+	}
+	if id, _ := claims["id"].(string); !bson.IsObjectIdHex(id) {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
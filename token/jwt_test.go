@@ -2,12 +2,14 @@ package token
 
 import (
 	"testing"
+	"time"
 
+	"github.com/TinyKitten/TimelineServer/utils"
 	"gopkg.in/mgo.v2/bson"
 )
 
 func TestCreateToken(t *testing.T) {
-	token, err := CreateToken(bson.NewObjectId(), false)
+	token, err := CreateToken(bson.NewObjectId(), 0)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
@@ -15,3 +17,111 @@ func TestCreateToken(t *testing.T) {
 		t.Fatalf("token is empty")
 	}
 }
+
+func TestChallengeToken(t *testing.T) {
+	clock := &utils.FakeClock{Current: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+	id := bson.NewObjectId()
+
+	token, err := CreateChallengeToken(id, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseChallengeToken(token, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != id {
+		t.Fatalf("id mismatch: %s", parsed.Hex())
+	}
+
+	clock.Advance(ChallengeTTL + time.Second)
+	if _, err := ParseChallengeToken(token, clock.Now()); err != ErrTokenExpired {
+		t.Fatalf("expected ErrTokenExpired, actual %v", err)
+	}
+}
+
+func TestChallengeTokenIsNotSessionToken(t *testing.T) {
+	now := time.Now()
+	session, err := CreateToken(bson.NewObjectId(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseChallengeToken(session, now); err != ErrInvalidToken {
+		t.Fatalf("session token must not be accepted as challenge, got %v", err)
+	}
+}
+
+func TestEmailVerificationToken(t *testing.T) {
+	clock := &utils.FakeClock{Current: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+	id := bson.NewObjectId()
+
+	token, err := CreateEmailVerificationToken(id, "kitten@example.com", clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedID, email, err := ParseEmailVerificationToken(token, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsedID != id || email != "kitten@example.com" {
+		t.Fatalf("unexpected claims: %s %s", parsedID.Hex(), email)
+	}
+
+	// 用途の異なるトークンとしては使えない
+	if _, _, err := ParsePasswordResetToken(token, clock.Now()); err != ErrInvalidToken {
+		t.Fatalf("expected ErrInvalidToken, actual %v", err)
+	}
+
+	clock.Advance(EmailVerificationTTL + time.Second)
+	if _, _, err := ParseEmailVerificationToken(token, clock.Now()); err != ErrTokenExpired {
+		t.Fatalf("expected ErrTokenExpired, actual %v", err)
+	}
+}
+
+func TestPasswordResetToken(t *testing.T) {
+	clock := &utils.FakeClock{Current: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+	id := bson.NewObjectId()
+
+	token, err := CreatePasswordResetToken(id, 3, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedID, version, err := ParsePasswordResetToken(token, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsedID != id || version != 3 {
+		t.Fatalf("unexpected claims: %s %d", parsedID.Hex(), version)
+	}
+
+	clock.Advance(PasswordResetTTL + time.Second)
+	if _, _, err := ParsePasswordResetToken(token, clock.Now()); err != ErrTokenExpired {
+		t.Fatalf("expected ErrTokenExpired, actual %v", err)
+	}
+}
+
+func TestExportDownloadToken(t *testing.T) {
+	clock := &utils.FakeClock{Current: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+	jobID := bson.NewObjectId()
+
+	token, err := CreateExportDownloadToken(jobID, clock.Now().Add(ExportDownloadTTL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ParsePasswordResetToken(token, clock.Now()); err != ErrInvalidToken {
+		t.Fatalf("download token should not be usable for another scope: %v", err)
+	}
+	parsed, err := ParseExportDownloadToken(token, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != jobID {
+		t.Fatalf("expected %s, actual %s", jobID.Hex(), parsed.Hex())
+	}
+
+	clock.Advance(ExportDownloadTTL + time.Second)
+	if _, err := ParseExportDownloadToken(token, clock.Now()); err != ErrTokenExpired {
+		t.Fatalf("expected ErrTokenExpired, actual %v", err)
+	}
+}
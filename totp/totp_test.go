@@ -0,0 +1,131 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/utils"
+)
+
+// RFC 6238 Appendix B のテストベクタ(SHA1)
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestHOTPVectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, expected := range vectors {
+		actual := hotp(key, unix/Period, 8)
+		if actual != expected {
+			t.Fatalf("time %d: expected %s, actual %s", unix, expected, actual)
+		}
+	}
+}
+
+func TestVerifyWithFakeClock(t *testing.T) {
+	clock := &utils.FakeClock{Current: time.Unix(1111111109, 0)}
+
+	code, err := Code(rfcSecret, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "081804" {
+		t.Fatalf("unexpected code %s", code)
+	}
+
+	s, ok := Verify(rfcSecret, code, clock.Now())
+	if !ok {
+		t.Fatal("code should be accepted")
+	}
+	if s != clock.Now().Unix()/Period {
+		t.Fatalf("unexpected step %d", s)
+	}
+
+	// 1ステップ分のずれは許容する
+	clock.Advance(Period * time.Second)
+	if _, ok := Verify(rfcSecret, code, clock.Now()); !ok {
+		t.Fatal("code within skew should be accepted")
+	}
+
+	// それ以上古いコードは拒否する
+	clock.Advance(Period * time.Second)
+	if _, ok := Verify(rfcSecret, code, clock.Now()); ok {
+		t.Fatal("expired code should be rejected")
+	}
+}
+
+func TestVerifyRejectsMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+	if _, ok := Verify(rfcSecret, "12345", now); ok {
+		t.Fatal("short code should be rejected")
+	}
+	if _, ok := Verify("not base32!", "287082", now); ok {
+		t.Fatal("invalid secret should be rejected")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Code(secret, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	other, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == other {
+		t.Fatal("secrets should be random")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("KittenTimeline", "kitten", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/KittenTimeline:kitten?") {
+		t.Fatalf("unexpected uri %s", uri)
+	}
+	if !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") {
+		t.Fatalf("secret missing in uri %s", uri)
+	}
+	if !strings.Contains(uri, "issuer=KittenTimeline") {
+		t.Fatalf("issuer missing in uri %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("expected 10 codes, actual %d", len(codes))
+	}
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(c) != 9 || c[4] != '-' {
+			t.Fatalf("unexpected format %s", c)
+		}
+		if seen[c] {
+			t.Fatalf("duplicated code %s", c)
+		}
+		seen[c] = true
+
+		input := strings.ToUpper(strings.Replace(c, "-", "", 1))
+		if NormalizeRecoveryCode(input) != c {
+			t.Fatalf("normalize failed for %s", input)
+		}
+		if HashRecoveryCode(input) != HashRecoveryCode(c) {
+			t.Fatalf("hash mismatch for %s", input)
+		}
+	}
+}
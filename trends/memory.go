@@ -0,0 +1,108 @@
+package trends
+
+import (
+	"sync"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/utils"
+)
+
+type memoryEntry struct {
+	scores   map[string]float64
+	members  map[string]bool
+	expireAt time.Time
+}
+
+// MemoryStore メモリ上のStore。テストやRedisを使わない単一プロセスでの利用向け
+type MemoryStore struct {
+	mu      sync.Mutex
+	clock   utils.Clock
+	entries map[string]*memoryEntry
+}
+
+// NewMemoryStore MemoryStoreを生成する
+func NewMemoryStore(clock utils.Clock) *MemoryStore {
+	return &MemoryStore{clock: clock, entries: make(map[string]*memoryEntry)}
+}
+
+// IncrScore memberのスコアをby増やす
+func (m *MemoryStore) IncrScore(key, member string, by float64, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.entry(key, ttl)
+	e.scores[member] += by
+	return nil
+}
+
+// Scores keysそれぞれの全メンバーとスコアを返す
+func (m *MemoryStore) Scores(keys ...string) ([]map[string]float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]map[string]float64, len(keys))
+	for i, key := range keys {
+		scores := map[string]float64{}
+		if e := m.get(key); e != nil {
+			for member, score := range e.scores {
+				scores[member] = score
+			}
+		}
+		result[i] = scores
+	}
+	return result, nil
+}
+
+// AddDistinct 集合にmemberを加える
+func (m *MemoryStore) AddDistinct(key, member string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.entry(key, ttl)
+	e.members[member] = true
+	return nil
+}
+
+// CountDistinct setsの各要素について和集合の要素数を正確に返す
+func (m *MemoryStore) CountDistinct(sets ...[]string) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make([]int64, len(sets))
+	for i, keys := range sets {
+		union := map[string]bool{}
+		for _, key := range keys {
+			if e := m.get(key); e != nil {
+				for member := range e.members {
+					union[member] = true
+				}
+			}
+		}
+		counts[i] = int64(len(union))
+	}
+	return counts, nil
+}
+
+// entry keyのエントリを必要なら作成し、有効期限を設定する
+func (m *MemoryStore) entry(key string, ttl time.Duration) *memoryEntry {
+	e := m.get(key)
+	if e == nil {
+		e = &memoryEntry{scores: map[string]float64{}, members: map[string]bool{}}
+		m.entries[key] = e
+	}
+	e.expireAt = m.clock.Now().Add(ttl)
+	return e
+}
+
+// get 期限切れのエントリを取り除いてから返す
+func (m *MemoryStore) get(key string) *memoryEntry {
+	e, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	if !m.clock.Now().Before(e.expireAt) {
+		delete(m.entries, key)
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,236 @@
+package trends
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Store 時間枠ごとのハッシュタグの出現数と投稿者を保持するストア
+type Store interface {
+	// IncrScore keyのソート済み集合でmemberのスコアをby増やし、keyの有効期限をttlにする
+	IncrScore(key, member string, by float64, ttl time.Duration) error
+	// Scores keysのソート済み集合それぞれの全メンバーとスコアをkeysの順に返す
+	Scores(keys ...string) ([]map[string]float64, error)
+	// AddDistinct keyの集合にmemberを加え、keyの有効期限をttlにする
+	AddDistinct(key, member string, ttl time.Duration) error
+	// CountDistinct setsの各要素について、keysの集合の和集合の要素数をsetsの順に返す(Redisでは近似値)
+	CountDistinct(sets ...[]string) ([]int64, error)
+}
+
+// Policy トレンドの集計条件
+type Policy struct {
+	// Bucket 出現数を数える時間枠の長さ
+	Bucket time.Duration
+	// Window 集計の対象とする期間。Bucketの倍数にする
+	Window time.Duration
+	// HalfLife スコアが半分になるまでの時間
+	HalfLife time.Duration
+	// MinUsers トレンドとみなす最低限の投稿者数
+	MinUsers int64
+	// CacheTTL 集計結果を使い回す時間。ゼロの場合は毎回集計する
+	CacheTTL time.Duration
+}
+
+// DefaultPolicy 標準の集計条件
+var DefaultPolicy = Policy{
+	Bucket:   5 * time.Minute,
+	Window:   2 * time.Hour,
+	HalfLife: 30 * time.Minute,
+	MinUsers: 3,
+	CacheTTL: 30 * time.Second,
+}
+
+const (
+	countPrefix = "trends:count:"
+	usersPrefix = "trends:users:"
+
+	// candidateFactor 投稿者数を数える候補の数(limitの倍数)
+	// スコア上位のタグが投稿者数の条件を満たさない場合、limit件に届かないことがある
+	candidateFactor = 3
+)
+
+// Trend トレンド入りしたハッシュタグ
+type Trend struct {
+	Name   string  // #を除いた正規化済みのハッシュタグ
+	Score  float64 // 減衰を加味した出現数
+	Volume int64   // 集計期間内の出現数
+	Users  int64   // 集計期間内の投稿者数
+}
+
+// Tracker スライディングウィンドウでハッシュタグの出現数を数え、トレンドを算出する
+type Tracker struct {
+	store  Store
+	policy Policy
+
+	mu       sync.Mutex
+	snapshot *snapshot
+}
+
+// snapshot CacheTTLの間使い回す集計結果
+type snapshot struct {
+	at     time.Time
+	bucket int64
+	// sorted スコアの高い順の全候補
+	sorted []*Trend
+	// counted 投稿者数を数えたタグ
+	counted map[string]bool
+}
+
+// NewTracker Trackerを生成する
+func NewTracker(store Store, policy Policy) *Tracker {
+	return &Tracker{store: store, policy: policy}
+}
+
+// Record 投稿に含まれるハッシュタグを記録する。tagsは正規化済みで重複を含まないこと
+func (t *Tracker) Record(userID string, tags []string, now time.Time) error {
+	bucket := t.bucket(now)
+	// 時間枠が集計期間から外れるまで保持する
+	ttl := t.policy.Window + t.policy.Bucket
+	for _, tag := range tags {
+		if err := t.store.IncrScore(countKey(bucket), tag, 1, ttl); err != nil {
+			return err
+		}
+		if err := t.store.AddDistinct(usersKey(bucket, tag), userID, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Top スコアの高い順にlimit件のトレンドを返す
+// 投稿者がMinUsersに満たないもの、denyに含まれるものは除く
+// 投稿者数はスコア上位のlimit*candidateFactor件だけ数える
+func (t *Tracker) Top(now time.Time, limit int, deny map[string]bool) ([]Trend, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snap, err := t.current(now)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []*Trend{}
+	for _, c := range snap.sorted {
+		if len(candidates) >= limit*candidateFactor {
+			break
+		}
+		if !deny[c.Name] {
+			candidates = append(candidates, c)
+		}
+	}
+	if err := t.countUsers(snap, candidates); err != nil {
+		return nil, err
+	}
+
+	trends := []Trend{}
+	for _, c := range candidates {
+		if len(trends) >= limit {
+			break
+		}
+		if c.Users >= t.policy.MinUsers {
+			trends = append(trends, *c)
+		}
+	}
+	return trends, nil
+}
+
+// current 有効な集計結果を返す。期限切れの場合は集計し直す
+func (t *Tracker) current(now time.Time) (*snapshot, error) {
+	if s := t.snapshot; s != nil && !now.Before(s.at) && now.Sub(s.at) < t.policy.CacheTTL {
+		return s, nil
+	}
+
+	bucket := t.bucket(now)
+	n := t.buckets()
+	keys := make([]string, n)
+	for i := int64(0); i < n; i++ {
+		keys[i] = countKey(bucket - i)
+	}
+	scores, err := t.store.Scores(keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]*Trend{}
+	for i, counts := range scores {
+		// 古い時間枠ほど減衰させる
+		decay := math.Pow(0.5, float64(time.Duration(i)*t.policy.Bucket)/float64(t.policy.HalfLife))
+		for tag, count := range counts {
+			c, ok := byName[tag]
+			if !ok {
+				c = &Trend{Name: tag}
+				byName[tag] = c
+			}
+			c.Score += count * decay
+			c.Volume += int64(count)
+		}
+	}
+
+	sorted := make([]*Trend, 0, len(byName))
+	for _, c := range byName {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Score != sorted[j].Score {
+			return sorted[i].Score > sorted[j].Score
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	t.snapshot = &snapshot{at: now, bucket: bucket, sorted: sorted, counted: map[string]bool{}}
+	return t.snapshot, nil
+}
+
+// countUsers まだ数えていない候補の投稿者数をまとめて数える
+func (t *Tracker) countUsers(snap *snapshot, candidates []*Trend) error {
+	n := t.buckets()
+	pending := []*Trend{}
+	sets := [][]string{}
+	for _, c := range candidates {
+		if snap.counted[c.Name] {
+			continue
+		}
+		keys := make([]string, n)
+		for i := int64(0); i < n; i++ {
+			keys[i] = usersKey(snap.bucket-i, c.Name)
+		}
+		pending = append(pending, c)
+		sets = append(sets, keys)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	users, err := t.store.CountDistinct(sets...)
+	if err != nil {
+		return err
+	}
+	for i, c := range pending {
+		c.Users = users[i]
+		snap.counted[c.Name] = true
+	}
+	return nil
+}
+
+func (t *Tracker) bucket(now time.Time) int64 {
+	return now.UnixNano() / int64(t.policy.Bucket)
+}
+
+func (t *Tracker) buckets() int64 {
+	n := int64(t.policy.Window / t.policy.Bucket)
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func countKey(bucket int64) string {
+	return countPrefix + strconv.FormatInt(bucket, 10)
+}
+
+func usersKey(bucket int64, tag string) string {
+	return usersPrefix + strconv.FormatInt(bucket, 10) + ":" + tag
+}
@@ -0,0 +1,128 @@
+package trends
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TinyKitten/TimelineServer/utils"
+)
+
+var testPolicy = Policy{
+	Bucket:   time.Minute,
+	Window:   10 * time.Minute,
+	HalfLife: 5 * time.Minute,
+	MinUsers: 2,
+}
+
+func newTestTracker() (*Tracker, *utils.FakeClock) {
+	clock := &utils.FakeClock{Current: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+	return NewTracker(NewMemoryStore(clock), testPolicy), clock
+}
+
+func record(t *testing.T, tr *Tracker, now time.Time, user string, tags ...string) {
+	if err := tr.Record(user, tags, now); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func names(trends []Trend) []string {
+	ns := []string{}
+	for _, t := range trends {
+		ns = append(ns, t.Name)
+	}
+	return ns
+}
+
+func TestTopRanksByDecayedScore(t *testing.T) {
+	tr, clock := newTestTracker()
+
+	// 古い話題は出現数が多くても減衰する
+	for i := 0; i < 6; i++ {
+		record(t, tr, clock.Now(), []string{"a", "b"}[i%2], "old")
+	}
+	clock.Advance(9 * time.Minute)
+	for i := 0; i < 4; i++ {
+		record(t, tr, clock.Now(), []string{"a", "b"}[i%2], "new")
+	}
+
+	top, err := tr.Top(clock.Now(), 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 || top[0].Name != "new" || top[1].Name != "old" {
+		t.Fatalf("unexpected trends: %v", top)
+	}
+	if top[1].Volume != 6 || top[1].Users != 2 {
+		t.Fatalf("unexpected volume or users: %+v", top[1])
+	}
+
+	// 集計期間を過ぎた時間枠は含まない
+	clock.Advance(time.Minute)
+	top, _ = tr.Top(clock.Now(), 10, nil)
+	if ns := names(top); len(ns) != 1 || ns[0] != "new" {
+		t.Fatalf("old bucket should be out of window: %v", ns)
+	}
+}
+
+func TestTopRequiresDistinctUsers(t *testing.T) {
+	tr, clock := newTestTracker()
+
+	for i := 0; i < 10; i++ {
+		record(t, tr, clock.Now(), "spammer", "spam")
+	}
+	record(t, tr, clock.Now(), "a", "real")
+	record(t, tr, clock.Now(), "b", "real")
+
+	top, err := tr.Top(clock.Now(), 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns := names(top); len(ns) != 1 || ns[0] != "real" {
+		t.Fatalf("single-user tag should not trend: %v", ns)
+	}
+}
+
+func TestTopDenyAndLimit(t *testing.T) {
+	tr, clock := newTestTracker()
+
+	for _, tag := range []string{"x", "y", "z"} {
+		record(t, tr, clock.Now(), "a", tag)
+		record(t, tr, clock.Now(), "b", tag)
+	}
+
+	top, err := tr.Top(clock.Now(), 1, map[string]bool{"x": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns := names(top); len(ns) != 1 || ns[0] != "y" {
+		t.Fatalf("expected [y], actual %v", ns)
+	}
+}
+
+func TestTopCachesRanking(t *testing.T) {
+	clock := &utils.FakeClock{Current: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+	policy := testPolicy
+	policy.CacheTTL = 30 * time.Second
+	tr := NewTracker(NewMemoryStore(clock), policy)
+
+	record(t, tr, clock.Now(), "a", "first")
+	record(t, tr, clock.Now(), "b", "first")
+	if _, err := tr.Top(clock.Now(), 10, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 有効期間中は集計し直さない
+	record(t, tr, clock.Now(), "a", "second")
+	record(t, tr, clock.Now(), "b", "second")
+	clock.Advance(10 * time.Second)
+	top, _ := tr.Top(clock.Now(), 10, nil)
+	if ns := names(top); len(ns) != 1 || ns[0] != "first" {
+		t.Fatalf("cached ranking should be used: %v", ns)
+	}
+
+	clock.Advance(20 * time.Second)
+	top, _ = tr.Top(clock.Now(), 10, nil)
+	if ns := names(top); len(ns) != 2 {
+		t.Fatalf("ranking should be refreshed: %v", ns)
+	}
+}
@@ -0,0 +1,34 @@
+package utils
+
+import "time"
+
+// Clock 現在時刻の取得元
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewClock システム時刻を返すClockを生成する
+func NewClock() Clock {
+	return systemClock{}
+}
+
+// FakeClock テスト用の時刻を自由に進められるClock
+type FakeClock struct {
+	Current time.Time
+}
+
+// Now 現在の偽装時刻を返す
+func (f *FakeClock) Now() time.Time {
+	return f.Current
+}
+
+// Advance 偽装時刻をdだけ進める
+func (f *FakeClock) Advance(d time.Duration) {
+	f.Current = f.Current.Add(d)
+}
@@ -17,17 +17,6 @@ var supportBase64MIME = map[string]string{
 
 var ErrFileNotSupported = errors.New("base64 file uris is not supported")
 
-func DetectFileExtension(str string) string {
-	fileExt := ""
-	for ext, mime := range supportBase64MIME {
-		if strings.HasPrefix(str, "data:"+mime) {
-			fileExt = ext
-		}
-	}
-
-	return fileExt
-}
-
 func DecodeImage(str string) ([]byte, error) {
 	trimed := ""
 	for _, mime := range supportBase64MIME {
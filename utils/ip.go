@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP 接続元のIPアドレスを返す
+// hopsは手前にある信頼できるプロキシの段数で、0の場合はX-Forwarded-Forを使わない
+// 各プロキシはX-Forwarded-Forの末尾に接続元を追加するため、末尾からhops番目をクライアントとみなす
+// それより前の値はクライアントが自由に書き換えられるので使わない
+func ClientIP(r *http.Request, hops int) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if hops <= 0 {
+		return remote
+	}
+
+	forwarded := []string{}
+	for _, v := range r.Header[http.CanonicalHeaderKey("X-Forwarded-For")] {
+		for _, ip := range strings.Split(v, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(ip))
+		}
+	}
+	if len(forwarded) < hops {
+		return remote
+	}
+	ip := forwarded[len(forwarded)-hops]
+	if net.ParseIP(ip) == nil {
+		return remote
+	}
+	return ip
+}
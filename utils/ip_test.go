@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		forwarded []string
+		hops      int
+		expected  string
+	}{
+		// プロキシを信頼しない場合はヘッダを無視する
+		{[]string{"203.0.113.9"}, 0, "192.0.2.1"},
+		// 偽装された先頭の値ではなく、プロキシが追加した値を使う
+		{[]string{"203.0.113.9, 198.51.100.7"}, 1, "198.51.100.7"},
+		{[]string{"203.0.113.9", "198.51.100.7, 10.0.0.2"}, 2, "198.51.100.7"},
+		// プロキシを経由していない
+		{nil, 1, "192.0.2.1"},
+		{[]string{"not an ip"}, 1, "192.0.2.1"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = "192.0.2.1:12345"
+		for _, v := range c.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		r.Header.Set("X-Real-IP", "203.0.113.10")
+		if actual := ClientIP(r, c.hops); actual != c.expected {
+			t.Errorf("ClientIP(%v, %d) = %s, expected %s", c.forwarded, c.hops, actual, c.expected)
+		}
+	}
+}
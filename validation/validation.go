@@ -0,0 +1,219 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/TinyKitten/TimelineServer/config"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+var (
+	// ErrScreenNameCharset 使用できない文字を含む
+	ErrScreenNameCharset = errors.New("only letters, numbers and underscores are allowed")
+	// ErrScreenNameReserved 予約語
+	ErrScreenNameReserved = errors.New("this name is reserved")
+	// ErrScreenNameTaken 大文字小文字を区別せず既に使われている
+	ErrScreenNameTaken = errors.New("this name is already taken")
+	// ErrPasswordWeak 含む文字の種類が少ない
+	ErrPasswordWeak = errors.New("password is too weak")
+	// ErrPasswordContainsName スクリーンネームを含む
+	ErrPasswordContainsName = errors.New("password must not contain the screen name")
+	// ErrPasswordTooLong bcryptが扱える長さを超えている
+	ErrPasswordTooLong = errors.New("password must be at most 72 bytes")
+)
+
+// passwordMaxBytes bcryptは72バイトより後ろを無視する
+const passwordMaxBytes = 72
+
+// defaultReservedScreenNames URLやAPIのパスと衝突する名前
+var defaultReservedScreenNames = []string{
+	"about", "account", "admin", "administrator", "api", "friendships",
+	"help", "home", "login", "logout", "me", "null", "official", "realtime",
+	"root", "search", "settings", "signup", "statuses", "super", "support",
+	"system", "timeline", "undefined", "users",
+}
+
+// DefaultRules 設定がない場合の入力規則
+var DefaultRules = Rules{
+	ScreenNameMinLength: 3,
+	ScreenNameMaxLength: 20,
+	PasswordMinLength:   8,
+	PasswordMinClasses:  2,
+	reserved:            toSet(defaultReservedScreenNames),
+}
+
+// Rules スクリーンネームとパスワードの入力規則
+type Rules struct {
+	ScreenNameMinLength int
+	ScreenNameMaxLength int
+	PasswordMinLength   int
+	PasswordMinClasses  int
+	reserved            map[string]struct{}
+}
+
+// NewRules 設定から入力規則を生成する。未設定の項目はDefaultRulesの値を使う
+func NewRules(conf config.ValidationConfig) Rules {
+	r := DefaultRules
+	if conf.ScreenNameMinLength > 0 {
+		r.ScreenNameMinLength = conf.ScreenNameMinLength
+	}
+	if conf.ScreenNameMaxLength > 0 {
+		r.ScreenNameMaxLength = conf.ScreenNameMaxLength
+	}
+	if conf.PasswordMinLength > 0 {
+		r.PasswordMinLength = conf.PasswordMinLength
+	}
+	if conf.PasswordMinClasses > 0 {
+		r.PasswordMinClasses = conf.PasswordMinClasses
+	}
+	r.reserved = toSet(append(defaultReservedScreenNames, conf.ReservedScreenNames...))
+	return r
+}
+
+// ScreenName スクリーンネームが規則を満たすか検証する
+// 使える文字は英数字とアンダースコアのみ。予約語は大文字小文字を区別しない
+func (r Rules) ScreenName(name string) error {
+	if len(name) < r.ScreenNameMinLength || len(name) > r.ScreenNameMaxLength {
+		return fmt.Errorf("must be %d to %d characters", r.ScreenNameMinLength, r.ScreenNameMaxLength)
+	}
+	for _, c := range name {
+		if !isScreenNameChar(c) {
+			return ErrScreenNameCharset
+		}
+	}
+	if r.IsReserved(name) {
+		return ErrScreenNameReserved
+	}
+	return nil
+}
+
+// IsReserved 予約語か
+func (r Rules) IsReserved(name string) bool {
+	_, ok := r.reserved[strings.ToLower(name)]
+	return ok
+}
+
+// Password パスワードが規則を満たすか検証する
+func (r Rules) Password(password, screenName string) error {
+	if utf8.RuneCountInString(password) < r.PasswordMinLength {
+		return fmt.Errorf("must be at least %d characters", r.PasswordMinLength)
+	}
+	if len(password) > passwordMaxBytes {
+		return ErrPasswordTooLong
+	}
+	if passwordClasses(password) < r.PasswordMinClasses {
+		return ErrPasswordWeak
+	}
+	if screenName != "" && strings.Contains(strings.ToLower(password), strings.ToLower(screenName)) {
+		return ErrPasswordContainsName
+	}
+	return nil
+}
+
+func isScreenNameChar(c rune) bool {
+	return c == '_' ||
+		('a' <= c && c <= 'z') ||
+		('A' <= c && c <= 'Z') ||
+		('0' <= c && c <= '9')
+}
+
+// passwordClasses 英小文字・英大文字・数字・その他のうち含まれる種類の数
+func passwordClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = 1
+		case unicode.IsUpper(c):
+			upper = 1
+		case unicode.IsDigit(c):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+func toSet(words []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(words))
+	for _, w := range words {
+		set[strings.ToLower(w)] = struct{}{}
+	}
+	return set
+}
+
+// FieldErrors フィールド名(JSONのキー)ごとのエラー
+type FieldErrors map[string]string
+
+func (f FieldErrors) Error() string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	msgs := make([]string, len(keys))
+	for i, k := range keys {
+		msgs[i] = k + ": " + f[k]
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// Add fieldにまだエラーがなければ追加する
+func (f FieldErrors) Add(field string, err error) {
+	if err == nil {
+		return
+	}
+	if _, ok := f[field]; !ok {
+		f[field] = err.Error()
+	}
+}
+
+// FromValidator validatorのエラーをFieldErrorsに変換する。sはValidateに渡した構造体
+func FromValidator(s interface{}, err error) FieldErrors {
+	f := FieldErrors{}
+	verrs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return f
+	}
+
+	t := reflect.Indirect(reflect.ValueOf(s)).Type()
+	for _, fe := range verrs {
+		f.Add(jsonName(t, fe.StructField()), errors.New(tagMessage(fe)))
+	}
+	return f
+}
+
+func jsonName(t reflect.Type, field string) string {
+	sf, ok := t.FieldByName(field)
+	if !ok {
+		return field
+	}
+	name := strings.Split(sf.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field
+	}
+	return name
+}
+
+func tagMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "required"
+	case "email":
+		return "must be a valid email address"
+	case "max":
+		return "must be at most " + fe.Param() + " characters"
+	case "min":
+		return "must be at least " + fe.Param() + " characters"
+	default:
+		return "invalid"
+	}
+}
@@ -0,0 +1,90 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TinyKitten/TimelineServer/config"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+func TestScreenName(t *testing.T) {
+	r := DefaultRules
+	cases := []struct {
+		name string
+		ok   bool
+	}{
+		{"kitten_01", true},
+		{"ab", false},
+		{"abcdefghijklmnopqrstu", false},
+		{"kit ten", false},
+		{"kit/ten", false},
+		{"kit.*", false},
+		{"ｋｉｔｔｅｎ", false},
+		{"Super", false},
+		{"settings", false},
+	}
+	for _, c := range cases {
+		if err := r.ScreenName(c.name); (err == nil) != c.ok {
+			t.Errorf("%q: expected ok=%v, actual %v", c.name, c.ok, err)
+		}
+	}
+}
+
+func TestNewRules(t *testing.T) {
+	r := NewRules(config.ValidationConfig{
+		ScreenNameMaxLength: 5,
+		ReservedScreenNames: []string{"Kitten"},
+	})
+	if r.ScreenNameMinLength != DefaultRules.ScreenNameMinLength {
+		t.Fatalf("unset value should fall back to default: %d", r.ScreenNameMinLength)
+	}
+	if err := r.ScreenName("abcdef"); err == nil {
+		t.Fatal("max length should be configurable")
+	}
+	if err := r.ScreenName("kitten"[:5]); err != nil {
+		t.Fatal(err)
+	}
+	if !r.IsReserved("KITTEN") || !r.IsReserved("super") {
+		t.Fatal("configured words should be added to the defaults")
+	}
+	if DefaultRules.IsReserved("kitten") {
+		t.Fatal("DefaultRules should not be modified")
+	}
+}
+
+func TestPassword(t *testing.T) {
+	r := DefaultRules
+	cases := []struct {
+		password string
+		ok       bool
+	}{
+		{"a", false},
+		{"password", false},
+		{"password1", true},
+		{"correct horse", true},
+		{"12345678", false},
+		{"kitten123", false},
+		{strings.Repeat("aB1", 25), false},
+	}
+	for _, c := range cases {
+		if err := r.Password(c.password, "Kitten"); (err == nil) != c.ok {
+			t.Errorf("%q: expected ok=%v, actual %v", c.password, c.ok, err)
+		}
+	}
+}
+
+func TestFromValidator(t *testing.T) {
+	req := struct {
+		ID    string `json:"id" validate:"required"`
+		Email string `json:"email" validate:"required,email"`
+	}{Email: "invalid"}
+
+	f := FromValidator(&req, validator.New().Struct(&req))
+	if f["id"] != "required" {
+		t.Fatalf("unexpected id error: %v", f)
+	}
+	if f["email"] == "" {
+		t.Fatalf("email error missing: %v", f)
+	}
+}